@@ -0,0 +1,60 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v0
+
+import (
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Conversions between v0 and the v1 hub are lossless. When a spec cannot be
+// expressed in the other version, such as a v0 relationship still using the
+// deprecated multiplicity, the original spec is kept in an annotation of
+// the converted object and restored when converting back, unless the spec
+// was changed in between.
+const (
+	V0SpecAnnotation = "conversion.dtdl.digitaltwin/v0-spec"
+	V1SpecAnnotation = "conversion.dtdl.digitaltwin/v1-spec"
+)
+
+// stashSpec keeps spec in the annotation key.
+func stashSpec(meta *metav1.ObjectMeta, key string, spec interface{}) error {
+	value, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[key] = string(value)
+	return nil
+}
+
+// unstashSpec removes the annotation key and decodes the spec it holds into
+// spec. It reports whether the annotation was present.
+func unstashSpec(meta *metav1.ObjectMeta, key string, spec interface{}) (bool, error) {
+	value, ok := meta.Annotations[key]
+	if !ok {
+		return false, nil
+	}
+	delete(meta.Annotations, key)
+	if len(meta.Annotations) == 0 {
+		meta.Annotations = nil
+	}
+	return true, json.Unmarshal([]byte(value), spec)
+}
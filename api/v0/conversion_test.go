@@ -0,0 +1,188 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v0
+
+import (
+	"encoding/json"
+
+	"github.com/google/go-cmp/cmp"
+	fuzz "github.com/google/gofuzz"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+// spoke is a v0 kind converting through the v1 hub.
+type spoke interface {
+	conversion.Convertible
+	DeepCopySpoke() spoke
+}
+
+func (c *TwinClass) DeepCopySpoke() spoke   { return c.DeepCopy() }
+func (e *TwinEnum) DeepCopySpoke() spoke    { return e.DeepCopy() }
+func (s *TwinService) DeepCopySpoke() spoke { return s.DeepCopy() }
+
+var fuzzer = fuzz.New().NilChance(0.3).NumElements(0, 3).MaxDepth(8).Funcs(
+	// Type metadata is set by the conversion webhook, not by the kinds.
+	func(t *metav1.TypeMeta, c fuzz.Continue) {},
+	// Metadata and pod templates are copied as they are; fuzz the parts
+	// conversion handles.
+	func(m *metav1.ObjectMeta, c fuzz.Continue) {
+		c.Fuzz(&m.Name)
+		c.Fuzz(&m.Namespace)
+		c.Fuzz(&m.Labels)
+		c.Fuzz(&m.Annotations)
+	},
+	func(t *corev1.PodTemplateSpec, c fuzz.Continue) {
+		t.Spec.Containers = []corev1.Container{{Name: c.RandString(), Image: c.RandString()}}
+	},
+)
+
+// fuzzObject fills obj with random values and passes it through JSON, as
+// objects read from the API server always have been.
+func fuzzObject(obj runtime.Object) {
+	fuzzer.Fuzz(obj)
+	data, err := json.Marshal(obj)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(json.Unmarshal(data, obj)).To(Succeed())
+}
+
+func expectSemanticallyEqual(actual, expected interface{}) {
+	ExpectWithOffset(1, equality.Semantic.DeepEqual(actual, expected)).To(BeTrue(), cmp.Diff(expected, actual))
+}
+
+var _ = Describe("Conversion", func() {
+	one, three := int32(1), int32(3)
+
+	It("maps the deprecated relationship fields to v1", func() {
+		class := &TwinClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "line"},
+			Spec: TwinClassSpec{
+				Name: "Line",
+				Relationships: []TwinRelationship{
+					{Name: "head", Multiplicity: ONE, Reference: "Machine"},
+					{Name: "machines", Multiplicity: MANY, Reference: "Machine"},
+				},
+			},
+		}
+
+		hub := &dtdlv1.TwinClass{}
+		Expect(class.ConvertTo(hub)).To(Succeed())
+		Expect(hub.Spec.Relationships).To(Equal([]dtdlv1.TwinRelationship{
+			{Name: "head", MaxMultiplicity: &one, Targets: []string{"Machine"}},
+			{Name: "machines", Targets: []string{"Machine"}},
+		}))
+		Expect(hub.Annotations).To(HaveKey(V0SpecAnnotation))
+
+		restored := &TwinClass{}
+		Expect(restored.ConvertFrom(hub)).To(Succeed())
+		Expect(restored).To(Equal(class))
+	})
+
+	It("does not annotate specs converting exactly", func() {
+		class := &TwinClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "line", Annotations: map[string]string{"owner": "plant"}},
+			Spec: TwinClassSpec{
+				ID:   "dtmi:acme:Line;2",
+				Name: "Line",
+				Attributes: []TwinClassAttributes{
+					{Name: "speed", TwinSchema: TwinSchema{Type: "double"}, Kind: Telemetry, Unit: "metrePerSecond"},
+				},
+				Relationships: []TwinRelationship{{Name: "feeds", MaxMultiplicity: &three, Targets: []string{"Machine"}}},
+			},
+		}
+
+		hub := &dtdlv1.TwinClass{}
+		Expect(class.ConvertTo(hub)).To(Succeed())
+		Expect(hub.Annotations).To(Equal(map[string]string{"owner": "plant"}))
+		Expect(hub.Spec.Attributes[0].Type).To(Equal(dtdlv1.Double))
+	})
+
+	It("drops the stashed spec once the object changes", func() {
+		class := &TwinClass{Spec: TwinClassSpec{
+			Name:          "Line",
+			Relationships: []TwinRelationship{{Name: "head", Multiplicity: ONE}},
+		}}
+
+		hub := &dtdlv1.TwinClass{}
+		Expect(class.ConvertTo(hub)).To(Succeed())
+		hub.Spec.Relationships[0].Targets = []string{"Machine"}
+
+		restored := &TwinClass{}
+		Expect(restored.ConvertFrom(hub)).To(Succeed())
+		Expect(restored.Annotations).To(BeEmpty())
+		Expect(restored.Spec.Relationships).To(Equal([]TwinRelationship{{Name: "head", MaxMultiplicity: &one, Targets: []string{"Machine"}}}))
+	})
+
+	It("splits service class references", func() {
+		service := &TwinService{Spec: TwinServiceSpec{
+			Classes:    []string{"Factory", "dtmi:acme:Machine;>=2,<4"},
+			DataSource: "mqtt",
+		}}
+
+		hub := &dtdlv1.TwinService{}
+		Expect(service.ConvertTo(hub)).To(Succeed())
+		Expect(hub.Spec.Classes).To(Equal([]dtdlv1.TwinClassReference{
+			{Name: "Factory"},
+			{Name: "dtmi:acme:Machine", Version: ">=2,<4"},
+		}))
+		Expect(hub.Spec.DataSource).To(Equal(dtdlv1.MQTT))
+		Expect(hub.Annotations).To(BeEmpty())
+	})
+
+	DescribeTable("round-trips v0 objects through v1 losslessly",
+		func(newSpoke func() spoke, newHub func() conversion.Hub) {
+			for i := 0; i < 200; i++ {
+				original := newSpoke()
+				fuzzObject(original)
+
+				hub := newHub()
+				Expect(original.DeepCopySpoke().ConvertTo(hub)).To(Succeed())
+				restored := newSpoke()
+				Expect(restored.ConvertFrom(hub)).To(Succeed())
+				expectSemanticallyEqual(restored, original)
+			}
+		},
+		Entry("TwinClass", func() spoke { return &TwinClass{} }, func() conversion.Hub { return &dtdlv1.TwinClass{} }),
+		Entry("TwinEnum", func() spoke { return &TwinEnum{} }, func() conversion.Hub { return &dtdlv1.TwinEnum{} }),
+		Entry("TwinService", func() spoke { return &TwinService{} }, func() conversion.Hub { return &dtdlv1.TwinService{} }),
+	)
+
+	DescribeTable("round-trips v1 objects through v0 losslessly",
+		func(newSpoke func() spoke, newHub func() conversion.Hub) {
+			for i := 0; i < 200; i++ {
+				original := newHub()
+				fuzzObject(original)
+
+				converted := newSpoke()
+				Expect(converted.ConvertFrom(original.DeepCopyObject().(conversion.Hub))).To(Succeed())
+				restored := newHub()
+				Expect(converted.ConvertTo(restored)).To(Succeed())
+				expectSemanticallyEqual(restored, original)
+			}
+		},
+		Entry("TwinClass", func() spoke { return &TwinClass{} }, func() conversion.Hub { return &dtdlv1.TwinClass{} }),
+		Entry("TwinEnum", func() spoke { return &TwinEnum{} }, func() conversion.Hub { return &dtdlv1.TwinEnum{} }),
+		Entry("TwinService", func() spoke { return &TwinService{} }, func() conversion.Hub { return &dtdlv1.TwinService{} }),
+	)
+})
@@ -0,0 +1,77 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v0
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// v0Schema returns the schema the CRD of plural serves v0 with, without its
+// descriptions, which may still be reworded.
+func v0Schema(plural string) []byte {
+	data, err := os.ReadFile(filepath.Join("..", "..", "config", "crd", "bases", GroupVersion.Group+"_"+plural+".yaml"))
+	Expect(err).NotTo(HaveOccurred())
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	Expect(yaml.Unmarshal(data, crd)).To(Succeed())
+	for _, version := range crd.Spec.Versions {
+		if version.Name == GroupVersion.Version {
+			Expect(version.Storage).To(BeFalse(), "v0 must not be the storage version")
+			withoutDescriptions(version.Schema.OpenAPIV3Schema)
+			schema, err := yaml.Marshal(version.Schema)
+			Expect(err).NotTo(HaveOccurred())
+			return schema
+		}
+	}
+	Fail("the CRD of " + plural + " does not serve v0")
+	return nil
+}
+
+func withoutDescriptions(schema *apiextensionsv1.JSONSchemaProps) {
+	if schema == nil {
+		return
+	}
+	schema.Description = ""
+	for name, property := range schema.Properties {
+		withoutDescriptions(&property)
+		schema.Properties[name] = property
+	}
+	if schema.Items != nil {
+		withoutDescriptions(schema.Items.Schema)
+	}
+	if schema.AdditionalProperties != nil {
+		withoutDescriptions(schema.AdditionalProperties.Schema)
+	}
+}
+
+var _ = Describe("Frozen", func() {
+	DescribeTable("keeps the served v0 schema",
+		func(plural string) {
+			frozen, err := os.ReadFile(filepath.Join("testdata", plural+".yaml"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(v0Schema(plural))).To(Equal(string(frozen)), "v0 is frozen: add fields to v1 instead")
+		},
+		Entry("TwinClass", "twinclasses"),
+		Entry("TwinEnum", "twinenums"),
+		Entry("TwinService", "twinservices"),
+	)
+})
@@ -14,7 +14,14 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Package v0 contains API Schema definitions for the dtdl v0 API group
+// Package v0 contains API Schema definitions for the dtdl v0 API group.
+//
+// v0 is frozen: its kinds gain no new fields, which the Frozen specs check
+// against the served schemas in testdata. The v1 hub evolves instead, and
+// the API server converts the stored v1 objects for the controllers,
+// pkg/model and the webhooks that still read v0. v1 fields v0 cannot
+// express, such as the autoscaling of a TwinService, are kept in the
+// conversion annotation and are only seen by code reading v1.
 // +kubebuilder:object:generate=true
 // +groupName=dtdl.digitaltwin
 package v0
@@ -0,0 +1,251 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v0
+
+import (
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+// ConvertTo converts this TwinClass to the v1 hub. The deprecated
+// relationship fields are mapped to their replacements: Multiplicity to
+// MaxMultiplicity and Reference to Targets.
+func (src *TwinClass) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*dtdlv1.TwinClass)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.Spec = twinClassSpecToV1(&src.Spec)
+	dst.Status = dtdlv1.TwinClassStatus(*src.Status.DeepCopy())
+
+	stashed := dtdlv1.TwinClassSpec{}
+	if ok, err := unstashSpec(&dst.ObjectMeta, V1SpecAnnotation, &stashed); err != nil {
+		return err
+	} else if ok && equality.Semantic.DeepEqual(twinClassSpecFromV1(&stashed), src.Spec) {
+		dst.Spec = stashed
+	}
+	if !equality.Semantic.DeepEqual(twinClassSpecFromV1(&dst.Spec), src.Spec) {
+		return stashSpec(&dst.ObjectMeta, V0SpecAnnotation, &src.Spec)
+	}
+	return nil
+}
+
+// ConvertFrom converts the v1 hub to this TwinClass.
+func (dst *TwinClass) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*dtdlv1.TwinClass)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.Spec = twinClassSpecFromV1(&src.Spec)
+	dst.Status = TwinClassStatus(*src.Status.DeepCopy())
+
+	stashed := TwinClassSpec{}
+	if ok, err := unstashSpec(&dst.ObjectMeta, V0SpecAnnotation, &stashed); err != nil {
+		return err
+	} else if ok && equality.Semantic.DeepEqual(twinClassSpecToV1(&stashed), src.Spec) {
+		dst.Spec = stashed
+	}
+	if !equality.Semantic.DeepEqual(twinClassSpecToV1(&dst.Spec), src.Spec) {
+		return stashSpec(&dst.ObjectMeta, V1SpecAnnotation, &src.Spec)
+	}
+	return nil
+}
+
+func twinClassSpecToV1(in *TwinClassSpec) dtdlv1.TwinClassSpec {
+	out := dtdlv1.TwinClassSpec{
+		ID:               in.ID,
+		Name:             in.Name,
+		HistoryRetention: in.HistoryRetention,
+	}
+	if in.Attributes != nil {
+		out.Attributes = make([]dtdlv1.TwinAttribute, len(in.Attributes))
+		for i, attribute := range in.Attributes {
+			out.Attributes[i] = dtdlv1.TwinAttribute{
+				Name:         attribute.Name,
+				TwinSchema:   schemaToV1(&attribute.TwinSchema),
+				Kind:         dtdlv1.ContentKind(attribute.Kind),
+				Writable:     attribute.Writable,
+				SemanticType: attribute.SemanticType,
+				Unit:         attribute.Unit,
+				FieldNumber:  attribute.FieldNumber,
+			}
+		}
+	}
+	if in.Relationships != nil {
+		out.Relationships = make([]dtdlv1.TwinRelationship, len(in.Relationships))
+		for i := range in.Relationships {
+			relationship := *in.Relationships[i].DeepCopy()
+			relationship.MigrateLegacyFields()
+			out.Relationships[i] = dtdlv1.TwinRelationship{
+				Name:            relationship.Name,
+				MinMultiplicity: relationship.MinMultiplicity,
+				MaxMultiplicity: relationship.MaxMultiplicity,
+				Targets:         relationship.Targets,
+				Properties:      fieldsToV1(relationship.Properties),
+				Inverse:         relationship.Inverse,
+				OnDelete:        dtdlv1.DeletionPolicy(relationship.OnDelete),
+			}
+		}
+	}
+	if in.Commands != nil {
+		out.Commands = make([]dtdlv1.TwinCommand, len(in.Commands))
+		for i, command := range in.Commands {
+			out.Commands[i] = dtdlv1.TwinCommand{
+				Name:     command.Name,
+				Request:  payloadToV1(command.Request),
+				Response: payloadToV1(command.Response),
+			}
+		}
+	}
+	if in.Components != nil {
+		out.Components = make([]dtdlv1.TwinComponent, len(in.Components))
+		for i, component := range in.Components {
+			out.Components[i] = dtdlv1.TwinComponent(component)
+		}
+	}
+	return out
+}
+
+func twinClassSpecFromV1(in *dtdlv1.TwinClassSpec) TwinClassSpec {
+	out := TwinClassSpec{
+		ID:               in.ID,
+		Name:             in.Name,
+		HistoryRetention: in.HistoryRetention,
+	}
+	if in.Attributes != nil {
+		out.Attributes = make([]TwinClassAttributes, len(in.Attributes))
+		for i, attribute := range in.Attributes {
+			out.Attributes[i] = TwinClassAttributes{
+				Name:         attribute.Name,
+				TwinSchema:   schemaFromV1(&attribute.TwinSchema),
+				Kind:         ContentKind(attribute.Kind),
+				Writable:     attribute.Writable,
+				SemanticType: attribute.SemanticType,
+				Unit:         attribute.Unit,
+				FieldNumber:  attribute.FieldNumber,
+			}
+		}
+	}
+	if in.Relationships != nil {
+		out.Relationships = make([]TwinRelationship, len(in.Relationships))
+		for i := range in.Relationships {
+			relationship := in.Relationships[i].DeepCopy()
+			out.Relationships[i] = TwinRelationship{
+				Name:            relationship.Name,
+				MinMultiplicity: relationship.MinMultiplicity,
+				MaxMultiplicity: relationship.MaxMultiplicity,
+				Targets:         relationship.Targets,
+				Properties:      fieldsFromV1(relationship.Properties),
+				Inverse:         relationship.Inverse,
+				OnDelete:        DeletionPolicy(relationship.OnDelete),
+			}
+		}
+	}
+	if in.Commands != nil {
+		out.Commands = make([]TwinCommand, len(in.Commands))
+		for i, command := range in.Commands {
+			out.Commands[i] = TwinCommand{
+				Name:     command.Name,
+				Request:  payloadFromV1(command.Request),
+				Response: payloadFromV1(command.Response),
+			}
+		}
+	}
+	if in.Components != nil {
+		out.Components = make([]TwinComponent, len(in.Components))
+		for i, component := range in.Components {
+			out.Components[i] = TwinComponent(component)
+		}
+	}
+	return out
+}
+
+func schemaToV1(in *TwinSchema) dtdlv1.TwinSchema {
+	out := dtdlv1.TwinSchema{
+		Type:      dtdlv1.PrimitiveType(in.Type),
+		Reference: in.Reference,
+		Fields:    fieldsToV1(in.Fields),
+	}
+	if in.Items != nil {
+		items := schemaToV1(in.Items)
+		out.Items = &items
+	}
+	if in.Values != nil {
+		values := schemaToV1(in.Values)
+		out.Values = &values
+	}
+	return out
+}
+
+func schemaFromV1(in *dtdlv1.TwinSchema) TwinSchema {
+	out := TwinSchema{
+		Type:      string(in.Type),
+		Reference: in.Reference,
+		Fields:    fieldsFromV1(in.Fields),
+	}
+	if in.Items != nil {
+		items := schemaFromV1(in.Items)
+		out.Items = &items
+	}
+	if in.Values != nil {
+		values := schemaFromV1(in.Values)
+		out.Values = &values
+	}
+	return out
+}
+
+func fieldsToV1(in []TwinSchemaField) []dtdlv1.TwinSchemaField {
+	if in == nil {
+		return nil
+	}
+	out := make([]dtdlv1.TwinSchemaField, len(in))
+	for i := range in {
+		out[i] = dtdlv1.TwinSchemaField{
+			Name:        in[i].Name,
+			TwinSchema:  schemaToV1(&in[i].TwinSchema),
+			FieldNumber: in[i].FieldNumber,
+		}
+	}
+	return out
+}
+
+func fieldsFromV1(in []dtdlv1.TwinSchemaField) []TwinSchemaField {
+	if in == nil {
+		return nil
+	}
+	out := make([]TwinSchemaField, len(in))
+	for i := range in {
+		out[i] = TwinSchemaField{
+			Name:        in[i].Name,
+			TwinSchema:  schemaFromV1(&in[i].TwinSchema),
+			FieldNumber: in[i].FieldNumber,
+		}
+	}
+	return out
+}
+
+func payloadToV1(in *TwinCommandPayload) *dtdlv1.TwinCommandPayload {
+	if in == nil {
+		return nil
+	}
+	return &dtdlv1.TwinCommandPayload{Name: in.Name, TwinSchema: schemaToV1(&in.TwinSchema)}
+}
+
+func payloadFromV1(in *dtdlv1.TwinCommandPayload) *TwinCommandPayload {
+	if in == nil {
+		return nil
+	}
+	return &TwinCommandPayload{Name: in.Name, TwinSchema: schemaFromV1(&in.TwinSchema)}
+}
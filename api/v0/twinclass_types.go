@@ -22,12 +22,20 @@ import (
 
 type PrimitiveTypes string
 type Multiplicity string
+type ContentKind string
+type DeletionPolicy string
 
 const (
 	Integer PrimitiveTypes = "integer"
 	String  PrimitiveTypes = "string"
 	Boolean PrimitiveTypes = "boolean"
 	Double  PrimitiveTypes = "double"
+
+	Enumeration PrimitiveTypes = "enumeration"
+
+	Array  PrimitiveTypes = "array"
+	Map    PrimitiveTypes = "map"
+	Object PrimitiveTypes = "object"
 )
 
 const (
@@ -35,28 +43,171 @@ const (
 	MANY Multiplicity = "many"
 )
 
+// Deletion policies choose what happens to the edges of a relationship when
+// the related twin is deleted. Without a policy the edge is kept and
+// reported as dangling.
+const (
+	// BlockDeletion keeps the related twin until the edge is removed.
+	BlockDeletion DeletionPolicy = "Block"
+	// CascadeDeletion deletes the twin holding the edge as well.
+	CascadeDeletion DeletionPolicy = "Cascade"
+	// NullifyEdge removes the edge from the twin holding it.
+	NullifyEdge DeletionPolicy = "Nullify"
+)
+
+// Content kinds follow DTDL: telemetry is a stream of samples, a property is
+// state that can be read at any time and, when writable, set by services.
+const (
+	Telemetry ContentKind = "telemetry"
+	Property  ContentKind = "property"
+)
+
 // TwinClassSpec defines the desired state of TwinClass
 type TwinClassSpec struct {
+	// ID is the DTMI of this version of the class, such as
+	// dtmi:acme:Factory;2. Its last path segment must equal Name. Once set,
+	// the spec cannot change: edits go into a new TwinClass with a higher
+	// version.
+	// +kubebuilder:validation:Pattern=`^dtmi:[A-Za-z](?:[A-Za-z0-9_]*[A-Za-z0-9])?(?::[A-Za-z](?:[A-Za-z0-9_]*[A-Za-z0-9])?)*;[1-9][0-9]{0,8}$`
+	ID            string                `json:"id,omitempty"`
 	Name          string                `json:"name"`
 	Attributes    []TwinClassAttributes `json:"attributes,omitempty"`
 	Relationships []TwinRelationship    `json:"relationships,omitempty"`
+	Commands      []TwinCommand         `json:"commands,omitempty"`
+	// Components embed other twin classes under a named slot. Their contents
+	// become part of this class rather than separate twins.
+	Components []TwinComponent `json:"components,omitempty"`
+	// HistoryRetention is how long the history store keeps the telemetry
+	// and reported properties of twins of the class. The history store
+	// default applies when unset.
+	HistoryRetention *metav1.Duration `json:"historyRetention,omitempty"`
 }
 
 type TwinClassAttributes struct {
-	Name string `json:"name,omitempty"`
+	Name       string `json:"name,omitempty"`
+	TwinSchema `json:",inline"`
+	// +kubebuilder:validation:Enum=telemetry;property
+	// +kubebuilder:default=property
+	Kind ContentKind `json:"kind,omitempty"`
+	// Writable marks a property that services may set.
+	Writable bool `json:"writable,omitempty"`
+	// SemanticType is the DTDL semantic type of a numeric attribute, such as
+	// Temperature.
+	SemanticType string `json:"semanticType,omitempty"`
+	// Unit is the DTDL unit values of the attribute are expressed in, such
+	// as degreeCelsius. It must be a unit of the semantic type.
+	Unit string `json:"unit,omitempty"`
+	// FieldNumber pins the protobuf field number of the attribute. When unset
+	// the operator assigns the next free number.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=536870911
+	FieldNumber int32 `json:"fieldNumber,omitempty"`
+}
+
+// TwinSchema describes the values of an attribute, a command payload or an
+// object field. Arrays, maps and objects nest further schemas; map keys are
+// strings.
+//
+// The nested schemas are not validated by the API server, since CRD schemas
+// cannot be recursive. The operator validates them instead and reports
+// errors with their field path.
+type TwinSchema struct {
 	Type string `json:"type,omitempty"`
+	// Reference names the TwinEnum providing the values of an enumeration.
+	Reference string `json:"reference,omitempty"`
+	// Items is the schema of the elements of an array.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Items *TwinSchema `json:"items,omitempty"`
+	// Values is the schema of the values of a map.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Values *TwinSchema `json:"values,omitempty"`
+	// Fields are the fields of an object.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Fields []TwinSchemaField `json:"fields,omitempty"`
+}
+
+type TwinSchemaField struct {
+	Name       string `json:"name"`
+	TwinSchema `json:",inline"`
+	// FieldNumber pins the protobuf field number of the field within the
+	// message generated for its object.
+	FieldNumber int32 `json:"fieldNumber,omitempty"`
+}
+
+// TwinCommand is an operation invoked on a twin through a request topic and
+// answered on a response topic.
+type TwinCommand struct {
+	Name     string              `json:"name"`
+	Request  *TwinCommandPayload `json:"request,omitempty"`
+	Response *TwinCommandPayload `json:"response,omitempty"`
+}
+
+type TwinCommandPayload struct {
+	Name       string `json:"name"`
+	TwinSchema `json:",inline"`
+}
+
+// TwinComponent embeds the TwinClass named by Class under the slot Name.
+type TwinComponent struct {
+	Name  string `json:"name"`
+	Class string `json:"class"`
 }
 
 type TwinRelationship struct {
-	Name         string       `json:"name,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Multiplicity is the original two-valued cardinality. The conversion to
+	// v1 maps one to a MaxMultiplicity of 1 and many to no upper bound.
+	//
+	// Deprecated: use MinMultiplicity and MaxMultiplicity.
+	// +kubebuilder:validation:Enum=one;many
 	Multiplicity Multiplicity `json:"multiplicity,omitempty"`
-	Reference    string       `json:"ref,omitempty"`
+	// Reference is the original single target class. The conversion to v1
+	// moves it to Targets.
+	//
+	// Deprecated: use Targets.
+	Reference string `json:"ref,omitempty"`
+	// MinMultiplicity is the least number of twins an instance must relate
+	// to.
+	// +kubebuilder:validation:Minimum=0
+	MinMultiplicity int32 `json:"minMultiplicity,omitempty"`
+	// MaxMultiplicity is the greatest number of twins an instance may relate
+	// to. There is no upper bound when unset.
+	// +kubebuilder:validation:Minimum=1
+	MaxMultiplicity *int32 `json:"maxMultiplicity,omitempty"`
+	// Targets lists the twin classes related twins may belong to. Any class
+	// is allowed when empty.
+	Targets []string `json:"targets,omitempty"`
+	// Properties describe the edge itself, such as the date a machine was
+	// installed in a factory.
+	Properties []TwinSchemaField `json:"properties,omitempty"`
+	// Inverse names the relationship of the target classes holding the
+	// edges back to the twins of this class. The operator adds and removes
+	// them as edges of this relationship change, and the other way around.
+	Inverse string `json:"inverse,omitempty"`
+	// OnDelete chooses what happens to an edge when its target is deleted.
+	// The edge is kept and reported as dangling when unset.
+	// +kubebuilder:validation:Enum=Block;Cascade;Nullify
+	OnDelete DeletionPolicy `json:"onDelete,omitempty"`
 }
 
 // TwinClassStatus defines the observed state of TwinClass
 type TwinClassStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// FieldNumbers records the protobuf field number assigned to each
+	// attribute and relationship. Fields of nested objects are recorded
+	// under their dotted path, such as "position.x", and are numbered
+	// within the message of their object. Assignments are kept across edits.
+	FieldNumbers map[string]int32 `json:"fieldNumbers,omitempty"`
+	// ReservedFieldNumbers records the numbers of removed fields, which are
+	// never handed out to another field.
+	ReservedFieldNumbers map[string]int32 `json:"reservedFieldNumbers,omitempty"`
+	// SchemaConfigMap names the ConfigMap holding the generated schemas.
+	SchemaConfigMap string             `json:"schemaConfigMap,omitempty"`
+	Conditions      []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,56 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v0
+
+import (
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+// ConvertTo converts this TwinEnum to the v1 hub.
+func (src *TwinEnum) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*dtdlv1.TwinEnum)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.Spec = dtdlv1.TwinEnumSpec(*src.Spec.DeepCopy())
+	dst.Status = dtdlv1.TwinEnumStatus(*src.Status.DeepCopy())
+
+	stashed := dtdlv1.TwinEnumSpec{}
+	if ok, err := unstashSpec(&dst.ObjectMeta, V1SpecAnnotation, &stashed); err != nil {
+		return err
+	} else if ok && equality.Semantic.DeepEqual(TwinEnumSpec(stashed), src.Spec) {
+		dst.Spec = stashed
+	}
+	return nil
+}
+
+// ConvertFrom converts the v1 hub to this TwinEnum.
+func (dst *TwinEnum) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*dtdlv1.TwinEnum)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.Spec = TwinEnumSpec(*src.Spec.DeepCopy())
+	dst.Status = TwinEnumStatus(*src.Status.DeepCopy())
+
+	stashed := TwinEnumSpec{}
+	if ok, err := unstashSpec(&dst.ObjectMeta, V0SpecAnnotation, &stashed); err != nil {
+		return err
+	} else if ok && equality.Semantic.DeepEqual(dtdlv1.TwinEnumSpec(stashed), src.Spec) {
+		dst.Spec = stashed
+	}
+	return nil
+}
@@ -20,23 +20,19 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
-// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
-
 // TwinEnumSpec defines the desired state of TwinEnum
 type TwinEnumSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
-
-	// Foo is an example field of TwinEnum. Edit twinenum_types.go to remove/update
 	Name   string   `json:"name,omitempty"`
 	Values []string `json:"values,omitempty"`
 }
 
 // TwinEnumStatus defines the observed state of TwinEnum
 type TwinEnumStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ValueNumbers records the protobuf number assigned to each value.
+	// Assignments are kept across edits.
+	ValueNumbers map[string]int32 `json:"valueNumbers,omitempty"`
+	// ReservedValueNumbers records the numbers of removed values.
+	ReservedValueNumbers map[string]int32 `json:"reservedValueNumbers,omitempty"`
 }
 
 //+kubebuilder:object:root=true
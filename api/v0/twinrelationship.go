@@ -0,0 +1,66 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v0
+
+// Cardinality returns the least and greatest number of twins an instance of
+// the relationship relates to, honouring the original multiplicity when the
+// new fields are unset. A maximum of 0 means there is no upper bound.
+func (r *TwinRelationship) Cardinality() (int32, int32) {
+	if r.MaxMultiplicity != nil {
+		return r.MinMultiplicity, *r.MaxMultiplicity
+	}
+	if r.Multiplicity == ONE {
+		return r.MinMultiplicity, 1
+	}
+	return r.MinMultiplicity, 0
+}
+
+// AllowedTargets returns the classes related twins may belong to, including
+// the original single target.
+func (r *TwinRelationship) AllowedTargets() []string {
+	targets := append([]string(nil), r.Targets...)
+	if r.Reference == "" {
+		return targets
+	}
+	for _, target := range targets {
+		if target == r.Reference {
+			return targets
+		}
+	}
+	return append(targets, r.Reference)
+}
+
+// MigrateLegacyFields replaces Multiplicity and Reference with the fields
+// superseding them. A Multiplicity contradicting MaxMultiplicity is kept so
+// that validation can report the conflict.
+func (r *TwinRelationship) MigrateLegacyFields() {
+	switch {
+	case r.Multiplicity == ONE && r.MaxMultiplicity == nil:
+		one := int32(1)
+		r.MaxMultiplicity = &one
+		r.Multiplicity = ""
+	case r.Multiplicity == ONE && *r.MaxMultiplicity == 1:
+		r.Multiplicity = ""
+	case r.Multiplicity == MANY && (r.MaxMultiplicity == nil || *r.MaxMultiplicity > 1):
+		r.Multiplicity = ""
+	}
+
+	if r.Reference != "" {
+		r.Targets = r.AllowedTargets()
+		r.Reference = ""
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v0
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+// ConvertTo converts this TwinService to the v1 hub. Class entries are split
+// into the class and its version constraint.
+func (src *TwinService) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*dtdlv1.TwinService)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.Spec = twinServiceSpecToV1(&src.Spec)
+	dst.Status = twinServiceStatusToV1(&src.Status)
+
+	stashed := dtdlv1.TwinServiceSpec{}
+	if ok, err := unstashSpec(&dst.ObjectMeta, V1SpecAnnotation, &stashed); err != nil {
+		return err
+	} else if ok && equality.Semantic.DeepEqual(twinServiceSpecFromV1(&stashed), src.Spec) {
+		dst.Spec = stashed
+	}
+	if !equality.Semantic.DeepEqual(twinServiceSpecFromV1(&dst.Spec), src.Spec) {
+		return stashSpec(&dst.ObjectMeta, V0SpecAnnotation, &src.Spec)
+	}
+	return nil
+}
+
+// ConvertFrom converts the v1 hub to this TwinService.
+func (dst *TwinService) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*dtdlv1.TwinService)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.Spec = twinServiceSpecFromV1(&src.Spec)
+	dst.Status = twinServiceStatusFromV1(&src.Status)
+
+	stashed := TwinServiceSpec{}
+	if ok, err := unstashSpec(&dst.ObjectMeta, V0SpecAnnotation, &stashed); err != nil {
+		return err
+	} else if ok && equality.Semantic.DeepEqual(twinServiceSpecToV1(&stashed), src.Spec) {
+		dst.Spec = stashed
+	}
+	if !equality.Semantic.DeepEqual(twinServiceSpecToV1(&dst.Spec), src.Spec) {
+		return stashSpec(&dst.ObjectMeta, V1SpecAnnotation, &src.Spec)
+	}
+	return nil
+}
+
+func twinServiceSpecToV1(in *TwinServiceSpec) dtdlv1.TwinServiceSpec {
+	out := dtdlv1.TwinServiceSpec{
+		DataSource: dtdlv1.DataEndpoint(in.DataSource),
+		DataTarget: dtdlv1.DataEndpoint(in.DataTarget),
+		Template:   *in.Template.DeepCopy(),
+	}
+	if in.Classes != nil {
+		out.Classes = make([]dtdlv1.TwinClassReference, len(in.Classes))
+		for i, class := range in.Classes {
+			name, version, _ := strings.Cut(class, ";")
+			out.Classes[i] = dtdlv1.TwinClassReference{Name: name, Version: version}
+		}
+	}
+	return out
+}
+
+func twinServiceSpecFromV1(in *dtdlv1.TwinServiceSpec) TwinServiceSpec {
+	out := TwinServiceSpec{
+		DataSource: string(in.DataSource),
+		DataTarget: string(in.DataTarget),
+		Template:   *in.Template.DeepCopy(),
+	}
+	if in.Classes != nil {
+		out.Classes = make([]string, len(in.Classes))
+		for i, class := range in.Classes {
+			out.Classes[i] = class.String()
+		}
+	}
+	return out
+}
+
+func twinServiceStatusToV1(in *TwinServiceStatus) dtdlv1.TwinServiceStatus {
+	out := dtdlv1.TwinServiceStatus{Conditions: in.DeepCopy().Conditions}
+	if in.Autoscaling != nil {
+		autoscaling := in.Autoscaling.DeepCopy()
+		out.Autoscaling = &dtdlv1.TwinServiceAutoscalingStatus{
+			MessagesPerSecond: autoscaling.MessagesPerSecond,
+			CurrentReplicas:   autoscaling.CurrentReplicas,
+			DesiredReplicas:   autoscaling.DesiredReplicas,
+			LastScaleTime:     autoscaling.LastScaleTime,
+		}
+		if autoscaling.Decisions != nil {
+			out.Autoscaling.Decisions = make([]dtdlv1.ScalingDecision, len(autoscaling.Decisions))
+			for i, decision := range autoscaling.Decisions {
+				out.Autoscaling.Decisions[i] = dtdlv1.ScalingDecision(decision)
+			}
+		}
+	}
+	return out
+}
+
+func twinServiceStatusFromV1(in *dtdlv1.TwinServiceStatus) TwinServiceStatus {
+	out := TwinServiceStatus{Conditions: in.DeepCopy().Conditions}
+	if in.Autoscaling != nil {
+		autoscaling := in.Autoscaling.DeepCopy()
+		out.Autoscaling = &TwinServiceAutoscalingStatus{
+			MessagesPerSecond: autoscaling.MessagesPerSecond,
+			CurrentReplicas:   autoscaling.CurrentReplicas,
+			DesiredReplicas:   autoscaling.DesiredReplicas,
+			LastScaleTime:     autoscaling.LastScaleTime,
+		}
+		if autoscaling.Decisions != nil {
+			out.Autoscaling.Decisions = make([]ScalingDecision, len(autoscaling.Decisions))
+			for i, decision := range autoscaling.Decisions {
+				out.Autoscaling.Decisions[i] = ScalingDecision(decision)
+			}
+		}
+	}
+	return out
+}
@@ -21,15 +21,12 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
-// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
-
 // TwinServiceSpec defines the desired state of TwinService
 type TwinServiceSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
-
-	// Foo is an example field of TwinService. Edit twinservice_types.go to remove/update
+	// Classes are the twin classes the service handles. Each entry is a
+	// class name or DTMI, optionally followed by a version or version range:
+	// Factory, dtmi:acme:Factory;2 or dtmi:acme:Factory;>=2,<4. The latest
+	// matching version is used.
 	Classes    []string               `json:"classes,omitempty"`
 	DataSource string                 `json:"dataSource,omitempty"`
 	DataTarget string                 `json:"dataTarget,omitempty"`
@@ -38,8 +35,39 @@ type TwinServiceSpec struct {
 
 // TwinServiceStatus defines the observed state of TwinService
 type TwinServiceStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Autoscaling is the state of the autoscaling of the workload, which is
+	// configured in v1.
+	Autoscaling *TwinServiceAutoscalingStatus `json:"autoscaling,omitempty"`
+	Conditions  []metav1.Condition            `json:"conditions,omitempty"`
+}
+
+// TwinServiceAutoscalingStatus is the latest measurement of the input rate
+// of a service and the scaling decisions taken on it.
+type TwinServiceAutoscalingStatus struct {
+	// MessagesPerSecond is the measured rate of the input of the service.
+	MessagesPerSecond string `json:"messagesPerSecond,omitempty"`
+	// CurrentReplicas is the replica count of the workload when measured.
+	CurrentReplicas int32 `json:"currentReplicas"`
+	// DesiredReplicas is the replica count the rate asks for.
+	DesiredReplicas int32 `json:"desiredReplicas"`
+	// LastScaleTime is when the workload was last scaled.
+	LastScaleTime *metav1.Time `json:"lastScaleTime,omitempty"`
+	// Decisions are the latest scaling decisions, the most recent last.
+	Decisions []ScalingDecision `json:"decisions,omitempty"`
+}
+
+// ScalingDecision records a change of the replicas of a workload.
+type ScalingDecision struct {
+	// Time is when the workload was scaled.
+	Time metav1.Time `json:"time"`
+	// From is the replica count before scaling.
+	From int32 `json:"from"`
+	// To is the replica count after scaling.
+	To int32 `json:"to"`
+	// MessagesPerSecond is the input rate the decision was taken on.
+	MessagesPerSecond string `json:"messagesPerSecond"`
+	// Reason explains the decision.
+	Reason string `json:"reason"`
 }
 
 //+kubebuilder:object:root=true
@@ -22,16 +22,33 @@ limitations under the License.
 package v0
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScalingDecision) DeepCopyInto(out *ScalingDecision) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScalingDecision.
+func (in *ScalingDecision) DeepCopy() *ScalingDecision {
+	if in == nil {
+		return nil
+	}
+	out := new(ScalingDecision)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TwinClass) DeepCopyInto(out *TwinClass) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinClass.
@@ -55,6 +72,7 @@ func (in *TwinClass) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TwinClassAttributes) DeepCopyInto(out *TwinClassAttributes) {
 	*out = *in
+	in.TwinSchema.DeepCopyInto(&out.TwinSchema)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinClassAttributes.
@@ -105,13 +123,34 @@ func (in *TwinClassSpec) DeepCopyInto(out *TwinClassSpec) {
 	if in.Attributes != nil {
 		in, out := &in.Attributes, &out.Attributes
 		*out = make([]TwinClassAttributes, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	if in.Relationships != nil {
 		in, out := &in.Relationships, &out.Relationships
 		*out = make([]TwinRelationship, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Commands != nil {
+		in, out := &in.Commands, &out.Commands
+		*out = make([]TwinCommand, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Components != nil {
+		in, out := &in.Components, &out.Components
+		*out = make([]TwinComponent, len(*in))
 		copy(*out, *in)
 	}
+	if in.HistoryRetention != nil {
+		in, out := &in.HistoryRetention, &out.HistoryRetention
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinClassSpec.
@@ -127,6 +166,27 @@ func (in *TwinClassSpec) DeepCopy() *TwinClassSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TwinClassStatus) DeepCopyInto(out *TwinClassStatus) {
 	*out = *in
+	if in.FieldNumbers != nil {
+		in, out := &in.FieldNumbers, &out.FieldNumbers
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ReservedFieldNumbers != nil {
+		in, out := &in.ReservedFieldNumbers, &out.ReservedFieldNumbers
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinClassStatus.
@@ -139,13 +199,69 @@ func (in *TwinClassStatus) DeepCopy() *TwinClassStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TwinCommand) DeepCopyInto(out *TwinCommand) {
+	*out = *in
+	if in.Request != nil {
+		in, out := &in.Request, &out.Request
+		*out = new(TwinCommandPayload)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Response != nil {
+		in, out := &in.Response, &out.Response
+		*out = new(TwinCommandPayload)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinCommand.
+func (in *TwinCommand) DeepCopy() *TwinCommand {
+	if in == nil {
+		return nil
+	}
+	out := new(TwinCommand)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TwinCommandPayload) DeepCopyInto(out *TwinCommandPayload) {
+	*out = *in
+	in.TwinSchema.DeepCopyInto(&out.TwinSchema)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinCommandPayload.
+func (in *TwinCommandPayload) DeepCopy() *TwinCommandPayload {
+	if in == nil {
+		return nil
+	}
+	out := new(TwinCommandPayload)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TwinComponent) DeepCopyInto(out *TwinComponent) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinComponent.
+func (in *TwinComponent) DeepCopy() *TwinComponent {
+	if in == nil {
+		return nil
+	}
+	out := new(TwinComponent)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TwinEnum) DeepCopyInto(out *TwinEnum) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinEnum.
@@ -221,6 +337,20 @@ func (in *TwinEnumSpec) DeepCopy() *TwinEnumSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TwinEnumStatus) DeepCopyInto(out *TwinEnumStatus) {
 	*out = *in
+	if in.ValueNumbers != nil {
+		in, out := &in.ValueNumbers, &out.ValueNumbers
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ReservedValueNumbers != nil {
+		in, out := &in.ReservedValueNumbers, &out.ReservedValueNumbers
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinEnumStatus.
@@ -236,6 +366,23 @@ func (in *TwinEnumStatus) DeepCopy() *TwinEnumStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TwinRelationship) DeepCopyInto(out *TwinRelationship) {
 	*out = *in
+	if in.MaxMultiplicity != nil {
+		in, out := &in.MaxMultiplicity, &out.MaxMultiplicity
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Properties != nil {
+		in, out := &in.Properties, &out.Properties
+		*out = make([]TwinSchemaField, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinRelationship.
@@ -248,13 +395,61 @@ func (in *TwinRelationship) DeepCopy() *TwinRelationship {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TwinSchema) DeepCopyInto(out *TwinSchema) {
+	*out = *in
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = new(TwinSchema)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = new(TwinSchema)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Fields != nil {
+		in, out := &in.Fields, &out.Fields
+		*out = make([]TwinSchemaField, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinSchema.
+func (in *TwinSchema) DeepCopy() *TwinSchema {
+	if in == nil {
+		return nil
+	}
+	out := new(TwinSchema)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TwinSchemaField) DeepCopyInto(out *TwinSchemaField) {
+	*out = *in
+	in.TwinSchema.DeepCopyInto(&out.TwinSchema)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinSchemaField.
+func (in *TwinSchemaField) DeepCopy() *TwinSchemaField {
+	if in == nil {
+		return nil
+	}
+	out := new(TwinSchemaField)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TwinService) DeepCopyInto(out *TwinService) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinService.
@@ -275,6 +470,32 @@ func (in *TwinService) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TwinServiceAutoscalingStatus) DeepCopyInto(out *TwinServiceAutoscalingStatus) {
+	*out = *in
+	if in.LastScaleTime != nil {
+		in, out := &in.LastScaleTime, &out.LastScaleTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Decisions != nil {
+		in, out := &in.Decisions, &out.Decisions
+		*out = make([]ScalingDecision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinServiceAutoscalingStatus.
+func (in *TwinServiceAutoscalingStatus) DeepCopy() *TwinServiceAutoscalingStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TwinServiceAutoscalingStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TwinServiceList) DeepCopyInto(out *TwinServiceList) {
 	*out = *in
@@ -331,6 +552,18 @@ func (in *TwinServiceSpec) DeepCopy() *TwinServiceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TwinServiceStatus) DeepCopyInto(out *TwinServiceStatus) {
 	*out = *in
+	if in.Autoscaling != nil {
+		in, out := &in.Autoscaling, &out.Autoscaling
+		*out = new(TwinServiceAutoscalingStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TwinServiceStatus.
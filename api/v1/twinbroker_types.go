@@ -0,0 +1,71 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TwinBrokerSpec defines the desired state of TwinBroker
+type TwinBrokerSpec struct {
+	// Service names the TwinService the broker is dedicated to. The broker
+	// serves every MQTT TwinService of its namespace when empty.
+	Service string `json:"service,omitempty"`
+}
+
+// TwinBrokerStatus defines the observed state of TwinBroker
+type TwinBrokerStatus struct {
+	// Address is the URL twins and services connect to.
+	Address string `json:"address,omitempty"`
+	// Services lists the MQTT TwinServices the ACL of the broker grants
+	// access to.
+	Services   []string           `json:"services,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.spec.service`
+//+kubebuilder:printcolumn:name="Address",type=string,JSONPath=`.status.address`
+
+// TwinBroker is an MQTT broker of a namespace, or of a single TwinService,
+// managed by the operator when brokers are not shared by the cluster. The
+// broker resources are owned by the TwinBroker, which is owned by the
+// TwinServices it serves, so that they are deleted with the last of them.
+// State ingestion, history and autoscaling do not read TwinBrokers, and
+// cannot be enabled with them.
+type TwinBroker struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TwinBrokerSpec   `json:"spec,omitempty"`
+	Status TwinBrokerStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TwinBrokerList contains a list of TwinBroker
+type TwinBrokerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TwinBroker `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TwinBroker{}, &TwinBrokerList{})
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PrimitiveType is the type of the values described by a TwinSchema.
+// +kubebuilder:validation:Enum=integer;string;boolean;double;enumeration;array;map;object
+type PrimitiveType string
+
+// ContentKind tells telemetry from properties.
+// +kubebuilder:validation:Enum=telemetry;property
+type ContentKind string
+
+// DeletionPolicy chooses what happens to the edges of a relationship when
+// the related twin is deleted.
+// +kubebuilder:validation:Enum=Block;Cascade;Nullify
+type DeletionPolicy string
+
+const (
+	// BlockDeletion keeps the related twin until the edge is removed.
+	BlockDeletion DeletionPolicy = "Block"
+	// CascadeDeletion deletes the twin holding the edge as well.
+	CascadeDeletion DeletionPolicy = "Cascade"
+	// NullifyEdge removes the edge from the twin holding it.
+	NullifyEdge DeletionPolicy = "Nullify"
+)
+
+const (
+	Integer PrimitiveType = "integer"
+	String  PrimitiveType = "string"
+	Boolean PrimitiveType = "boolean"
+	Double  PrimitiveType = "double"
+
+	Enumeration PrimitiveType = "enumeration"
+
+	Array  PrimitiveType = "array"
+	Map    PrimitiveType = "map"
+	Object PrimitiveType = "object"
+)
+
+// Content kinds follow DTDL: telemetry is a stream of samples, a property is
+// state that can be read at any time and, when writable, set by services.
+const (
+	Telemetry ContentKind = "telemetry"
+	Property  ContentKind = "property"
+)
+
+// TwinClassSpec defines the desired state of TwinClass
+type TwinClassSpec struct {
+	// ID is the DTMI of this version of the class, such as
+	// dtmi:acme:Factory;2. Its last path segment must equal Name. Once set,
+	// the spec cannot change: edits go into a new TwinClass with a higher
+	// version.
+	// +kubebuilder:validation:Pattern=`^dtmi:[A-Za-z](?:[A-Za-z0-9_]*[A-Za-z0-9])?(?::[A-Za-z](?:[A-Za-z0-9_]*[A-Za-z0-9])?)*;[1-9][0-9]{0,8}$`
+	ID string `json:"id,omitempty"`
+	// Name is the class name used by topics, schemas and references from
+	// other classes.
+	Name          string             `json:"name"`
+	Attributes    []TwinAttribute    `json:"attributes,omitempty"`
+	Relationships []TwinRelationship `json:"relationships,omitempty"`
+	Commands      []TwinCommand      `json:"commands,omitempty"`
+	// Components embed other twin classes under a named slot. Their contents
+	// become part of this class rather than separate twins.
+	Components []TwinComponent `json:"components,omitempty"`
+	// HistoryRetention is how long the history store keeps the telemetry
+	// and reported properties of twins of the class. The history store
+	// default applies when unset.
+	HistoryRetention *metav1.Duration `json:"historyRetention,omitempty"`
+}
+
+// TwinAttribute is a telemetry stream or a property of a twin.
+type TwinAttribute struct {
+	Name       string `json:"name"`
+	TwinSchema `json:",inline"`
+	// +kubebuilder:default=property
+	Kind ContentKind `json:"kind,omitempty"`
+	// Writable marks a property that services may set.
+	Writable bool `json:"writable,omitempty"`
+	// SemanticType is the DTDL semantic type of a numeric attribute, such as
+	// Temperature.
+	SemanticType string `json:"semanticType,omitempty"`
+	// Unit is the DTDL unit values of the attribute are expressed in, such
+	// as degreeCelsius. It must be a unit of the semantic type.
+	Unit string `json:"unit,omitempty"`
+	// FieldNumber pins the protobuf field number of the attribute. When unset
+	// the operator assigns the next free number.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=536870911
+	FieldNumber int32 `json:"fieldNumber,omitempty"`
+}
+
+// TwinSchema describes the values of an attribute, a command payload or an
+// object field. Arrays, maps and objects nest further schemas; map keys are
+// strings.
+//
+// The nested schemas are not validated by the API server, since CRD schemas
+// cannot be recursive. The operator validates them instead and reports
+// errors with their field path.
+type TwinSchema struct {
+	Type PrimitiveType `json:"type,omitempty"`
+	// Reference names the TwinEnum providing the values of an enumeration.
+	Reference string `json:"reference,omitempty"`
+	// Items is the schema of the elements of an array.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Items *TwinSchema `json:"items,omitempty"`
+	// Values is the schema of the values of a map.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Values *TwinSchema `json:"values,omitempty"`
+	// Fields are the fields of an object.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Fields []TwinSchemaField `json:"fields,omitempty"`
+}
+
+// TwinSchemaField is a named field of an object schema.
+type TwinSchemaField struct {
+	Name       string `json:"name"`
+	TwinSchema `json:",inline"`
+	// FieldNumber pins the protobuf field number of the field within the
+	// message generated for its object.
+	FieldNumber int32 `json:"fieldNumber,omitempty"`
+}
+
+// TwinCommand is an operation invoked on a twin through a request topic and
+// answered on a response topic.
+type TwinCommand struct {
+	Name     string              `json:"name"`
+	Request  *TwinCommandPayload `json:"request,omitempty"`
+	Response *TwinCommandPayload `json:"response,omitempty"`
+}
+
+// TwinCommandPayload is the named value carried by a command request or
+// response.
+type TwinCommandPayload struct {
+	Name       string `json:"name"`
+	TwinSchema `json:",inline"`
+}
+
+// TwinComponent embeds the TwinClass named by Class under the slot Name.
+type TwinComponent struct {
+	Name  string `json:"name"`
+	Class string `json:"class"`
+}
+
+// TwinRelationship links the twins of a class to other twins.
+type TwinRelationship struct {
+	Name string `json:"name"`
+	// MinMultiplicity is the least number of twins an instance must relate
+	// to.
+	// +kubebuilder:validation:Minimum=0
+	MinMultiplicity int32 `json:"minMultiplicity,omitempty"`
+	// MaxMultiplicity is the greatest number of twins an instance may relate
+	// to. There is no upper bound when unset.
+	// +kubebuilder:validation:Minimum=1
+	MaxMultiplicity *int32 `json:"maxMultiplicity,omitempty"`
+	// Targets lists the twin classes related twins may belong to. Any class
+	// is allowed when empty.
+	Targets []string `json:"targets,omitempty"`
+	// Properties describe the edge itself, such as the date a machine was
+	// installed in a factory.
+	Properties []TwinSchemaField `json:"properties,omitempty"`
+	// Inverse names the relationship of the target classes holding the
+	// edges back to the twins of this class. The operator adds and removes
+	// them as edges of this relationship change, and the other way around.
+	Inverse string `json:"inverse,omitempty"`
+	// OnDelete chooses what happens to an edge when its target is deleted.
+	// The edge is kept and reported as dangling when unset.
+	OnDelete DeletionPolicy `json:"onDelete,omitempty"`
+}
+
+// TwinClassStatus defines the observed state of TwinClass
+type TwinClassStatus struct {
+	// FieldNumbers records the protobuf field number assigned to each
+	// attribute and relationship. Fields of nested objects are recorded
+	// under their dotted path, such as "position.x", and are numbered
+	// within the message of their object. Assignments are kept across edits.
+	FieldNumbers map[string]int32 `json:"fieldNumbers,omitempty"`
+	// ReservedFieldNumbers records the numbers of removed fields, which are
+	// never handed out to another field.
+	ReservedFieldNumbers map[string]int32 `json:"reservedFieldNumbers,omitempty"`
+	// SchemaConfigMap names the ConfigMap holding the generated schemas.
+	SchemaConfigMap string             `json:"schemaConfigMap,omitempty"`
+	Conditions      []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+
+// TwinClass describes a kind of digital twin: its telemetry, properties,
+// relationships, commands and components.
+type TwinClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TwinClassSpec   `json:"spec,omitempty"`
+	Status TwinClassStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TwinClassList contains a list of TwinClass
+type TwinClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TwinClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TwinClass{}, &TwinClassList{})
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TwinEnumSpec defines the desired state of TwinEnum
+type TwinEnumSpec struct {
+	// Name is the enum name referenced by enumeration schemas.
+	Name string `json:"name"`
+	// Values are the symbols of the enum.
+	Values []string `json:"values,omitempty"`
+}
+
+// TwinEnumStatus defines the observed state of TwinEnum
+type TwinEnumStatus struct {
+	// ValueNumbers records the protobuf number assigned to each value.
+	// Assignments are kept across edits.
+	ValueNumbers map[string]int32 `json:"valueNumbers,omitempty"`
+	// ReservedValueNumbers records the numbers of removed values.
+	ReservedValueNumbers map[string]int32 `json:"reservedValueNumbers,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+
+// TwinEnum is a set of symbols shared by the enumeration schemas of twin
+// classes.
+type TwinEnum struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TwinEnumSpec   `json:"spec,omitempty"`
+	Status TwinEnumStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TwinEnumList contains a list of TwinEnum
+type TwinEnumList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TwinEnum `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TwinEnum{}, &TwinEnumList{})
+}
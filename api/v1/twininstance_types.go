@@ -0,0 +1,107 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TwinInstanceSpec defines the desired state of TwinInstance
+type TwinInstanceSpec struct {
+	// Class selects the twin class the instance belongs to.
+	Class TwinClassReference `json:"class"`
+	// Attributes hold the values of the class properties, keyed by property
+	// name. Properties of components are named <slot>/<name>. Telemetry is
+	// reported by the twin itself and cannot be set.
+	Attributes map[string]apiextensionsv1.JSON `json:"attributes,omitempty"`
+	// Relationships hold the edges from the instance to other twins.
+	Relationships []TwinInstanceRelationship `json:"relationships,omitempty"`
+}
+
+// TwinInstanceRelationship holds the edges of one relationship of the class.
+type TwinInstanceRelationship struct {
+	// Name is the name of the relationship in the class.
+	Name string `json:"name"`
+	// Targets are the twins the instance relates to.
+	Targets []TwinInstanceEdge `json:"targets,omitempty"`
+}
+
+// TwinInstanceEdge relates an instance to another twin.
+type TwinInstanceEdge struct {
+	// Name is the TwinInstance the edge leads to, in the namespace of the
+	// instance.
+	Name string `json:"name"`
+	// Properties hold the values of the relationship properties, keyed by
+	// property name.
+	Properties map[string]apiextensionsv1.JSON `json:"properties,omitempty"`
+}
+
+// TwinInstanceStatus defines the observed state of TwinInstance
+type TwinInstanceStatus struct {
+	// TwinClass names the TwinClass the class reference resolved to.
+	TwinClass string `json:"twinClass,omitempty"`
+	// ClassID is the DTMI of the resolved class, when it has one.
+	ClassID string `json:"classID,omitempty"`
+	// DanglingEdges lists the edges whose target TwinInstance does not
+	// exist.
+	DanglingEdges []DanglingEdge `json:"danglingEdges,omitempty"`
+	// Reported holds the latest values the twin published on the broker,
+	// keyed by attribute name, when state ingestion is enabled.
+	Reported map[string]apiextensionsv1.JSON `json:"reported,omitempty"`
+	// LastSeen is when the twin last published on the broker.
+	LastSeen   *metav1.Time       `json:"lastSeen,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// DanglingEdge is an edge whose target TwinInstance does not exist.
+type DanglingEdge struct {
+	// Relationship is the name of the relationship holding the edge.
+	Relationship string `json:"relationship"`
+	// Target is the name of the missing TwinInstance.
+	Target string `json:"target"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Class",type=string,JSONPath=`.spec.class.name`
+//+kubebuilder:printcolumn:name="Resolved",type=string,JSONPath=`.status.conditions[?(@.type=="Resolved")].status`
+//+kubebuilder:printcolumn:name="Last Seen",type=date,JSONPath=`.status.lastSeen`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// TwinInstance is a digital twin of a given class: the values of its
+// properties and its relationships to other twins.
+type TwinInstance struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TwinInstanceSpec   `json:"spec,omitempty"`
+	Status TwinInstanceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TwinInstanceList contains a list of TwinInstance
+type TwinInstanceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TwinInstance `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TwinInstance{}, &TwinInstanceList{})
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RecordingPhase is the stage a recording or a replay is in.
+type RecordingPhase string
+
+const (
+	// RecordingPending waits for the recorder or the replayer to start.
+	RecordingPending RecordingPhase = "Pending"
+	// RecordingRunning records or replays messages.
+	RecordingRunning RecordingPhase = "Running"
+	// RecordingStopped was stopped before its end.
+	RecordingStopped RecordingPhase = "Stopped"
+	// RecordingCompleted ran to its end.
+	RecordingCompleted RecordingPhase = "Completed"
+	// RecordingFailed gave up after the recorder or the replayer failed.
+	RecordingFailed RecordingPhase = "Failed"
+)
+
+// TwinRecordingSpec defines the desired state of TwinRecording
+type TwinRecordingSpec struct {
+	// Service names the TwinService of the namespace whose input is
+	// recorded: the telemetry, reported properties and command responses of
+	// its classes.
+	Service string `json:"service"`
+	// Duration stops the recording after the given time. The recording
+	// runs until stopped when unset.
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// Stop ends the recording, keeping what was recorded.
+	Stop bool `json:"stop,omitempty"`
+	// StorageSize is the size of the volume claimed for the recording.
+	// Defaults to 1Gi.
+	StorageSize *resource.Quantity `json:"storageSize,omitempty"`
+}
+
+// TwinRecordingStatus defines the observed state of TwinRecording
+type TwinRecordingStatus struct {
+	Phase RecordingPhase `json:"phase,omitempty"`
+	// Topics are the subscription filters the recorder records.
+	Topics []string `json:"topics,omitempty"`
+	// ClaimName names the PersistentVolumeClaim holding the recording.
+	ClaimName string `json:"claimName,omitempty"`
+	// StartedAt is when the recorder was started.
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// CompletedAt is when the recording ended.
+	CompletedAt *metav1.Time       `json:"completedAt,omitempty"`
+	Conditions  []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Service",type=string,JSONPath=`.spec.service`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Started",type=date,JSONPath=`.status.startedAt`
+
+// TwinRecording records the MQTT traffic a TwinService reads to a volume,
+// so that it can be replayed with a TwinReplay.
+type TwinRecording struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TwinRecordingSpec   `json:"spec,omitempty"`
+	Status TwinRecordingStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TwinRecordingList contains a list of TwinRecording
+type TwinRecordingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TwinRecording `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TwinRecording{}, &TwinRecordingList{})
+}
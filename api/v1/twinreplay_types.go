@@ -0,0 +1,79 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TwinReplaySpec defines the desired state of TwinReplay
+type TwinReplaySpec struct {
+	// Recording names the TwinRecording of the namespace to replay. It is
+	// replayed once it has ended.
+	Recording string `json:"recording"`
+	// Speed multiplies the pace of the recording, such as 10 or 500m.
+	// Messages are published without delay when 0. Defaults to 1.
+	Speed *resource.Quantity `json:"speed,omitempty"`
+	// Rewrites replace topic prefixes, such as dt/production/ with
+	// dt/staging/. The first matching rewrite applies.
+	Rewrites []TopicRewrite `json:"rewrites,omitempty"`
+}
+
+// TopicRewrite replaces the prefix From of replayed topics with To.
+type TopicRewrite struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// TwinReplayStatus defines the observed state of TwinReplay
+type TwinReplayStatus struct {
+	Phase RecordingPhase `json:"phase,omitempty"`
+	// StartedAt is when the replayer was started.
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// CompletedAt is when the replay ended.
+	CompletedAt *metav1.Time       `json:"completedAt,omitempty"`
+	Conditions  []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Recording",type=string,JSONPath=`.spec.recording`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Started",type=date,JSONPath=`.status.startedAt`
+
+// TwinReplay publishes a TwinRecording back to the broker.
+type TwinReplay struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TwinReplaySpec   `json:"spec,omitempty"`
+	Status TwinReplayStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TwinReplayList contains a list of TwinReplay
+type TwinReplayList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TwinReplay `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TwinReplay{}, &TwinReplayList{})
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DataEndpoint is a system a twin service exchanges twin data with.
+type DataEndpoint string
+
+// MQTT is the operator-managed MQTT broker.
+const MQTT DataEndpoint = "mqtt"
+
+// TwinServiceSpec defines the desired state of TwinService
+type TwinServiceSpec struct {
+	// Classes select the twin classes the service handles.
+	Classes []TwinClassReference `json:"classes,omitempty"`
+	// DataSource is where the service reads twin data from.
+	DataSource DataEndpoint `json:"dataSource,omitempty"`
+	// DataTarget is where the service writes twin data to.
+	DataTarget DataEndpoint `json:"dataTarget,omitempty"`
+	// Template is the pod template of the service workload.
+	Template corev1.PodTemplateSpec `json:"template,omitempty"`
+	// Autoscaling scales the service workload with the rate of the messages
+	// the service reads.
+	Autoscaling *TwinServiceAutoscaling `json:"autoscaling,omitempty"`
+}
+
+// TwinServiceAutoscaling bounds the replicas of a service workload and sets
+// the message rate each replica should handle.
+type TwinServiceAutoscaling struct {
+	// ScaleTargetRef is the workload to scale. Defaults to the Deployment
+	// named after the service.
+	ScaleTargetRef *ScaleTargetReference `json:"scaleTargetRef,omitempty"`
+	// MinReplicas is the lower bound of the replicas. Defaults to 1.
+	//+kubebuilder:validation:Minimum=1
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// MaxReplicas is the upper bound of the replicas.
+	//+kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+	// TargetMessagesPerSecond is the rate of input messages a replica
+	// handles. The workload gets as many replicas as the rate of the
+	// telemetry, reported properties and command responses of the service
+	// classes needs.
+	TargetMessagesPerSecond resource.Quantity `json:"targetMessagesPerSecond"`
+	// ScaleDownStabilization is how long the rate must stay low before the
+	// workload is scaled down; the highest replica count recommended within
+	// it applies. Defaults to 5m.
+	ScaleDownStabilization *metav1.Duration `json:"scaleDownStabilization,omitempty"`
+}
+
+// ScaleTargetReference names a workload with a scale subresource in the
+// namespace of the service.
+type ScaleTargetReference struct {
+	// APIVersion of the workload. Defaults to apps/v1.
+	APIVersion string `json:"apiVersion,omitempty"`
+	// Kind of the workload. Defaults to Deployment.
+	Kind string `json:"kind,omitempty"`
+	// Name of the workload.
+	Name string `json:"name"`
+}
+
+// TwinClassReference selects a version of a twin class.
+type TwinClassReference struct {
+	// Name is a class name, such as Factory, or a DTMI without its version,
+	// such as dtmi:acme:Factory.
+	Name string `json:"name"`
+	// Version is a version, such as 2, or a version range, such as >=2,<4.
+	// The latest matching version is used; any version matches when empty.
+	Version string `json:"version,omitempty"`
+}
+
+// String returns the reference in the syntax of v0 class references, such
+// as dtmi:acme:Factory;>=2,<4.
+func (r TwinClassReference) String() string {
+	if r.Version == "" {
+		return r.Name
+	}
+	return r.Name + ";" + r.Version
+}
+
+// Workload returns the workload of the service: the scale target of its
+// autoscaling, or else the Deployment named after it.
+func (s *TwinService) Workload() ScaleTargetReference {
+	workload := ScaleTargetReference{APIVersion: "apps/v1", Kind: "Deployment", Name: s.Name}
+	if s.Spec.Autoscaling == nil || s.Spec.Autoscaling.ScaleTargetRef == nil {
+		return workload
+	}
+	ref := s.Spec.Autoscaling.ScaleTargetRef
+	workload.Name = ref.Name
+	if ref.APIVersion != "" {
+		workload.APIVersion = ref.APIVersion
+	}
+	if ref.Kind != "" {
+		workload.Kind = ref.Kind
+	}
+	return workload
+}
+
+// TwinServiceStatus defines the observed state of TwinService
+type TwinServiceStatus struct {
+	// Autoscaling is the state of the autoscaling of the workload.
+	Autoscaling *TwinServiceAutoscalingStatus `json:"autoscaling,omitempty"`
+	Conditions  []metav1.Condition            `json:"conditions,omitempty"`
+}
+
+// TwinServiceAutoscalingStatus is the latest measurement of the input rate
+// of a service and the scaling decisions taken on it.
+type TwinServiceAutoscalingStatus struct {
+	// MessagesPerSecond is the measured rate of the input of the service.
+	MessagesPerSecond string `json:"messagesPerSecond,omitempty"`
+	// CurrentReplicas is the replica count of the workload when measured.
+	CurrentReplicas int32 `json:"currentReplicas"`
+	// DesiredReplicas is the replica count the rate asks for.
+	DesiredReplicas int32 `json:"desiredReplicas"`
+	// LastScaleTime is when the workload was last scaled.
+	LastScaleTime *metav1.Time `json:"lastScaleTime,omitempty"`
+	// Decisions are the latest scaling decisions, the most recent last.
+	Decisions []ScalingDecision `json:"decisions,omitempty"`
+}
+
+// ScalingDecision records a change of the replicas of a workload.
+type ScalingDecision struct {
+	// Time is when the workload was scaled.
+	Time metav1.Time `json:"time"`
+	// From is the replica count before scaling.
+	From int32 `json:"from"`
+	// To is the replica count after scaling.
+	To int32 `json:"to"`
+	// MessagesPerSecond is the input rate the decision was taken on.
+	MessagesPerSecond string `json:"messagesPerSecond"`
+	// Reason explains the decision.
+	Reason string `json:"reason"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.status.autoscaling.currentReplicas`
+//+kubebuilder:printcolumn:name="Rate",type=string,JSONPath=`.status.autoscaling.messagesPerSecond`
+
+// TwinService runs a workload processing the twins of a set of classes.
+type TwinService struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TwinServiceSpec   `json:"spec,omitempty"`
+	Status TwinServiceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TwinServiceList contains a list of TwinService
+type TwinServiceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TwinService `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TwinService{}, &TwinServiceList{})
+}
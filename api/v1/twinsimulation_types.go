@@ -0,0 +1,133 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GeneratorType chooses how the simulated values of an attribute change
+// over time.
+// +kubebuilder:validation:Enum=randomWalk;sine;constant;enumCycle
+type GeneratorType string
+
+const (
+	// RandomWalkGenerator moves numbers by up to Step within Min and Max,
+	// flips booleans now and then and moves enumerations to a neighbouring
+	// value.
+	RandomWalkGenerator GeneratorType = "randomWalk"
+	// SineGenerator oscillates numbers between Min and Max over Period.
+	SineGenerator GeneratorType = "sine"
+	// ConstantGenerator always publishes Value.
+	ConstantGenerator GeneratorType = "constant"
+	// EnumCycleGenerator publishes the values of an enumeration in turn.
+	EnumCycleGenerator GeneratorType = "enumCycle"
+)
+
+// TwinSimulationSpec defines the desired state of TwinSimulation
+type TwinSimulationSpec struct {
+	// Classes select the twin classes to simulate.
+	// +kubebuilder:validation:MinItems=1
+	Classes []TwinSimulationClass `json:"classes"`
+	// Rate is the number of samples every virtual instance publishes per
+	// second, such as 2 or 500m. Defaults to 1.
+	Rate *resource.Quantity `json:"rate,omitempty"`
+	// Jitter delays every sample by a random duration of up to Jitter.
+	Jitter *metav1.Duration `json:"jitter,omitempty"`
+	// Burst, when set, makes every virtual instance publish several samples
+	// back to back at regular intervals.
+	Burst *TwinSimulationBurst `json:"burst,omitempty"`
+}
+
+// TwinSimulationClass simulates a number of virtual instances of a class.
+type TwinSimulationClass struct {
+	// Class selects the simulated class version.
+	Class TwinClassReference `json:"class"`
+	// Instances is the number of virtual instances of the class.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	Instances int32 `json:"instances,omitempty"`
+	// Generators choose the generator of attributes, keyed by attribute
+	// name. Properties of components are named <slot>/<name>. Attributes
+	// without a generator get the default generator of their type.
+	Generators map[string]TwinGenerator `json:"generators,omitempty"`
+}
+
+// TwinGenerator produces the simulated values of an attribute.
+type TwinGenerator struct {
+	Type GeneratorType `json:"type"`
+	// Min is the lowest value of numeric generators. Defaults to 0.
+	Min *resource.Quantity `json:"min,omitempty"`
+	// Max is the highest value of numeric generators. Defaults to 100.
+	Max *resource.Quantity `json:"max,omitempty"`
+	// Step is the largest change of a random walk per sample. Defaults to
+	// a hundredth of the range.
+	Step *resource.Quantity `json:"step,omitempty"`
+	// Period is the period of a sine. Defaults to 1m.
+	Period *metav1.Duration `json:"period,omitempty"`
+	// Value is the value of a constant generator, which must match the
+	// schema of the attribute.
+	Value *apiextensionsv1.JSON `json:"value,omitempty"`
+}
+
+// TwinSimulationBurst publishes Size samples back to back, instead of one,
+// every Every.
+type TwinSimulationBurst struct {
+	// +kubebuilder:validation:Minimum=1
+	Size  int32           `json:"size"`
+	Every metav1.Duration `json:"every"`
+}
+
+// TwinSimulationStatus defines the observed state of TwinSimulation
+type TwinSimulationStatus struct {
+	// Instances is the number of virtual instances the simulator publishes
+	// for.
+	Instances  int32              `json:"instances,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Instances",type=integer,JSONPath=`.status.instances`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// TwinSimulation runs a simulator publishing synthetic telemetry and
+// properties to the broker for virtual instances of twin classes, so that
+// TwinServices can be developed without real twins.
+type TwinSimulation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TwinSimulationSpec   `json:"spec,omitempty"`
+	Status TwinSimulationStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TwinSimulationList contains a list of TwinSimulation
+type TwinSimulationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TwinSimulation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TwinSimulation{}, &TwinSimulationList{})
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TwinSnapshotSpec defines the desired state of TwinSnapshot
+type TwinSnapshotSpec struct {
+	// IncludeInstances adds the TwinInstances of the namespace to the
+	// snapshot, next to its TwinClasses, TwinEnums and TwinServices.
+	IncludeInstances bool `json:"includeInstances,omitempty"`
+}
+
+// TwinSnapshotStatus defines the observed state of TwinSnapshot
+type TwinSnapshotStatus struct {
+	// TakenAt is when the resources were exported. A snapshot is taken once.
+	TakenAt *metav1.Time `json:"takenAt,omitempty"`
+	// ArchiveVersion is the format version of the archive.
+	ArchiveVersion string `json:"archiveVersion,omitempty"`
+	// ConfigMaps lists, in order, the ConfigMaps holding the chunks of the
+	// archive.
+	ConfigMaps []string `json:"configMaps,omitempty"`
+	// Size is the size of the archive in bytes.
+	Size int64 `json:"size,omitempty"`
+	// Counts holds the number of resources in the archive, keyed by kind.
+	Counts     map[string]int32   `json:"counts,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Size",type=integer,JSONPath=`.status.size`
+//+kubebuilder:printcolumn:name="Taken",type=date,JSONPath=`.status.takenAt`
+
+// TwinSnapshot is an archive of the twin model of a namespace, stored in a
+// chain of ConfigMaps, that can be restored into any namespace or cluster.
+type TwinSnapshot struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TwinSnapshotSpec   `json:"spec,omitempty"`
+	Status TwinSnapshotStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TwinSnapshotList contains a list of TwinSnapshot
+type TwinSnapshotList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TwinSnapshot `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TwinSnapshot{}, &TwinSnapshotList{})
+}
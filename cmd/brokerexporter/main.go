@@ -0,0 +1,81 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// brokerexporter exposes the statistics the Mosquitto broker publishes on
+// its $SYS topics in the Prometheus format. The operator runs it as a
+// sidecar of the broker.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/brokermetrics"
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+func main() {
+	var brokerAddr string
+	var bindAddr string
+	flag.StringVar(&brokerAddr, "broker-address", "tcp://localhost:1883", "The address of the broker whose statistics are exported.")
+	flag.StringVar(&bindAddr, "bind-address", fmt.Sprintf(":%d", brokermetrics.Port), "The address the metrics endpoint binds to.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	logger := ctrl.Log.WithName("brokerexporter")
+	ctx := log.IntoContext(ctrl.SetupSignalHandler(), logger)
+
+	exporter := brokermetrics.NewExporter()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	c := exporter.Subscribe(ctx, brokerAddr, topics.OperatorUsername+"-exporter", broker.CredentialsFromEnv())
+	defer c.Disconnect(250)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Addr:              bindAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdown)
+	}()
+
+	logger.Info("Exporting broker statistics", "broker", brokerAddr, "address", bindAddr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(err, "Problem serving broker metrics")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// dtgen generates typed Go packages from TwinClass and TwinEnum resources,
+// read either from manifest files or from a live cluster.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/gogen"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func main() {
+	var files fileList
+	var namespace string
+	var pkg string
+	var out string
+	flag.Var(&files, "f", "Manifest file or directory to read. May be repeated. Reads from the cluster when omitted.")
+	flag.StringVar(&namespace, "namespace", "", "Namespace to read from the cluster. All namespaces when empty, which must not define the same class or enum name.")
+	flag.StringVar(&pkg, "package", "twins", "Name of the generated Go package.")
+	flag.StringVar(&out, "out", ".", "Directory the generated files are written to.")
+	flag.Parse()
+
+	m, err := loadModel(files, namespace)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dtgen:", err)
+		os.Exit(1)
+	}
+
+	generated, err := gogen.Generate(m, gogen.Options{Package: pkg})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dtgen:", err)
+		os.Exit(1)
+	}
+
+	if err := writeFiles(out, generated); err != nil {
+		fmt.Fprintln(os.Stderr, "dtgen:", err)
+		os.Exit(1)
+	}
+}
+
+func loadModel(files []string, namespace string) (*model.Model, error) {
+	if len(files) > 0 {
+		manifests, err := model.LoadFiles(files...)
+		if err != nil {
+			return nil, err
+		}
+		return manifests.Model()
+	}
+
+	scheme := runtime.NewScheme()
+	if err := dtdlv0.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+
+	return model.Load(context.Background(), c, client.InNamespace(namespace))
+}
+
+func writeFiles(out string, files map[string][]byte) error {
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(out, name)
+		if err := os.WriteFile(path, files[name], 0o644); err != nil {
+			return err
+		}
+		fmt.Println(path)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// dtrecord records the MQTT traffic of twin topics to a file and replays
+// it. The operator runs it in the Jobs of TwinRecordings and TwinReplays.
+//
+//	dtrecord record -namespace plant -service monitor -duration 1h -o plant.dtrec
+//	dtrecord replay -f plant.dtrec -speed 10 -rewrite dt/plant/=dt/staging/
+//	dtrecord inspect -f plant.dtrec
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/recording"
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	ctrl.SetLogger(zap.New())
+	ctx := log.IntoContext(ctrl.SetupSignalHandler(), ctrl.Log.WithName("dtrecord"))
+
+	var err error
+	switch os.Args[1] {
+	case "record":
+		err = record(ctx, os.Args[2:])
+	case "replay":
+		err = replay(ctx, os.Args[2:])
+	case "inspect":
+		err = inspect(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dtrecord:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dtrecord record|replay|inspect [flags]")
+	os.Exit(2)
+}
+
+func record(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	address := flags.String("broker-address", "tcp://localhost:1883", "The address of the broker to record from.")
+	clientID := flags.String("client-id", topics.OperatorUsername+"-recorder", "The MQTT client ID of the recorder.")
+	out := flags.String("o", "", "Recording file to write. An existing recording is appended to.")
+	namespace := flags.String("namespace", "default", "Namespace whose twin topics are recorded, and of the TwinService. All its twin topics are recorded when neither -service nor -topic is given.")
+	service := flags.String("service", "", "TwinService whose input topics are recorded.")
+	duration := flags.Duration("duration", 0, "Stop recording after this time. Records until interrupted when 0.")
+	filters := stringList{}
+	flags.Var(&filters, "topic", "Topic filter to record, instead of the input of a TwinService. Must be below the twin topics of the namespace. Can be repeated.")
+	_ = flags.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("-o is required")
+	}
+	if *service != "" && len(filters) > 0 {
+		return fmt.Errorf("only one of -service and -topic can be given")
+	}
+	if *service == "" && len(filters) == 0 {
+		filters = append(filters, topics.NamespaceFilter(*namespace))
+	}
+
+	if *service != "" {
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+		if filters, err = recording.ServiceTopics(ctx, c, *namespace, *service); err != nil {
+			return err
+		}
+	}
+
+	writer, file, err := recording.Append(*out, time.Now())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	log.FromContext(ctx).Info("Recording", "topics", filters, "file", *out)
+	recorder := &recording.Recorder{Writer: writer, Namespace: *namespace, Credentials: broker.CredentialsFromEnv(), FlushInterval: time.Second}
+	if err := recorder.Record(ctx, *address, *clientID, filters); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func replay(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	address := flags.String("broker-address", "tcp://localhost:1883", "The address of the broker to replay to.")
+	clientID := flags.String("client-id", "dt-replayer", "The MQTT client ID of the replayer.")
+	in := flags.String("f", "", "Recording file to replay.")
+	speed := flags.Float64("speed", 1, "Pace of the replay relative to the recording. Publishes without delay when 0.")
+	rewriteFlags := stringList{}
+	flags.Var(&rewriteFlags, "rewrite", "Topic prefix rewrite, as from=to. Can be repeated; the first match applies.")
+	_ = flags.Parse(args)
+	if *in == "" {
+		return fmt.Errorf("-f is required")
+	}
+	if *speed < 0 {
+		return fmt.Errorf("-speed must not be negative")
+	}
+	rewrites := []recording.Rewrite{}
+	for _, value := range rewriteFlags {
+		rewrite, err := recording.ParseRewrite(value)
+		if err != nil {
+			return err
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+
+	file, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := recording.NewReader(file)
+	if err != nil {
+		return err
+	}
+
+	// Replayed messages are published as twins publish them, with the twin
+	// credentials of the namespace.
+	options := broker.CredentialsFromEnv().Apply(mqtt.NewClientOptions()).
+		AddBroker(*address).
+		SetClientID(*clientID)
+	c := mqtt.NewClient(options)
+	if token := c.Connect(); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	defer c.Disconnect(250)
+
+	replayer := &recording.Replayer{
+		Publish: func(message *recording.Message) error {
+			token := c.Publish(message.Topic, 0, message.Retained, message.Payload)
+			token.Wait()
+			return token.Error()
+		},
+		Speed:    *speed,
+		Rewrites: rewrites,
+	}
+	published, err := replayer.Replay(ctx, reader)
+	log.FromContext(ctx).Info("Replayed recording", "messages", published)
+	return err
+}
+
+func inspect(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	in := flags.String("f", "", "Recording file to inspect.")
+	_ = flags.Parse(args)
+	if *in == "" {
+		return fmt.Errorf("-f is required")
+	}
+
+	file, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := recording.NewReader(file)
+	if err != nil {
+		return err
+	}
+
+	start := reader.Start()
+	end := start
+	counts := map[string]int{}
+	total := 0
+	for {
+		message, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		counts[message.Topic]++
+		total++
+		end = message.At
+	}
+
+	fmt.Printf("Start:    %s\n", start.UTC().Format(time.RFC3339))
+	fmt.Printf("Duration: %s\n", end.Sub(start))
+	fmt.Printf("Messages: %d\n", total)
+	names := make([]string, 0, len(counts))
+	for topic := range counts {
+		names = append(names, topic)
+	}
+	sort.Strings(names)
+	for _, topic := range names {
+		fmt.Printf("%8d  %s\n", counts[topic], topic)
+	}
+	return nil
+}
+
+func newClient() (client.Client, error) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := dtdlv0.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(config, client.Options{Scheme: scheme})
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// dtsnapshot exports the twin model of a namespace into an archive file and
+// restores archive files, or the archives of TwinSnapshots, into a
+// namespace.
+//
+//	dtsnapshot export -namespace plant -instances -o plant.dtsnap
+//	dtsnapshot restore -namespace plant-copy -f plant.dtsnap -conflict rename
+//	dtsnapshot restore -namespace plant -snapshot before-upgrade -conflict overwrite
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/snapshot"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = export(os.Args[2:])
+	case "restore":
+		err = restore(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dtsnapshot:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dtsnapshot export|restore [flags]")
+	os.Exit(2)
+}
+
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	namespace := flags.String("namespace", "default", "Namespace to export.")
+	instances := flags.Bool("instances", false, "Export the TwinInstances as well.")
+	out := flags.String("o", "", "Archive file to write.")
+	_ = flags.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("-o is required")
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	archive, err := snapshot.Export(context.Background(), c, *namespace, *instances)
+	if err != nil {
+		return err
+	}
+	data, err := snapshot.Encode(archive)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		return err
+	}
+	counts := archive.Counts()
+	for _, kind := range []string{"TwinEnum", "TwinClass", "TwinService", "TwinInstance"} {
+		fmt.Printf("%s: %d\n", kind, counts[kind])
+	}
+	return nil
+}
+
+func restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	namespace := flags.String("namespace", "default", "Namespace to restore into.")
+	file := flags.String("f", "", "Archive file to restore.")
+	name := flags.String("snapshot", "", "TwinSnapshot whose archive is restored, instead of a file.")
+	snapshotNamespace := flags.String("snapshot-namespace", "", "Namespace of the TwinSnapshot. Defaults to -namespace.")
+	conflictNames := []string{}
+	for _, conflict := range snapshot.Conflicts {
+		conflictNames = append(conflictNames, string(conflict))
+	}
+	conflict := flags.String("conflict", string(snapshot.ConflictSkip),
+		"What to do with resources whose name is taken: "+strings.Join(conflictNames, ", ")+".")
+	_ = flags.Parse(args)
+	if (*file == "") == (*name == "") {
+		return fmt.Errorf("exactly one of -f and -snapshot is required")
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if *file != "" {
+		data, err = os.ReadFile(*file)
+	} else {
+		if *snapshotNamespace == "" {
+			*snapshotNamespace = *namespace
+		}
+		data, err = snapshot.ReadConfigMaps(context.Background(), c, *snapshotNamespace, *name)
+	}
+	if err != nil {
+		return err
+	}
+	archive, err := snapshot.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	outcomes, err := snapshot.Restore(context.Background(), c, archive, *namespace, snapshot.Conflict(*conflict))
+	for _, outcome := range outcomes {
+		if outcome.RenamedTo != "" {
+			fmt.Printf("%s/%s %s to %s\n", outcome.Kind, outcome.Name, outcome.Action, outcome.RenamedTo)
+		} else {
+			fmt.Printf("%s/%s %s\n", outcome.Kind, outcome.Name, outcome.Action)
+		}
+	}
+	return err
+}
+
+func newClient() (client.Client, error) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := dtdlv1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(config, client.Options{Scheme: scheme})
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// history records the telemetry and reported properties twins publish on
+// the broker to disk and serves range queries over them to callers allowed
+// to list the TwinInstances of the namespace they query. The operator runs
+// it as a StatefulSet when history is enabled.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/history"
+	"github.com/agwermann/dt-operator/pkg/state"
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+func main() {
+	var brokerAddr string
+	var dataDir string
+	var retentionConfig string
+	var defaultRetention time.Duration
+	var pruneInterval time.Duration
+	var bindAddr string
+	flag.StringVar(&brokerAddr, "broker-address", "tcp://mqtt-broker-service.mqtt:1883", "The address of the broker history is recorded from.")
+	flag.StringVar(&dataDir, "data-dir", "/data", "The directory history is stored in.")
+	flag.StringVar(&retentionConfig, "retention-config", "", "The retention configuration file. Only the default applies when empty.")
+	flag.DurationVar(&defaultRetention, "default-retention", 30*24*time.Hour, "The retention of classes without one.")
+	flag.DurationVar(&pruneInterval, "prune-interval", time.Hour, "How often history past its retention is dropped.")
+	flag.StringVar(&bindAddr, "bind-address", ":8080", "The address the query API binds to.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	logger := ctrl.Log.WithName("history")
+	ctx := log.IntoContext(ctrl.SetupSignalHandler(), logger)
+
+	store := history.NewStore(dataDir)
+	defer store.Close()
+
+	c := state.Subscribe(ctx, brokerAddr, topics.OperatorUsername+"-history", broker.CredentialsFromEnv(), func(topic string, payload []byte) {
+		if err := store.Record(topic, payload, time.Now()); err != nil {
+			logger.Error(err, "Unable to record message", "topic", topic)
+		}
+	})
+	defer c.Disconnect(250)
+
+	go prune(ctx, store, retentionConfig, defaultRetention, pruneInterval)
+
+	clientset, err := kubernetes.NewForConfig(ctrl.GetConfigOrDie())
+	if err != nil {
+		logger.Error(err, "Unable to create the client reviewing callers")
+		os.Exit(1)
+	}
+
+	server := &http.Server{
+		Addr:              bindAddr,
+		Handler:           &history.Server{Store: store, Now: time.Now, Clientset: clientset},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdown)
+	}()
+
+	logger.Info("Recording twin history", "broker", brokerAddr, "dataDir", dataDir, "address", bindAddr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(err, "Problem serving history queries")
+		os.Exit(1)
+	}
+}
+
+// prune drops history past its retention every interval, reading the
+// retention configuration each time so that changes to the mounted
+// ConfigMap apply without a restart.
+func prune(ctx context.Context, store *history.Store, config string, def, interval time.Duration) {
+	logger := log.FromContext(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := pruneOnce(store, config, def); err != nil {
+			logger.Error(err, "Unable to prune history")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruneOnce leaves the history untouched when the retention configuration
+// cannot be read, since the default may be shorter than the retention of
+// some classes.
+func pruneOnce(store *history.Store, config string, def time.Duration) error {
+	retention := &history.Retention{}
+	retention.Default.Duration = def
+	if config != "" {
+		loaded, err := history.LoadRetention(config, def)
+		if err != nil {
+			return err
+		}
+		retention = loaded
+	}
+	return store.Prune(time.Now(), retention.For)
+}
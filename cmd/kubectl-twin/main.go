@@ -0,0 +1,258 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-twin is a kubectl plugin exploring twin models and the services
+// using them. Installed on the PATH, it runs as kubectl twin.
+//
+//	kubectl twin describe class Machine -n plant
+//	kubectl twin graph -o mermaid -n plant
+//	kubectl twin graph -root Factory -f config/samples/
+//	kubectl twin validate -f models/
+//	kubectl twin services -A
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/diagram"
+	"github.com/agwermann/dt-operator/pkg/inspect"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+// fileList is a flag that can be repeated.
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "describe":
+		err = describe(os.Args[2:])
+	case "graph":
+		err = graph(os.Args[2:])
+	case "validate":
+		err = validate(os.Args[2:])
+	case "services":
+		err = services(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kubectl-twin:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: kubectl twin describe class|graph|validate|services [flags]")
+	os.Exit(2)
+}
+
+func describe(args []string) error {
+	flags := flag.NewFlagSet("describe", flag.ExitOnError)
+	namespace := flags.String("n", "", "Namespace of the model. Defaults to the namespace of the current context.")
+	files := fileList{}
+	flags.Var(&files, "f", "Manifest file or directory to read the model from, instead of the cluster. Can be repeated.")
+	positional := parseInterspersed(flags, args)
+	if len(positional) != 2 || positional[0] != "class" {
+		return fmt.Errorf("usage: kubectl twin describe class <name|dtmi>[;version] [flags]")
+	}
+
+	m, err := loadModel(files, *namespace)
+	if err != nil {
+		return err
+	}
+	return inspect.DescribeClass(os.Stdout, m, positional[1])
+}
+
+func graph(args []string) error {
+	flags := flag.NewFlagSet("graph", flag.ExitOnError)
+	namespace := flags.String("n", "", "Namespace of the model. Defaults to the namespace of the current context.")
+	files := fileList{}
+	flags.Var(&files, "f", "Manifest file or directory to read the model from, instead of the cluster. Can be repeated.")
+	output := flags.String("o", "tree", "Output format: tree, dot, mermaid or svg.")
+	root := flags.String("root", "", "Class the graph starts from. Every class no other class leads to when empty.")
+	_ = parseInterspersed(flags, args)
+
+	m, err := loadModel(files, *namespace)
+	if err != nil {
+		return err
+	}
+	if *output == "tree" {
+		return inspect.WriteTree(os.Stdout, inspect.ClassGraph(m), *root)
+	}
+
+	writers := map[string]func(io.Writer, *diagram.Diagram) error{
+		"dot":     diagram.WriteDOT,
+		"mermaid": diagram.WriteMermaid,
+		"svg":     diagram.WriteSVG,
+	}
+	write, ok := writers[*output]
+	if !ok {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+	d, err := diagram.Build(m, *root)
+	if err != nil {
+		return err
+	}
+	return write(os.Stdout, d)
+}
+
+func validate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	files := fileList{}
+	flags.Var(&files, "f", "Manifest file or directory of the model. Can be repeated.")
+	_ = parseInterspersed(flags, args)
+	if len(files) == 0 {
+		return fmt.Errorf("-f is required")
+	}
+
+	manifests, err := model.LoadFiles(files...)
+	if err != nil {
+		return err
+	}
+	problems := inspect.Validate(manifests)
+	inspect.WriteProblems(os.Stdout, manifests, problems)
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems found", len(problems))
+	}
+	return nil
+}
+
+func services(args []string) error {
+	flags := flag.NewFlagSet("services", flag.ExitOnError)
+	namespace := flags.String("n", "", "Namespace of the services. Defaults to the namespace of the current context.")
+	all := flags.Bool("A", false, "List the services of all namespaces.")
+	brokerAddress := flags.String("broker", broker.ClusterScope.For("", "").Address(), "Address of the broker of services using MQTT in the cluster scope.")
+	brokerScope := flags.String("broker-scope", string(broker.ClusterScope), "Broker scope of the operator: cluster, namespace or service.")
+	_ = parseInterspersed(flags, args)
+
+	scope, err := broker.ParseScope(*brokerScope)
+	if err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	opts := []client.ListOption{}
+	if !*all {
+		if *namespace, err = currentNamespace(*namespace); err != nil {
+			return err
+		}
+		opts = append(opts, client.InNamespace(*namespace))
+	}
+	twinServices := &dtdlv0.TwinServiceList{}
+	if err := c.List(ctx, twinServices, opts...); err != nil {
+		return err
+	}
+
+	models := map[string]*model.Model{}
+	if *all {
+		if models, err = model.LoadByNamespace(ctx, c); err != nil {
+			return err
+		}
+	} else if models[*namespace], err = model.Load(ctx, c, opts...); err != nil {
+		return err
+	}
+	return inspect.WriteServices(os.Stdout, twinServices.Items, models, broker.Addresses{Scope: scope, Default: *brokerAddress})
+}
+
+// parseInterspersed parses flags given before, between and after the
+// positional arguments, as kubectl does, and returns the positional ones.
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	positional := []string{}
+	for {
+		_ = flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func loadModel(files []string, namespace string) (*model.Model, error) {
+	if len(files) > 0 {
+		manifests, err := model.LoadFiles(files...)
+		if err != nil {
+			return nil, err
+		}
+		return manifests.Model()
+	}
+
+	namespace, err := currentNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	return model.Load(context.Background(), c, client.InNamespace(namespace))
+}
+
+// currentNamespace defaults an empty namespace to the one of the current
+// kubeconfig context.
+func currentNamespace(namespace string) (string, error) {
+	if namespace != "" {
+		return namespace, nil
+	}
+	namespace, _, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).Namespace()
+	return namespace, err
+}
+
+func newClient() (client.Client, error) {
+	scheme := runtime.NewScheme()
+	if err := dtdlv0.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(config, client.Options{Scheme: scheme})
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// simulator publishes synthetic telemetry and properties for the virtual
+// instances of a TwinSimulation. The operator runs it as a Deployment with
+// the resolved simulation plan mounted from a ConfigMap.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/simulation"
+)
+
+func main() {
+	var brokerAddr string
+	var planFile string
+	var clientID string
+	var seed int64
+	flag.StringVar(&brokerAddr, "broker-address", "tcp://mqtt-broker-service.mqtt:1883", "The address of the broker samples are published to.")
+	flag.StringVar(&planFile, "plan", "/config/"+simulation.PlanKey, "The simulation plan file.")
+	flag.StringVar(&clientID, "client-id", "dt-simulator", "The MQTT client ID of the simulator.")
+	flag.Int64Var(&seed, "seed", time.Now().UnixNano(), "The seed of the simulated values.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	logger := ctrl.Log.WithName("simulator")
+	ctx := log.IntoContext(ctrl.SetupSignalHandler(), logger)
+
+	data, err := os.ReadFile(planFile)
+	if err != nil {
+		logger.Error(err, "Unable to read simulation plan")
+		os.Exit(1)
+	}
+	plan := &simulation.Plan{}
+	if err := json.Unmarshal(data, plan); err != nil {
+		logger.Error(err, "Unable to decode simulation plan")
+		os.Exit(1)
+	}
+
+	// Virtual instances publish as twins do, with the twin credentials of
+	// the namespace.
+	options := broker.CredentialsFromEnv().Apply(mqtt.NewClientOptions()).
+		AddBroker(brokerAddr).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true)
+	c := mqtt.NewClient(options)
+	c.Connect()
+	defer c.Disconnect(250)
+
+	(&simulation.Simulator{Client: c, Plan: plan, Seed: seed}).Run(ctx)
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/naming"
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+// OPERATOR_CREDENTIALS_KEY is the Secret holding the broker credentials of
+// state ingestion and autoscaling, next to the cluster broker.
+var OPERATOR_CREDENTIALS_KEY = types.NamespacedName{
+	Name:      "dt-operator-mqtt-credentials",
+	Namespace: BROKER_NAMESPACE,
+}
+
+// HISTORY_CREDENTIALS_SECRET_NAME is the Secret holding the broker
+// credentials of the history store, in its namespace.
+const HISTORY_CREDENTIALS_SECRET_NAME = "twin-history-mqtt-credentials"
+
+// RECORDER_CREDENTIALS_SECRET_NAME is the Secret holding the broker
+// credentials of the recorders of a namespace, in that namespace.
+const RECORDER_CREDENTIALS_SECRET_NAME = "twin-recorder-mqtt-credentials"
+
+// TWIN_CREDENTIALS_SECRET_NAME is the Secret holding the broker credentials
+// of the twin instances of a namespace, in that namespace.
+const TWIN_CREDENTIALS_SECRET_NAME = "twin-instance-mqtt-credentials"
+
+// SERVICE_CREDENTIALS_SUFFIX ends the name of the Secret holding the broker
+// credentials of an MQTT TwinService, in its namespace.
+const SERVICE_CREDENTIALS_SUFFIX = "-mqtt-credentials"
+
+// applyServiceCredentials returns the broker credentials of twinService,
+// creating their Secret, controlled by the service, when it does not exist.
+func applyServiceCredentials(ctx context.Context, c client.Client, scheme *runtime.Scheme, twinService *dtdlv0.TwinService) (broker.Credentials, error) {
+	key := types.NamespacedName{
+		Namespace: twinService.Namespace,
+		Name:      naming.Truncate(twinService.Name+SERVICE_CREDENTIALS_SUFFIX, validation.DNS1123SubdomainMaxLength),
+	}
+	return broker.ApplySecret(ctx, c, key, topics.ServiceUsername(twinService.Namespace, twinService.Name), func(secret *corev1.Secret) error {
+		return controllerutil.SetControllerReference(twinService, secret, scheme)
+	})
+}
+
+// applyTwinCredentials returns the broker credentials of the twin instances
+// of namespace, creating their Secret when it does not exist.
+func applyTwinCredentials(ctx context.Context, c client.Client, namespace string) (broker.Credentials, error) {
+	key := types.NamespacedName{Namespace: namespace, Name: TWIN_CREDENTIALS_SECRET_NAME}
+	return broker.ApplySecret(ctx, c, key, topics.TwinUsername(namespace), nil)
+}
+
+// credentialsEnv passes the credentials of a Secret to a container of the
+// operator image, which reads them from broker.UsernameEnv and
+// broker.PasswordEnv.
+func credentialsEnv(secret string) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{Name: broker.UsernameEnv, ValueFrom: secretKey(secret, corev1.BasicAuthUsernameKey)},
+		{Name: broker.PasswordEnv, ValueFrom: secretKey(secret, corev1.BasicAuthPasswordKey)},
+	}
+}
+
+func secretKey(secret, key string) *corev1.EnvVarSource {
+	return &corev1.EnvVarSource{
+		SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+			Key:                  key,
+		},
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// setConditionWithEvent sets a status condition and, when it changes,
+// records it as an event: Normal once the condition holds, Warning
+// otherwise. Reconciling an unchanged object records nothing.
+func setConditionWithEvent(recorder record.EventRecorder, object runtime.Object, conditions *[]metav1.Condition, condition metav1.Condition) {
+	previous := meta.FindStatusCondition(*conditions, condition.Type)
+	changed := previous == nil || previous.Status != condition.Status || previous.Reason != condition.Reason || previous.Message != condition.Message
+	meta.SetStatusCondition(conditions, condition)
+	if !changed {
+		return
+	}
+
+	eventType := corev1.EventTypeWarning
+	if condition.Status == metav1.ConditionTrue {
+		eventType = corev1.EventTypeNormal
+	}
+	recorder.Event(object, eventType, condition.Reason, condition.Message)
+}
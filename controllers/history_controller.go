@@ -0,0 +1,264 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/history"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+const HISTORY_NAME = "twin-history"
+const HISTORY_CONFIG_MAP_NAME = "twin-history-config"
+const HISTORY_SERVICE_NAME = "twin-history-service"
+const HISTORY_PORT = 8080
+
+// HISTORY_CLUSTER_ROLE_NAME names the ClusterRole and ClusterRoleBinding
+// letting the history store review the tokens of its callers.
+const HISTORY_CLUSTER_ROLE_NAME = "dt-operator-twin-history"
+
+// NONROOT_FS_GROUP is the group of the distroless nonroot user the operator
+// image runs as, which must own the volumes its binaries write to.
+var NONROOT_FS_GROUP = int64(65532)
+
+// HistoryReconciler runs the history store next to the broker and keeps its
+// retention configuration in step with the TwinClasses.
+type HistoryReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Image is the operator image, which holds the history binary.
+	Image string
+	// Broker is the URL of the broker the history store records from.
+	Broker string
+	// StorageSize is the size of the volume claimed for the history.
+	StorageSize resource.Quantity
+	// DefaultRetention applies to classes without a historyRetention.
+	DefaultRetention time.Duration
+}
+
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;create
+//+kubebuilder:rbac:groups=core,resources=configmaps;services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;clusterrolebindings,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile renders the retention of every class into the history
+// ConfigMap and applies the history StatefulSet and Service, the
+// ServiceAccount the store authorizes its callers as and the Secret of its
+// broker credentials. The history
+// store is shared by all namespaces, so every request reconciles it whole.
+func (r *HistoryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: BROKER_NAMESPACE}}
+	if err := r.Create(ctx, namespace); err != nil && !errors.IsAlreadyExists(err) {
+		return ctrl.Result{}, err
+	}
+
+	retention, err := r.buildRetention(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: HISTORY_CONFIG_MAP_NAME, Namespace: BROKER_NAMESPACE}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		configMap.Data = map[string]string{history.RetentionConfigKey: retention}
+		return nil
+	}); err != nil {
+		logger.Error(err, "Error while applying history config map")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.applyHistoryAccess(ctx); err != nil {
+		logger.Error(err, "Error while applying history service account")
+		return ctrl.Result{}, err
+	}
+
+	credentialsKey := types.NamespacedName{Namespace: BROKER_NAMESPACE, Name: HISTORY_CREDENTIALS_SECRET_NAME}
+	if _, err := broker.ApplySecret(ctx, r.Client, credentialsKey, topics.HistoryUsername, nil); err != nil {
+		logger.Error(err, "Error while applying history broker credentials")
+		return ctrl.Result{}, err
+	}
+
+	statefulSet := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: HISTORY_NAME, Namespace: BROKER_NAMESPACE}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, statefulSet, func() error {
+		r.buildHistoryStatefulSet(statefulSet)
+		return nil
+	}); err != nil {
+		logger.Error(err, "Error while applying history stateful set")
+		return ctrl.Result{}, err
+	}
+
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: HISTORY_SERVICE_NAME, Namespace: BROKER_NAMESPACE}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, service, func() error {
+		service.Spec.Selector = buildLabels(HISTORY_NAME)
+		service.Spec.Ports = []corev1.ServicePort{{
+			Name:       "http",
+			Port:       HISTORY_PORT,
+			TargetPort: intstr.FromInt(HISTORY_PORT),
+		}}
+		return nil
+	}); err != nil {
+		logger.Error(err, "Error while applying history service")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// applyHistoryAccess applies the ServiceAccount of the history store, bound
+// to a ClusterRole allowing it to review the tokens and the access of the
+// callers of its query API.
+func (r *HistoryReconciler) applyHistoryAccess(ctx context.Context) error {
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: HISTORY_NAME, Namespace: BROKER_NAMESPACE}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, serviceAccount, func() error {
+		serviceAccount.Labels = buildLabels(HISTORY_NAME)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	clusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: HISTORY_CLUSTER_ROLE_NAME}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, clusterRole, func() error {
+		clusterRole.Labels = buildLabels(HISTORY_NAME)
+		clusterRole.Rules = []rbacv1.PolicyRule{
+			{APIGroups: []string{"authentication.k8s.io"}, Resources: []string{"tokenreviews"}, Verbs: []string{"create"}},
+			{APIGroups: []string{"authorization.k8s.io"}, Resources: []string{"subjectaccessreviews"}, Verbs: []string{"create"}},
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	binding := &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: HISTORY_CLUSTER_ROLE_NAME}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, binding, func() error {
+		binding.Labels = buildLabels(HISTORY_NAME)
+		binding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: HISTORY_CLUSTER_ROLE_NAME}
+		binding.Subjects = []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: HISTORY_NAME, Namespace: BROKER_NAMESPACE}}
+		return nil
+	})
+	return err
+}
+
+// buildRetention renders the retention configuration from the latest
+// version of every class. Classes without a historyRetention are left to
+// the default.
+func (r *HistoryReconciler) buildRetention(ctx context.Context) (string, error) {
+	models, err := model.LoadByNamespace(ctx, r.Client)
+	if err != nil {
+		return "", err
+	}
+
+	retention := history.Retention{
+		Default: metav1.Duration{Duration: r.DefaultRetention},
+		Classes: map[string]metav1.Duration{},
+	}
+	for namespace, m := range models {
+		for _, class := range m.Classes() {
+			if class.Spec.HistoryRetention != nil {
+				retention.Classes[namespace+"/"+class.Spec.Name] = *class.Spec.HistoryRetention
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(retention, "", "  ")
+	return string(data), err
+}
+
+// buildHistoryStatefulSet fills in the history StatefulSet. The volume
+// claim template is only set on creation, since it cannot change.
+func (r *HistoryReconciler) buildHistoryStatefulSet(statefulSet *appsv1.StatefulSet) {
+	replicas := int32(1)
+	statefulSet.Spec.Replicas = &replicas
+	statefulSet.Spec.ServiceName = HISTORY_SERVICE_NAME
+	statefulSet.Spec.Selector = &metav1.LabelSelector{MatchLabels: buildLabels(HISTORY_NAME)}
+	statefulSet.Spec.Template = corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: buildLabels(HISTORY_NAME)},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: HISTORY_NAME,
+			SecurityContext:    &corev1.PodSecurityContext{FSGroup: &NONROOT_FS_GROUP},
+			Containers: []corev1.Container{{
+				Name:    "history",
+				Image:   r.Image,
+				Command: []string{"/history"},
+				Args: []string{
+					"--broker-address=" + r.Broker,
+					"--data-dir=/data",
+					"--retention-config=/config/" + history.RetentionConfigKey,
+					"--default-retention=" + r.DefaultRetention.String(),
+					"--bind-address=:8080",
+				},
+				Env:   credentialsEnv(HISTORY_CREDENTIALS_SECRET_NAME),
+				Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: HISTORY_PORT}},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "data", MountPath: "/data"},
+					{Name: "config", MountPath: "/config"},
+				},
+			}},
+			Volumes: []corev1.Volume{{
+				Name: "config",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: HISTORY_CONFIG_MAP_NAME},
+					},
+				},
+			}},
+		},
+	}
+	if statefulSet.CreationTimestamp.IsZero() {
+		statefulSet.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{{
+			ObjectMeta: metav1.ObjectMeta{Name: "data"},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: r.StorageSize},
+				},
+			},
+		}}
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HistoryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("history").
+		For(&dtdlv0.TwinClass{}).
+		Complete(monitoring.Instrument("history", r))
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/instance"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
+)
+
+const INVERSES_CONSISTENT_CONDITION = "InversesConsistent"
+
+// InverseReconciler mirrors the edges of relationships declaring an inverse
+type InverseReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twininstances,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twininstances/status,verbs=get;update;patch
+
+// Reconcile keeps the edges of the relationships of the TwinInstance that
+// declare an inverse mirrored on the related twins, in both directions, and
+// reports edges that cannot be mirrored in the InversesConsistent condition.
+// Instances of classes without inverse relationships are left alone.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
+func (r *InverseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("TwinInstance", req.NamespacedName)
+
+	twinInstance := &dtdlv1.TwinInstance{}
+	if err := r.Get(ctx, req.NamespacedName, twinInstance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !twinInstance.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	m, err := model.Load(ctx, r.Client, client.InNamespace(req.Namespace))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	resolved, err := resolveInstanceClass(m, twinInstance)
+	if err != nil || !hasInverses(resolved) {
+		return ctrl.Result{}, nil
+	}
+
+	twinInstances := &dtdlv1.TwinInstanceList{}
+	if err := r.List(ctx, twinInstances, client.InNamespace(req.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	instances := map[string]*dtdlv1.TwinInstance{}
+	for i := range twinInstances.Items {
+		instances[twinInstances.Items[i].Name] = &twinInstances.Items[i]
+	}
+	classOf := func(other *dtdlv1.TwinInstance) *model.ResolvedClass {
+		otherClass, err := resolveInstanceClass(m, other)
+		if err != nil {
+			return nil
+		}
+		return otherClass
+	}
+
+	sync, err := instance.SyncInverses(resolved, twinInstance, instances, classOf)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	for _, target := range sync.Targets {
+		logger.Info("Mirroring inverse edges", "target", target.Name)
+		if err := r.Update(ctx, target); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if sync.Source != nil {
+		logger.Info("Mirroring edges of inverse relationships")
+		if err := r.Update(ctx, sync.Source); err != nil {
+			return ctrl.Result{}, err
+		}
+		twinInstance = sync.Source
+	}
+
+	condition := metav1.Condition{
+		Type:               INVERSES_CONSISTENT_CONDITION,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Consistent",
+		Message:            "Inverse edges are mirrored",
+		ObservedGeneration: twinInstance.Generation,
+	}
+	if len(sync.Conflicts) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "InverseConflict"
+		condition.Message = strings.Join(sync.Conflicts, "; ")
+	}
+	meta.SetStatusCondition(&twinInstance.Status.Conditions, condition)
+	return ctrl.Result{}, r.Status().Update(ctx, twinInstance)
+}
+
+func hasInverses(class *model.ResolvedClass) bool {
+	for _, relationship := range class.Relationships {
+		if relationship.Inverse != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *InverseReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("inverse").
+		For(&dtdlv1.TwinInstance{}).
+		Watches(&source.Kind{Type: &dtdlv1.TwinInstance{}}, handler.EnqueueRequestsFromMapFunc(relatedInstances(r.Client))).
+		Watches(&source.Kind{Type: &dtdlv0.TwinClass{}}, handler.EnqueueRequestsFromMapFunc(namespaceInstances(r.Client))).
+		Complete(monitoring.Instrument("inverse", r))
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+var _ = Describe("InverseReconciler", func() {
+	var ctx context.Context
+	var namespace string
+	var reconciler *InverseReconciler
+
+	reconcileTwin := func(twinInstance *dtdlv1.TwinInstance) *dtdlv1.TwinInstance {
+		_, err := reconciler.Reconcile(ctx, requestFor(twinInstance))
+		Expect(err).NotTo(HaveOccurred())
+		return getTwin(ctx, twinInstance)
+	}
+	expectCondition := func(twinInstance *dtdlv1.TwinInstance, status metav1.ConditionStatus, reason string) *metav1.Condition {
+		condition := meta.FindStatusCondition(twinInstance.Status.Conditions, INVERSES_CONSISTENT_CONDITION)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(status))
+		Expect(condition.Reason).To(Equal(reason))
+		return condition
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = createNamespace(ctx)
+		reconciler = &InverseReconciler{Client: k8sClient, Scheme: scheme.Scheme}
+
+		one := int32(1)
+		for _, class := range []*dtdlv0.TwinClass{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "line", Namespace: namespace},
+				Spec: dtdlv0.TwinClassSpec{Name: "Line", Relationships: []dtdlv0.TwinRelationship{
+					{Name: "machines", Targets: []string{"Machine"}, Inverse: "line"},
+				}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: namespace},
+				Spec: dtdlv0.TwinClassSpec{Name: "Machine", Relationships: []dtdlv0.TwinRelationship{
+					{Name: "line", MaxMultiplicity: &one, Targets: []string{"Line"}},
+				}},
+			},
+		} {
+			Expect(k8sClient.Create(ctx, class)).To(Succeed())
+		}
+	})
+
+	It("mirrors an added edge on the related twin", func() {
+		press := createTwin(ctx, namespace, "press", "Machine", "")
+		line := createTwin(ctx, namespace, "line", "Line", "machines", "press")
+
+		line = reconcileTwin(line)
+		Expect(edgeTargets(getTwin(ctx, press), "line")).To(Equal([]string{"line"}))
+		expectCondition(line, metav1.ConditionTrue, "Consistent")
+	})
+
+	It("mirrors an edge added on the inverse side", func() {
+		line := createTwin(ctx, namespace, "line", "Line", "")
+		createTwin(ctx, namespace, "press", "Machine", "line", "line")
+
+		line = reconcileTwin(line)
+		Expect(edgeTargets(line, "machines")).To(Equal([]string{"press"}))
+		expectCondition(line, metav1.ConditionTrue, "Consistent")
+	})
+
+	It("mirrors a removed edge on the related twin", func() {
+		press := createTwin(ctx, namespace, "press", "Machine", "")
+		line := createTwin(ctx, namespace, "line", "Line", "machines", "press")
+		line = reconcileTwin(line)
+		Expect(edgeTargets(getTwin(ctx, press), "line")).To(Equal([]string{"line"}))
+
+		line.Spec.Relationships = nil
+		Expect(k8sClient.Update(ctx, line)).To(Succeed())
+		line = reconcileTwin(line)
+		Expect(edgeTargets(getTwin(ctx, press), "line")).To(BeEmpty())
+		Expect(edgeTargets(line, "machines")).To(BeEmpty())
+		expectCondition(line, metav1.ConditionTrue, "Consistent")
+	})
+
+	It("reports an edge the maxMultiplicity of the inverse keeps from being mirrored", func() {
+		press := createTwin(ctx, namespace, "press", "Machine", "")
+		first := createTwin(ctx, namespace, "first", "Line", "machines", "press")
+		reconcileTwin(first)
+
+		second := createTwin(ctx, namespace, "second", "Line", "machines", "press")
+		second = reconcileTwin(second)
+		condition := expectCondition(second, metav1.ConditionFalse, "InverseConflict")
+		Expect(condition.Message).To(ContainSubstring(`relationship "line" of press already holds its maxMultiplicity of 1: first`))
+		Expect(edgeTargets(getTwin(ctx, press), "line")).To(Equal([]string{"first"}))
+		Expect(edgeTargets(second, "machines")).To(Equal([]string{"press"}))
+	})
+})
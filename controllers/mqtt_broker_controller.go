@@ -2,23 +2,86 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/brokermetrics"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/topics"
 )
 
 const BROKER_CONFIG_MAP_NAME = "mqtt-broker-config"
 const BROKER_DEPLOYMENT_NAME = "mqtt-broker-deployment"
 const BROKER_SERVICE_NAME = "mqtt-broker-service"
-const BROKER_NAMESPACE = "mqtt"
+const BROKER_NAMESPACE = broker.Namespace
+
+// CLUSTER_BROKER places the broker of the cluster scope.
+var CLUSTER_BROKER = broker.ClusterScope.For("", "")
+
+// BROKER_METRICS_SERVICE_NAME is the Service, and the ServiceMonitor, of the
+// metrics exporter running next to the cluster broker.
+const BROKER_METRICS_SERVICE_NAME = "mqtt-broker-metrics"
+const BROKER_METRICS_PORT = brokermetrics.Port
+
+// SERVICE_MONITOR_GVK is the ServiceMonitor kind of the Prometheus
+// Operator, which is only applied when its CRD is installed.
+var SERVICE_MONITOR_GVK = schema.GroupVersionKind{
+	Group:   "monitoring.coreos.com",
+	Version: "v1",
+	Kind:    "ServiceMonitor",
+}
+
+// BROKER_CONFIG_HASH_ANNOTATION carries a hash of the broker ConfigMap on the
+// broker pod template, so that configuration changes roll the broker.
+const BROKER_CONFIG_HASH_ANNOTATION = "dtdl.digitaltwin/broker-config-hash"
+
+const BROKER_CONFIG = `# Generated by dt-operator.
+
+# Listen on all interfaces.
+listener 1883
+
+# Every client authenticates: twin instances with the twins@<namespace>
+# username of their namespace, services with their <namespace>/<name>
+# username and the workloads of the operator with theirs, against the
+# password file generated from their Secrets. Topic access is restricted by
+# the ACL generated from the TwinClass topic layout.
+allow_anonymous false
+password_file /mosquitto/config/passwd
+acl_file /mosquitto/config/acl
+`
+
+// BROKER_PASSWORD_FILE_KEY is the key of the password file in the password
+// Secret of a broker.
+const BROKER_PASSWORD_FILE_KEY = "passwd"
+
+// BROKER_FS_GROUP is the group of the mosquitto user of the broker image,
+// which alone may read the password file.
+var BROKER_FS_GROUP = int64(1883)
+var BROKER_PASSWORD_FILE_MODE = int32(0440)
 
 var BROKER_CONFIG_MAP_KEY = types.NamespacedName{
 	Name:      BROKER_CONFIG_MAP_NAME,
@@ -35,114 +98,633 @@ var BROKER_SERVICE_KEY = types.NamespacedName{
 	Namespace: BROKER_NAMESPACE,
 }
 
+var BROKER_METRICS_SERVICE_KEY = types.NamespacedName{
+	Name:      BROKER_METRICS_SERVICE_NAME,
+	Namespace: BROKER_NAMESPACE,
+}
+
 func buildLabels(appLabel string) map[string]string {
 	return map[string]string{
 		"app": appLabel,
 	}
 }
 
-func (r *TwinServiceReconciler) deleteBrokerDeployment(ctx context.Context, request reconcile.Request) (*reconcile.Result, error) {
-	logger := log.FromContext(ctx).WithValues("TwinService", request.NamespacedName)
+// brokerApplier applies the resources of the broker at Placement.
+type brokerApplier struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// ExporterImage is the image of the metrics sidecar, left out when empty.
+	ExporterImage string
+	Placement     broker.Placement
+	// Owner, when set, controls the broker resources, so that they are
+	// garbage collected with it.
+	Owner client.Object
+	// Subject is the object the lifecycle events of the broker are recorded
+	// on.
+	Subject client.Object
+	// OperatorNamespace is the namespace the operator runs in, admitted to
+	// the broker of a tenant.
+	OperatorNamespace string
+}
+
+// clusterBroker returns the applier of the broker of the cluster scope,
+// recording its events on twinService.
+func (r *TwinServiceReconciler) clusterBroker(twinService *dtdlv0.TwinService) *brokerApplier {
+	return &brokerApplier{
+		Client:        r.Client,
+		Scheme:        r.Scheme,
+		Recorder:      r.Recorder,
+		ExporterImage: r.ExporterImage,
+		Placement:     CLUSTER_BROKER,
+		Subject:       twinService,
+	}
+}
+
+func (r *TwinServiceReconciler) applyBrokerDeployment(ctx context.Context, twinService *dtdlv0.TwinService) (*reconcile.Result, error) {
+	logger := log.FromContext(ctx).WithValues("TwinService", client.ObjectKeyFromObject(twinService))
+
+	logger.Info("Creating MQTT Broker")
+
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: BROKER_NAMESPACE,
+		},
+	}
+
+	err := r.Create(ctx, namespace)
+
+	if err == nil {
+		r.Recorder.Event(twinService, corev1.EventTypeNormal, "BrokerNamespaceCreated", "Created broker namespace "+BROKER_NAMESPACE)
+	} else if !errors.IsAlreadyExists(err) {
+		logger.Error(err, `Error while creating broker namespace: `+BROKER_NAMESPACE)
+		return &reconcile.Result{}, err
+	}
 
-	err := r.Delete(context.TODO(), r.buildBrokerConfigMapDefinition())
+	operatorCredentials, err := broker.ApplySecret(ctx, r.Client, OPERATOR_CREDENTIALS_KEY, topics.OperatorUsername, nil)
 
 	if err != nil {
-		logger.Error(err, "Error while deleting broker config map")
+		logger.Error(err, `Error while applying operator broker credentials`)
+		return &reconcile.Result{}, err
 	}
 
-	err = r.Delete(context.TODO(), r.buildBrokerDeploymentDefinition())
+	historyCredentials, err := broker.ApplySecret(ctx, r.Client, types.NamespacedName{Namespace: BROKER_NAMESPACE, Name: HISTORY_CREDENTIALS_SECRET_NAME}, topics.HistoryUsername, nil)
 
 	if err != nil {
-		logger.Error(err, "Error while deleting broker deployment")
+		logger.Error(err, `Error while applying history broker credentials`)
+		return &reconcile.Result{}, err
 	}
 
-	err = r.Delete(context.TODO(), r.buildBrokerServiceDefinition())
+	access, err := buildBrokerAccess(ctx, r.Client, r.Scheme, "", "")
 
 	if err != nil {
-		logger.Error(err, "Error while deleting broker service")
+		logger.Error(err, `Error while building broker ACL`)
+		return &reconcile.Result{}, err
+	}
+	access.Credentials = append(access.Credentials, operatorCredentials, historyCredentials)
+
+	if err := r.clusterBroker(twinService).apply(ctx, access); err != nil {
+		return &reconcile.Result{}, err
 	}
 
 	return nil, nil
 }
 
-func (r *TwinServiceReconciler) applyBrokerDeployment(ctx context.Context, request reconcile.Request) (*reconcile.Result, error) {
-	logger := log.FromContext(ctx).WithValues("TwinService", request.NamespacedName)
+// applyTwinBroker makes twinService an owner of the TwinBroker serving it
+// in the namespace and service scopes, creating the TwinBroker when needed.
+// It releases the TwinBrokers of the namespace the service no longer uses,
+// such as after it stopped using MQTT or the scope changed, and deletes
+// those left without owners.
+func (r *TwinServiceReconciler) applyTwinBroker(ctx context.Context, twinService *dtdlv0.TwinService) error {
+	logger := log.FromContext(ctx).WithValues("TwinService", client.ObjectKeyFromObject(twinService))
+
+	desired := ""
+	if r.BrokerScope != broker.ClusterScope && usesBroker(twinService) {
+		desired = r.BrokerScope.For(twinService.Namespace, twinService.Name).Name
+	}
 
-	logger.Info("Creating MQTT Broker")
+	twinBrokers := &dtdlv1.TwinBrokerList{}
+	if err := r.List(ctx, twinBrokers, client.InNamespace(twinService.Namespace)); err != nil {
+		return err
+	}
 
-	namespace := &corev1.Namespace{
+	found := false
+	for i := range twinBrokers.Items {
+		twinBroker := &twinBrokers.Items[i]
+		if twinBroker.Name == desired {
+			found = true
+			if ownedBy(twinBroker, twinService) {
+				continue
+			}
+			if err := r.ownTwinBroker(twinService, twinBroker); err != nil {
+				return err
+			}
+			if err := r.Update(ctx, twinBroker); err != nil {
+				logger.Error(err, "Error while adding owner to twin broker: "+twinBroker.Name)
+				return err
+			}
+			continue
+		}
+
+		if !ownedBy(twinBroker, twinService) {
+			continue
+		}
+		references := []metav1.OwnerReference{}
+		for _, reference := range twinBroker.OwnerReferences {
+			if reference.UID != twinService.UID {
+				references = append(references, reference)
+			}
+		}
+		if len(references) == 0 {
+			if err := r.Delete(ctx, twinBroker); err != nil && !errors.IsNotFound(err) {
+				logger.Error(err, "Error while deleting twin broker: "+twinBroker.Name)
+				return err
+			}
+			r.Recorder.Event(twinService, corev1.EventTypeNormal, "TwinBrokerDeleted", "Deleted twin broker "+twinBroker.Name+", which serves no other service")
+			continue
+		}
+		twinBroker.OwnerReferences = references
+		if err := r.Update(ctx, twinBroker); err != nil {
+			logger.Error(err, "Error while removing owner from twin broker: "+twinBroker.Name)
+			return err
+		}
+	}
+
+	if found || desired == "" {
+		return nil
+	}
+
+	twinBroker := &dtdlv1.TwinBroker{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: BROKER_NAMESPACE,
+			Name:      desired,
+			Namespace: twinService.Namespace,
 		},
 	}
+	if r.BrokerScope == broker.ServiceScope {
+		twinBroker.Spec.Service = twinService.Name
+	}
+	if err := r.ownTwinBroker(twinService, twinBroker); err != nil {
+		return err
+	}
+	if err := r.Create(ctx, twinBroker); err != nil {
+		logger.Error(err, "Error while creating twin broker: "+desired)
+		return err
+	}
+	r.Recorder.Event(twinService, corev1.EventTypeNormal, "TwinBrokerCreated", "Created twin broker "+desired)
+	return nil
+}
 
-	err := r.Create(ctx, namespace)
+// ownTwinBroker adds twinService to the owners of twinBroker. The broker of
+// a single service is controlled by it, the broker of a namespace is owned
+// by all its services and deleted by the garbage collector with the last.
+func (r *TwinServiceReconciler) ownTwinBroker(twinService *dtdlv0.TwinService, twinBroker *dtdlv1.TwinBroker) error {
+	if twinBroker.Spec.Service != "" {
+		return controllerutil.SetControllerReference(twinService, twinBroker, r.Scheme)
+	}
+	return controllerutil.SetOwnerReference(twinService, twinBroker, r.Scheme)
+}
+
+func ownedBy(object, owner metav1.Object) bool {
+	for _, reference := range object.GetOwnerReferences() {
+		if reference.UID == owner.GetUID() {
+			return true
+		}
+	}
+	return false
+}
+
+// own makes the owner of the applier the controller of object.
+func (a *brokerApplier) own(object metav1.Object) error {
+	if a.Owner == nil {
+		return nil
+	}
+	return controllerutil.SetControllerReference(a.Owner, object, a.Scheme)
+}
+
+func (a *brokerApplier) key(name string) types.NamespacedName {
+	return types.NamespacedName{Namespace: a.Placement.Namespace, Name: name}
+}
 
-	// TODO FIX
+// apply creates the broker with the ACL and the password file of access,
+// keeps its configuration up to date and rolls it when the configuration
+// changes.
+func (a *brokerApplier) apply(ctx context.Context, access *brokerAccess) error {
+	logger := log.FromContext(ctx).WithValues("Broker", a.key(a.Placement.Name))
+
+	credentials := access.Credentials
+	if a.ExporterImage != "" {
+		key := a.key(a.Placement.ExporterCredentials())
+		exporter, err := broker.ApplySecret(ctx, a.Client, key, topics.ExporterUsername, func(secret *v1.Secret) error {
+			return a.own(secret)
+		})
+		if err != nil {
+			logger.Error(err, `Error while applying broker exporter credentials: `+key.Name)
+			return err
+		}
+		credentials = append(credentials, exporter)
+	}
+
+	passwd, err := a.applyPasswordFile(ctx, credentials)
 	if err != nil {
-		logger.Info(`Namespace already exists: ` + BROKER_NAMESPACE)
+		logger.Error(err, `Error while applying broker password file: `+a.Placement.PasswordFile())
+		return err
 	}
 
-	// Create ConfigMap, if not exists
+	if a.Owner != nil {
+		if err := a.applyNetworkPolicy(ctx); err != nil {
+			logger.Error(err, `Error while applying broker network policy: `+a.Placement.NetworkPolicy())
+			return err
+		}
+	}
+
+	desiredConfigMap := a.buildConfigMapDefinition(access.ACL)
+	configHash := hashBrokerConfig(desiredConfigMap, passwd)
+
+	// Create ConfigMap, if not exists, and keep its data up to date
 	configMap := &v1.ConfigMap{}
+	configMapKey := a.key(a.Placement.ConfigMap())
 
-	err = r.Get(context.TODO(), BROKER_CONFIG_MAP_KEY, configMap)
+	err = a.Get(ctx, configMapKey, configMap)
 
 	if err != nil && errors.IsNotFound(err) {
-		err := r.Create(context.TODO(), r.buildBrokerConfigMapDefinition())
+		if err := a.own(desiredConfigMap); err != nil {
+			return err
+		}
+
+		err := a.Create(ctx, desiredConfigMap)
 
 		if err != nil {
-			logger.Error(err, `Error while creating broker config map: `+BROKER_CONFIG_MAP_NAME)
-			return &reconcile.Result{}, err
+			logger.Error(err, `Error while creating broker config map: `+configMapKey.Name)
+			return err
 		}
+	} else if err == nil && !equality.Semantic.DeepEqual(configMap.Data, desiredConfigMap.Data) {
+		configMap.Data = desiredConfigMap.Data
+
+		err := a.Update(ctx, configMap)
+
+		if err != nil {
+			logger.Error(err, `Error while updating broker config map: `+configMapKey.Name)
+			return err
+		}
+
+		a.Recorder.Event(a.Subject, corev1.EventTypeNormal, "BrokerConfigUpdated", "Updated broker configuration and ACL in config map "+configMapKey.String())
+	} else if err != nil {
+		return err
 	}
 
-	// Create Deployment, if not exists
+	// Create Deployment, if not exists, and roll it when the configuration changes
 	deployment := &appsv1.Deployment{}
+	deploymentKey := a.key(a.Placement.Deployment())
 
-	err = r.Get(context.TODO(), BROKER_DEPLOYMENT_KEY, deployment)
+	err = a.Get(ctx, deploymentKey, deployment)
 
 	if err != nil && errors.IsNotFound(err) {
-		err := r.Create(context.TODO(), r.buildBrokerDeploymentDefinition())
+		desired := a.buildDeploymentDefinition(configHash)
+		if err := a.own(desired); err != nil {
+			return err
+		}
+
+		err := a.Create(ctx, desired)
+
+		if err != nil {
+			logger.Error(err, `Error while creating broker deployment: `+deploymentKey.Name)
+			return err
+		}
+
+		a.Recorder.Event(a.Subject, corev1.EventTypeNormal, "BrokerCreated", "Created broker deployment "+deploymentKey.String())
+	} else if desired := a.buildDeploymentDefinition(configHash); err == nil && brokerTemplateChanged(deployment, desired) {
+		deployment.Spec.Template = desired.Spec.Template
+
+		err := a.Update(ctx, deployment)
 
 		if err != nil {
-			logger.Error(err, `Error while creating broker deployment: `+BROKER_CONFIG_MAP_NAME)
-			return &reconcile.Result{}, err
+			logger.Error(err, `Error while updating broker deployment: `+deploymentKey.Name)
+			return err
 		}
+
+		a.Recorder.Event(a.Subject, corev1.EventTypeNormal, "BrokerRollout", "Rolling out broker deployment "+deploymentKey.String())
+	} else if err != nil {
+		return err
 	}
 
-	// Create Service, if not exists
-	service := &v1.Service{}
+	// Create Service, if not exists, and keep it selecting the broker
+	desiredService := a.buildServiceDefinition()
+	service := &v1.Service{ObjectMeta: desiredService.ObjectMeta}
 
-	err = r.Get(context.TODO(), BROKER_SERVICE_KEY, service)
+	result, err := controllerutil.CreateOrUpdate(ctx, a.Client, service, func() error {
+		service.Spec.Selector = desiredService.Spec.Selector
+		service.Spec.Ports = desiredService.Spec.Ports
+		return a.own(service)
+	})
 
-	if err != nil && errors.IsNotFound(err) {
-		err := r.Create(context.TODO(), r.buildBrokerServiceDefinition())
+	if err != nil {
+		logger.Error(err, `Error while applying broker service: `+service.Name)
+		return err
+	}
 
+	if result == controllerutil.OperationResultCreated {
+		a.Recorder.Event(a.Subject, corev1.EventTypeNormal, "BrokerServiceCreated", "Created broker service "+a.key(service.Name).String())
+	}
+
+	if a.ExporterImage == "" {
+		return nil
+	}
+
+	if err := a.applyMetrics(ctx); err != nil {
+		logger.Error(err, `Error while applying broker metrics service`)
+		return err
+	}
+
+	return nil
+}
+
+// applyPasswordFile renders the password file of credentials into the
+// password Secret of the broker, keeping the hashes of the passwords that
+// did not change, and returns it.
+func (a *brokerApplier) applyPasswordFile(ctx context.Context, credentials []broker.Credentials) (string, error) {
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: a.Placement.PasswordFile(), Namespace: a.Placement.Namespace}}
+	passwd := ""
+	_, err := controllerutil.CreateOrUpdate(ctx, a.Client, secret, func() error {
+		var err error
+		if passwd, err = broker.PasswordFile(credentials, string(secret.Data[BROKER_PASSWORD_FILE_KEY])); err != nil {
+			return err
+		}
+		secret.Data = map[string][]byte{BROKER_PASSWORD_FILE_KEY: []byte(passwd)}
+		return a.own(secret)
+	})
+	return passwd, err
+}
+
+// applyNetworkPolicy admits to the broker of a tenant only the pods of its
+// namespace and of the operator namespace. The metrics of the broker stay
+// open to scrapers.
+func (a *brokerApplier) applyNetworkPolicy(ctx context.Context) error {
+	policy := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: a.Placement.NetworkPolicy(), Namespace: a.Placement.Namespace}}
+	_, err := controllerutil.CreateOrUpdate(ctx, a.Client, policy, func() error {
+		peers := []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}
+		if a.OperatorNamespace != "" && a.OperatorNamespace != a.Placement.Namespace {
+			peers = append(peers, networkingv1.NetworkPolicyPeer{NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{corev1.LabelMetadataName: a.OperatorNamespace},
+			}})
+		}
+		brokerPort := intstr.FromInt(broker.Port)
+		ingress := []networkingv1.NetworkPolicyIngressRule{{
+			Ports: []networkingv1.NetworkPolicyPort{{Port: &brokerPort}},
+			From:  peers,
+		}}
+		if a.ExporterImage != "" {
+			metricsPort := intstr.FromInt(BROKER_METRICS_PORT)
+			ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+				Ports: []networkingv1.NetworkPolicyPort{{Port: &metricsPort}},
+			})
+		}
+		policy.Spec = networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: buildLabels(a.Placement.Deployment())},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     ingress,
+		}
+		return a.own(policy)
+	})
+	return err
+}
+
+// applyMetrics exposes the exporter sidecar of the broker through a Service
+// and, when the Prometheus Operator is installed, a ServiceMonitor scraping
+// it.
+func (a *brokerApplier) applyMetrics(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+	name := a.Placement.MetricsService()
+
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: a.Placement.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, a.Client, service, func() error {
+		service.Labels = buildLabels(name)
+		service.Spec.Selector = buildLabels(a.Placement.Deployment())
+		service.Spec.Ports = []v1.ServicePort{{
+			Name:       "metrics",
+			Port:       BROKER_METRICS_PORT,
+			TargetPort: intstr.FromInt(BROKER_METRICS_PORT),
+		}}
+		return a.own(service)
+	}); err != nil {
+		return err
+	}
+
+	if _, err := a.RESTMapper().RESTMapping(SERVICE_MONITOR_GVK.GroupKind(), SERVICE_MONITOR_GVK.Version); err != nil {
+		if meta.IsNoMatchError(err) {
+			logger.V(1).Info("Prometheus Operator not installed, skipping broker service monitor")
+			return nil
+		}
+		return err
+	}
+
+	serviceMonitor := &unstructured.Unstructured{}
+	serviceMonitor.SetGroupVersionKind(SERVICE_MONITOR_GVK)
+	serviceMonitor.SetName(name)
+	serviceMonitor.SetNamespace(a.Placement.Namespace)
+	_, err := controllerutil.CreateOrUpdate(ctx, a.Client, serviceMonitor, func() error {
+		serviceMonitor.SetLabels(buildLabels(name))
+		if err := a.own(serviceMonitor); err != nil {
+			return err
+		}
+		return unstructured.SetNestedField(serviceMonitor.Object, buildServiceMonitorSpec(name), "spec")
+	})
+	return err
+}
+
+func buildServiceMonitorSpec(metricsService string) map[string]interface{} {
+	return map[string]interface{}{
+		"selector": map[string]interface{}{
+			"matchLabels": map[string]interface{}{
+				"app": metricsService,
+			},
+		},
+		"endpoints": []interface{}{
+			map[string]interface{}{
+				"port":     "metrics",
+				"path":     "/metrics",
+				"interval": "30s",
+			},
+		},
+	}
+}
+
+// brokerTemplateChanged is true when the broker must roll: its configuration
+// changed, or its containers run other images or environments.
+func brokerTemplateChanged(current, desired *appsv1.Deployment) bool {
+	if current.Spec.Template.Annotations[BROKER_CONFIG_HASH_ANNOTATION] != desired.Spec.Template.Annotations[BROKER_CONFIG_HASH_ANNOTATION] {
+		return true
+	}
+	currentContainers := current.Spec.Template.Spec.Containers
+	desiredContainers := desired.Spec.Template.Spec.Containers
+	if len(currentContainers) != len(desiredContainers) {
+		return true
+	}
+	for i := range desiredContainers {
+		if currentContainers[i].Name != desiredContainers[i].Name || currentContainers[i].Image != desiredContainers[i].Image ||
+			!equality.Semantic.DeepEqual(currentContainers[i].Env, desiredContainers[i].Env) {
+			return true
+		}
+	}
+	return false
+}
+
+// brokerAccess is what a broker lets its clients do.
+type brokerAccess struct {
+	// ACL is the acl_file of the broker.
+	ACL string
+	// Credentials are the users of the password file of the broker.
+	Credentials []broker.Credentials
+	// Services are the names of the services granted access.
+	Services []string
+}
+
+// buildBrokerAccess renders the ACL of a broker from the topic layout of
+// every TwinClass version and the class versions selected by every MQTT
+// TwinService. The ACL of the broker of a namespace only covers its
+// classes and services, and the ACL of the broker of a single service only
+// the classes that service selects. Classes that do not resolve are left
+// out until they do. The credentials of the services, and of the twins and
+// the recorders of the namespaces served, are returned with the ACL,
+// creating their Secrets when they do not exist.
+func buildBrokerAccess(ctx context.Context, c client.Client, scheme *runtime.Scheme, namespace, service string) (*brokerAccess, error) {
+	logger := log.FromContext(ctx)
+	reader := client.Reader(c)
+
+	models := map[string]*model.Model{}
+	opts := []client.ListOption{}
+	if namespace == "" {
+		var err error
+		if models, err = model.LoadByNamespace(ctx, reader); err != nil {
+			return nil, err
+		}
+	} else {
+		opts = append(opts, client.InNamespace(namespace))
+		m, err := model.Load(ctx, reader, opts...)
 		if err != nil {
-			logger.Error(err, `Error while creating broker service: `+BROKER_SERVICE_NAME)
-			return &reconcile.Result{}, err
+			return nil, err
 		}
+		models[namespace] = m
 	}
 
-	return nil, nil
+	twinTopics := map[string][]topics.Topic{}
+	layouts := map[string]map[string][]topics.Topic{}
+	for namespace, m := range models {
+		twinTopics[namespace] = []topics.Topic{}
+		layouts[namespace] = map[string][]topics.Topic{}
+		for _, latest := range m.Classes() {
+			for _, class := range m.Versions(latest.Spec.Name) {
+				resolved, err := m.ResolveClass(class)
+				if err != nil {
+					logger.Info("Leaving unresolved twin class out of broker ACL", "TwinClass", class.Name, "reason", err.Error())
+					continue
+				}
+				layout := topics.ForClass(namespace, resolved)
+				layouts[namespace][class.Name] = layout
+				twinTopics[namespace] = append(twinTopics[namespace], layout...)
+			}
+		}
+	}
+
+	twinServices := &dtdlv0.TwinServiceList{}
+	if err := reader.List(ctx, twinServices, opts...); err != nil {
+		return nil, err
+	}
+
+	access := &brokerAccess{Credentials: []broker.Credentials{}, Services: []string{}}
+	services := []topics.ServiceAccess{}
+	for i := range twinServices.Items {
+		twinService := &twinServices.Items[i]
+		if !usesBroker(twinService) || (service != "" && twinService.Name != service) {
+			continue
+		}
+		serviceAccess := topics.ServiceAccess{
+			Username: topics.ServiceUsername(twinService.Namespace, twinService.Name),
+		}
+		m, ok := models[twinService.Namespace]
+		if !ok {
+			m = model.New(nil, nil)
+		}
+		for _, reference := range twinService.Spec.Classes {
+			class, err := m.Select(reference)
+			if err != nil {
+				logger.Info("Leaving unmatched twin class out of broker ACL", "TwinService", twinService.Name, "reason", err.Error())
+				continue
+			}
+			serviceAccess.Topics = append(serviceAccess.Topics, layouts[twinService.Namespace][class.Name]...)
+		}
+		credentials, err := applyServiceCredentials(ctx, c, scheme, twinService)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, serviceAccess)
+		access.Services = append(access.Services, twinService.Name)
+		access.Credentials = append(access.Credentials, credentials)
+	}
+
+	if service != "" {
+		twinTopics[namespace] = []topics.Topic{}
+		for _, serviceAccess := range services {
+			twinTopics[namespace] = append(twinTopics[namespace], serviceAccess.Topics...)
+		}
+	}
+
+	for namespace := range twinTopics {
+		twins, err := applyTwinCredentials(ctx, c, namespace)
+		if err != nil {
+			return nil, err
+		}
+		key := types.NamespacedName{Namespace: namespace, Name: RECORDER_CREDENTIALS_SECRET_NAME}
+		recorder, err := broker.ApplySecret(ctx, c, key, topics.RecorderUsername(namespace), nil)
+		if err != nil {
+			return nil, err
+		}
+		access.Credentials = append(access.Credentials, twins, recorder)
+	}
+
+	access.ACL = topics.ACL(twinTopics, services)
+	return access, nil
 }
 
-func (r *TwinServiceReconciler) buildBrokerDeploymentDefinition() *appsv1.Deployment {
+func usesBroker(twinService *dtdlv0.TwinService) bool {
+	return twinService.Spec.DataSource == "mqtt" || twinService.Spec.DataTarget == "mqtt"
+}
+
+// hashBrokerConfig hashes the configuration, the ACL and the password file
+// of the broker, whose changes roll it.
+func hashBrokerConfig(configMap *v1.ConfigMap, passwd string) string {
+	hash := sha256.New()
+	for _, key := range []string{"mosquitto.conf", "acl"} {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write([]byte(configMap.Data[key]))
+		hash.Write([]byte{0})
+	}
+	hash.Write([]byte(BROKER_PASSWORD_FILE_KEY))
+	hash.Write([]byte{0})
+	hash.Write([]byte(passwd))
+	hash.Write([]byte{0})
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (a *brokerApplier) buildDeploymentDefinition(configHash string) *appsv1.Deployment {
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      BROKER_DEPLOYMENT_NAME,
-			Namespace: BROKER_NAMESPACE,
+			Name:      a.Placement.Deployment(),
+			Namespace: a.Placement.Namespace,
 		},
 		Spec: appsv1.DeploymentSpec{
 			Selector: &metav1.LabelSelector{
-				MatchLabels: buildLabels(BROKER_DEPLOYMENT_NAME),
+				MatchLabels: buildLabels(a.Placement.Deployment()),
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: buildLabels(BROKER_DEPLOYMENT_NAME),
+					Labels: buildLabels(a.Placement.Deployment()),
+					Annotations: map[string]string{
+						BROKER_CONFIG_HASH_ANNOTATION: configHash,
+					},
 				},
 				Spec: corev1.PodSpec{
+					SecurityContext: &corev1.PodSecurityContext{FSGroup: &BROKER_FS_GROUP},
 					Containers: []corev1.Container{{
 						Name:  "mosquitto",
 						Image: "eclipse-mosquitto:2.0",
@@ -152,72 +734,107 @@ func (r *TwinServiceReconciler) buildBrokerDeploymentDefinition() *appsv1.Deploy
 						},
 						Ports: []corev1.ContainerPort{
 							{
-								ContainerPort: 1883,
+								ContainerPort: broker.Port,
+							},
+						},
+						VolumeMounts: []corev1.VolumeMount{
+							{
+								Name:      "mosquitto-config",
+								MountPath: "/mosquitto/config/mosquitto.conf",
+								SubPath:   "mosquitto.conf",
+							},
+							{
+								Name:      "mosquitto-config",
+								MountPath: "/mosquitto/config/acl",
+								SubPath:   "acl",
+							},
+							{
+								Name:      "mosquitto-passwd",
+								MountPath: "/mosquitto/config/passwd",
+								SubPath:   BROKER_PASSWORD_FILE_KEY,
 							},
 						},
-						// VolumeMounts: []corev1.VolumeMount{
-						// 	{
-						// 		Name:      "mosquitto-config",
-						// 		MountPath: "/mosquitto/config/mosquitto.conf",
-						// 		SubPath:   "mosquitto.conf",
-						// 	},
-						// },
 					}},
-					// Volumes: []corev1.Volume{
-					// 	{
-					// 		Name: "mosquitto-config",
-					// 	},
-					// },
+					Volumes: []corev1.Volume{
+						{
+							Name: "mosquitto-config",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: a.Placement.ConfigMap(),
+									},
+								},
+							},
+						},
+						{
+							Name: "mosquitto-passwd",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName:  a.Placement.PasswordFile(),
+									DefaultMode: &BROKER_PASSWORD_FILE_MODE,
+								},
+							},
+						},
+					},
 				},
 			},
 		},
 	}
+	if a.ExporterImage != "" {
+		containers := &deployment.Spec.Template.Spec.Containers
+		*containers = append(*containers, a.buildExporterContainer())
+	}
 	return deployment
 }
 
-func (r *TwinServiceReconciler) buildBrokerConfigMapDefinition() *v1.ConfigMap {
+// buildExporterContainer runs the broker exporter next to Mosquitto, reading
+// its $SYS topics over the pod network with the exporter credentials. It has
+// no readiness probe, so that a failing exporter does not take the broker out
+// of its Service.
+func (a *brokerApplier) buildExporterContainer() corev1.Container {
+	return corev1.Container{
+		Name:    "exporter",
+		Image:   a.ExporterImage,
+		Command: []string{"/brokerexporter"},
+		Args: []string{
+			fmt.Sprintf("--broker-address=tcp://localhost:%d", broker.Port),
+			fmt.Sprintf("--bind-address=:%d", BROKER_METRICS_PORT),
+		},
+		Env: credentialsEnv(a.Placement.ExporterCredentials()),
+		Ports: []corev1.ContainerPort{{
+			Name:          "metrics",
+			ContainerPort: BROKER_METRICS_PORT,
+		}},
+	}
+}
+
+func (a *brokerApplier) buildConfigMapDefinition(acl string) *v1.ConfigMap {
 	configmap := &v1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      BROKER_CONFIG_MAP_NAME,
-			Namespace: BROKER_NAMESPACE,
+			Name:      a.Placement.ConfigMap(),
+			Namespace: a.Placement.Namespace,
 		},
 		Data: map[string]string{
-			"mosquitto.conf": `|-
-			# Ip/hostname to listen to.
-			# If not given, will listen on all interfaces
-			#bind_address
-		
-			# Port to use for the default listener.
-			port 1883
-		
-			# Allow anonymous users to connect?
-			# If not, the password file should be created
-			allow_anonymous true
-		
-			# The password file.
-			# Use the "mosquitto_passwd" utility.
-			# If TLS is not compiled, plaintext "username:password" lines bay be used
-			# password_file /mosquitto/config/passwd
-			`,
+			"mosquitto.conf": BROKER_CONFIG,
+			"acl":            acl,
 		},
 	}
 	return configmap
 }
 
-func (r *TwinServiceReconciler) buildBrokerServiceDefinition() *v1.Service {
+// buildServiceDefinition selects the pods of the broker Deployment.
+func (a *brokerApplier) buildServiceDefinition() *v1.Service {
 	service := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      BROKER_SERVICE_NAME,
-			Namespace: BROKER_NAMESPACE,
+			Name:      a.Placement.Service(),
+			Namespace: a.Placement.Namespace,
 		},
 		Spec: v1.ServiceSpec{
-			Selector: buildLabels(BROKER_SERVICE_NAME),
+			Selector: buildLabels(a.Placement.Deployment()),
 			Ports: []v1.ServicePort{
 				{
-					Port: 1883,
-					TargetPort: intstr.IntOrString{
-						IntVal: 1883,
-					},
+					Port:       broker.Port,
+					TargetPort: intstr.FromInt(broker.Port),
 				},
 			},
 		},
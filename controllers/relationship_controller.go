@@ -0,0 +1,279 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/instance"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
+)
+
+// RELATIONSHIP_FINALIZER holds the deletion of a TwinInstance until the
+// deletion policies of the edges leading to it have been applied.
+const RELATIONSHIP_FINALIZER = "dtdl.digitaltwin/relationships"
+
+const RELATIONSHIPS_VALID_CONDITION = "RelationshipsValid"
+const DELETION_BLOCKED_CONDITION = "DeletionBlocked"
+
+// RelationshipReconciler keeps the edges between TwinInstances consistent
+type RelationshipReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Recorder records deletions held by relationships.
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twininstances,verbs=get;list;watch;update;patch;delete
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twininstances/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twininstances/finalizers,verbs=update
+
+// Reconcile checks the edges of the TwinInstance against the other instances
+// of its namespace and reports them in the RelationshipsValid condition,
+// listing edges to missing twins as dangling. When the instance is deleted,
+// the edges leading to it are handled by the onDelete policy of their
+// relationship: Block holds the deletion until the edge is removed, Cascade
+// deletes the twin holding the edge and Nullify removes the edge.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
+func (r *RelationshipReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("TwinInstance", req.NamespacedName)
+
+	twinInstance := &dtdlv1.TwinInstance{}
+	if err := r.Get(ctx, req.NamespacedName, twinInstance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	m, err := model.Load(ctx, r.Client, client.InNamespace(req.Namespace))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	twinInstances := &dtdlv1.TwinInstanceList{}
+	if err := r.List(ctx, twinInstances, client.InNamespace(req.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if !twinInstance.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.finalize(ctx, m, twinInstance, twinInstances.Items)
+	}
+
+	if controllerutil.AddFinalizer(twinInstance, RELATIONSHIP_FINALIZER) {
+		if err := r.Update(ctx, twinInstance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	resolved, err := resolveInstanceClass(m, twinInstance)
+	if err != nil {
+		twinInstance.Status.DanglingEdges = nil
+		return ctrl.Result{}, r.setRelationshipsCondition(ctx, twinInstance, metav1.ConditionUnknown, "ClassNotResolved", err.Error())
+	}
+
+	instances := map[string]*dtdlv1.TwinInstance{}
+	for i := range twinInstances.Items {
+		instances[twinInstances.Items[i].Name] = &twinInstances.Items[i]
+	}
+	errs, dangling := instance.CheckEdges(resolved, twinInstance, instances)
+	twinInstance.Status.DanglingEdges = dangling
+	if len(errs) > 0 {
+		logger.Info("Twin instance has invalid edges", "reason", errs.ToAggregate().Error())
+		return ctrl.Result{}, r.setRelationshipsCondition(ctx, twinInstance, metav1.ConditionFalse, edgeErrorReason(errs), errs.ToAggregate().Error())
+	}
+	return ctrl.Result{}, r.setRelationshipsCondition(ctx, twinInstance, metav1.ConditionTrue, "Valid", "All edges lead to existing twins of the allowed classes")
+}
+
+// finalize applies the deletion policies of the edges leading to a deleted
+// instance and releases it once no edge blocks its deletion.
+func (r *RelationshipReconciler) finalize(ctx context.Context, m *model.Model, twinInstance *dtdlv1.TwinInstance, twinInstances []dtdlv1.TwinInstance) error {
+	logger := log.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(twinInstance, RELATIONSHIP_FINALIZER) {
+		return nil
+	}
+
+	sources := map[string]*dtdlv1.TwinInstance{}
+	for i := range twinInstances {
+		sources[twinInstances[i].Name] = &twinInstances[i]
+	}
+
+	blockers := []string{}
+	for _, edge := range instance.IncomingEdges(twinInstances, twinInstance.Name) {
+		source := sources[edge.Source]
+		if !source.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		switch deletionPolicy(m, source, edge.Relationship) {
+		case dtdlv0.BlockDeletion:
+			blockers = append(blockers, fmt.Sprintf("%s (%s)", edge.Source, edge.Relationship))
+		case dtdlv0.CascadeDeletion:
+			logger.Info("Deleting twin instance related to a deleted twin", "source", edge.Source, "relationship", edge.Relationship)
+			if err := r.Delete(ctx, source); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		case dtdlv0.NullifyEdge:
+			if instance.RemoveEdge(&source.Spec, edge.Relationship, edge.Target) {
+				logger.Info("Removing edge to a deleted twin", "source", edge.Source, "relationship", edge.Relationship)
+				if err := r.Update(ctx, source); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if len(blockers) > 0 {
+		message := "Twin is the target of edges with the Block deletion policy from " + strings.Join(blockers, ", ")
+		if previous := meta.FindStatusCondition(twinInstance.Status.Conditions, DELETION_BLOCKED_CONDITION); previous == nil || previous.Message != message {
+			r.Recorder.Event(twinInstance, corev1.EventTypeWarning, "BlockedByRelationships", message)
+		}
+		meta.SetStatusCondition(&twinInstance.Status.Conditions, metav1.Condition{
+			Type:               DELETION_BLOCKED_CONDITION,
+			Status:             metav1.ConditionTrue,
+			Reason:             "BlockedByRelationships",
+			Message:            message,
+			ObservedGeneration: twinInstance.Generation,
+		})
+		return r.Status().Update(ctx, twinInstance)
+	}
+
+	controllerutil.RemoveFinalizer(twinInstance, RELATIONSHIP_FINALIZER)
+	if err := r.Update(ctx, twinInstance); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// resolveInstanceClass resolves the class an instance selects.
+func resolveInstanceClass(m *model.Model, twinInstance *dtdlv1.TwinInstance) (*model.ResolvedClass, error) {
+	twinClass, err := m.Select(twinInstance.Spec.Class.String())
+	if err != nil {
+		return nil, err
+	}
+	return m.ResolveClass(twinClass)
+}
+
+// deletionPolicy returns the onDelete policy of a relationship of the class
+// of source, or no policy when the class does not resolve.
+func deletionPolicy(m *model.Model, source *dtdlv1.TwinInstance, relationship string) dtdlv0.DeletionPolicy {
+	resolved, err := resolveInstanceClass(m, source)
+	if err != nil {
+		return ""
+	}
+	for _, rel := range resolved.Relationships {
+		if rel.Name == relationship {
+			return rel.OnDelete
+		}
+	}
+	return ""
+}
+
+// edgeErrorReason summarizes edge errors: dangling edges take precedence
+// over wrong target classes and multiplicities.
+func edgeErrorReason(errs field.ErrorList) string {
+	for _, err := range errs {
+		if err.Type == field.ErrorTypeNotFound {
+			return "DanglingEdges"
+		}
+	}
+	return "InvalidEdges"
+}
+
+func (r *RelationshipReconciler) setRelationshipsCondition(ctx context.Context, twinInstance *dtdlv1.TwinInstance, status metav1.ConditionStatus, reason, message string) error {
+	meta.SetStatusCondition(&twinInstance.Status.Conditions, metav1.Condition{
+		Type:               RELATIONSHIPS_VALID_CONDITION,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: twinInstance.Generation,
+	})
+	return r.Status().Update(ctx, twinInstance)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RelationshipReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("relationship").
+		For(&dtdlv1.TwinInstance{}).
+		Watches(&source.Kind{Type: &dtdlv1.TwinInstance{}}, handler.EnqueueRequestsFromMapFunc(relatedInstances(r.Client))).
+		Watches(&source.Kind{Type: &dtdlv0.TwinClass{}}, handler.EnqueueRequestsFromMapFunc(namespaceInstances(r.Client))).
+		Complete(monitoring.Instrument("relationship", r))
+}
+
+// relatedInstances maps a TwinInstance to the instances it relates to, whose
+// deletion it may block, and to the instances relating to it, whose edges it
+// may resolve or leave dangling.
+func relatedInstances(reader client.Reader) handler.MapFunc {
+	return func(object client.Object) []reconcile.Request {
+		twinInstance, ok := object.(*dtdlv1.TwinInstance)
+		if !ok {
+			return nil
+		}
+
+		requests := []reconcile.Request{}
+		for _, relationship := range twinInstance.Spec.Relationships {
+			for _, edge := range relationship.Targets {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Namespace: twinInstance.Namespace, Name: edge.Name}})
+			}
+		}
+
+		twinInstances := &dtdlv1.TwinInstanceList{}
+		if err := reader.List(context.TODO(), twinInstances, client.InNamespace(twinInstance.Namespace)); err != nil {
+			return requests
+		}
+		for _, edge := range instance.IncomingEdges(twinInstances.Items, twinInstance.Name) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Namespace: twinInstance.Namespace, Name: edge.Source}})
+		}
+		return requests
+	}
+}
+
+// namespaceInstances maps an object to every TwinInstance of its namespace,
+// such as a TwinClass, whose relationships decide which classes the targets
+// of other classes may have.
+func namespaceInstances(reader client.Reader) handler.MapFunc {
+	return func(object client.Object) []reconcile.Request {
+		twinInstances := &dtdlv1.TwinInstanceList{}
+		if err := reader.List(context.TODO(), twinInstances, client.InNamespace(object.GetNamespace())); err != nil {
+			return nil
+		}
+		requests := []reconcile.Request{}
+		for i := range twinInstances.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&twinInstances.Items[i])})
+		}
+		return requests
+	}
+}
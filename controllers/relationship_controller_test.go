@@ -0,0 +1,167 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+// createTwin creates an instance of class in namespace with edges to
+// targets through relationship.
+func createTwin(ctx context.Context, namespace, name, class, relationship string, targets ...string) *dtdlv1.TwinInstance {
+	twinInstance := &dtdlv1.TwinInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       dtdlv1.TwinInstanceSpec{Class: dtdlv1.TwinClassReference{Name: class}},
+	}
+	if len(targets) > 0 {
+		edges := []dtdlv1.TwinInstanceEdge{}
+		for _, target := range targets {
+			edges = append(edges, dtdlv1.TwinInstanceEdge{Name: target})
+		}
+		twinInstance.Spec.Relationships = []dtdlv1.TwinInstanceRelationship{{Name: relationship, Targets: edges}}
+	}
+	Expect(k8sClient.Create(ctx, twinInstance)).To(Succeed())
+	return twinInstance
+}
+
+// getTwin returns the current state of twinInstance.
+func getTwin(ctx context.Context, twinInstance *dtdlv1.TwinInstance) *dtdlv1.TwinInstance {
+	current := &dtdlv1.TwinInstance{}
+	Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(twinInstance), current)).To(Succeed())
+	return current
+}
+
+// edgeTargets returns the targets of the edges of relationship of
+// twinInstance.
+func edgeTargets(twinInstance *dtdlv1.TwinInstance, relationship string) []string {
+	targets := []string{}
+	for _, rel := range twinInstance.Spec.Relationships {
+		if rel.Name == relationship {
+			for _, edge := range rel.Targets {
+				targets = append(targets, edge.Name)
+			}
+		}
+	}
+	return targets
+}
+
+var _ = Describe("RelationshipReconciler", func() {
+	var ctx context.Context
+	var namespace string
+	var recorder *record.FakeRecorder
+	var reconciler *RelationshipReconciler
+
+	createClasses := func(onDelete dtdlv0.DeletionPolicy) {
+		for _, class := range []*dtdlv0.TwinClass{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "line", Namespace: namespace},
+				Spec: dtdlv0.TwinClassSpec{Name: "Line", Relationships: []dtdlv0.TwinRelationship{
+					{Name: "machines", Targets: []string{"Machine"}, OnDelete: onDelete},
+				}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: namespace},
+				Spec:       dtdlv0.TwinClassSpec{Name: "Machine"},
+			},
+		} {
+			Expect(k8sClient.Create(ctx, class)).To(Succeed())
+		}
+	}
+	reconcileTwin := func(twinInstance *dtdlv1.TwinInstance) {
+		_, err := reconciler.Reconcile(ctx, requestFor(twinInstance))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	// deleteMachine deletes a machine held by the finalizer and applies the
+	// deletion policies of the edges leading to it.
+	deleteMachine := func(machine *dtdlv1.TwinInstance) {
+		reconcileTwin(machine)
+		Expect(getTwin(ctx, machine).Finalizers).To(ContainElement(RELATIONSHIP_FINALIZER))
+		Expect(k8sClient.Delete(ctx, machine)).To(Succeed())
+		reconcileTwin(machine)
+	}
+	expectDeleted := func(twinInstance *dtdlv1.TwinInstance) {
+		err := k8sClient.Get(ctx, client.ObjectKeyFromObject(twinInstance), &dtdlv1.TwinInstance{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue(), "twin instance %s still exists", twinInstance.Name)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = createNamespace(ctx)
+		recorder = record.NewFakeRecorder(1024)
+		reconciler = &RelationshipReconciler{Client: k8sClient, Scheme: scheme.Scheme, Recorder: recorder}
+	})
+
+	It("blocks the deletion of a twin referenced with the Block policy", func() {
+		createClasses(dtdlv0.BlockDeletion)
+		press := createTwin(ctx, namespace, "press", "Machine", "")
+		line := createTwin(ctx, namespace, "line", "Line", "machines", "press")
+
+		deleteMachine(press)
+		blocked := getTwin(ctx, press)
+		Expect(blocked.DeletionTimestamp.IsZero()).To(BeFalse())
+		Expect(blocked.Finalizers).To(ContainElement(RELATIONSHIP_FINALIZER))
+		condition := meta.FindStatusCondition(blocked.Status.Conditions, DELETION_BLOCKED_CONDITION)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Reason).To(Equal("BlockedByRelationships"))
+		Expect(condition.Message).To(ContainSubstring("line (machines)"))
+		Expect(recorder.Events).To(Receive(ContainSubstring("BlockedByRelationships")))
+		Expect(edgeTargets(getTwin(ctx, line), "machines")).To(Equal([]string{"press"}))
+
+		By("releasing the twin once the edge is removed")
+		line = getTwin(ctx, line)
+		line.Spec.Relationships = nil
+		Expect(k8sClient.Update(ctx, line)).To(Succeed())
+		reconcileTwin(press)
+		expectDeleted(press)
+	})
+
+	It("deletes the twins referencing a deleted twin with the Cascade policy", func() {
+		createClasses(dtdlv0.CascadeDeletion)
+		press := createTwin(ctx, namespace, "press", "Machine", "")
+		line := createTwin(ctx, namespace, "line", "Line", "machines", "press")
+		other := createTwin(ctx, namespace, "other", "Line", "machines", "lathe")
+
+		deleteMachine(press)
+		expectDeleted(press)
+		expectDeleted(line)
+		Expect(edgeTargets(getTwin(ctx, other), "machines")).To(Equal([]string{"lathe"}))
+	})
+
+	It("removes the edges to a deleted twin with the Nullify policy", func() {
+		createClasses(dtdlv0.NullifyEdge)
+		press := createTwin(ctx, namespace, "press", "Machine", "")
+		createTwin(ctx, namespace, "lathe", "Machine", "")
+		line := createTwin(ctx, namespace, "line", "Line", "machines", "press", "lathe")
+
+		deleteMachine(press)
+		expectDeleted(press)
+		Expect(edgeTargets(getTwin(ctx, line), "machines")).To(Equal([]string{"lathe"}))
+	})
+})
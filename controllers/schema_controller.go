@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/naming"
+	"github.com/agwermann/dt-operator/pkg/schema"
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+const SCHEMA_CONFIG_MAP_SUFFIX = "-schema"
+const SCHEMA_PACKAGE_PREFIX = "digitaltwin."
+
+// SCHEMA_CLASS_LABEL is set on schema ConfigMaps so that consumers and schema
+// registry syncers can select them.
+const SCHEMA_CLASS_LABEL = "dtdl.digitaltwin/schema-class"
+
+// SCHEMA_VERSION_LABEL holds the DTMI version of versioned classes, since
+// every version publishes its own schema ConfigMap.
+const SCHEMA_VERSION_LABEL = "dtdl.digitaltwin/schema-version"
+
+const SCHEMA_READY_CONDITION = "SchemaReady"
+
+func schemaPackage(namespace string) string {
+	return SCHEMA_PACKAGE_PREFIX + naming.SnakeCase(namespace)
+}
+
+// publishSchemas assigns stable field numbers to the class, renders its
+// Protobuf, Avro and JSON schemas and its topic layout into the class schema
+// ConfigMap and records the assignment in the class status.
+func (r *TwinClassReconciler) publishSchemas(ctx context.Context, twinClass *dtdlv0.TwinClass, resolved *model.ResolvedClass) error {
+	previous := schema.Numbering{
+		Assigned: twinClass.Status.FieldNumbers,
+		Reserved: twinClass.Status.ReservedFieldNumbers,
+	}
+
+	numbering, err := schema.AssignClass(previous, resolved)
+	if err != nil {
+		return r.setSchemaCondition(ctx, twinClass, metav1.ConditionFalse, "FieldNumberConflict", err.Error())
+	}
+
+	pkg := schemaPackage(twinClass.Namespace)
+	proto, err := schema.Proto(resolved, numbering, pkg)
+	if err != nil {
+		return err
+	}
+	avro, err := schema.Avro(resolved, numbering, pkg)
+	if err != nil {
+		return err
+	}
+	jsonSchemas, err := schema.JSONSchema(resolved)
+	if err != nil {
+		return err
+	}
+	layout, err := json.MarshalIndent(topics.ForClass(twinClass.Namespace, resolved), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      twinClass.Name + SCHEMA_CONFIG_MAP_SUFFIX,
+			Namespace: twinClass.Namespace,
+		},
+	}
+
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		if configMap.Labels == nil {
+			configMap.Labels = map[string]string{}
+		}
+		configMap.Labels[SCHEMA_CLASS_LABEL] = naming.GoName(resolved.Name)
+		if version := model.Version(twinClass); version > 0 {
+			configMap.Labels[SCHEMA_VERSION_LABEL] = strconv.Itoa(version)
+		}
+
+		fileName := naming.GoName(resolved.Name)
+		configMap.Data = map[string]string{
+			fileName + ".proto":       proto,
+			fileName + ".topics.json": string(layout),
+		}
+		for record, avsc := range avro {
+			configMap.Data[record+".avsc"] = avsc
+		}
+		for name, document := range jsonSchemas {
+			configMap.Data[name+".schema.json"] = document
+		}
+		return controllerutil.SetControllerReference(twinClass, configMap, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+
+	twinClass.Status.FieldNumbers = numbering.Assigned
+	twinClass.Status.ReservedFieldNumbers = numbering.Reserved
+	twinClass.Status.SchemaConfigMap = configMap.Name
+	return r.setSchemaCondition(ctx, twinClass, metav1.ConditionTrue, "Published", "Schemas published to ConfigMap "+configMap.Name)
+}
+
+func (r *TwinClassReconciler) setSchemaCondition(ctx context.Context, twinClass *dtdlv0.TwinClass, status metav1.ConditionStatus, reason, message string) error {
+	setConditionWithEvent(r.Recorder, twinClass, &twinClass.Status.Conditions, metav1.Condition{
+		Type:               SCHEMA_READY_CONDITION,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: twinClass.Generation,
+	})
+	return r.Status().Update(ctx, twinClass)
+}
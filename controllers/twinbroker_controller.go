@@ -0,0 +1,164 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
+)
+
+const BROKER_READY_CONDITION = "Ready"
+
+// TwinBrokerReconciler reconciles a TwinBroker object
+type TwinBrokerReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Recorder records the lifecycle of the broker resources.
+	Recorder record.EventRecorder
+	// ExporterImage is the operator image, which holds the broker exporter.
+	// Brokers run without their metrics sidecar when empty.
+	ExporterImage string
+	// OperatorNamespace is the namespace the operator runs in, which the
+	// network policy of every broker admits.
+	OperatorNamespace string
+}
+
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinbrokers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinbrokers/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinbrokers/finalizers,verbs=update
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinclasses;twinenums;twinservices,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps;services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile applies the broker of a namespace, or of a single TwinService,
+// in the namespace of the TwinBroker. The ACL of the broker only covers the
+// classes and services it serves, and its NetworkPolicy only admits the pods
+// of the namespace and of the operator. The broker resources are controlled
+// by the TwinBroker, so that they are deleted with it.
+func (r *TwinBrokerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("TwinBroker", req.NamespacedName)
+
+	twinBroker := &dtdlv1.TwinBroker{}
+	if err := r.Get(ctx, req.NamespacedName, twinBroker); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !twinBroker.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	placement := broker.Placement{Namespace: twinBroker.Namespace, Name: twinBroker.Name}
+	applier := &brokerApplier{
+		Client:            r.Client,
+		Scheme:            r.Scheme,
+		Recorder:          r.Recorder,
+		ExporterImage:     r.ExporterImage,
+		Placement:         placement,
+		Owner:             twinBroker,
+		Subject:           twinBroker,
+		OperatorNamespace: r.OperatorNamespace,
+	}
+
+	access, err := buildBrokerAccess(ctx, r.Client, r.Scheme, twinBroker.Namespace, twinBroker.Spec.Service)
+	if err == nil {
+		err = applier.apply(ctx, access)
+	}
+	if err != nil {
+		logger.Error(err, "Error while applying broker")
+		setConditionWithEvent(r.Recorder, twinBroker, &twinBroker.Status.Conditions, metav1.Condition{
+			Type:               BROKER_READY_CONDITION,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: twinBroker.Generation,
+			Reason:             "BrokerFailed",
+			Message:            err.Error(),
+		})
+		if updateErr := r.Status().Update(ctx, twinBroker); updateErr != nil {
+			return ctrl.Result{}, updateErr
+		}
+		return ctrl.Result{}, err
+	}
+
+	deployment := &appsv1.Deployment{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: placement.Namespace, Name: placement.Deployment()}, deployment)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	condition := metav1.Condition{
+		Type:               BROKER_READY_CONDITION,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: twinBroker.Generation,
+		Reason:             "BrokerUnavailable",
+		Message:            "Broker deployment " + placement.Deployment() + " has no available replica",
+	}
+	if err == nil && deployment.Status.AvailableReplicas > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "BrokerAvailable"
+		condition.Message = "Broker available at " + placement.Address()
+	}
+	setConditionWithEvent(r.Recorder, twinBroker, &twinBroker.Status.Conditions, condition)
+
+	twinBroker.Status.Address = placement.Address()
+	twinBroker.Status.Services = access.Services
+	return ctrl.Result{}, r.Status().Update(ctx, twinBroker)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *TwinBrokerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dtdlv1.TwinBroker{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Service{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Watches(&source.Kind{Type: &dtdlv0.TwinClass{}}, handler.EnqueueRequestsFromMapFunc(r.namespaceBrokers)).
+		Watches(&source.Kind{Type: &dtdlv0.TwinService{}}, handler.EnqueueRequestsFromMapFunc(r.namespaceBrokers)).
+		Complete(monitoring.Instrument("twinbroker", r))
+}
+
+// namespaceBrokers maps a TwinClass or TwinService to the TwinBrokers of its
+// namespace, whose ACLs may cover it.
+func (r *TwinBrokerReconciler) namespaceBrokers(object client.Object) []reconcile.Request {
+	twinBrokers := &dtdlv1.TwinBrokerList{}
+	if err := r.List(context.TODO(), twinBrokers, client.InNamespace(object.GetNamespace())); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, twinBroker := range twinBrokers.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&twinBroker)})
+	}
+	return requests
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+var _ = Describe("TwinBroker", func() {
+	const operatorNamespace = "dt-operator-system"
+
+	var ctx context.Context
+	var namespace string
+	var serviceReconciler *TwinServiceReconciler
+	var brokerReconciler *TwinBrokerReconciler
+
+	createService := func(name string) *dtdlv0.TwinService {
+		twinService := &dtdlv0.TwinService{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: dtdlv0.TwinServiceSpec{
+				DataSource: "mqtt",
+				Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: name, Image: name}},
+				}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, twinService)).To(Succeed())
+		return twinService
+	}
+	stopUsingMQTT := func(twinService *dtdlv0.TwinService) {
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(twinService), twinService)).To(Succeed())
+		twinService.Spec.DataSource = ""
+		Expect(k8sClient.Update(ctx, twinService)).To(Succeed())
+	}
+	reconcileService := func(twinService *dtdlv0.TwinService) {
+		_, err := serviceReconciler.Reconcile(ctx, requestFor(twinService))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	getBroker := func(name string) *dtdlv1.TwinBroker {
+		twinBroker := &dtdlv1.TwinBroker{}
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, twinBroker)).To(Succeed())
+		return twinBroker
+	}
+	expectNoBroker := func(name string) {
+		err := k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &dtdlv1.TwinBroker{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue(), "twin broker %s still exists", name)
+	}
+	reconcileBroker := func(name string) *dtdlv1.TwinBroker {
+		_, err := brokerReconciler.Reconcile(ctx, requestFor(getBroker(name)))
+		Expect(err).NotTo(HaveOccurred())
+		return getBroker(name)
+	}
+	ownerUIDs := func(object metav1.Object) []string {
+		uids := []string{}
+		for _, reference := range object.GetOwnerReferences() {
+			uids = append(uids, string(reference.UID))
+		}
+		return uids
+	}
+	expectControlledBy := func(object client.Object, owner metav1.Object) {
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(object), object)).To(Succeed())
+		Expect(metav1.IsControlledBy(object, owner)).To(BeTrue(), "%T %s is not controlled by %s", object, object.GetName(), owner.GetName())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = createNamespace(ctx)
+		recorder := record.NewFakeRecorder(1024)
+		serviceReconciler = &TwinServiceReconciler{
+			Client:      k8sClient,
+			Scheme:      scheme.Scheme,
+			Recorder:    recorder,
+			BrokerScope: broker.NamespaceScope,
+		}
+		brokerReconciler = &TwinBrokerReconciler{
+			Client:            k8sClient,
+			Scheme:            scheme.Scheme,
+			Recorder:          recorder,
+			OperatorNamespace: operatorNamespace,
+		}
+	})
+
+	It("is shared by the services of a namespace through owner references", func() {
+		alarms := createService("alarms")
+		energy := createService("energy")
+		reconcileService(alarms)
+		reconcileService(energy)
+
+		placement := broker.NamespaceScope.For(namespace, "")
+		twinBroker := getBroker(placement.Name)
+		Expect(twinBroker.Spec.Service).To(BeEmpty())
+		Expect(ownerUIDs(twinBroker)).To(ConsistOf(string(alarms.UID), string(energy.UID)))
+		Expect(metav1.GetControllerOf(twinBroker)).To(BeNil())
+
+		twinBroker = reconcileBroker(placement.Name)
+		Expect(twinBroker.Status.Address).To(Equal(placement.Address()))
+		Expect(twinBroker.Status.Services).To(ConsistOf("alarms", "energy"))
+
+		configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: placement.ConfigMap(), Namespace: namespace}}
+		expectControlledBy(configMap, twinBroker)
+		Expect(configMap.Data["acl"]).To(ContainSubstring("user " + topics.ServiceUsername(namespace, "alarms") + "\n"))
+		Expect(configMap.Data["acl"]).To(ContainSubstring("user " + topics.ServiceUsername(namespace, "energy") + "\n"))
+		Expect(configMap.Data["mosquitto.conf"]).To(ContainSubstring("allow_anonymous false"))
+		expectControlledBy(&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: placement.Deployment(), Namespace: namespace}}, twinBroker)
+		expectControlledBy(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: placement.Service(), Namespace: namespace}}, twinBroker)
+	})
+
+	It("is deleted with the last service using it", func() {
+		alarms := createService("alarms")
+		energy := createService("energy")
+		reconcileService(alarms)
+		reconcileService(energy)
+		name := broker.NamespaceScope.For(namespace, "").Name
+
+		// The test environment runs no garbage collector: the TwinBroker
+		// is released by services that stop using MQTT, and otherwise
+		// left to the collector through its owner references.
+		stopUsingMQTT(alarms)
+		reconcileService(alarms)
+		Expect(ownerUIDs(getBroker(name))).To(ConsistOf(string(energy.UID)))
+		Expect(reconcileBroker(name).Status.Services).To(ConsistOf("energy"))
+
+		Expect(k8sClient.Delete(ctx, alarms)).To(Succeed())
+		reconcileService(alarms)
+		Expect(ownerUIDs(getBroker(name))).To(ConsistOf(string(energy.UID)))
+
+		stopUsingMQTT(energy)
+		reconcileService(energy)
+		expectNoBroker(name)
+	})
+
+	It("moves services to the broker of the new scope", func() {
+		alarms := createService("alarms")
+		reconcileService(alarms)
+		namespaceBroker := broker.NamespaceScope.For(namespace, "alarms").Name
+		Expect(ownerUIDs(getBroker(namespaceBroker))).To(ConsistOf(string(alarms.UID)))
+
+		serviceReconciler.BrokerScope = broker.ServiceScope
+		reconcileService(alarms)
+		expectNoBroker(namespaceBroker)
+		serviceBroker := getBroker(broker.ServiceScope.For(namespace, "alarms").Name)
+		Expect(serviceBroker.Spec.Service).To(Equal("alarms"))
+		Expect(metav1.IsControlledBy(serviceBroker, alarms)).To(BeTrue())
+
+		serviceReconciler.BrokerScope = broker.NamespaceScope
+		reconcileService(alarms)
+		expectNoBroker(serviceBroker.Name)
+		Expect(ownerUIDs(getBroker(namespaceBroker))).To(ConsistOf(string(alarms.UID)))
+	})
+
+	It("serves a single service in the service scope", func() {
+		serviceReconciler.BrokerScope = broker.ServiceScope
+		alarms := createService("alarms")
+		energy := createService("energy")
+		reconcileService(alarms)
+		reconcileService(energy)
+
+		placement := broker.ServiceScope.For(namespace, "alarms")
+		twinBroker := reconcileBroker(placement.Name)
+		Expect(twinBroker.Status.Services).To(ConsistOf("alarms"))
+
+		configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: placement.ConfigMap(), Namespace: namespace}}
+		expectControlledBy(configMap, twinBroker)
+		Expect(configMap.Data["acl"]).To(ContainSubstring("user " + topics.ServiceUsername(namespace, "alarms") + "\n"))
+		Expect(configMap.Data["acl"]).NotTo(ContainSubstring(topics.ServiceUsername(namespace, "energy")))
+	})
+
+	It("admits only the namespace and the operator to the broker", func() {
+		reconcileService(createService("alarms"))
+		placement := broker.NamespaceScope.For(namespace, "")
+		twinBroker := reconcileBroker(placement.Name)
+
+		policy := &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: placement.NetworkPolicy(), Namespace: namespace}}
+		expectControlledBy(policy, twinBroker)
+		Expect(policy.Spec.PodSelector.MatchLabels).To(Equal(buildLabels(placement.Deployment())))
+		Expect(policy.Spec.PolicyTypes).To(Equal([]networkingv1.PolicyType{networkingv1.PolicyTypeIngress}))
+		Expect(policy.Spec.Ingress).To(HaveLen(1))
+		Expect(policy.Spec.Ingress[0].Ports).To(HaveLen(1))
+		Expect(policy.Spec.Ingress[0].Ports[0].Port.IntValue()).To(Equal(broker.Port))
+		Expect(policy.Spec.Ingress[0].From).To(Equal([]networkingv1.NetworkPolicyPeer{
+			{PodSelector: &metav1.LabelSelector{}},
+			{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: operatorNamespace}}},
+		}))
+	})
+
+	It("applies the password file of the services, twins and recorders", func() {
+		alarms := createService("alarms")
+		reconcileService(alarms)
+		placement := broker.NamespaceScope.For(namespace, "")
+		twinBroker := reconcileBroker(placement.Name)
+
+		passwd := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: placement.PasswordFile(), Namespace: namespace}}
+		expectControlledBy(passwd, twinBroker)
+		usernames := []string{}
+		for _, line := range strings.Split(strings.TrimSpace(string(passwd.Data[BROKER_PASSWORD_FILE_KEY])), "\n") {
+			username, hash, ok := strings.Cut(line, ":")
+			Expect(ok).To(BeTrue(), "malformed password file line %q", line)
+			Expect(hash).NotTo(BeEmpty())
+			usernames = append(usernames, username)
+		}
+		Expect(usernames).To(Equal([]string{
+			topics.RecorderUsername(namespace),
+			topics.ServiceUsername(namespace, "alarms"),
+			topics.TwinUsername(namespace),
+		}))
+
+		serviceSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "alarms" + SERVICE_CREDENTIALS_SUFFIX, Namespace: namespace}}
+		expectControlledBy(serviceSecret, alarms)
+		Expect(serviceSecret.Type).To(Equal(corev1.SecretTypeBasicAuth))
+		Expect(string(serviceSecret.Data[corev1.BasicAuthUsernameKey])).To(Equal(topics.ServiceUsername(namespace, "alarms")))
+
+		By("keeping the password file and the broker pods when nothing changed")
+		deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: placement.Deployment(), Namespace: namespace}}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(deployment), deployment)).To(Succeed())
+		reconcileBroker(placement.Name)
+		unchanged := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(passwd), unchanged)).To(Succeed())
+		Expect(unchanged.Data).To(Equal(passwd.Data))
+		rolled := &appsv1.Deployment{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(deployment), rolled)).To(Succeed())
+		Expect(rolled.Spec.Template.Annotations).To(Equal(deployment.Spec.Template.Annotations))
+	})
+})
@@ -19,37 +19,63 @@ package controllers
 import (
 	"context"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
 )
 
 // TwinClassReconciler reconciles a TwinClass object
 type TwinClassReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Recorder records the resolution and schema publication of classes.
+	Recorder record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinclasses,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinclasses/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinclasses/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the TwinClass object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// Reconcile resolves the TwinClass against the TwinClasses and TwinEnums of
+// its namespace and publishes the generated schemas.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
 func (r *TwinClassReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	logger := log.FromContext(ctx).WithValues("TwinClass", req.NamespacedName)
 
-	// TODO(user): your logic here
+	twinClass := &dtdlv0.TwinClass{}
+	if err := r.Get(ctx, req.NamespacedName, twinClass); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	m, err := model.Load(ctx, r.Client, client.InNamespace(req.Namespace))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	resolved, err := m.ResolveClass(twinClass)
+	if err != nil {
+		logger.Info("Unable to resolve twin class", "reason", err.Error())
+		return ctrl.Result{}, r.setSchemaCondition(ctx, twinClass, metav1.ConditionFalse, "ResolutionFailed", err.Error())
+	}
+
+	if err := r.publishSchemas(ctx, twinClass, resolved); err != nil {
+		logger.Error(err, "Error while publishing twin class schemas")
+		return ctrl.Result{}, err
+	}
 
 	return ctrl.Result{}, nil
 }
@@ -58,5 +84,47 @@ func (r *TwinClassReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 func (r *TwinClassReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&dtdlv0.TwinClass{}).
-		Complete(r)
+		Owns(&corev1.ConfigMap{}).
+		Watches(&source.Kind{Type: &dtdlv0.TwinEnum{}}, handler.EnqueueRequestsFromMapFunc(r.classesReferencingEnum)).
+		Watches(&source.Kind{Type: &dtdlv0.TwinClass{}}, handler.EnqueueRequestsFromMapFunc(r.classesDependingOnClass)).
+		Complete(monitoring.Instrument("twinclass", r))
+}
+
+// classesReferencingEnum maps a TwinEnum to the TwinClasses of its namespace
+// that use it, directly or through their components.
+func (r *TwinClassReconciler) classesReferencingEnum(object client.Object) []reconcile.Request {
+	twinEnum, ok := object.(*dtdlv0.TwinEnum)
+	if !ok {
+		return nil
+	}
+
+	m, err := model.Load(context.TODO(), r.Client, client.InNamespace(twinEnum.Namespace))
+	if err != nil {
+		return nil
+	}
+	return classRequests(m.ClassesUsingEnum(twinEnum.Spec.Name))
+}
+
+// classesDependingOnClass maps a TwinClass to the TwinClasses of its
+// namespace embedding it as a component, whose flattened schemas change with
+// it, and to those relying on it to declare the inverse of a relationship.
+func (r *TwinClassReconciler) classesDependingOnClass(object client.Object) []reconcile.Request {
+	twinClass, ok := object.(*dtdlv0.TwinClass)
+	if !ok {
+		return nil
+	}
+
+	m, err := model.Load(context.TODO(), r.Client, client.InNamespace(twinClass.Namespace))
+	if err != nil {
+		return nil
+	}
+	return classRequests(append(m.ClassesEmbedding(twinClass.Spec.Name), m.ClassesWithInverseTo(twinClass.Spec.Name)...))
+}
+
+func classRequests(classes []*dtdlv0.TwinClass) []reconcile.Request {
+	requests := []reconcile.Request{}
+	for _, twinClass := range classes {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(twinClass)})
+	}
+	return requests
 }
@@ -19,37 +19,65 @@ package controllers
 import (
 	"context"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
+	"github.com/agwermann/dt-operator/pkg/schema"
 )
 
 // TwinEnumReconciler reconciles a TwinEnum object
 type TwinEnumReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Recorder records value numbering and its failures.
+	Recorder record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinenums,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinenums/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinenums/finalizers,verbs=update
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the TwinEnum object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// Reconcile records a stable protobuf number for every TwinEnum value, so
+// that reordering or removing values never renumbers the remaining ones.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
 func (r *TwinEnumReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	logger := log.FromContext(ctx).WithValues("TwinEnum", req.NamespacedName)
 
-	// TODO(user): your logic here
+	twinEnum := &dtdlv0.TwinEnum{}
+	if err := r.Get(ctx, req.NamespacedName, twinEnum); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	previous := schema.Numbering{
+		Assigned: twinEnum.Status.ValueNumbers,
+		Reserved: twinEnum.Status.ReservedValueNumbers,
+	}
+
+	numbering, err := schema.Assign(previous, twinEnum.Spec.Values, nil, 1)
+	if err != nil {
+		r.Recorder.Event(twinEnum, corev1.EventTypeWarning, "NumberingFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+
+	if numbering.Equal(previous) {
+		return ctrl.Result{}, nil
+	}
+
+	twinEnum.Status.ValueNumbers = numbering.Assigned
+	twinEnum.Status.ReservedValueNumbers = numbering.Reserved
+	if err := r.Status().Update(ctx, twinEnum); err != nil {
+		logger.Error(err, "Error while updating twin enum value numbers")
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Eventf(twinEnum, corev1.EventTypeNormal, "ValuesNumbered", "Numbered %d values", len(numbering.Assigned))
 
 	return ctrl.Result{}, nil
 }
@@ -58,5 +86,5 @@ func (r *TwinEnumReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 func (r *TwinEnumReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&dtdlv0.TwinEnum{}).
-		Complete(r)
+		Complete(monitoring.Instrument("twinenum", r))
 }
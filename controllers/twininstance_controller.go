@@ -0,0 +1,168 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/dtmi"
+	"github.com/agwermann/dt-operator/pkg/instance"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
+)
+
+const INSTANCE_RESOLVED_CONDITION = "Resolved"
+
+// TwinInstanceReconciler reconciles a TwinInstance object
+type TwinInstanceReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twininstances,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twininstances/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twininstances/finalizers,verbs=update
+
+// Reconcile resolves the class of the TwinInstance and checks its values
+// against it, reporting the outcome in the Resolved condition. Classes can
+// change or disappear after the instance was admitted, so the check is
+// repeated whenever the classes and enums of the namespace change.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
+func (r *TwinInstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("TwinInstance", req.NamespacedName)
+
+	twinInstance := &dtdlv1.TwinInstance{}
+	if err := r.Get(ctx, req.NamespacedName, twinInstance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	m, err := model.Load(ctx, r.Client, client.InNamespace(req.Namespace))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	twinInstance.Status.TwinClass = ""
+	twinInstance.Status.ClassID = ""
+	twinClass, err := m.Select(twinInstance.Spec.Class.String())
+	if err != nil {
+		logger.Info("Unable to select twin class", "reason", err.Error())
+		return ctrl.Result{}, r.setResolvedCondition(ctx, twinInstance, metav1.ConditionFalse, "ClassNotFound", err.Error())
+	}
+	twinInstance.Status.TwinClass = twinClass.Name
+	twinInstance.Status.ClassID = twinClass.Spec.ID
+
+	resolved, err := m.ResolveClass(twinClass)
+	if err != nil {
+		logger.Info("Unable to resolve twin class", "reason", err.Error())
+		return ctrl.Result{}, r.setResolvedCondition(ctx, twinInstance, metav1.ConditionFalse, "ClassInvalid", err.Error())
+	}
+
+	if errs := instance.Validate(resolved, &twinInstance.Spec); len(errs) > 0 {
+		return ctrl.Result{}, r.setResolvedCondition(ctx, twinInstance, metav1.ConditionFalse, "InvalidValues", errs.ToAggregate().Error())
+	}
+
+	return ctrl.Result{}, r.setResolvedCondition(ctx, twinInstance, metav1.ConditionTrue, "Resolved",
+		fmt.Sprintf("Values match twin class %q", twinClass.Spec.Name))
+}
+
+func (r *TwinInstanceReconciler) setResolvedCondition(ctx context.Context, twinInstance *dtdlv1.TwinInstance, status metav1.ConditionStatus, reason, message string) error {
+	meta.SetStatusCondition(&twinInstance.Status.Conditions, metav1.Condition{
+		Type:               INSTANCE_RESOLVED_CONDITION,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: twinInstance.Generation,
+	})
+	return r.Status().Update(ctx, twinInstance)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *TwinInstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dtdlv1.TwinInstance{}).
+		Watches(&source.Kind{Type: &dtdlv0.TwinClass{}}, handler.EnqueueRequestsFromMapFunc(r.instancesOfClass)).
+		Watches(&source.Kind{Type: &dtdlv0.TwinEnum{}}, handler.EnqueueRequestsFromMapFunc(r.instancesUsingEnum)).
+		Complete(monitoring.Instrument("twininstance", r))
+}
+
+// instancesOfClass maps a TwinClass to the TwinInstances of its namespace
+// whose class is it or embeds it.
+func (r *TwinInstanceReconciler) instancesOfClass(object client.Object) []reconcile.Request {
+	twinClass, ok := object.(*dtdlv0.TwinClass)
+	if !ok {
+		return nil
+	}
+
+	m, err := model.Load(context.TODO(), r.Client, client.InNamespace(twinClass.Namespace))
+	if err != nil {
+		return nil
+	}
+	classes := append([]*dtdlv0.TwinClass{twinClass}, m.ClassesEmbedding(twinClass.Spec.Name)...)
+	return r.instanceRequests(twinClass.Namespace, classes)
+}
+
+// instancesUsingEnum maps a TwinEnum to the TwinInstances of its namespace
+// whose class uses it.
+func (r *TwinInstanceReconciler) instancesUsingEnum(object client.Object) []reconcile.Request {
+	twinEnum, ok := object.(*dtdlv0.TwinEnum)
+	if !ok {
+		return nil
+	}
+
+	m, err := model.Load(context.TODO(), r.Client, client.InNamespace(twinEnum.Namespace))
+	if err != nil {
+		return nil
+	}
+	return r.instanceRequests(twinEnum.Namespace, m.ClassesUsingEnum(twinEnum.Spec.Name))
+}
+
+// instanceRequests lists the TwinInstances of namespace referencing any
+// version of classes by name.
+func (r *TwinInstanceReconciler) instanceRequests(namespace string, classes []*dtdlv0.TwinClass) []reconcile.Request {
+	names := map[string]bool{}
+	for _, twinClass := range classes {
+		names[twinClass.Spec.Name] = true
+	}
+
+	twinInstances := &dtdlv1.TwinInstanceList{}
+	if err := r.List(context.TODO(), twinInstances, client.InNamespace(namespace)); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, twinInstance := range twinInstances.Items {
+		ref, err := dtmi.ParseReference(twinInstance.Spec.Class.String())
+		if err == nil && names[ref.Name] {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&twinInstance)})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
+	"github.com/agwermann/dt-operator/pkg/recording"
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+const RECORDING_CLAIM_SUFFIX = "-recording"
+const RECORDING_JOB_SUFFIX = "-recorder"
+const RECORDING_READY_CONDITION = "Ready"
+const RECORDING_DEFAULT_STORAGE_SIZE = "1Gi"
+
+// RECORDING_RETRY_INTERVAL is how often recordings of services that do not
+// resolve are retried.
+const RECORDING_RETRY_INTERVAL = 30 * time.Second
+
+// TwinRecordingReconciler reconciles a TwinRecording object
+type TwinRecordingReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Image is the operator image, which holds the dtrecord binary.
+	Image string
+	// Brokers resolve the broker of the recorded service, which recordings
+	// are made from.
+	Brokers broker.Addresses
+}
+
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinrecordings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinrecordings/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinrecordings/finalizers,verbs=update
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinclasses;twinenums;twinservices,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch
+
+// Reconcile claims a volume for the recording and runs the recorder Job,
+// subscribed to the input topics the TwinService has when the recording
+// starts, with the broker credentials of the recorders of the namespace.
+// Stopping the recording deletes the Job and keeps the volume, which is
+// deleted with the TwinRecording.
+func (r *TwinRecordingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("TwinRecording", req.NamespacedName)
+
+	twinRecording := &dtdlv1.TwinRecording{}
+	if err := r.Get(ctx, req.NamespacedName, twinRecording); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if recordingEnded(twinRecording.Status.Phase) {
+		return ctrl.Result{}, nil
+	}
+
+	claim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name:      twinRecording.Name + RECORDING_CLAIM_SUFFIX,
+		Namespace: twinRecording.Namespace,
+	}}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(claim), claim); errors.IsNotFound(err) {
+		size := resource.MustParse(RECORDING_DEFAULT_STORAGE_SIZE)
+		if twinRecording.Spec.StorageSize != nil {
+			size = *twinRecording.Spec.StorageSize
+		}
+		claim.Spec = corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+		}
+		if err := controllerutil.SetControllerReference(twinRecording, claim, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Create(ctx, claim); err != nil {
+			logger.Error(err, "Error while creating recording volume claim")
+			return ctrl.Result{}, err
+		}
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+	twinRecording.Status.ClaimName = claim.Name
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: twinRecording.Namespace, Name: twinRecording.Name + RECORDING_JOB_SUFFIX}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	switch {
+	case errors.IsNotFound(err) && twinRecording.Spec.Stop:
+		endRecording(&twinRecording.Status.Phase, &twinRecording.Status.CompletedAt, dtdlv1.RecordingStopped)
+	case errors.IsNotFound(err):
+		filters, err := recording.ServiceTopics(ctx, r.Client, twinRecording.Namespace, twinRecording.Spec.Service)
+		if err != nil {
+			twinRecording.Status.Phase = dtdlv1.RecordingPending
+			setRecordingCondition(&twinRecording.Status.Conditions, twinRecording.Generation, metav1.ConditionFalse, "ServiceNotResolved", err.Error())
+			if err := r.Status().Update(ctx, twinRecording); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: RECORDING_RETRY_INTERVAL}, nil
+		}
+
+		credentialsKey := types.NamespacedName{Namespace: twinRecording.Namespace, Name: RECORDER_CREDENTIALS_SECRET_NAME}
+		if _, err := broker.ApplySecret(ctx, r.Client, credentialsKey, topics.RecorderUsername(twinRecording.Namespace), nil); err != nil {
+			logger.Error(err, "Error while applying recorder broker credentials")
+			return ctrl.Result{}, err
+		}
+
+		job = r.buildRecorderJob(twinRecording, claim.Name, filters)
+		if err := controllerutil.SetControllerReference(twinRecording, job, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Create(ctx, job); err != nil {
+			logger.Error(err, "Error while creating recorder job")
+			return ctrl.Result{}, err
+		}
+		now := metav1.Now()
+		twinRecording.Status.Topics = filters
+		twinRecording.Status.StartedAt = &now
+		twinRecording.Status.Phase = dtdlv1.RecordingRunning
+		setRecordingCondition(&twinRecording.Status.Conditions, twinRecording.Generation, metav1.ConditionTrue, "Started", "Recorder job "+job.Name+" started")
+	case twinRecording.Spec.Stop:
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		endRecording(&twinRecording.Status.Phase, &twinRecording.Status.CompletedAt, dtdlv1.RecordingStopped)
+	default:
+		if phase, ended := jobPhase(job); ended {
+			endRecording(&twinRecording.Status.Phase, &twinRecording.Status.CompletedAt, phase)
+		}
+	}
+
+	return ctrl.Result{}, r.Status().Update(ctx, twinRecording)
+}
+
+// buildRecorderJob returns the recorder Job. The recorder appends to the
+// recording when its pod is restarted.
+func (r *TwinRecordingReconciler) buildRecorderJob(twinRecording *dtdlv1.TwinRecording, claim string, filters []string) *batchv1.Job {
+	args := []string{
+		"--broker-address=" + r.Brokers.For(twinRecording.Namespace, twinRecording.Spec.Service),
+		"--client-id=" + topics.OperatorUsername + "-recorder-" + twinRecording.Namespace + "-" + twinRecording.Name,
+		"--namespace=" + twinRecording.Namespace,
+		"-o=/data/" + recording.FileName,
+	}
+	if twinRecording.Spec.Duration != nil {
+		args = append(args, "--duration="+twinRecording.Spec.Duration.Duration.String())
+	}
+	for _, filter := range filters {
+		args = append(args, "--topic="+filter)
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      twinRecording.Name + RECORDING_JOB_SUFFIX,
+			Namespace: twinRecording.Namespace,
+		},
+		Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy:   corev1.RestartPolicyOnFailure,
+					SecurityContext: &corev1.PodSecurityContext{FSGroup: &NONROOT_FS_GROUP},
+					Containers: []corev1.Container{{
+						Name:         "recorder",
+						Image:        r.Image,
+						Command:      []string{"/dtrecord", "record"},
+						Args:         args,
+						Env:          credentialsEnv(RECORDER_CREDENTIALS_SECRET_NAME),
+						VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data"}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "data",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// jobPhase returns the phase a finished Job ends a recording or a replay
+// with.
+func jobPhase(job *batchv1.Job) (dtdlv1.RecordingPhase, bool) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return dtdlv1.RecordingCompleted, true
+		case batchv1.JobFailed:
+			return dtdlv1.RecordingFailed, true
+		}
+	}
+	return dtdlv1.RecordingRunning, false
+}
+
+func recordingEnded(phase dtdlv1.RecordingPhase) bool {
+	return phase == dtdlv1.RecordingStopped || phase == dtdlv1.RecordingCompleted || phase == dtdlv1.RecordingFailed
+}
+
+func endRecording(phase *dtdlv1.RecordingPhase, completedAt **metav1.Time, ended dtdlv1.RecordingPhase) {
+	now := metav1.Now()
+	*phase = ended
+	*completedAt = &now
+}
+
+func setRecordingCondition(conditions *[]metav1.Condition, generation int64, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(conditions, metav1.Condition{
+		Type:               RECORDING_READY_CONDITION,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: generation,
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *TwinRecordingReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dtdlv1.TwinRecording{}).
+		Owns(&batchv1.Job{}).
+		Owns(&corev1.PersistentVolumeClaim{}).
+		Complete(monitoring.Instrument("twinrecording", r))
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strconv"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
+	"github.com/agwermann/dt-operator/pkg/recording"
+)
+
+const REPLAY_JOB_SUFFIX = "-replayer"
+
+// TwinReplayReconciler reconciles a TwinReplay object
+type TwinReplayReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Image is the operator image, which holds the dtrecord binary.
+	Image string
+	// Brokers resolve the broker of the recorded service, which recordings
+	// are replayed to.
+	Brokers broker.Addresses
+}
+
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinreplays,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinreplays/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinreplays/finalizers,verbs=update
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinrecordings,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch
+
+// Reconcile runs the replayer Job once the recording has ended, mounting
+// the recording volume read-only. The replayer publishes with the twin
+// credentials of the namespace. A replay runs once: the Job does not
+// retry, so that no message is published twice.
+func (r *TwinReplayReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("TwinReplay", req.NamespacedName)
+
+	twinReplay := &dtdlv1.TwinReplay{}
+	if err := r.Get(ctx, req.NamespacedName, twinReplay); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if recordingEnded(twinReplay.Status.Phase) {
+		return ctrl.Result{}, nil
+	}
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: twinReplay.Namespace, Name: twinReplay.Name + REPLAY_JOB_SUFFIX}, job)
+	if err == nil {
+		if phase, ended := jobPhase(job); ended {
+			endRecording(&twinReplay.Status.Phase, &twinReplay.Status.CompletedAt, phase)
+			return ctrl.Result{}, r.Status().Update(ctx, twinReplay)
+		}
+		return ctrl.Result{}, nil
+	}
+	if !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	twinRecording := &dtdlv1.TwinRecording{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: twinReplay.Namespace, Name: twinReplay.Spec.Recording}, twinRecording); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		twinReplay.Status.Phase = dtdlv1.RecordingPending
+		setRecordingCondition(&twinReplay.Status.Conditions, twinReplay.Generation, metav1.ConditionFalse, "RecordingNotFound", "TwinRecording "+twinReplay.Spec.Recording+" does not exist")
+		return ctrl.Result{}, r.Status().Update(ctx, twinReplay)
+	}
+	if !recordingEnded(twinRecording.Status.Phase) {
+		twinReplay.Status.Phase = dtdlv1.RecordingPending
+		setRecordingCondition(&twinReplay.Status.Conditions, twinReplay.Generation, metav1.ConditionFalse, "RecordingInProgress", "TwinRecording "+twinRecording.Name+" has not ended")
+		return ctrl.Result{}, r.Status().Update(ctx, twinReplay)
+	}
+
+	if _, err := applyTwinCredentials(ctx, r.Client, twinReplay.Namespace); err != nil {
+		logger.Error(err, "Error while applying twin credentials")
+		return ctrl.Result{}, err
+	}
+
+	job = r.buildReplayerJob(twinReplay, twinRecording.Status.ClaimName, r.Brokers.For(twinReplay.Namespace, twinRecording.Spec.Service))
+	if err := controllerutil.SetControllerReference(twinReplay, job, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.Create(ctx, job); err != nil {
+		logger.Error(err, "Error while creating replayer job")
+		return ctrl.Result{}, err
+	}
+	now := metav1.Now()
+	twinReplay.Status.StartedAt = &now
+	twinReplay.Status.Phase = dtdlv1.RecordingRunning
+	setRecordingCondition(&twinReplay.Status.Conditions, twinReplay.Generation, metav1.ConditionTrue, "Started", "Replayer job "+job.Name+" started")
+	return ctrl.Result{}, r.Status().Update(ctx, twinReplay)
+}
+
+func (r *TwinReplayReconciler) buildReplayerJob(twinReplay *dtdlv1.TwinReplay, claim, brokerAddress string) *batchv1.Job {
+	speed := 1.0
+	if twinReplay.Spec.Speed != nil {
+		speed = twinReplay.Spec.Speed.AsApproximateFloat64()
+	}
+	args := []string{
+		"--broker-address=" + brokerAddress,
+		"--client-id=dt-replayer-" + twinReplay.Namespace + "-" + twinReplay.Name,
+		"-f=/data/" + recording.FileName,
+		"--speed=" + strconv.FormatFloat(speed, 'g', -1, 64),
+	}
+	for _, rewrite := range twinReplay.Spec.Rewrites {
+		args = append(args, "--rewrite="+rewrite.From+"="+rewrite.To)
+	}
+
+	backoffLimit := int32(0)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      twinReplay.Name + REPLAY_JOB_SUFFIX,
+			Namespace: twinReplay.Namespace,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{{
+						Name:         "replayer",
+						Image:        r.Image,
+						Command:      []string{"/dtrecord", "replay"},
+						Args:         args,
+						Env:          credentialsEnv(TWIN_CREDENTIALS_SECRET_NAME),
+						VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data", ReadOnly: true}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "data",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim, ReadOnly: true},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *TwinReplayReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dtdlv1.TwinReplay{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &dtdlv1.TwinRecording{}}, handler.EnqueueRequestsFromMapFunc(r.replaysOfRecording)).
+		Complete(monitoring.Instrument("twinreplay", r))
+}
+
+// replaysOfRecording maps a TwinRecording to the TwinReplays of its
+// namespace replaying it.
+func (r *TwinReplayReconciler) replaysOfRecording(object client.Object) []reconcile.Request {
+	twinReplays := &dtdlv1.TwinReplayList{}
+	if err := r.List(context.TODO(), twinReplays, client.InNamespace(object.GetNamespace())); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, twinReplay := range twinReplays.Items {
+		if twinReplay.Spec.Recording == object.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: twinReplay.Namespace,
+				Name:      twinReplay.Name,
+			}})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/autoscale"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+const AUTOSCALING_CONDITION = "ScalingActive"
+
+// AUTOSCALING_DECISIONS is the number of scaling decisions kept in the
+// status of a TwinService.
+const AUTOSCALING_DECISIONS = 10
+
+// TwinServiceAutoscalerReconciler scales the workloads of TwinServices with
+// the rate of the messages they read, as measured by the Meter.
+type TwinServiceAutoscalerReconciler struct {
+	client.Client
+	Scheme     *runtime.Scheme
+	Meter      *autoscale.Meter
+	Stabilizer *autoscale.Stabilizer
+	// SyncPeriod is how often the rates of every service are read.
+	SyncPeriod time.Duration
+}
+
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinservices,verbs=get;list;watch
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinservices/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinclasses;twinenums,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch;update;patch
+
+// Reconcile reads the input rate of an autoscaled TwinService every
+// SyncPeriod and sets the replicas of its workload to the rate divided by
+// the target rate per replica, within the bounds of the service. Scaling
+// down waits for the scale-down stabilization window and for the meter to
+// have measured a full window. Decisions are recorded in the status.
+func (r *TwinServiceAutoscalerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("TwinService", req.NamespacedName)
+
+	twinService := &dtdlv1.TwinService{}
+	if err := r.Get(ctx, req.NamespacedName, twinService); err != nil {
+		if errors.IsNotFound(err) {
+			r.Stabilizer.Forget(req.NamespacedName)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if twinService.Spec.Autoscaling == nil {
+		r.Stabilizer.Forget(req.NamespacedName)
+		if twinService.Status.Autoscaling == nil && meta.FindStatusCondition(twinService.Status.Conditions, AUTOSCALING_CONDITION) == nil {
+			return ctrl.Result{}, nil
+		}
+		twinService.Status.Autoscaling = nil
+		meta.RemoveStatusCondition(&twinService.Status.Conditions, AUTOSCALING_CONDITION)
+		return ctrl.Result{}, r.Status().Update(ctx, twinService)
+	}
+
+	policy, err := autoscale.NewPolicy(twinService.Spec.Autoscaling)
+	if err != nil {
+		return ctrl.Result{}, r.setAutoscalingCondition(ctx, twinService, metav1.ConditionFalse, "InvalidAutoscaling", err.Error())
+	}
+
+	inputs, err := r.serviceInputs(ctx, twinService)
+	if err != nil {
+		return ctrl.Result{RequeueAfter: r.SyncPeriod},
+			r.setAutoscalingCondition(ctx, twinService, metav1.ConditionFalse, "ServiceNotResolved", err.Error())
+	}
+
+	target := scaleTarget(twinService)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(target), target); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: r.SyncPeriod},
+			r.setAutoscalingCondition(ctx, twinService, metav1.ConditionFalse, "TargetNotFound",
+				fmt.Sprintf("%s %s not found", target.GetKind(), target.GetName()))
+	}
+	current := int32(1)
+	if replicas, found, _ := unstructured.NestedInt64(target.Object, "spec", "replicas"); found {
+		current = int32(replicas)
+	}
+
+	rate := r.Meter.Rate(inputs)
+	now := time.Now()
+	recommended, reason := policy.Recommend(rate, current)
+	desired := r.Stabilizer.Stabilize(req.NamespacedName, recommended, now, policy.ScaleDownStabilization)
+	if desired > recommended {
+		reason = fmt.Sprintf("Scale down to %d stabilized for %s", recommended, policy.ScaleDownStabilization)
+	}
+	if desired < current && !r.Meter.Warm() {
+		desired = current
+	}
+
+	status := twinService.Status.Autoscaling
+	if status == nil {
+		status = &dtdlv1.TwinServiceAutoscalingStatus{}
+		twinService.Status.Autoscaling = status
+	}
+	status.MessagesPerSecond = autoscale.FormatRate(rate)
+	status.CurrentReplicas = current
+	status.DesiredReplicas = desired
+
+	if desired != current {
+		patch := client.RawPatch(types.MergePatchType, []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, desired)))
+		if err := r.Patch(ctx, target, patch); err != nil {
+			logger.Error(err, "Error while scaling twin service workload")
+			return ctrl.Result{}, err
+		}
+		logger.Info("Scaled twin service workload", "from", current, "to", desired, "reason", reason)
+
+		scaledAt := metav1.NewTime(now)
+		status.LastScaleTime = &scaledAt
+		status.CurrentReplicas = desired
+		status.Decisions = append(status.Decisions, dtdlv1.ScalingDecision{
+			Time:              scaledAt,
+			From:              current,
+			To:                desired,
+			MessagesPerSecond: status.MessagesPerSecond,
+			Reason:            reason,
+		})
+		if len(status.Decisions) > AUTOSCALING_DECISIONS {
+			status.Decisions = status.Decisions[len(status.Decisions)-AUTOSCALING_DECISIONS:]
+		}
+	}
+
+	message := fmt.Sprintf("Measuring %d topics of %s %s", len(inputs), target.GetKind(), target.GetName())
+	return ctrl.Result{RequeueAfter: r.SyncPeriod},
+		r.setAutoscalingCondition(ctx, twinService, metav1.ConditionTrue, "ValidRate", message)
+}
+
+// serviceInputs returns the filters of the topics a service reads.
+func (r *TwinServiceAutoscalerReconciler) serviceInputs(ctx context.Context, twinService *dtdlv1.TwinService) ([]string, error) {
+	m, err := model.Load(ctx, r.Client, client.InNamespace(twinService.Namespace))
+	if err != nil {
+		return nil, err
+	}
+	classes := []string{}
+	for _, class := range twinService.Spec.Classes {
+		classes = append(classes, class.String())
+	}
+	layout, err := topics.ForService(m, twinService.Namespace, classes)
+	if err != nil {
+		return nil, err
+	}
+	inputs := topics.ServiceInputs(layout)
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("the service reads no topics")
+	}
+	return inputs, nil
+}
+
+// scaleTarget returns the workload of a service.
+func scaleTarget(twinService *dtdlv1.TwinService) *unstructured.Unstructured {
+	workload := twinService.Workload()
+	target := &unstructured.Unstructured{}
+	target.SetAPIVersion(workload.APIVersion)
+	target.SetKind(workload.Kind)
+	target.SetNamespace(twinService.Namespace)
+	target.SetName(workload.Name)
+	return target
+}
+
+func (r *TwinServiceAutoscalerReconciler) setAutoscalingCondition(ctx context.Context, twinService *dtdlv1.TwinService, status metav1.ConditionStatus, reason, message string) error {
+	meta.SetStatusCondition(&twinService.Status.Conditions, metav1.Condition{
+		Type:               AUTOSCALING_CONDITION,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: twinService.Generation,
+	})
+	return r.Status().Update(ctx, twinService)
+}
+
+// SetupWithManager sets up the controller with the Manager. Only spec
+// changes trigger a reconcile, since the status it writes changes with every
+// measurement; rates are read every SyncPeriod instead.
+func (r *TwinServiceAutoscalerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("twinservice-autoscaler").
+		For(&dtdlv1.TwinService{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(monitoring.Instrument("twinservice-autoscaler", r))
+}
@@ -18,33 +18,64 @@ package controllers
 
 import (
 	"context"
+	"strings"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
 	v0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
 )
 
+// CLASSES_RESOLVED_CONDITION is false while a class reference of a
+// TwinService matches no TwinClass.
+const CLASSES_RESOLVED_CONDITION = "ClassesResolved"
+
 // TwinServiceReconciler reconciles a TwinService object
 type TwinServiceReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Recorder records the lifecycle of the broker, and the classes a
+	// service refers to that do not resolve.
+	Recorder record.EventRecorder
+	// ExporterImage is the operator image, which holds the broker exporter.
+	// The broker runs without its metrics sidecar when empty.
+	ExporterImage string
+	// BrokerScope chooses which services share a broker. The brokers of the
+	// namespace and service scopes are applied through TwinBrokers.
+	BrokerScope broker.Scope
 }
 
 //+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinservices,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinservices/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinservices/finalizers,verbs=update
-
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the TwinService object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinclasses;twinenums,verbs=get;list;watch
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinbrokers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;create
+//+kubebuilder:rbac:groups=core,resources=configmaps;services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile reports whether the classes of the TwinService resolve and, for
+// services using MQTT, applies their broker credentials and the broker
+// serving them: the TwinBroker of the namespace or of the service, or the
+// broker of the cluster scope, whose failures are recorded as events on the
+// service.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
@@ -54,13 +85,32 @@ func (r *TwinServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	logger.Info("Reconciling twin service")
 
 	twinService := &v0.TwinService{}
-	r.Get(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace}, twinService)
+	if err := r.Get(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace}, twinService); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if err := r.setClassesResolvedCondition(ctx, twinService); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if usesBroker(twinService) {
+		if _, err := applyServiceCredentials(ctx, r.Client, r.Scheme, twinService); err != nil {
+			logger.Error(err, "Error while applying service broker credentials")
+			return ctrl.Result{}, err
+		}
+	}
 
-	if twinService.Spec.DataSource == "mqtt" {
-		_, err := r.applyBrokerDeployment(ctx, req)
+	if err := r.applyTwinBroker(ctx, twinService); err != nil {
+		logger.Error(err, "Error while applying twin broker")
+		r.Recorder.Event(twinService, corev1.EventTypeWarning, "BrokerFailed", err.Error())
+		return ctrl.Result{}, err
+	}
 
-		if err != nil {
+	if r.BrokerScope == broker.ClusterScope && usesBroker(twinService) {
+		if _, err := r.applyBrokerDeployment(ctx, twinService); err != nil {
 			logger.Error(err, "Error while creating broker")
+			r.Recorder.Event(twinService, corev1.EventTypeWarning, "BrokerFailed", err.Error())
+			return ctrl.Result{}, err
 		}
 	}
 
@@ -71,5 +121,66 @@ func (r *TwinServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 func (r *TwinServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&dtdlv0.TwinService{}).
-		Complete(r)
+		Watches(&source.Kind{Type: &dtdlv0.TwinClass{}}, handler.EnqueueRequestsFromMapFunc(r.brokerServices)).
+		Watches(&source.Kind{Type: &dtdlv1.TwinBroker{}}, &handler.EnqueueRequestForOwner{OwnerType: &dtdlv0.TwinService{}}).
+		Complete(monitoring.Instrument("twinservice", r))
+}
+
+// setClassesResolvedCondition sets the ClassesResolved condition of the
+// service, which is false while a class reference matches no TwinClass
+// version of its namespace. The status is only written, and the event only
+// recorded, when the condition changes.
+func (r *TwinServiceReconciler) setClassesResolvedCondition(ctx context.Context, twinService *v0.TwinService) error {
+	m, err := model.Load(ctx, r.Client, client.InNamespace(twinService.Namespace))
+	if err != nil {
+		return err
+	}
+
+	condition := metav1.Condition{
+		Type:               CLASSES_RESOLVED_CONDITION,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: twinService.Generation,
+		Reason:             "ClassesResolved",
+		Message:            "All classes of the service resolve",
+	}
+	unresolved := []string{}
+	for _, reference := range twinService.Spec.Classes {
+		if _, err := m.Select(reference); err != nil {
+			unresolved = append(unresolved, err.Error())
+		}
+	}
+	if len(unresolved) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ClassNotResolved"
+		condition.Message = strings.Join(unresolved, "; ")
+	}
+
+	conditions := twinService.Status.DeepCopy().Conditions
+	setConditionWithEvent(r.Recorder, twinService, &twinService.Status.Conditions, condition)
+	if equality.Semantic.DeepEqual(conditions, twinService.Status.Conditions) {
+		return nil
+	}
+	return r.Status().Update(ctx, twinService)
+}
+
+// brokerServices maps a TwinClass change to the TwinServices using the
+// cluster broker, whose ACL covers the topics of every class. The ACLs of
+// the other scopes are kept by the TwinBroker controller.
+func (r *TwinServiceReconciler) brokerServices(_ client.Object) []reconcile.Request {
+	if r.BrokerScope != broker.ClusterScope {
+		return nil
+	}
+
+	twinServices := &dtdlv0.TwinServiceList{}
+	if err := r.List(context.TODO(), twinServices); err != nil {
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, twinService := range twinServices.Items {
+		if usesBroker(&twinService) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&twinService)})
+		}
+	}
+	return requests
 }
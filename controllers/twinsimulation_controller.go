@@ -0,0 +1,210 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
+	"github.com/agwermann/dt-operator/pkg/simulation"
+)
+
+const SIMULATION_CONFIG_MAP_SUFFIX = "-simulation"
+const SIMULATION_DEPLOYMENT_SUFFIX = "-simulator"
+const SIMULATION_READY_CONDITION = "Ready"
+
+// SIMULATION_PLAN_HASH_ANNOTATION carries a hash of the simulation plan on
+// the simulator pod template, so that plan changes roll the simulator.
+const SIMULATION_PLAN_HASH_ANNOTATION = "dtdl.digitaltwin/simulation-plan-hash"
+
+// TwinSimulationReconciler reconciles a TwinSimulation object
+type TwinSimulationReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Image is the operator image, which holds the simulator binary.
+	Image string
+	// Brokers resolve the broker simulators publish to. Simulations serve no
+	// TwinService, so they use the default broker in the service scope.
+	Brokers broker.Addresses
+}
+
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinsimulations,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinsimulations/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinsimulations/finalizers,verbs=update
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinclasses;twinenums,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch
+
+// Reconcile resolves the simulation against the twin model of its
+// namespace into a plan, stores the plan in a ConfigMap and runs the
+// simulator Deployment, both owned by the TwinSimulation, which connects
+// with the twin credentials of the namespace. A simulation that
+// does not resolve keeps its simulator running with the last good plan.
+func (r *TwinSimulationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("TwinSimulation", req.NamespacedName)
+
+	twinSimulation := &dtdlv1.TwinSimulation{}
+	if err := r.Get(ctx, req.NamespacedName, twinSimulation); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	m, err := model.Load(ctx, r.Client, client.InNamespace(req.Namespace))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	plan, err := simulation.Build(twinSimulation, m)
+	if err != nil {
+		return ctrl.Result{}, r.setSimulationCondition(ctx, twinSimulation, metav1.ConditionFalse, "InvalidSimulation", err.Error())
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	hash := sha256.Sum256(data)
+
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:      twinSimulation.Name + SIMULATION_CONFIG_MAP_SUFFIX,
+		Namespace: twinSimulation.Namespace,
+	}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		configMap.Data = map[string]string{simulation.PlanKey: string(data)}
+		return controllerutil.SetControllerReference(twinSimulation, configMap, r.Scheme)
+	}); err != nil {
+		logger.Error(err, "Error while applying simulation config map")
+		return ctrl.Result{}, err
+	}
+
+	if _, err := applyTwinCredentials(ctx, r.Client, twinSimulation.Namespace); err != nil {
+		logger.Error(err, "Error while applying twin credentials")
+		return ctrl.Result{}, err
+	}
+
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Name:      twinSimulation.Name + SIMULATION_DEPLOYMENT_SUFFIX,
+		Namespace: twinSimulation.Namespace,
+	}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, deployment, func() error {
+		r.buildSimulatorDeployment(deployment, twinSimulation, configMap.Name, hex.EncodeToString(hash[:]))
+		return controllerutil.SetControllerReference(twinSimulation, deployment, r.Scheme)
+	}); err != nil {
+		logger.Error(err, "Error while applying simulator deployment")
+		return ctrl.Result{}, err
+	}
+
+	twinSimulation.Status.Instances = int32(plan.Instances())
+	return ctrl.Result{}, r.setSimulationCondition(ctx, twinSimulation, metav1.ConditionTrue, "Running",
+		fmt.Sprintf("Simulating %d twins", plan.Instances()))
+}
+
+// buildSimulatorDeployment fills in the simulator Deployment, which mounts
+// the plan from the simulation ConfigMap.
+func (r *TwinSimulationReconciler) buildSimulatorDeployment(deployment *appsv1.Deployment, twinSimulation *dtdlv1.TwinSimulation, configMap, planHash string) {
+	labels := buildLabels(deployment.Name)
+	replicas := int32(1)
+	deployment.Spec.Replicas = &replicas
+	deployment.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+	deployment.Spec.Template = corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      labels,
+			Annotations: map[string]string{SIMULATION_PLAN_HASH_ANNOTATION: planHash},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:    "simulator",
+				Image:   r.Image,
+				Command: []string{"/simulator"},
+				Args: []string{
+					"--broker-address=" + r.Brokers.For(twinSimulation.Namespace, ""),
+					"--plan=/config/" + simulation.PlanKey,
+					"--client-id=dt-simulator-" + twinSimulation.Namespace + "-" + twinSimulation.Name,
+				},
+				Env:          credentialsEnv(TWIN_CREDENTIALS_SECRET_NAME),
+				VolumeMounts: []corev1.VolumeMount{{Name: "config", MountPath: "/config"}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name: "config",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: configMap},
+					},
+				},
+			}},
+		},
+	}
+}
+
+func (r *TwinSimulationReconciler) setSimulationCondition(ctx context.Context, twinSimulation *dtdlv1.TwinSimulation, status metav1.ConditionStatus, reason, message string) error {
+	meta.SetStatusCondition(&twinSimulation.Status.Conditions, metav1.Condition{
+		Type:               SIMULATION_READY_CONDITION,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: twinSimulation.Generation,
+	})
+	return r.Status().Update(ctx, twinSimulation)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *TwinSimulationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dtdlv1.TwinSimulation{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&appsv1.Deployment{}).
+		Watches(&source.Kind{Type: &dtdlv0.TwinClass{}}, handler.EnqueueRequestsFromMapFunc(r.simulationsInNamespace)).
+		Watches(&source.Kind{Type: &dtdlv0.TwinEnum{}}, handler.EnqueueRequestsFromMapFunc(r.simulationsInNamespace)).
+		Complete(monitoring.Instrument("twinsimulation", r))
+}
+
+// simulationsInNamespace maps a TwinClass or TwinEnum to the TwinSimulations
+// of its namespace, since any of them may simulate a class using it.
+func (r *TwinSimulationReconciler) simulationsInNamespace(object client.Object) []reconcile.Request {
+	twinSimulations := &dtdlv1.TwinSimulationList{}
+	if err := r.List(context.TODO(), twinSimulations, client.InNamespace(object.GetNamespace())); err != nil {
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, twinSimulation := range twinSimulations.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: twinSimulation.Namespace,
+			Name:      twinSimulation.Name,
+		}})
+	}
+	return requests
+}
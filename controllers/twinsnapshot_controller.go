@@ -0,0 +1,108 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
+	"github.com/agwermann/dt-operator/pkg/snapshot"
+)
+
+const SNAPSHOT_READY_CONDITION = "Ready"
+
+// TwinSnapshotReconciler reconciles a TwinSnapshot object
+type TwinSnapshotReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinsnapshots,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinsnapshots/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinsnapshots/finalizers,verbs=update
+//+kubebuilder:rbac:groups=dtdl.digitaltwin,resources=twinclasses;twinenums;twinservices;twininstances,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete;deletecollection
+
+// Reconcile takes the snapshot once: it exports the twin resources of the
+// namespace into an archive, stores it in a chain of ConfigMaps owned by the
+// TwinSnapshot and records the chain in the status. Deleting the snapshot
+// deletes its ConfigMaps.
+func (r *TwinSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("TwinSnapshot", req.NamespacedName)
+
+	twinSnapshot := &dtdlv1.TwinSnapshot{}
+	if err := r.Get(ctx, req.NamespacedName, twinSnapshot); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if twinSnapshot.Status.TakenAt != nil {
+		return ctrl.Result{}, nil
+	}
+
+	// Chunks left by an attempt that failed to record its status are
+	// replaced.
+	if err := r.DeleteAllOf(ctx, &corev1.ConfigMap{}, client.InNamespace(req.Namespace),
+		client.MatchingLabels{snapshot.SnapshotLabel: twinSnapshot.Name}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	archive, err := snapshot.Export(ctx, r.Client, req.Namespace, twinSnapshot.Spec.IncludeInstances)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	data, err := snapshot.Encode(archive)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	configMaps, err := snapshot.WriteConfigMaps(ctx, r.Client, r.Scheme, twinSnapshot, data)
+	if err != nil {
+		logger.Error(err, "Error while writing snapshot config maps")
+		return ctrl.Result{}, err
+	}
+
+	twinSnapshot.Status.TakenAt = &archive.TakenAt
+	twinSnapshot.Status.ArchiveVersion = archive.Version
+	twinSnapshot.Status.ConfigMaps = configMaps
+	twinSnapshot.Status.Size = int64(len(data))
+	twinSnapshot.Status.Counts = archive.Counts()
+	meta.SetStatusCondition(&twinSnapshot.Status.Conditions, metav1.Condition{
+		Type:               SNAPSHOT_READY_CONDITION,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Taken",
+		Message:            fmt.Sprintf("Archive stored in %d ConfigMaps", len(configMaps)),
+		ObservedGeneration: twinSnapshot.Generation,
+	})
+	logger.Info("Took snapshot", "size", len(data), "configMaps", len(configMaps))
+	return ctrl.Result{}, r.Status().Update(ctx, twinSnapshot)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *TwinSnapshotReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dtdlv1.TwinSnapshot{}).
+		Owns(&corev1.ConfigMap{}).
+		Complete(monitoring.Instrument("twinsnapshot", r))
+}
@@ -19,20 +19,34 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
 	"github.com/agwermann/dt-operator/controllers"
+	"github.com/agwermann/dt-operator/pkg/autoscale"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/graph"
+	"github.com/agwermann/dt-operator/pkg/monitoring"
+	"github.com/agwermann/dt-operator/pkg/state"
+	"github.com/agwermann/dt-operator/webhooks"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -45,6 +59,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(dtdlv0.AddToScheme(scheme))
+	utilruntime.Must(dtdlv1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -52,8 +67,53 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var graphAddr string
+	var graphCertDir string
+	var enableStateIngestion bool
+	var brokerAddr string
+	var brokerScopeName string
+	var stateFlushInterval time.Duration
+	var stateQPS float64
+	var enableHistory bool
+	var historyImage string
+	var historyStorageSize string
+	var historyRetention time.Duration
+	var simulatorImage string
+	var recorderImage string
+	var exporterImage string
+	var enableAutoscaling bool
+	var autoscalingWindow time.Duration
+	var autoscalingSyncPeriod time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&graphAddr, "graph-bind-address", ":8082",
+		"The address the twin graph query and model diagram API binds to. Set to 0 to disable it.")
+	flag.StringVar(&graphCertDir, "graph-cert-dir", "/tmp/k8s-webhook-server/serving-certs",
+		"The directory holding the tls.crt and tls.key the twin graph API is served with, by default the webhook serving certificate.")
+	flag.BoolVar(&enableStateIngestion, "enable-state-ingestion", false,
+		"Keep the latest state twins publish on the broker in the status of their TwinInstances. Requires the cluster broker scope.")
+	flag.StringVar(&brokerAddr, "broker-address", "tcp://mqtt-broker-service.mqtt:1883",
+		"The address of the broker state ingestion, autoscaling, history, simulators and recordings connect to.")
+	flag.StringVar(&brokerScopeName, "broker-scope", string(broker.ClusterScope),
+		"Which TwinServices share a broker: cluster runs one broker in the mqtt namespace, namespace one broker per namespace "+
+			"and service one broker per TwinService. State ingestion, history and autoscaling require cluster.")
+	flag.DurationVar(&stateFlushInterval, "state-flush-interval", 10*time.Second,
+		"How often the ingested twin state is written to the TwinInstances.")
+	flag.Float64Var(&stateQPS, "state-qps", 5, "The maximum rate of TwinInstance status writes of state ingestion.")
+	flag.BoolVar(&enableHistory, "enable-history", false,
+		"Run the history store recording twin telemetry and properties next to the broker. Requires the cluster broker scope.")
+	flag.StringVar(&historyImage, "history-image", "controller:latest", "The image of the history store, normally the operator image.")
+	flag.StringVar(&historyStorageSize, "history-storage-size", "1Gi", "The size of the volume claimed by the history store.")
+	flag.DurationVar(&historyRetention, "history-retention", 30*24*time.Hour, "The retention of classes without a historyRetention.")
+	flag.StringVar(&simulatorImage, "simulator-image", "controller:latest", "The image of TwinSimulation simulators, normally the operator image.")
+	flag.StringVar(&recorderImage, "recorder-image", "controller:latest", "The image of TwinRecording recorders and TwinReplay replayers, normally the operator image.")
+	flag.StringVar(&exporterImage, "broker-exporter-image", "controller:latest", "The image of the broker metrics sidecar, normally the operator image. The sidecar is left out when empty.")
+	flag.BoolVar(&enableAutoscaling, "enable-autoscaling", false,
+		"Scale the workloads of TwinServices with autoscaling by the rate of the messages they read on the broker. "+
+			"Requires the cluster broker scope.")
+	flag.DurationVar(&autoscalingWindow, "autoscaling-window", time.Minute, "The time message rates are averaged over.")
+	flag.DurationVar(&autoscalingSyncPeriod, "autoscaling-sync-period", 15*time.Second,
+		"How often the message rates of autoscaled TwinServices are read.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -65,6 +125,17 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	brokerScope, err := broker.ParseScope(brokerScopeName)
+	if err != nil {
+		setupLog.Error(err, "invalid broker scope")
+		os.Exit(1)
+	}
+	if brokerScope != broker.ClusterScope && (enableStateIngestion || enableHistory || enableAutoscaling) {
+		setupLog.Error(nil, "state ingestion, history and autoscaling read the cluster broker and require --broker-scope=cluster")
+		os.Exit(1)
+	}
+	brokers := broker.Addresses{Scope: brokerScope, Default: brokerAddr}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -72,6 +143,9 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "0a323ade.digitaltwin",
+		// Secrets are read when needed rather than cached, so that the
+		// manager does not hold every Secret of the cluster.
+		ClientDisableCacheFor: []client.Object{&corev1.Secret{}},
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -89,28 +163,204 @@ func main() {
 		os.Exit(1)
 	}
 	if err = (&controllers.TwinClassReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("twinclass-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "TwinClass")
 		os.Exit(1)
 	}
 	if err = (&controllers.TwinEnumReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("twinenum-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "TwinEnum")
 		os.Exit(1)
 	}
 	if err = (&controllers.TwinServiceReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		Recorder:      mgr.GetEventRecorderFor("twinservice-controller"),
+		ExporterImage: exporterImage,
+		BrokerScope:   brokerScope,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TwinService")
+		os.Exit(1)
+	}
+	if err = (&controllers.TwinBrokerReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("twinbroker-controller"),
+		ExporterImage:     exporterImage,
+		OperatorNamespace: os.Getenv("POD_NAMESPACE"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TwinBroker")
+		os.Exit(1)
+	}
+	if err = (&controllers.TwinInstanceReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "TwinService")
+		setupLog.Error(err, "unable to create controller", "controller", "TwinInstance")
+		os.Exit(1)
+	}
+	if err = (&controllers.RelationshipReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("relationship-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Relationship")
+		os.Exit(1)
+	}
+	if err = (&controllers.InverseReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Inverse")
 		os.Exit(1)
 	}
+	if err = (&controllers.TwinSnapshotReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TwinSnapshot")
+		os.Exit(1)
+	}
+	if err = (&controllers.TwinSimulationReconciler{
+		Client:  mgr.GetClient(),
+		Scheme:  mgr.GetScheme(),
+		Image:   simulatorImage,
+		Brokers: brokers,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TwinSimulation")
+		os.Exit(1)
+	}
+	if err = (&controllers.TwinRecordingReconciler{
+		Client:  mgr.GetClient(),
+		Scheme:  mgr.GetScheme(),
+		Image:   recorderImage,
+		Brokers: brokers,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TwinRecording")
+		os.Exit(1)
+	}
+	if err = (&controllers.TwinReplayReconciler{
+		Client:  mgr.GetClient(),
+		Scheme:  mgr.GetScheme(),
+		Image:   recorderImage,
+		Brokers: brokers,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TwinReplay")
+		os.Exit(1)
+	}
+	if enableAutoscaling {
+		meter := autoscale.NewMeter(brokerAddr, autoscalingWindow)
+		meter.Credentials = broker.SecretCredentials(mgr.GetClient(), controllers.OPERATOR_CREDENTIALS_KEY)
+		if err := mgr.Add(meter); err != nil {
+			setupLog.Error(err, "unable to set up message rate meter")
+			os.Exit(1)
+		}
+		if err = (&controllers.TwinServiceAutoscalerReconciler{
+			Client:     mgr.GetClient(),
+			Scheme:     mgr.GetScheme(),
+			Meter:      meter,
+			Stabilizer: autoscale.NewStabilizer(),
+			SyncPeriod: autoscalingSyncPeriod,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "TwinServiceAutoscaler")
+			os.Exit(1)
+		}
+	}
+	if enableHistory {
+		storageSize, err := resource.ParseQuantity(historyStorageSize)
+		if err != nil {
+			setupLog.Error(err, "invalid history storage size")
+			os.Exit(1)
+		}
+		if err = (&controllers.HistoryReconciler{
+			Client:           mgr.GetClient(),
+			Scheme:           mgr.GetScheme(),
+			Image:            historyImage,
+			Broker:           brokerAddr,
+			StorageSize:      storageSize,
+			DefaultRetention: historyRetention,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "History")
+			os.Exit(1)
+		}
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&webhooks.TwinClassWebhook{
+			Client: mgr.GetClient(),
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "TwinClass")
+			os.Exit(1)
+		}
+		if err = (&webhooks.TwinInstanceWebhook{
+			Client: mgr.GetClient(),
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "TwinInstance")
+			os.Exit(1)
+		}
+		if err = (&dtdlv1.TwinClass{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "TwinClass")
+			os.Exit(1)
+		}
+		if err = (&dtdlv1.TwinEnum{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "TwinEnum")
+			os.Exit(1)
+		}
+		if err = (&dtdlv1.TwinService{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "TwinService")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
+	if graphAddr != "0" {
+		clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			setupLog.Error(err, "unable to create clientset")
+			os.Exit(1)
+		}
+		if err := mgr.Add(&graph.Server{
+			Addr:      graphAddr,
+			CertDir:   graphCertDir,
+			Reader:    mgr.GetCache(),
+			Clientset: clientset,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up graph query server")
+			os.Exit(1)
+		}
+	}
+
+	collector := &monitoring.Collector{
+		Reader:  mgr.GetCache(),
+		Timeout: 10 * time.Second,
+	}
+	if brokerScope == broker.ClusterScope {
+		collector.Broker = controllers.BROKER_DEPLOYMENT_KEY
+	}
+	if err := metrics.Registry.Register(collector); err != nil {
+		setupLog.Error(err, "unable to register twin model metrics")
+		os.Exit(1)
+	}
+
+	if enableStateIngestion {
+		if err := mgr.Add(&state.Ingestor{
+			Client:        mgr.GetClient(),
+			Store:         state.NewStore(),
+			Broker:        brokerAddr,
+			Credentials:   broker.SecretCredentials(mgr.GetClient(), controllers.OPERATOR_CREDENTIALS_KEY),
+			FlushInterval: stateFlushInterval,
+			Limiter:       rate.NewLimiter(rate.Limit(stateQPS), int(stateQPS)+1),
+		}); err != nil {
+			setupLog.Error(err, "unable to set up state ingestion")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
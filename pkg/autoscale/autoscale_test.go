@@ -0,0 +1,134 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package autoscale
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+var _ = Describe("Meter", func() {
+	var meter *Meter
+	var now time.Time
+	filters := []string{"dt/plant/Machine/+/telemetry/temperature"}
+
+	BeforeEach(func() {
+		now = time.Unix(1665000000, 0)
+		meter = NewMeter("tcp://localhost:1883", time.Minute)
+		meter.Now = func() time.Time { return now }
+		meter.started = now
+	})
+
+	publish := func(perSecond int, seconds int, topics ...string) {
+		for s := 0; s < seconds; s++ {
+			for i := 0; i < perSecond; i++ {
+				for _, topic := range topics {
+					meter.Observe(topic)
+				}
+			}
+			now = now.Add(time.Second)
+		}
+	}
+
+	It("averages the rate of matching topics over the window", func() {
+		publish(10, 120, "dt/plant/Machine/m1/telemetry/temperature")
+		Expect(meter.Warm()).To(BeTrue())
+		Expect(meter.Rate(filters)).To(BeNumerically("~", 10, 0.01))
+
+		publish(3, 60, "dt/plant/Machine/m1/telemetry/temperature", "dt/plant/Machine/m2/telemetry/temperature",
+			"dt/plant/Machine/m2/telemetry/pressure")
+		Expect(meter.Rate(filters)).To(BeNumerically("~", 6, 0.01))
+	})
+
+	It("measures over the time since it started while warming up", func() {
+		publish(4, 30, "dt/plant/Machine/m1/telemetry/temperature")
+		Expect(meter.Warm()).To(BeFalse())
+		Expect(meter.Rate(filters)).To(BeNumerically("~", 4, 0.01))
+	})
+
+	It("forgets quiet topics", func() {
+		publish(1, 1, "dt/plant/Machine/m1/telemetry/temperature")
+		now = now.Add(2 * time.Minute)
+		meter.Prune()
+		Expect(meter.counters).To(BeEmpty())
+		Expect(meter.Rate(filters)).To(BeZero())
+	})
+})
+
+var _ = Describe("Policy", func() {
+	policy := func(min *int32, max int32, target string) (Policy, error) {
+		return NewPolicy(&dtdlv1.TwinServiceAutoscaling{
+			MinReplicas:             min,
+			MaxReplicas:             max,
+			TargetMessagesPerSecond: resource.MustParse(target),
+		})
+	}
+
+	It("applies defaults and validates bounds", func() {
+		p, err := policy(nil, 5, "50")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(Equal(Policy{MinReplicas: 1, MaxReplicas: 5, Target: 50, ScaleDownStabilization: 5 * time.Minute}))
+
+		three := int32(3)
+		_, err = policy(&three, 2, "0")
+		Expect(err).To(MatchError(ContainSubstring("spec.autoscaling.maxReplicas")))
+		Expect(err).To(MatchError(ContainSubstring("spec.autoscaling.targetMessagesPerSecond")))
+	})
+
+	It("recommends the replicas the rate needs within bounds", func() {
+		p, _ := policy(nil, 5, "50")
+		replicas, reason := p.Recommend(120, 1)
+		Expect(replicas).To(Equal(int32(3)))
+		Expect(reason).To(Equal("120.0 messages per second need 3 replicas at 50.0 per replica"))
+
+		replicas, reason = p.Recommend(1000, 1)
+		Expect(replicas).To(Equal(int32(5)))
+		Expect(reason).To(Equal("Limited by maxReplicas 5"))
+
+		replicas, _ = p.Recommend(0, 3)
+		Expect(replicas).To(Equal(int32(1)))
+	})
+
+	It("keeps the replicas within the tolerance", func() {
+		p, _ := policy(nil, 5, "50")
+		replicas, _ := p.Recommend(105, 2)
+		Expect(replicas).To(Equal(int32(2)))
+	})
+})
+
+var _ = Describe("Stabilizer", func() {
+	It("scales down only after the window", func() {
+		key := types.NamespacedName{Namespace: "plant", Name: "monitor"}
+		window := 5 * time.Minute
+		start := time.Unix(1665000000, 0)
+		s := NewStabilizer()
+
+		Expect(s.Stabilize(key, 4, start, window)).To(Equal(int32(4)))
+		Expect(s.Stabilize(key, 2, start.Add(time.Minute), window)).To(Equal(int32(4)))
+		Expect(s.Stabilize(key, 5, start.Add(2*time.Minute), window)).To(Equal(int32(5)))
+		Expect(s.Stabilize(key, 2, start.Add(8*time.Minute), window)).To(Equal(int32(2)))
+
+		s.Forget(key)
+		Expect(s.Stabilize(key, 1, start.Add(9*time.Minute), window)).To(Equal(int32(1)))
+	})
+})
@@ -0,0 +1,201 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package autoscale measures the rate of the messages twin services read
+// and derives the replicas of their workloads from it.
+package autoscale
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/state"
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+// Buckets is the number of buckets the window of a meter is split into.
+const Buckets = 12
+
+// Meter counts the messages published on every twin topic over a sliding
+// window, so that the rate of any set of topics can be read. Counts are kept
+// in Buckets buckets per topic; the bucket being filled is left out of
+// rates.
+type Meter struct {
+	// Broker is the URL of the broker, such as tcp://host:1883.
+	Broker string
+	// Credentials returns the credentials the meter connects with.
+	Credentials func(context.Context) (broker.Credentials, error)
+	// Window is the time rates are averaged over.
+	Window time.Duration
+	// Now returns the time messages are received at.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	started  time.Time
+	counters map[string]*counter
+}
+
+// counter holds the counts of a topic. slots[i] is the bucket number counts[i]
+// was counted in, so that buckets left over from a previous turn of the
+// window are recognised.
+type counter struct {
+	counts [Buckets]uint64
+	slots  [Buckets]int64
+	last   int64
+}
+
+// NewMeter returns a meter of the given window that starts measuring now.
+func NewMeter(broker string, window time.Duration) *Meter {
+	return &Meter{
+		Broker:   broker,
+		Window:   window,
+		Now:      time.Now,
+		started:  time.Now(),
+		counters: map[string]*counter{},
+	}
+}
+
+// Start measures until the context is done, making the meter a Runnable of
+// the manager.
+func (m *Meter) Start(ctx context.Context) error {
+	m.mu.Lock()
+	m.started = m.Now()
+	m.mu.Unlock()
+
+	credentials, err := m.Credentials(ctx)
+	if err != nil {
+		return err
+	}
+	c := state.Subscribe(ctx, m.Broker, topics.OperatorUsername+"-autoscale", credentials, func(topic string, _ []byte) {
+		m.Observe(topic)
+	})
+	defer c.Disconnect(250)
+	log.FromContext(ctx).WithName("autoscale").Info("Measuring twin message rates", "broker", m.Broker)
+
+	ticker := time.NewTicker(m.Window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			m.Prune()
+		}
+	}
+}
+
+// NeedLeaderElection is true, so that the meter runs next to the
+// controllers reading it.
+func (m *Meter) NeedLeaderElection() bool {
+	return true
+}
+
+// Observe counts a message published on topic.
+func (m *Meter) Observe(topic string) {
+	slot := m.slot(m.Now())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.counters[topic]
+	if !ok {
+		c = &counter{}
+		m.counters[topic] = c
+	}
+	i := slot % Buckets
+	if c.slots[i] != slot {
+		c.slots[i] = slot
+		c.counts[i] = 0
+	}
+	c.counts[i]++
+	c.last = slot
+}
+
+// Rate returns the messages per second published on the topics matching any
+// of the filters over the last window, or over the time since the meter
+// started when it has not measured a full window yet.
+func (m *Meter) Rate(filters []string) float64 {
+	now := m.Now()
+	slot := m.slot(now)
+	width := m.width()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from := time.Unix(0, (slot-Buckets+1)*int64(width))
+	if m.started.After(from) {
+		from = m.started
+	}
+	span := time.Unix(0, slot*int64(width)).Sub(from)
+	if span <= 0 {
+		return 0
+	}
+
+	total := uint64(0)
+	for topic, c := range m.counters {
+		if !matchAny(filters, topic) {
+			continue
+		}
+		for i := range c.counts {
+			if c.slots[i] > slot-Buckets && c.slots[i] < slot {
+				total += c.counts[i]
+			}
+		}
+	}
+	return float64(total) / span.Seconds()
+}
+
+// Warm reports whether the meter has measured a full window, so that its
+// rates are not underestimated.
+func (m *Meter) Warm() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Now().Sub(m.started) >= m.Window
+}
+
+// Prune forgets the topics no message was published on over the last
+// window.
+func (m *Meter) Prune() {
+	slot := m.slot(m.Now())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for topic, c := range m.counters {
+		if c.last <= slot-Buckets {
+			delete(m.counters, topic)
+		}
+	}
+}
+
+func (m *Meter) width() time.Duration {
+	return m.Window / Buckets
+}
+
+func (m *Meter) slot(t time.Time) int64 {
+	return t.UnixNano() / int64(m.width())
+}
+
+func matchAny(filters []string, topic string) bool {
+	for _, filter := range filters {
+		if topics.Match(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
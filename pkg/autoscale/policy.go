@@ -0,0 +1,154 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package autoscale
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+// Tolerance is the relative deviation of the rate per replica from the
+// target within which the replicas are left as they are.
+const Tolerance = 0.1
+
+// DefaultScaleDownStabilization applies to services without a
+// scaleDownStabilization.
+const DefaultScaleDownStabilization = 5 * time.Minute
+
+// Policy is the autoscaling of a service workload, defaults applied.
+type Policy struct {
+	MinReplicas int32
+	MaxReplicas int32
+	// Target is the messages per second a replica handles.
+	Target                 float64
+	ScaleDownStabilization time.Duration
+}
+
+// NewPolicy applies the defaults to the autoscaling of a service and
+// validates it.
+func NewPolicy(spec *dtdlv1.TwinServiceAutoscaling) (Policy, error) {
+	path := field.NewPath("spec", "autoscaling")
+	policy := Policy{
+		MinReplicas:            1,
+		MaxReplicas:            spec.MaxReplicas,
+		Target:                 spec.TargetMessagesPerSecond.AsApproximateFloat64(),
+		ScaleDownStabilization: DefaultScaleDownStabilization,
+	}
+	if spec.MinReplicas != nil {
+		policy.MinReplicas = *spec.MinReplicas
+	}
+	if spec.ScaleDownStabilization != nil {
+		policy.ScaleDownStabilization = spec.ScaleDownStabilization.Duration
+	}
+
+	errs := field.ErrorList{}
+	if policy.MinReplicas < 1 {
+		errs = append(errs, field.Invalid(path.Child("minReplicas"), policy.MinReplicas, "must be at least 1"))
+	}
+	if policy.MaxReplicas < policy.MinReplicas {
+		errs = append(errs, field.Invalid(path.Child("maxReplicas"), policy.MaxReplicas, "must not be below minReplicas"))
+	}
+	if policy.Target <= 0 {
+		errs = append(errs, field.Invalid(path.Child("targetMessagesPerSecond"), spec.TargetMessagesPerSecond.String(), "must be positive"))
+	}
+	if policy.ScaleDownStabilization < 0 {
+		errs = append(errs, field.Invalid(path.Child("scaleDownStabilization"), policy.ScaleDownStabilization.String(), "must not be negative"))
+	}
+	return policy, errs.ToAggregate()
+}
+
+// Recommend returns the replicas a rate asks for and why. Replicas whose
+// rate is within Tolerance of the target are kept.
+func (p Policy) Recommend(rate float64, current int32) (int32, string) {
+	if current > 0 && math.Abs(rate/(p.Target*float64(current))-1) <= Tolerance {
+		return p.clamp(current)
+	}
+	replicas, reason := p.clamp(int32(math.Ceil(rate / p.Target)))
+	if reason == "" {
+		reason = fmt.Sprintf("%s messages per second need %d replicas at %s per replica",
+			FormatRate(rate), replicas, FormatRate(p.Target))
+	}
+	return replicas, reason
+}
+
+func (p Policy) clamp(replicas int32) (int32, string) {
+	switch {
+	case replicas < p.MinReplicas:
+		return p.MinReplicas, fmt.Sprintf("Limited by minReplicas %d", p.MinReplicas)
+	case replicas > p.MaxReplicas:
+		return p.MaxReplicas, fmt.Sprintf("Limited by maxReplicas %d", p.MaxReplicas)
+	}
+	return replicas, ""
+}
+
+// FormatRate formats a rate of messages per second with one decimal.
+func FormatRate(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', 1, 64)
+}
+
+// Stabilizer keeps the recent recommendations of every workload, so that
+// workloads are only scaled down once the rate stayed low for their
+// stabilization window.
+type Stabilizer struct {
+	mu              sync.Mutex
+	recommendations map[types.NamespacedName][]recommendation
+}
+
+type recommendation struct {
+	at       time.Time
+	replicas int32
+}
+
+// NewStabilizer returns a stabilizer without recommendations.
+func NewStabilizer() *Stabilizer {
+	return &Stabilizer{recommendations: map[types.NamespacedName][]recommendation{}}
+}
+
+// Stabilize records a recommendation for a workload and returns the highest
+// recommendation made within the window.
+func (s *Stabilizer) Stabilize(key types.NamespacedName, replicas int32, now time.Time, window time.Duration) int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := []recommendation{{at: now, replicas: replicas}}
+	highest := replicas
+	for _, r := range s.recommendations[key] {
+		if now.Sub(r.at) < window {
+			kept = append(kept, r)
+			if r.replicas > highest {
+				highest = r.replicas
+			}
+		}
+	}
+	s.recommendations[key] = kept
+	return highest
+}
+
+// Forget drops the recommendations of a workload.
+func (s *Stabilizer) Forget(key types.NamespacedName) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.recommendations, key)
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package broker places the MQTT brokers of TwinServices. Depending on the
+// scope, one broker serves the whole cluster, every namespace gets its own
+// broker, or every TwinService does.
+package broker
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/agwermann/dt-operator/pkg/naming"
+)
+
+// Scope chooses which TwinServices share a broker.
+type Scope string
+
+const (
+	// ClusterScope runs a single broker in Namespace for every TwinService.
+	ClusterScope Scope = "cluster"
+	// NamespaceScope runs a broker in every namespace with an MQTT
+	// TwinService, shared by the services of the namespace.
+	NamespaceScope Scope = "namespace"
+	// ServiceScope runs a broker next to every MQTT TwinService.
+	ServiceScope Scope = "service"
+)
+
+// Namespace holds the broker of ClusterScope.
+const Namespace = "mqtt"
+
+// Name prefixes the resources of the broker of a cluster or a namespace.
+const Name = "mqtt-broker"
+
+// Port is the MQTT port of brokers.
+const Port = 1883
+
+// ParseScope parses the name of a scope.
+func ParseScope(name string) (Scope, error) {
+	switch scope := Scope(name); scope {
+	case ClusterScope, NamespaceScope, ServiceScope:
+		return scope, nil
+	}
+	return "", fmt.Errorf("unknown broker scope %q, expected %s, %s or %s", name, ClusterScope, NamespaceScope, ServiceScope)
+}
+
+// Placement locates the resources of a broker: they live in Namespace and
+// their names start with Name. Names that are not DNS labels, or are longer,
+// are rewritten and end with a hash of the whole name.
+type Placement struct {
+	Namespace string
+	Name      string
+}
+
+// For returns the placement of the broker serving the TwinService service
+// of namespace.
+func (s Scope) For(namespace, service string) Placement {
+	switch s {
+	case NamespaceScope:
+		return Placement{Namespace: namespace, Name: Name}
+	case ServiceScope:
+		return Placement{Namespace: namespace, Name: service + "-" + Name}
+	}
+	return Placement{Namespace: Namespace, Name: Name}
+}
+
+// ConfigMap names the configuration and ACL of the broker.
+func (p Placement) ConfigMap() string {
+	return p.name("-config")
+}
+
+// Deployment names the broker Deployment.
+func (p Placement) Deployment() string {
+	return p.name("-deployment")
+}
+
+// Service names the Service twins and services connect to.
+func (p Placement) Service() string {
+	return p.name("-service")
+}
+
+// MetricsService names the Service, and the ServiceMonitor, of the metrics
+// exporter of the broker.
+func (p Placement) MetricsService() string {
+	return p.name("-metrics")
+}
+
+// PasswordFile names the Secret holding the password file of the broker.
+func (p Placement) PasswordFile() string {
+	return p.name("-passwd")
+}
+
+// ExporterCredentials names the Secret holding the credentials of the
+// metrics exporter of the broker.
+func (p Placement) ExporterCredentials() string {
+	return p.name("-exporter-credentials")
+}
+
+// NetworkPolicy names the NetworkPolicy admitting the clients of the
+// broker.
+func (p Placement) NetworkPolicy() string {
+	return p.name("-network-policy")
+}
+
+// name is the name of the resource of the broker ending with suffix. The
+// Services and Deployments of brokers need DNS labels, which the names of
+// TwinServices, such as svc.v2, are not always.
+func (p Placement) name(suffix string) string {
+	return naming.DNSLabel(p.Name+suffix, validation.DNS1035LabelMaxLength)
+}
+
+// Address is the URL of the broker within the cluster.
+func (p Placement) Address() string {
+	return fmt.Sprintf("tcp://%s.%s:%d", p.Service(), p.Namespace, Port)
+}
+
+// Addresses resolves the broker the workloads of a namespace connect to.
+type Addresses struct {
+	Scope Scope
+	// Default is the broker of ClusterScope, and of workloads of
+	// ServiceScope that are not tied to a TwinService.
+	Default string
+}
+
+// For returns the address of the broker of the TwinService service of
+// namespace. service may be empty for workloads serving no TwinService.
+func (a Addresses) For(namespace, service string) string {
+	switch {
+	case a.Scope == NamespaceScope:
+		return a.Scope.For(namespace, service).Address()
+	case a.Scope == ServiceScope && service != "":
+		return a.Scope.For(namespace, service).Address()
+	}
+	return a.Default
+}
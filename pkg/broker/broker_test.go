@@ -0,0 +1,146 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var _ = Describe("Scope", func() {
+	It("parses the scopes", func() {
+		for _, name := range []string{"cluster", "namespace", "service"} {
+			scope, err := ParseScope(name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(scope)).To(Equal(name))
+		}
+		_, err := ParseScope("tenant")
+		Expect(err).To(MatchError(ContainSubstring(`unknown broker scope "tenant"`)))
+	})
+
+	It("keeps the cluster broker in the mqtt namespace", func() {
+		placement := ClusterScope.For("factory", "alarms")
+		Expect(placement).To(Equal(Placement{Namespace: "mqtt", Name: "mqtt-broker"}))
+		Expect(placement.ConfigMap()).To(Equal("mqtt-broker-config"))
+		Expect(placement.Deployment()).To(Equal("mqtt-broker-deployment"))
+		Expect(placement.Service()).To(Equal("mqtt-broker-service"))
+		Expect(placement.MetricsService()).To(Equal("mqtt-broker-metrics"))
+		Expect(placement.PasswordFile()).To(Equal("mqtt-broker-passwd"))
+		Expect(placement.ExporterCredentials()).To(Equal("mqtt-broker-exporter-credentials"))
+		Expect(placement.NetworkPolicy()).To(Equal("mqtt-broker-network-policy"))
+		Expect(placement.Address()).To(Equal("tcp://mqtt-broker-service.mqtt:1883"))
+	})
+
+	It("places a broker in the namespace of the service", func() {
+		placement := NamespaceScope.For("factory", "alarms")
+		Expect(placement).To(Equal(Placement{Namespace: "factory", Name: "mqtt-broker"}))
+		Expect(placement.Address()).To(Equal("tcp://mqtt-broker-service.factory:1883"))
+	})
+
+	It("places a broker next to every service", func() {
+		placement := ServiceScope.For("factory", "alarms")
+		Expect(placement).To(Equal(Placement{Namespace: "factory", Name: "alarms-mqtt-broker"}))
+		Expect(placement.Deployment()).To(Equal("alarms-mqtt-broker-deployment"))
+		Expect(placement.Address()).To(Equal("tcp://alarms-mqtt-broker-service.factory:1883"))
+	})
+
+	It("shortens the names of the broker of a long service name", func() {
+		service := "production-line-seven-spindle-temperature-and-vibration-alarms"
+		placement := ServiceScope.For("factory", service)
+		other := ServiceScope.For("factory", service+"-v2")
+		for _, name := range []string{placement.ConfigMap(), placement.Deployment(), placement.Service(), placement.MetricsService(), placement.PasswordFile(), placement.ExporterCredentials(), placement.NetworkPolicy()} {
+			Expect(validation.IsDNS1035Label(name)).To(BeEmpty(), name)
+		}
+		Expect(placement.Deployment()).To(HavePrefix("production-line-seven-spindle-temperature-and-vibratio-"))
+		Expect(placement.Deployment()).NotTo(Equal(other.Deployment()))
+		Expect(placement.Deployment()).NotTo(Equal(placement.Service()))
+		Expect(placement.Address()).To(Equal("tcp://" + placement.Service() + ".factory:1883"))
+	})
+
+	It("turns dotted and numeric service names into DNS labels", func() {
+		dotted := ServiceScope.For("factory", "svc.v2")
+		dashed := ServiceScope.For("factory", "svc-v2")
+		numeric := ServiceScope.For("factory", "2nd-line")
+		for _, placement := range []Placement{dotted, numeric} {
+			for _, name := range []string{placement.ConfigMap(), placement.Deployment(), placement.Service(), placement.MetricsService(), placement.PasswordFile(), placement.ExporterCredentials(), placement.NetworkPolicy()} {
+				Expect(validation.IsDNS1035Label(name)).To(BeEmpty(), name)
+			}
+		}
+		Expect(dotted.Service()).To(HavePrefix("svc-v2-mqtt-broker-service-"))
+		Expect(dotted.Service()).NotTo(Equal(dashed.Service()))
+		Expect(dashed.Service()).To(Equal("svc-v2-mqtt-broker-service"))
+		Expect(numeric.Deployment()).To(HavePrefix("x2nd-line-mqtt-broker-deployment-"))
+	})
+})
+
+var _ = Describe("Addresses", func() {
+	const fallback = "tcp://broker.example:1883"
+
+	It("uses the default broker in cluster scope", func() {
+		addresses := Addresses{Scope: ClusterScope, Default: fallback}
+		Expect(addresses.For("factory", "alarms")).To(Equal(fallback))
+	})
+
+	It("uses the broker of the namespace in namespace scope", func() {
+		addresses := Addresses{Scope: NamespaceScope, Default: fallback}
+		Expect(addresses.For("factory", "")).To(Equal("tcp://mqtt-broker-service.factory:1883"))
+		Expect(addresses.For("factory", "alarms")).To(Equal("tcp://mqtt-broker-service.factory:1883"))
+	})
+
+	It("uses the broker of the service in service scope", func() {
+		addresses := Addresses{Scope: ServiceScope, Default: fallback}
+		Expect(addresses.For("factory", "alarms")).To(Equal("tcp://alarms-mqtt-broker-service.factory:1883"))
+		Expect(addresses.For("factory", "")).To(Equal(fallback))
+	})
+})
+
+var _ = Describe("Credentials", func() {
+	It("hashes passwords as mosquitto_passwd does", func() {
+		salt := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+		Expect(hashPassword("secret", salt, hashIterations)).To(Equal(
+			"$7$101$AAECAwQFBgcICQoL$Xr99N9ym9ys8TWxis5ajETJq6EVYzmc6nb8t3pUYnPBbCAbICS4xejTltonXWCtJNBvA+By+TXUqU6qCblO00w=="))
+
+		hash, err := HashPassword("secret")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(VerifyPassword(hash, "secret")).To(BeTrue())
+		Expect(VerifyPassword(hash, "other")).To(BeFalse())
+		Expect(VerifyPassword("secret", "secret")).To(BeFalse())
+	})
+
+	It("keeps the hashes of unchanged passwords in the password file", func() {
+		credentials := []Credentials{
+			{Username: "plant/monitor", Password: "monitor"},
+			{Username: "dt-operator", Password: "operator"},
+		}
+		first, err := PasswordFile(credentials, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first).To(MatchRegexp(`^dt-operator:\$7\$101\$[^\n]+\nplant/monitor:\$7\$101\$[^\n]+\n$`))
+
+		again, err := PasswordFile(credentials, first)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).To(Equal(first))
+
+		credentials[0].Password = "rotated"
+		rotated, err := PasswordFile(credentials, first)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotated).NotTo(Equal(first))
+		Expect(rotated).To(HavePrefix(strings.SplitAfter(first, "\n")[0]))
+	})
+})
@@ -0,0 +1,227 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"golang.org/x/crypto/pbkdf2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// UsernameEnv and PasswordEnv are the environment variables the workloads
+// of the operator read their broker credentials from.
+const (
+	UsernameEnv = "MQTT_USERNAME"
+	PasswordEnv = "MQTT_PASSWORD"
+)
+
+// Credentials authenticate an MQTT client to the broker.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// CredentialsFromEnv reads the credentials from UsernameEnv and
+// PasswordEnv.
+func CredentialsFromEnv() Credentials {
+	return Credentials{Username: os.Getenv(UsernameEnv), Password: os.Getenv(PasswordEnv)}
+}
+
+// Apply sets the credentials on the options of an MQTT client.
+func (c Credentials) Apply(options *mqtt.ClientOptions) *mqtt.ClientOptions {
+	return options.SetUsername(c.Username).SetPassword(c.Password)
+}
+
+// ApplySecret returns the credentials of username held by the basic-auth
+// Secret key, creating the Secret with a random password when it does not
+// exist. mutate, when set, is called on the Secret before it is created,
+// such as to set its owner.
+func ApplySecret(ctx context.Context, c client.Client, key types.NamespacedName, username string, mutate func(*corev1.Secret) error) (Credentials, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, key, secret)
+	if err == nil {
+		if string(secret.Data[corev1.BasicAuthUsernameKey]) != username {
+			if secret.Data == nil {
+				secret.Data = map[string][]byte{}
+			}
+			secret.Data[corev1.BasicAuthUsernameKey] = []byte(username)
+			if err := c.Update(ctx, secret); err != nil {
+				return Credentials{}, err
+			}
+		}
+		return secretCredentials(secret), nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return Credentials{}, err
+	}
+
+	password, err := newPassword()
+	if err != nil {
+		return Credentials{}, err
+	}
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+		Type:       corev1.SecretTypeBasicAuth,
+		Data: map[string][]byte{
+			corev1.BasicAuthUsernameKey: []byte(username),
+			corev1.BasicAuthPasswordKey: []byte(password),
+		},
+	}
+	if mutate != nil {
+		if err := mutate(secret); err != nil {
+			return Credentials{}, err
+		}
+	}
+	if err := c.Create(ctx, secret); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return ApplySecret(ctx, c, key, username, mutate)
+		}
+		return Credentials{}, err
+	}
+	return secretCredentials(secret), nil
+}
+
+// SecretCredentials returns a function waiting for the Secret key to exist
+// and reading its credentials, for clients started before the operator
+// created their Secret.
+func SecretCredentials(reader client.Reader, key types.NamespacedName) func(context.Context) (Credentials, error) {
+	return func(ctx context.Context) (Credentials, error) {
+		credentials := Credentials{}
+		err := wait.PollImmediateUntilWithContext(ctx, 5*time.Second, func(ctx context.Context) (bool, error) {
+			secret := &corev1.Secret{}
+			if err := reader.Get(ctx, key, secret); err != nil {
+				if apierrors.IsNotFound(err) {
+					log.FromContext(ctx).V(1).Info("Waiting for broker credentials", "Secret", key)
+					return false, nil
+				}
+				return false, err
+			}
+			credentials = secretCredentials(secret)
+			return true, nil
+		})
+		return credentials, err
+	}
+}
+
+func secretCredentials(secret *corev1.Secret) Credentials {
+	return Credentials{
+		Username: string(secret.Data[corev1.BasicAuthUsernameKey]),
+		Password: string(secret.Data[corev1.BasicAuthPasswordKey]),
+	}
+}
+
+// hashIterations and saltLength are the PBKDF2 parameters mosquitto_passwd
+// hashes passwords with.
+const (
+	hashIterations = 101
+	saltLength     = 12
+)
+
+// HashPassword hashes password with a random salt in the PBKDF2-SHA512
+// format of Mosquitto password files.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hashPassword(password, salt, hashIterations), nil
+}
+
+// VerifyPassword is true when hash, in the format of Mosquitto password
+// files, is a hash of password.
+func VerifyPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != "7" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[2])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashPassword(password, salt, iterations)), []byte(hash)) == 1
+}
+
+func hashPassword(password string, salt []byte, iterations int) string {
+	key := pbkdf2.Key([]byte(password), salt, iterations, sha512.Size, sha512.New)
+	return fmt.Sprintf("$7$%d$%s$%s", iterations, base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(key))
+}
+
+// PasswordFile renders a Mosquitto password_file of the credentials,
+// sorted by username. The hashes of previous, a password file rendered
+// before, are kept for the passwords they still match, so that the file only
+// changes with the credentials.
+func PasswordFile(credentials []Credentials, previous string) (string, error) {
+	hashes := map[string]string{}
+	for _, line := range strings.Split(previous, "\n") {
+		if username, hash, ok := strings.Cut(line, ":"); ok {
+			hashes[username] = hash
+		}
+	}
+
+	credentials = append([]Credentials(nil), credentials...)
+	sort.Slice(credentials, func(i, j int) bool {
+		return credentials[i].Username < credentials[j].Username
+	})
+	b := &strings.Builder{}
+	seen := map[string]bool{}
+	for _, c := range credentials {
+		if seen[c.Username] {
+			continue
+		}
+		seen[c.Username] = true
+		hash, ok := hashes[c.Username]
+		if !ok || !VerifyPassword(hash, c.Password) {
+			var err error
+			if hash, err = HashPassword(c.Password); err != nil {
+				return "", err
+			}
+		}
+		fmt.Fprintf(b, "%s:%s\n", c.Username, hash)
+	}
+	return b.String(), nil
+}
+
+// newPassword returns a random password of 192 bits.
+func newPassword() (string, error) {
+	data := make([]byte, 24)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
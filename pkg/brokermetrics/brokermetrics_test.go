@@ -0,0 +1,76 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package brokermetrics
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Exporter", func() {
+	It("exports the statistics the broker published", func() {
+		e := NewExporter()
+		e.Handle("$SYS/broker/clients/connected", []byte("12"))
+		e.Handle("$SYS/broker/load/messages/received/1min", []byte("300.00"))
+		e.Handle("$SYS/broker/retained messages/count", []byte("40"))
+		e.Handle("$SYS/broker/publish/messages/dropped", []byte("3"))
+		e.Handle("$SYS/broker/bytes/received", []byte("2048"))
+		e.Handle("$SYS/broker/uptime", []byte("120 seconds"))
+
+		Expect(testutil.CollectAndCompare(e, strings.NewReader(`
+# HELP dt_broker_clients_connected Number of clients connected to the broker.
+# TYPE dt_broker_clients_connected gauge
+dt_broker_clients_connected 12
+# HELP dt_broker_messages_dropped_total Messages dropped by the broker because a client queue was full.
+# TYPE dt_broker_messages_dropped_total counter
+dt_broker_messages_dropped_total 3
+# HELP dt_broker_messages_received_per_second Messages received by the broker per second, averaged over a minute.
+# TYPE dt_broker_messages_received_per_second gauge
+dt_broker_messages_received_per_second 5
+# HELP dt_broker_received_bytes_total Bytes received by the broker since it started.
+# TYPE dt_broker_received_bytes_total counter
+dt_broker_received_bytes_total 2048
+# HELP dt_broker_retained_messages Number of retained messages held by the broker.
+# TYPE dt_broker_retained_messages gauge
+dt_broker_retained_messages 40
+`))).To(Succeed())
+	})
+
+	It("keeps the last value of a statistic", func() {
+		e := NewExporter()
+		e.Handle("$SYS/broker/clients/connected", []byte("12"))
+		e.Handle("$SYS/broker/clients/connected", []byte("7"))
+
+		Expect(testutil.ToFloat64(e)).To(Equal(7.0))
+	})
+
+	It("ignores payloads without a number", func() {
+		e := NewExporter()
+		e.Handle("$SYS/broker/clients/connected", []byte("12"))
+		e.Handle("$SYS/broker/clients/connected", []byte(""))
+		e.Handle("$SYS/broker/clients/connected", []byte("many"))
+
+		Expect(testutil.ToFloat64(e)).To(Equal(12.0))
+	})
+
+	It("leaves out statistics not published yet", func() {
+		Expect(testutil.CollectAndCount(NewExporter())).To(Equal(0))
+	})
+})
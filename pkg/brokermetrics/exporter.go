@@ -0,0 +1,159 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package brokermetrics exposes the load of the Mosquitto broker, as it
+// publishes it on its $SYS topics, in the Prometheus format.
+package brokermetrics
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/agwermann/dt-operator/pkg/broker"
+)
+
+// SysTopics is the filter of the topics Mosquitto publishes its statistics
+// on, every sys_interval seconds.
+const SysTopics = "$SYS/#"
+
+// Port is the port the exporter serves metrics on.
+const Port = 9234
+
+// metric maps a $SYS topic to a Prometheus metric. Scale converts the value
+// Mosquitto publishes to the unit of the metric.
+type metric struct {
+	topic     string
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	scale     float64
+}
+
+func newMetric(topic, name, help string, valueType prometheus.ValueType, scale float64) metric {
+	return metric{
+		topic:     topic,
+		desc:      prometheus.NewDesc(name, help, nil, nil),
+		valueType: valueType,
+		scale:     scale,
+	}
+}
+
+// The load averages Mosquitto publishes are per minute.
+var metrics = []metric{
+	newMetric("$SYS/broker/clients/connected", "dt_broker_clients_connected",
+		"Number of clients connected to the broker.", prometheus.GaugeValue, 1),
+	newMetric("$SYS/broker/load/messages/received/1min", "dt_broker_messages_received_per_second",
+		"Messages received by the broker per second, averaged over a minute.", prometheus.GaugeValue, 1.0/60),
+	newMetric("$SYS/broker/load/messages/sent/1min", "dt_broker_messages_sent_per_second",
+		"Messages sent by the broker per second, averaged over a minute.", prometheus.GaugeValue, 1.0/60),
+	newMetric("$SYS/broker/messages/received", "dt_broker_messages_received_total",
+		"Messages received by the broker since it started.", prometheus.CounterValue, 1),
+	newMetric("$SYS/broker/messages/sent", "dt_broker_messages_sent_total",
+		"Messages sent by the broker since it started.", prometheus.CounterValue, 1),
+	newMetric("$SYS/broker/retained messages/count", "dt_broker_retained_messages",
+		"Number of retained messages held by the broker.", prometheus.GaugeValue, 1),
+	newMetric("$SYS/broker/publish/messages/dropped", "dt_broker_messages_dropped_total",
+		"Messages dropped by the broker because a client queue was full.", prometheus.CounterValue, 1),
+	newMetric("$SYS/broker/bytes/received", "dt_broker_received_bytes_total",
+		"Bytes received by the broker since it started.", prometheus.CounterValue, 1),
+	newMetric("$SYS/broker/bytes/sent", "dt_broker_sent_bytes_total",
+		"Bytes sent by the broker since it started.", prometheus.CounterValue, 1),
+}
+
+// Exporter is a prometheus.Collector of the last statistics the broker
+// published. Metrics the broker has not published yet are left out, rather
+// than reported as zero.
+type Exporter struct {
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewExporter returns an Exporter without statistics.
+func NewExporter() *Exporter {
+	return &Exporter{values: map[string]float64{}}
+}
+
+// Handle records a message published on a $SYS topic. Messages on topics
+// that are not exported, or without a number, are ignored.
+func (e *Exporter) Handle(topic string, payload []byte) {
+	for _, m := range metrics {
+		if m.topic != topic {
+			continue
+		}
+		fields := strings.Fields(string(payload))
+		if len(fields) == 0 {
+			return
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return
+		}
+		e.mu.Lock()
+		e.values[topic] = value * m.scale
+		e.mu.Unlock()
+		return
+	}
+}
+
+// Subscribe connects to the broker at address with credentials and hands
+// every $SYS message to the exporter. The client reconnects, and subscribes
+// again, until it is disconnected.
+func (e *Exporter) Subscribe(ctx context.Context, address, clientID string, credentials broker.Credentials) mqtt.Client {
+	logger := log.FromContext(ctx)
+
+	options := credentials.Apply(mqtt.NewClientOptions()).
+		AddBroker(address).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			token := c.Subscribe(SysTopics, 0, func(_ mqtt.Client, message mqtt.Message) {
+				e.Handle(message.Topic(), message.Payload())
+			})
+			if token.Wait() && token.Error() != nil {
+				logger.Error(token.Error(), "Unable to subscribe to broker statistics")
+			}
+		})
+	c := mqtt.NewClient(options)
+	c.Connect()
+	return c
+}
+
+// Describe implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range metrics {
+		ch <- m.desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, m := range metrics {
+		value, ok := e.values[m.topic]
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, value)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package diagram draws the TwinClass graph of a model, with the attributes
+// of the classes, the enums they use and their relationships, as SVG,
+// Graphviz DOT or Mermaid. SVG is laid out in Go, so that no graphviz
+// binary is needed.
+package diagram
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/inspect"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+// Formats lists the formats a diagram renders to.
+var Formats = []string{"svg", "dot", "mermaid"}
+
+// Diagram is the class graph of a model, built from the latest version of
+// every class.
+type Diagram struct {
+	Classes []Class
+	Enums   []Enum
+	Edges   []Edge
+}
+
+// Class is a class box, listing the attributes as written in the class.
+// Components are drawn as edges rather than flattened. Missing marks a
+// class edges lead to that is not in the model.
+type Class struct {
+	Name       string
+	ID         string
+	Attributes []string
+	Missing    bool
+}
+
+// Enum is an enum box listing its values, or a missing enum.
+type Enum struct {
+	Name    string
+	Values  []string
+	Missing bool
+}
+
+// EdgeKind tells relationships, components and the use of an enum apart.
+type EdgeKind string
+
+const (
+	Relationship EdgeKind = "relationship"
+	Component    EdgeKind = "component"
+	EnumUse      EdgeKind = "enum"
+)
+
+// Edge leads from a class to a class it relates to or embeds, or to an
+// enum its attributes use. Label is the name of the relationship or of the
+// component slot.
+type Edge struct {
+	From  string
+	To    string
+	Label string
+	Kind  EdgeKind
+	// Multiplicity is the cardinality of a relationship, as min..max.
+	Multiplicity string
+}
+
+// Build draws the classes of m, or only those root leads to when root is
+// set, with the enums they use. Relationships allowing any class are left
+// out.
+func Build(m *model.Model, root string) (*Diagram, error) {
+	graph := inspect.ClassGraph(m)
+	included := map[string]bool{}
+	if root == "" {
+		for _, class := range graph.Classes {
+			included[class] = true
+		}
+	} else {
+		if _, ok := m.Class(root); !ok {
+			return nil, fmt.Errorf("twin class %q not found", root)
+		}
+		queue := []string{root}
+		included[root] = true
+		for len(queue) > 0 {
+			class := queue[0]
+			queue = queue[1:]
+			for _, edge := range graph.Edges {
+				if edge.From == class && !included[edge.To] {
+					included[edge.To] = true
+					queue = append(queue, edge.To)
+				}
+			}
+		}
+	}
+
+	d := &Diagram{}
+	enums := map[string]bool{}
+	for _, class := range m.Classes() {
+		if !included[class.Spec.Name] {
+			continue
+		}
+		box := Class{Name: class.Spec.Name, ID: class.Spec.ID}
+		used := []string{}
+		for i := range class.Spec.Attributes {
+			attribute := &class.Spec.Attributes[i]
+			line := attribute.Name + ": " + schemaString(&attribute.TwinSchema)
+			if attribute.Kind == dtdlv0.Telemetry {
+				line += " «telemetry»"
+			}
+			box.Attributes = append(box.Attributes, line)
+			used = append(used, enumReferences(&attribute.TwinSchema)...)
+		}
+		d.Classes = append(d.Classes, box)
+
+		sort.Strings(used)
+		for i, enum := range used {
+			if i > 0 && used[i-1] == enum {
+				continue
+			}
+			enums[enum] = true
+			d.Edges = append(d.Edges, Edge{From: class.Spec.Name, To: enum, Kind: EnumUse})
+		}
+	}
+	for _, edge := range graph.Edges {
+		if !included[edge.From] {
+			continue
+		}
+		if edge.Component {
+			d.Edges = append(d.Edges, Edge{From: edge.From, To: edge.To, Label: edge.Name, Kind: Component})
+			continue
+		}
+		d.Edges = append(d.Edges, Edge{From: edge.From, To: edge.To, Label: edge.Name, Kind: Relationship, Multiplicity: edge.Multiplicity})
+	}
+	sort.SliceStable(d.Edges, func(i, j int) bool {
+		return d.Edges[i].From < d.Edges[j].From
+	})
+
+	for _, enum := range m.Enums() {
+		if enums[enum.Spec.Name] {
+			d.Enums = append(d.Enums, Enum{Name: enum.Spec.Name, Values: enum.Spec.Values})
+		}
+	}
+
+	nodes := map[string]bool{}
+	for _, class := range d.Classes {
+		nodes[class.Name] = true
+	}
+	for _, enum := range d.Enums {
+		nodes[enum.Name] = true
+	}
+	for _, edge := range d.Edges {
+		if nodes[edge.To] {
+			continue
+		}
+		nodes[edge.To] = true
+		if edge.Kind == EnumUse {
+			d.Enums = append(d.Enums, Enum{Name: edge.To, Missing: true})
+		} else {
+			d.Classes = append(d.Classes, Class{Name: edge.To, Missing: true})
+		}
+	}
+	return d, nil
+}
+
+// schemaString writes a schema as written in a class, naming the enum of
+// an enumeration.
+func schemaString(s *dtdlv0.TwinSchema) string {
+	switch dtdlv0.PrimitiveTypes(s.Type) {
+	case dtdlv0.Enumeration:
+		return s.Reference
+	case dtdlv0.Array:
+		if s.Items != nil {
+			return "array<" + schemaString(s.Items) + ">"
+		}
+	case dtdlv0.Map:
+		if s.Values != nil {
+			return "map<" + schemaString(s.Values) + ">"
+		}
+	case dtdlv0.Object:
+		fields := []string{}
+		for i := range s.Fields {
+			fields = append(fields, s.Fields[i].Name+": "+schemaString(&s.Fields[i].TwinSchema))
+		}
+		return "object{" + strings.Join(fields, ", ") + "}"
+	}
+	return s.Type
+}
+
+func enumReferences(s *dtdlv0.TwinSchema) []string {
+	references := []string{}
+	if dtdlv0.PrimitiveTypes(s.Type) == dtdlv0.Enumeration && s.Reference != "" {
+		references = append(references, s.Reference)
+	}
+	for _, nested := range []*dtdlv0.TwinSchema{s.Items, s.Values} {
+		if nested != nil {
+			references = append(references, enumReferences(nested)...)
+		}
+	}
+	for i := range s.Fields {
+		references = append(references, enumReferences(&s.Fields[i].TwinSchema)...)
+	}
+	return references
+}
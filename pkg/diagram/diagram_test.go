@@ -0,0 +1,172 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diagram
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+var one = int32(1)
+
+func plant() *model.Model {
+	return model.New([]dtdlv0.TwinClass{
+		{Spec: dtdlv0.TwinClassSpec{
+			Name: "Factory",
+			Relationships: []dtdlv0.TwinRelationship{
+				{Name: "machines", Targets: []string{"Machine"}},
+				{Name: "supplier", Targets: []string{"Warehouse"}, MaxMultiplicity: &one},
+			},
+		}},
+		{Spec: dtdlv0.TwinClassSpec{
+			Name: "Machine",
+			Attributes: []dtdlv0.TwinClassAttributes{
+				{Name: "state", TwinSchema: dtdlv0.TwinSchema{Type: "enumeration", Reference: "State"}, Kind: dtdlv0.Telemetry},
+				{Name: "loads", TwinSchema: dtdlv0.TwinSchema{Type: "map", Values: &dtdlv0.TwinSchema{Type: "enumeration", Reference: "State"}}},
+			},
+			Relationships: []dtdlv0.TwinRelationship{
+				{Name: "next", Targets: []string{"Machine"}, MaxMultiplicity: &one},
+			},
+			Components: []dtdlv0.TwinComponent{{Name: "motor", Class: "Motor"}},
+		}},
+		{Spec: dtdlv0.TwinClassSpec{
+			Name:       "Motor",
+			Attributes: []dtdlv0.TwinClassAttributes{{Name: "speed", TwinSchema: dtdlv0.TwinSchema{Type: "double"}}},
+		}},
+		{Spec: dtdlv0.TwinClassSpec{Name: "Office"}},
+	}, []dtdlv0.TwinEnum{
+		{Spec: dtdlv0.TwinEnumSpec{Name: "State", Values: []string{"idle", "busy"}}},
+		{Spec: dtdlv0.TwinEnumSpec{Name: "Unused", Values: []string{"a"}}},
+	})
+}
+
+var _ = Describe("Build", func() {
+	It("draws classes, the enums they use and their edges", func() {
+		d, err := Build(plant(), "")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(d.Classes).To(ConsistOf(
+			Class{Name: "Factory"},
+			Class{Name: "Machine", Attributes: []string{"state: State «telemetry»", "loads: map<State>"}},
+			Class{Name: "Motor", Attributes: []string{"speed: double"}},
+			Class{Name: "Office"},
+			Class{Name: "Warehouse", Missing: true},
+		))
+		Expect(d.Enums).To(Equal([]Enum{{Name: "State", Values: []string{"idle", "busy"}}}))
+		Expect(d.Edges).To(ConsistOf(
+			Edge{From: "Factory", To: "Machine", Label: "machines", Kind: Relationship, Multiplicity: "0..*"},
+			Edge{From: "Factory", To: "Warehouse", Label: "supplier", Kind: Relationship, Multiplicity: "0..1"},
+			Edge{From: "Machine", To: "State", Kind: EnumUse},
+			Edge{From: "Machine", To: "Machine", Label: "next", Kind: Relationship, Multiplicity: "0..1"},
+			Edge{From: "Machine", To: "Motor", Label: "motor", Kind: Component},
+		))
+	})
+
+	It("draws the classes a root leads to", func() {
+		d, err := Build(plant(), "Machine")
+		Expect(err).NotTo(HaveOccurred())
+
+		names := []string{}
+		for _, class := range d.Classes {
+			names = append(names, class.Name)
+		}
+		Expect(names).To(Equal([]string{"Machine", "Motor"}))
+		Expect(d.Enums).To(HaveLen(1))
+
+		_, err = Build(plant(), "Pump")
+		Expect(err).To(MatchError(ContainSubstring(`"Pump" not found`)))
+	})
+})
+
+var _ = Describe("Rendering", func() {
+	var d *Diagram
+
+	BeforeEach(func() {
+		var err error
+		d, err = Build(plant(), "")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("writes DOT with escaped HTML labels", func() {
+		out := &bytes.Buffer{}
+		Expect(WriteDOT(out, d)).To(Succeed())
+
+		Expect(out.String()).To(HavePrefix("digraph model {\n"))
+		Expect(out.String()).To(ContainSubstring("loads: map&lt;State&gt;"))
+		Expect(out.String()).To(ContainSubstring(`"Factory" -> "Machine" [label="machines", headlabel="0..*", arrowhead=vee];`))
+		Expect(out.String()).To(ContainSubstring(`"Machine" -> "Motor" [label="motor", dir=both, arrowtail=diamond, arrowhead=none];`))
+		Expect(out.String()).To(ContainSubstring(`"Machine" -> "State" [style=dotted, arrowhead=open];`))
+		Expect(out.String()).To(ContainSubstring(`style="dashed"><tr><td>«missing»<br/><b>Warehouse</b>`))
+	})
+
+	It("writes Mermaid", func() {
+		out := &bytes.Buffer{}
+		Expect(WriteMermaid(out, d)).To(Succeed())
+
+		Expect(out.String()).To(ContainSubstring("  class Machine {\n    state: State «telemetry»\n    loads: map~State~\n  }\n"))
+		Expect(out.String()).To(ContainSubstring("  class State {\n    <<enumeration>>\n    idle\n    busy\n  }\n"))
+		Expect(out.String()).To(ContainSubstring("  class Warehouse {\n    <<missing>>\n  }\n"))
+		Expect(out.String()).To(ContainSubstring("  Factory --> \"0..1\" Warehouse : supplier\n"))
+		Expect(out.String()).To(ContainSubstring("  Machine *-- Motor : motor\n"))
+		Expect(out.String()).To(ContainSubstring("  Machine ..> State\n"))
+	})
+
+	It("writes well-formed SVG with every box laid out apart", func() {
+		out := &bytes.Buffer{}
+		Expect(WriteSVG(out, d)).To(Succeed())
+
+		decoder := xml.NewDecoder(bytes.NewReader(out.Bytes()))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(out.String()).To(ContainSubstring(`<g id="Machine">`))
+		Expect(out.String()).To(ContainSubstring(`>loads: map&lt;State&gt;</text>`))
+		Expect(out.String()).To(ContainSubstring(`>supplier 0..1</text>`))
+
+		boxes, byName, width, height := layout(d)
+		for i, a := range boxes {
+			Expect(a.x + a.w).To(BeNumerically("<=", width))
+			Expect(a.y + a.h).To(BeNumerically("<=", height))
+			for _, b := range boxes[i+1:] {
+				apart := a.x+a.w <= b.x || b.x+b.w <= a.x || a.y+a.h <= b.y || b.y+b.h <= a.y
+				Expect(apart).To(BeTrue(), "%s overlaps %s", a.name, b.name)
+			}
+		}
+		Expect(byName["Factory"].layer).To(Equal(0))
+		Expect(byName["Machine"].layer).To(Equal(1))
+		Expect(byName["State"].layer).To(Equal(2))
+		Expect(byName["Motor"].layer).To(Equal(2))
+	})
+
+	It("is stable across renderings", func() {
+		first, second := &bytes.Buffer{}, &bytes.Buffer{}
+		Expect(WriteSVG(first, d)).To(Succeed())
+		Expect(WriteSVG(second, d)).To(Succeed())
+		Expect(first.String()).To(Equal(second.String()))
+	})
+})
@@ -0,0 +1,319 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diagram
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// Layout of the SVG rendering, in pixels. Text is set in a monospace font
+// so that boxes can be sized without measuring it.
+const (
+	fontSize   = 12
+	charWidth  = 7.2
+	lineHeight = 16
+	padding    = 8
+	layerGap   = 70
+	nodeGap    = 40
+	margin     = 20
+	// orderingSweeps is the number of barycenter passes ordering the boxes
+	// of each layer to reduce crossings.
+	orderingSweeps = 4
+)
+
+// box is a class or enum placed in the drawing.
+type box struct {
+	name    string
+	header  []string
+	lines   []string
+	enum    bool
+	missing bool
+
+	index int
+	layer int
+	order float64
+	x, y  float64
+	w, h  float64
+}
+
+func (b *box) center() (float64, float64) {
+	return b.x + b.w/2, b.y + b.h/2
+}
+
+// clip returns where the line from the center of b towards (tx, ty) leaves
+// the box.
+func (b *box) clip(tx, ty float64) (float64, float64) {
+	cx, cy := b.center()
+	dx, dy := tx-cx, ty-cy
+	if dx == 0 && dy == 0 {
+		return cx, cy
+	}
+	scale := math.Inf(1)
+	if dx != 0 {
+		scale = math.Min(scale, b.w/2/math.Abs(dx))
+	}
+	if dy != 0 {
+		scale = math.Min(scale, b.h/2/math.Abs(dy))
+	}
+	return cx + dx*scale, cy + dy*scale
+}
+
+func newBox(name string, header, lines []string, enum, missing bool) *box {
+	b := &box{name: name, header: header, lines: lines, enum: enum, missing: missing}
+	width := 0
+	for _, line := range append(append([]string(nil), header...), lines...) {
+		if n := utf8.RuneCountInString(line); n > width {
+			width = n
+		}
+	}
+	b.w = float64(width)*charWidth + 2*padding
+	b.h = float64(len(header))*lineHeight + 2*padding
+	if len(lines) > 0 {
+		b.h += float64(len(lines))*lineHeight + padding
+	}
+	return b
+}
+
+// layout places the boxes of the diagram in layers, so that edges mostly
+// point down: every box sits below the boxes leading to it, edges closing a
+// cycle aside. Boxes are then ordered within their layer by the mean
+// position of their neighbours.
+func layout(d *Diagram) ([]*box, map[string]*box, float64, float64) {
+	boxes := []*box{}
+	for _, class := range d.Classes {
+		header := []string{class.Name}
+		if class.Missing {
+			header = []string{"«missing»", class.Name}
+		}
+		boxes = append(boxes, newBox(class.Name, header, class.Attributes, false, class.Missing))
+	}
+	for _, enum := range d.Enums {
+		header := []string{"«enumeration»", enum.Name}
+		if enum.Missing {
+			header = []string{"«missing»", enum.Name}
+		}
+		boxes = append(boxes, newBox(enum.Name, header, enum.Values, true, enum.Missing))
+	}
+	byName := map[string]*box{}
+	for i, b := range boxes {
+		b.index = i
+		byName[b.name] = b
+	}
+
+	// Edges closing a cycle, found depth first, do not constrain layers.
+	successors := map[*box][]*box{}
+	for _, edge := range d.Edges {
+		from, to := byName[edge.From], byName[edge.To]
+		if from != to {
+			successors[from] = append(successors[from], to)
+		}
+	}
+	forward := [][2]*box{}
+	state := map[*box]int{}
+	var visit func(b *box)
+	visit = func(b *box) {
+		state[b] = 1
+		for _, next := range successors[b] {
+			if state[next] == 1 {
+				continue
+			}
+			forward = append(forward, [2]*box{b, next})
+			if state[next] == 0 {
+				visit(next)
+			}
+		}
+		state[b] = 2
+	}
+	for _, b := range boxes {
+		if state[b] == 0 {
+			visit(b)
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, edge := range forward {
+			if edge[1].layer < edge[0].layer+1 {
+				edge[1].layer = edge[0].layer + 1
+				changed = true
+			}
+		}
+	}
+
+	layers := [][]*box{}
+	for _, b := range boxes {
+		for len(layers) <= b.layer {
+			layers = append(layers, nil)
+		}
+		b.order = float64(len(layers[b.layer]))
+		layers[b.layer] = append(layers[b.layer], b)
+	}
+	neighbours := map[*box][]*box{}
+	for _, edge := range d.Edges {
+		from, to := byName[edge.From], byName[edge.To]
+		neighbours[from] = append(neighbours[from], to)
+		neighbours[to] = append(neighbours[to], from)
+	}
+	reorder := func(layer []*box, adjacent int) {
+		barycenters := map[*box]float64{}
+		for _, b := range layer {
+			sum, count := 0.0, 0
+			for _, neighbour := range neighbours[b] {
+				if neighbour.layer == adjacent {
+					sum += neighbour.order
+					count++
+				}
+			}
+			barycenters[b] = b.order
+			if count > 0 {
+				barycenters[b] = sum / float64(count)
+			}
+		}
+		sort.SliceStable(layer, func(i, j int) bool {
+			return barycenters[layer[i]] < barycenters[layer[j]]
+		})
+		for i, b := range layer {
+			b.order = float64(i)
+		}
+	}
+	for sweep := 0; sweep < orderingSweeps; sweep++ {
+		for i := 1; i < len(layers); i++ {
+			reorder(layers[i], i-1)
+		}
+		for i := len(layers) - 2; i >= 0; i-- {
+			reorder(layers[i], i+1)
+		}
+	}
+
+	width := 0.0
+	for _, layer := range layers {
+		layerWidth := -float64(nodeGap)
+		for _, b := range layer {
+			layerWidth += b.w + nodeGap
+		}
+		width = math.Max(width, layerWidth)
+	}
+	y := float64(margin)
+	for _, layer := range layers {
+		layerWidth, layerHeight := -float64(nodeGap), 0.0
+		for _, b := range layer {
+			layerWidth += b.w + nodeGap
+			layerHeight = math.Max(layerHeight, b.h)
+		}
+		x := margin + (width-layerWidth)/2
+		for _, b := range layer {
+			b.x, b.y = x, y
+			x += b.w + nodeGap
+		}
+		y += layerHeight + layerGap
+	}
+	return boxes, byName, width + 2*margin, y - layerGap + margin
+}
+
+// WriteSVG lays the diagram out and writes it as a standalone SVG image.
+// Relationships end in an open arrow with their multiplicity, components
+// start from a filled diamond and enum uses are dotted.
+func WriteSVG(w io.Writer, d *Diagram) error {
+	boxes, byName, width, height := layout(d)
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="monospace" font-size="%d">`+"\n",
+		width, height, width, height, fontSize)
+	fmt.Fprint(w, `<defs>
+<marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="10" markerHeight="10" orient="auto"><path d="M0,0 L10,5 L0,10" fill="none" stroke="#333"/></marker>
+<marker id="diamond" viewBox="0 0 14 8" refX="0" refY="4" markerWidth="14" markerHeight="8" orient="auto"><path d="M0,4 L7,0 L14,4 L7,8 Z" fill="#333"/></marker>
+</defs>
+<rect width="100%" height="100%" fill="#fff"/>
+`)
+
+	for _, edge := range d.Edges {
+		from, to := byName[edge.From], byName[edge.To]
+		if from == to {
+			fmt.Fprintf(w, `<path d="M%.1f,%.1f C%.1f,%.1f %.1f,%.1f %.1f,%.1f" fill="none" stroke="#333" marker-end="url(#arrow)"/>`+"\n",
+				from.x+from.w, from.y+from.h/3, from.x+from.w+50, from.y+from.h/3-20, from.x+from.w+50, from.y+2*from.h/3+20, from.x+from.w, from.y+2*from.h/3)
+			writeLabel(w, from.x+from.w+45, from.y+from.h/2, edgeLabel(edge))
+			continue
+		}
+		tx, ty := to.center()
+		fx, fy := from.center()
+		x1, y1 := from.clip(tx, ty)
+		x2, y2 := to.clip(fx, fy)
+		switch edge.Kind {
+		case Component:
+			fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#333" marker-start="url(#diamond)"/>`+"\n", x1, y1, x2, y2)
+		case EnumUse:
+			fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#333" stroke-dasharray="2,3" marker-end="url(#arrow)"/>`+"\n", x1, y1, x2, y2)
+		default:
+			fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#333" marker-end="url(#arrow)"/>`+"\n", x1, y1, x2, y2)
+		}
+		if label := edgeLabel(edge); label != "" {
+			writeLabel(w, (x1+x2)/2, (y1+y2)/2, label)
+		}
+	}
+
+	for _, b := range boxes {
+		fill := "#e8f0fe"
+		if b.enum {
+			fill = "#fff4d6"
+		}
+		dash := ""
+		if b.missing {
+			fill, dash = "#f4f4f4", ` stroke-dasharray="4,3"`
+		}
+		fmt.Fprintf(w, `<g id="%s">`+"\n", html.EscapeString(b.name))
+		fmt.Fprintf(w, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" rx="3" fill="%s" stroke="#333"%s/>`+"\n", b.x, b.y, b.w, b.h, fill, dash)
+		y := b.y + padding
+		for i, line := range b.header {
+			weight := ""
+			if i == len(b.header)-1 {
+				weight = ` font-weight="bold"`
+			}
+			y += lineHeight
+			fmt.Fprintf(w, `<text x="%.1f" y="%.1f" text-anchor="middle"%s>%s</text>`+"\n", b.x+b.w/2, y-4, weight, html.EscapeString(line))
+		}
+		if len(b.lines) > 0 {
+			y += padding / 2
+			fmt.Fprintf(w, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#333"/>`+"\n", b.x, y, b.x+b.w, y)
+			y += padding / 2
+			for _, line := range b.lines {
+				y += lineHeight
+				fmt.Fprintf(w, `<text x="%.1f" y="%.1f">%s</text>`+"\n", b.x+padding, y-4, html.EscapeString(line))
+			}
+		}
+		fmt.Fprintln(w, "</g>")
+	}
+
+	_, err := fmt.Fprintln(w, "</svg>")
+	return err
+}
+
+func edgeLabel(edge Edge) string {
+	if edge.Kind == Relationship {
+		return edge.Label + " " + edge.Multiplicity
+	}
+	return edge.Label
+}
+
+// writeLabel writes an edge label haloed in white, so that it stays legible
+// over the edges it crosses.
+func writeLabel(w io.Writer, x, y float64, label string) {
+	fmt.Fprintf(w, `<text x="%.1f" y="%.1f" text-anchor="middle" font-size="%d" stroke="#fff" stroke-width="3" paint-order="stroke">%s</text>`+"\n",
+		x, y, fontSize-1, html.EscapeString(label))
+}
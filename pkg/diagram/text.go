@@ -0,0 +1,124 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diagram
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// WriteDOT writes the diagram in the Graphviz DOT language, with classes
+// and enums as HTML-like tables. Components are drawn with a diamond at the
+// embedding class, and enum uses dotted.
+func WriteDOT(w io.Writer, d *Diagram) error {
+	fmt.Fprintln(w, "digraph model {")
+	fmt.Fprintln(w, `  node [shape=plain, fontname="Helvetica", fontsize=11];`)
+	fmt.Fprintln(w, `  edge [fontname="Helvetica", fontsize=10];`)
+	for _, class := range d.Classes {
+		fmt.Fprintf(w, "  %q [label=<%s>];\n", class.Name, dotTable(class.Name, "", class.Attributes, class.Missing))
+	}
+	for _, enum := range d.Enums {
+		fmt.Fprintf(w, "  %q [label=<%s>];\n", enum.Name, dotTable(enum.Name, "«enumeration»", enum.Values, enum.Missing))
+	}
+	for _, edge := range d.Edges {
+		switch edge.Kind {
+		case Component:
+			fmt.Fprintf(w, "  %q -> %q [label=%q, dir=both, arrowtail=diamond, arrowhead=none];\n", edge.From, edge.To, edge.Label)
+		case EnumUse:
+			fmt.Fprintf(w, "  %q -> %q [style=dotted, arrowhead=open];\n", edge.From, edge.To)
+		default:
+			fmt.Fprintf(w, "  %q -> %q [label=%q, headlabel=%q, arrowhead=vee];\n", edge.From, edge.To, edge.Label, edge.Multiplicity)
+		}
+	}
+	fmt.Fprintln(w, "}")
+	return nil
+}
+
+func dotTable(name, stereotype string, lines []string, missing bool) string {
+	b := &strings.Builder{}
+	style := ""
+	if missing {
+		style = ` style="dashed"`
+		stereotype = "«missing»"
+	}
+	fmt.Fprintf(b, `<table border="1" cellborder="0" cellspacing="0" cellpadding="4"%s>`, style)
+	b.WriteString("<tr><td>")
+	if stereotype != "" {
+		b.WriteString(html.EscapeString(stereotype) + "<br/>")
+	}
+	b.WriteString("<b>" + html.EscapeString(name) + "</b></td></tr>")
+	if len(lines) > 0 {
+		b.WriteString(`<hr/><tr><td align="left" balign="left">`)
+		for i, line := range lines {
+			if i > 0 {
+				b.WriteString("<br/>")
+			}
+			b.WriteString(html.EscapeString(line))
+		}
+		b.WriteString("</td></tr>")
+	}
+	b.WriteString("</table>")
+	return b.String()
+}
+
+// WriteMermaid writes the diagram as a Mermaid class diagram. Mermaid has
+// no angle brackets or braces in members, so generic schemas are written
+// with ~ and object fields in parentheses.
+func WriteMermaid(w io.Writer, d *Diagram) error {
+	fmt.Fprintln(w, "classDiagram")
+	for _, class := range d.Classes {
+		fmt.Fprintf(w, "  class %s {\n", class.Name)
+		if class.Missing {
+			fmt.Fprintln(w, "    <<missing>>")
+		}
+		for _, attribute := range class.Attributes {
+			fmt.Fprintf(w, "    %s\n", mermaidText(attribute))
+		}
+		fmt.Fprintln(w, "  }")
+	}
+	for _, enum := range d.Enums {
+		fmt.Fprintf(w, "  class %s {\n", enum.Name)
+		if enum.Missing {
+			fmt.Fprintln(w, "    <<missing>>")
+		} else {
+			fmt.Fprintln(w, "    <<enumeration>>")
+		}
+		for _, value := range enum.Values {
+			fmt.Fprintf(w, "    %s\n", mermaidText(value))
+		}
+		fmt.Fprintln(w, "  }")
+	}
+	for _, edge := range d.Edges {
+		switch edge.Kind {
+		case Component:
+			fmt.Fprintf(w, "  %s *-- %s : %s\n", edge.From, edge.To, mermaidText(edge.Label))
+		case EnumUse:
+			fmt.Fprintf(w, "  %s ..> %s\n", edge.From, edge.To)
+		default:
+			fmt.Fprintf(w, "  %s --> \"%s\" %s : %s\n", edge.From, edge.Multiplicity, edge.To, mermaidText(edge.Label))
+		}
+	}
+	return nil
+}
+
+var mermaidReplacer = strings.NewReplacer("<", "~", ">", "~", "{", "(", "}", ")")
+
+func mermaidText(text string) string {
+	return mermaidReplacer.Replace(text)
+}
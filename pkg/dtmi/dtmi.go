@@ -0,0 +1,93 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dtmi parses Digital Twin Model Identifiers and the version
+// constraints twin services use to select a class version.
+//
+// A DTMI is a colon-separated path followed by a version, such as
+// dtmi:acme:Factory;2. The last path segment is the name of the class.
+package dtmi
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Scheme prefixes every DTMI.
+const Scheme = "dtmi:"
+
+// MaxVersion is the greatest version a DTMI can carry.
+const MaxVersion = 999999999
+
+// Pattern matches a DTMI with a version. It is kept in sync with the
+// validation pattern of TwinClassSpec.ID.
+const Pattern = `^dtmi:[A-Za-z](?:[A-Za-z0-9_]*[A-Za-z0-9])?(?::[A-Za-z](?:[A-Za-z0-9_]*[A-Za-z0-9])?)*;[1-9][0-9]{0,8}$`
+
+var segmentPattern = regexp.MustCompile(`^[A-Za-z](?:[A-Za-z0-9_]*[A-Za-z0-9])?$`)
+
+// DTMI is a parsed model identifier.
+type DTMI struct {
+	// Path is the identifier without its version, such as dtmi:acme:Factory.
+	Path    string
+	Version int
+}
+
+// Parse parses a DTMI, which must carry a version.
+func Parse(id string) (DTMI, error) {
+	path, version, ok := strings.Cut(id, ";")
+	if !ok {
+		return DTMI{}, fmt.Errorf("%q has no version", id)
+	}
+	if err := validatePath(path); err != nil {
+		return DTMI{}, err
+	}
+	v, err := parseVersion(version)
+	if err != nil {
+		return DTMI{}, fmt.Errorf("%q: %w", id, err)
+	}
+	return DTMI{Path: path, Version: v}, nil
+}
+
+// Name returns the last segment of the path.
+func (d DTMI) Name() string {
+	return d.Path[strings.LastIndex(d.Path, ":")+1:]
+}
+
+func (d DTMI) String() string {
+	return d.Path + ";" + strconv.Itoa(d.Version)
+}
+
+func validatePath(path string) error {
+	if !strings.HasPrefix(path, Scheme) {
+		return fmt.Errorf("%q does not start with %q", path, Scheme)
+	}
+	for _, segment := range strings.Split(strings.TrimPrefix(path, Scheme), ":") {
+		if !segmentPattern.MatchString(segment) {
+			return fmt.Errorf("%q: invalid segment %q", path, segment)
+		}
+	}
+	return nil
+}
+
+func parseVersion(version string) (int, error) {
+	v, err := strconv.Atoi(version)
+	if err != nil || v < 1 || v > MaxVersion || strings.HasPrefix(version, "0") || strings.HasPrefix(version, "+") {
+		return 0, fmt.Errorf("invalid version %q", version)
+	}
+	return v, nil
+}
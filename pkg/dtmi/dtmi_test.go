@@ -0,0 +1,82 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dtmi
+
+import (
+	"regexp"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parse", func() {
+	It("splits the path and the version", func() {
+		id, err := Parse("dtmi:acme:plant:Factory;12")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(id.Path).To(Equal("dtmi:acme:plant:Factory"))
+		Expect(id.Version).To(Equal(12))
+		Expect(id.Name()).To(Equal("Factory"))
+		Expect(id.String()).To(Equal("dtmi:acme:plant:Factory;12"))
+	})
+
+	DescribeTable("rejects invalid identifiers",
+		func(id, expected string) {
+			_, err := Parse(id)
+			Expect(err).To(MatchError(ContainSubstring(expected)))
+			Expect(regexp.MustCompile(Pattern).MatchString(id)).To(BeFalse())
+		},
+		Entry("no version", "dtmi:acme:Factory", "has no version"),
+		Entry("no scheme", "acme:Factory;1", `does not start with "dtmi:"`),
+		Entry("bad segment", "dtmi:acme:_Factory;1", `invalid segment "_Factory"`),
+		Entry("trailing underscore", "dtmi:acme:Factory_;1", `invalid segment "Factory_"`),
+		Entry("zero version", "dtmi:acme:Factory;0", `invalid version "0"`),
+		Entry("leading zero", "dtmi:acme:Factory;01", `invalid version "01"`),
+		Entry("version too large", "dtmi:acme:Factory;1000000000", `invalid version "1000000000"`),
+	)
+})
+
+var _ = Describe("ParseReference", func() {
+	It("selects a class by name", func() {
+		ref, err := ParseReference("Factory")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ref).To(Equal(Reference{Name: "Factory"}))
+		Expect(ref.Matches(7)).To(BeTrue())
+	})
+
+	It("pins a version", func() {
+		ref, err := ParseReference("dtmi:acme:Factory;2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ref.Path).To(Equal("dtmi:acme:Factory"))
+		Expect(ref.Name).To(Equal("Factory"))
+		Expect(ref.Matches(2)).To(BeTrue())
+		Expect(ref.Matches(3)).To(BeFalse())
+	})
+
+	It("accepts version ranges", func() {
+		ref, err := ParseReference("Factory;>=2, <4")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ref.Constraints).To(Equal([]Constraint{{Operator: ">=", Version: 2}, {Operator: "<", Version: 4}}))
+		Expect(ref.Matches(1)).To(BeFalse())
+		Expect(ref.Matches(3)).To(BeTrue())
+		Expect(ref.Matches(4)).To(BeFalse())
+	})
+
+	It("rejects invalid constraints", func() {
+		_, err := ParseReference("Factory;~2")
+		Expect(err).To(MatchError(`"Factory;~2": invalid version "~2"`))
+	})
+})
@@ -0,0 +1,113 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dtmi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Reference selects a class version. It is written as a class name or a
+// DTMI path, optionally followed by a semicolon and a version constraint:
+//
+//	Factory                   the latest version of the class named Factory
+//	dtmi:acme:Factory         the latest version of dtmi:acme:Factory
+//	dtmi:acme:Factory;2       exactly version 2
+//	dtmi:acme:Factory;>=2,<4  the latest version from 2 up to 3
+type Reference struct {
+	// Path is the DTMI path, or empty when the class is selected by name.
+	Path        string
+	Name        string
+	Constraints []Constraint
+}
+
+// Constraint compares a version against a bound.
+type Constraint struct {
+	Operator string
+	Version  int
+}
+
+var operators = []string{">=", "<=", ">", "<", "="}
+
+// ParseReference parses a class reference.
+func ParseReference(reference string) (Reference, error) {
+	target, constraints, versioned := strings.Cut(reference, ";")
+
+	ref := Reference{Name: target}
+	if strings.HasPrefix(target, Scheme) {
+		if err := validatePath(target); err != nil {
+			return Reference{}, err
+		}
+		ref.Path = target
+		ref.Name = DTMI{Path: target}.Name()
+	} else if !segmentPattern.MatchString(target) {
+		return Reference{}, fmt.Errorf("%q is neither a class name nor a DTMI", target)
+	}
+
+	if !versioned {
+		return ref, nil
+	}
+	for _, term := range strings.Split(constraints, ",") {
+		constraint, err := parseConstraint(strings.TrimSpace(term))
+		if err != nil {
+			return Reference{}, fmt.Errorf("%q: %w", reference, err)
+		}
+		ref.Constraints = append(ref.Constraints, constraint)
+	}
+	return ref, nil
+}
+
+func parseConstraint(term string) (Constraint, error) {
+	operator := "="
+	for _, op := range operators {
+		if strings.HasPrefix(term, op) {
+			operator = op
+			break
+		}
+	}
+	version, err := parseVersion(strings.TrimSpace(strings.TrimPrefix(term, operator)))
+	if err != nil {
+		return Constraint{}, err
+	}
+	return Constraint{Operator: operator, Version: version}, nil
+}
+
+// Matches reports whether version satisfies every constraint.
+func (r Reference) Matches(version int) bool {
+	for _, c := range r.Constraints {
+		if !c.Matches(version) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether version satisfies the constraint.
+func (c Constraint) Matches(version int) bool {
+	switch c.Operator {
+	case ">=":
+		return version >= c.Version
+	case "<=":
+		return version <= c.Version
+	case ">":
+		return version > c.Version
+	case "<":
+		return version < c.Version
+	default:
+		return version == c.Version
+	}
+}
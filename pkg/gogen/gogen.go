@@ -0,0 +1,258 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gogen generates typed Go structs and enums from a twin model.
+package gogen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/naming"
+)
+
+const header = "// Code generated by dtgen. DO NOT EDIT.\n\n"
+
+const enumsFileName = "enums.go"
+
+// Options configures the generated package.
+type Options struct {
+	// Package is the name of the generated Go package.
+	Package string
+}
+
+// Generate renders one file per class and an enums.go file holding every
+// enum of the model. The result is keyed by file name.
+func Generate(m *model.Model, opts Options) (map[string][]byte, error) {
+	files := map[string][]byte{}
+
+	for _, class := range m.Classes() {
+		resolved, err := m.Resolve(class.Spec.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		name := naming.SnakeCase(resolved.Name) + ".go"
+		if name == enumsFileName {
+			name = "class_" + name
+		}
+
+		source, err := render(opts.Package, func(b *bytes.Buffer) error {
+			return writeClass(b, resolved)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("twin class %q: %w", resolved.Name, err)
+		}
+		files[name] = source
+	}
+
+	if enums := m.Enums(); len(enums) > 0 {
+		source, err := render(opts.Package, func(b *bytes.Buffer) error {
+			b.WriteString("import \"fmt\"\n")
+			for _, enum := range enums {
+				writeEnum(b, enum)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		files[enumsFileName] = source
+	}
+
+	return files, nil
+}
+
+func render(pkg string, body func(b *bytes.Buffer) error) ([]byte, error) {
+	b := &bytes.Buffer{}
+	b.WriteString(header)
+	fmt.Fprintf(b, "package %s\n\n", pkg)
+
+	if err := body(b); err != nil {
+		return nil, err
+	}
+
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w", err)
+	}
+	return source, nil
+}
+
+func writeClass(b *bytes.Buffer, class *model.ResolvedClass) error {
+	typeName := naming.GoName(class.Name)
+	fields := fieldSet{}
+	g := &classGenerator{}
+
+	fmt.Fprintf(b, "// %s is generated from the %s twin class.\n", typeName, class.Name)
+	fmt.Fprintf(b, "type %s struct {\n", typeName)
+
+	for i := range class.Attributes {
+		attribute := &class.Attributes[i]
+		fieldName := naming.GoName(attribute.Name)
+		if err := fields.add(fieldName, "attribute "+attribute.Name); err != nil {
+			return err
+		}
+		if attribute.Unit != "" {
+			fmt.Fprintf(b, "// %s is expressed in %s.\n", fieldName, attribute.Unit)
+		}
+		fmt.Fprintf(b, "%s %s `json:%q`\n", fieldName, g.goType(&attribute.ResolvedSchema, typeName+fieldName), attribute.Name)
+	}
+
+	if len(class.Relationships) > 0 && len(class.Attributes) > 0 {
+		b.WriteString("\n")
+	}
+
+	for _, relationship := range class.Relationships {
+		fieldName := naming.GoName(relationship.Name)
+		if err := fields.add(fieldName, "relationship "+relationship.Name); err != nil {
+			return err
+		}
+
+		targets := strings.Join(relationship.Targets, " or ")
+		if targets != "" {
+			targets += " "
+		}
+		held, heldMany := "the ID of", "the IDs of"
+		if len(relationship.Properties) > 0 {
+			held, heldMany = "the edge to", "the edges to"
+		}
+		comment := fmt.Sprintf("%s holds %s the related %stwin.", fieldName, held, targets)
+		if relationship.Many() {
+			comment = fmt.Sprintf("%s holds %s the related %stwins.", fieldName, heldMany, targets)
+		}
+		fmt.Fprintf(b, "// %s\n", comment)
+		fmt.Fprintf(b, "%s %s `json:\"%s,omitempty\"`\n", fieldName, g.goType(relationship.Schema(), typeName+fieldName), relationship.Name)
+	}
+
+	b.WriteString("}\n")
+
+	return g.writeObjects(b, class.Name)
+}
+
+type fieldSet map[string]string
+
+func (f fieldSet) add(name, source string) error {
+	if previous, ok := f[name]; ok {
+		return fmt.Errorf("%s and %s both map to field %s", previous, source, name)
+	}
+	f[name] = source
+	return nil
+}
+
+// classGenerator collects the struct types generated for the objects nested
+// in a class. They are named after the type and field holding them.
+type classGenerator struct {
+	objects []object
+}
+
+type object struct {
+	typeName string
+	schema   *model.ResolvedSchema
+}
+
+func (g *classGenerator) goType(s *model.ResolvedSchema, hint string) string {
+	switch s.Type {
+	case dtdlv0.Integer:
+		return "int64"
+	case dtdlv0.Boolean:
+		return "bool"
+	case dtdlv0.Double:
+		return "float64"
+	case dtdlv0.Enumeration:
+		return naming.GoName(s.Enum.Spec.Name)
+	case dtdlv0.Array:
+		return "[]" + g.goType(s.Items, hint+"Item")
+	case dtdlv0.Map:
+		return "map[string]" + g.goType(s.Values, hint+"Value")
+	case dtdlv0.Object:
+		g.objects = append(g.objects, object{typeName: hint, schema: s})
+		return hint
+	default:
+		return "string"
+	}
+}
+
+// writeObjects writes the struct types of nested objects, including those
+// discovered while writing them.
+func (g *classGenerator) writeObjects(b *bytes.Buffer, className string) error {
+	for i := 0; i < len(g.objects); i++ {
+		o := g.objects[i]
+		fields := fieldSet{}
+
+		fmt.Fprintf(b, "\n// %s is generated from an object nested in the %s twin class.\n", o.typeName, className)
+		fmt.Fprintf(b, "type %s struct {\n", o.typeName)
+		for j := range o.schema.Fields {
+			f := &o.schema.Fields[j]
+			fieldName := naming.GoName(f.Name)
+			if err := fields.add(fieldName, "field "+f.Name); err != nil {
+				return err
+			}
+			fmt.Fprintf(b, "%s %s `json:%q`\n", fieldName, g.goType(&f.ResolvedSchema, o.typeName+fieldName), f.Name)
+		}
+		b.WriteString("}\n")
+	}
+	return nil
+}
+
+func writeEnum(b *bytes.Buffer, enum *dtdlv0.TwinEnum) {
+	typeName := naming.GoName(enum.Spec.Name)
+
+	constants := make([]string, len(enum.Spec.Values))
+	for i, value := range enum.Spec.Values {
+		constants[i] = typeName + naming.GoName(value)
+	}
+
+	fmt.Fprintf(b, "\n// %s is generated from the %s twin enum.\n", typeName, enum.Spec.Name)
+	fmt.Fprintf(b, "type %s string\n\n", typeName)
+
+	b.WriteString("const (\n")
+	for i, value := range enum.Spec.Values {
+		fmt.Fprintf(b, "%s %s = %q\n", constants[i], typeName, value)
+	}
+	b.WriteString(")\n\n")
+
+	fmt.Fprintf(b, "// %sValues returns every value of %s.\n", typeName, typeName)
+	fmt.Fprintf(b, "func %sValues() []%s {\n", typeName, typeName)
+	fmt.Fprintf(b, "return []%s{\n", typeName)
+	for _, constant := range constants {
+		fmt.Fprintf(b, "%s,\n", constant)
+	}
+	b.WriteString("}\n}\n\n")
+
+	fmt.Fprintf(b, "// Parse%s converts s into a %s, failing on unknown values.\n", typeName, typeName)
+	fmt.Fprintf(b, "func Parse%s(s string) (%s, error) {\n", typeName, typeName)
+	fmt.Fprintf(b, "v := %s(s)\n", typeName)
+	b.WriteString("if err := v.Validate(); err != nil {\nreturn \"\", err\n}\nreturn v, nil\n}\n\n")
+
+	fmt.Fprintf(b, "// Validate reports whether v is one of the declared %s values.\n", typeName)
+	fmt.Fprintf(b, "func (v %s) Validate() error {\n", typeName)
+	if len(constants) > 0 {
+		b.WriteString("switch v {\ncase ")
+		for i, constant := range constants {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(constant)
+		}
+		b.WriteString(":\nreturn nil\n}\n")
+	}
+	fmt.Fprintf(b, "return fmt.Errorf(\"invalid %s value %%q\", string(v))\n}\n", typeName)
+}
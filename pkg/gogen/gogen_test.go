@@ -0,0 +1,88 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gogen
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+// Run with -update to rewrite the golden files after an intended change.
+var update = flag.Bool("update", false, "update golden files")
+
+var _ = Describe("Generate", func() {
+	DescribeTable("matches the golden files",
+		func(name string) {
+			manifests, err := model.LoadFiles(filepath.Join("testdata", name))
+			Expect(err).NotTo(HaveOccurred())
+
+			m, err := manifests.Model()
+			Expect(err).NotTo(HaveOccurred())
+
+			files, err := Generate(m, Options{Package: "twins"})
+			Expect(err).NotTo(HaveOccurred())
+
+			goldenDir := filepath.Join("testdata", "golden", name)
+			if *update {
+				Expect(os.RemoveAll(goldenDir)).To(Succeed())
+				Expect(os.MkdirAll(goldenDir, 0o755)).To(Succeed())
+				for file, content := range files {
+					Expect(os.WriteFile(filepath.Join(goldenDir, file+".golden"), content, 0o644)).To(Succeed())
+				}
+			}
+
+			golden, err := filepath.Glob(filepath.Join(goldenDir, "*.golden"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(golden).To(HaveLen(len(files)))
+
+			for file, content := range files {
+				expected, err := os.ReadFile(filepath.Join(goldenDir, file+".golden"))
+				Expect(err).NotTo(HaveOccurred(), "missing golden file for %s", file)
+				Expect(string(content)).To(Equal(string(expected)), "generated %s differs from golden file", file)
+			}
+		},
+		Entry("factory model", "factory"),
+	)
+
+	It("rejects attributes referencing unknown enums", func() {
+		manifests, err := model.LoadFiles(filepath.Join("testdata", "factory"))
+		Expect(err).NotTo(HaveOccurred())
+		manifests.Enums = manifests.Enums[:1]
+
+		m, err := manifests.Model()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = Generate(m, Options{Package: "twins"})
+		Expect(err).To(MatchError(ContainSubstring(`spec.attributes[3].reference: Not found: "MachineState": unknown twin enum`)))
+	})
+
+	It("rejects names mapping to the same field", func() {
+		manifests, err := model.LoadFiles(filepath.Join("testdata", "factory"))
+		Expect(err).NotTo(HaveOccurred())
+		manifests.Classes[0].Spec.Attributes[0].Name = "Machines"
+
+		m, err := manifests.Model()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = Generate(m, Options{Package: "twins"})
+		Expect(err).To(MatchError(ContainSubstring("both map to field Machines")))
+	})
+})
@@ -0,0 +1,82 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/agwermann/dt-operator/pkg/access"
+	"github.com/agwermann/dt-operator/pkg/diagram"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+var diagramWriters = map[string]struct {
+	contentType string
+	write       func(io.Writer, *diagram.Diagram) error
+}{
+	"svg":     {"image/svg+xml", diagram.WriteSVG},
+	"dot":     {"text/vnd.graphviz; charset=utf-8", diagram.WriteDOT},
+	"mermaid": {"text/plain; charset=utf-8", diagram.WriteMermaid},
+}
+
+// serveDiagram draws the TwinClasses of the namespace, read from the cache
+// on every request so that the diagram is always current. The format
+// parameter picks svg, the default, dot or mermaid, and root limits the
+// diagram to the classes a class leads to. Callers must be allowed to list
+// the TwinClasses of the namespace.
+func (s *Server) serveDiagram(w http.ResponseWriter, r *http.Request, namespace string) {
+	status, err := access.Authorize(r, s.Clientset, namespace, "twinclasses")
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	params := r.URL.Query()
+	format := params.Get("format")
+	if format == "" {
+		format = "svg"
+	}
+	writer, ok := diagramWriters[format]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "format must be one of "+strings.Join(diagram.Formats, ", "))
+		return
+	}
+
+	m, err := model.Load(r.Context(), s.Reader, client.InNamespace(namespace))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	d, err := diagram.Build(m, params.Get("root"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	body := &bytes.Buffer{}
+	if err := writer.write(body, d); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", writer.contentType)
+	_, _ = w.Write(body.Bytes())
+}
@@ -0,0 +1,359 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package graph answers queries over the graph of twin instances, whose
+// nodes are TwinInstances and whose edges are their relationships.
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/instance"
+)
+
+// Query is a path through the twin graph. The first step selects twins by
+// class, each further step follows a relationship forwards, from the twins
+// holding the edges to their targets, or backwards, and every step may
+// filter the twins it reaches. It is written as
+//
+//	Factory[location=Berlin]->machines[state!=idle]<-feeds
+//
+// where * selects twins of any class, ->name follows the edges of the
+// relationship name and <-name follows them backwards.
+type Query struct {
+	Steps []Step
+}
+
+// Step is a step of a query.
+type Step struct {
+	// Class selects the twins of the first step; * selects all of them.
+	Class string
+	// Relationship is the relationship followed by the other steps.
+	Relationship string
+	// Reverse follows the relationship from targets to sources.
+	Reverse bool
+	// Filters must all hold for the twins reached by the step.
+	Filters []Filter
+}
+
+// Filter compares an attribute of a twin with a value. Path selects the
+// attribute and, for objects and maps, the nested value. The pseudo
+// attributes @name and @class hold the name and the class of the twin.
+type Filter struct {
+	Path     []string
+	Operator string
+	Value    string
+}
+
+// operators are matched in order, so longer operators come first.
+var operators = []string{"!=", ">=", "<=", "=", ">", "<"}
+
+// ParseQuery parses a query. An empty query selects all twins.
+func ParseQuery(query string) (*Query, error) {
+	q := &Query{}
+	rest := strings.TrimSpace(query)
+	if rest == "" {
+		return &Query{Steps: []Step{{Class: "*"}}}, nil
+	}
+
+	first := true
+	for rest != "" {
+		step := Step{}
+		if first {
+			first = false
+		} else {
+			switch {
+			case strings.HasPrefix(rest, "->"):
+			case strings.HasPrefix(rest, "<-"):
+				step.Reverse = true
+			default:
+				return nil, fmt.Errorf("expected -> or <- before %q", rest)
+			}
+			rest = rest[2:]
+		}
+
+		end := strings.Index(rest, "[")
+		if next := nextArrow(rest); next >= 0 && (end < 0 || next < end) {
+			end = next
+		}
+		if end < 0 {
+			end = len(rest)
+		}
+		name := strings.TrimSpace(rest[:end])
+		if name == "" {
+			return nil, fmt.Errorf("missing class or relationship name before %q", rest[end:])
+		}
+		if len(q.Steps) == 0 {
+			step.Class = name
+		} else {
+			step.Relationship = name
+		}
+		rest = rest[end:]
+
+		if strings.HasPrefix(rest, "[") {
+			closing := closingBracket(rest)
+			if closing < 0 {
+				return nil, fmt.Errorf("unterminated filter %q", rest)
+			}
+			filters, err := parseFilters(rest[1:closing])
+			if err != nil {
+				return nil, err
+			}
+			step.Filters = filters
+			rest = strings.TrimSpace(rest[closing+1:])
+		}
+		q.Steps = append(q.Steps, step)
+	}
+	return q, nil
+}
+
+func nextArrow(s string) int {
+	forward, backward := strings.Index(s, "->"), strings.Index(s, "<-")
+	if forward < 0 || (backward >= 0 && backward < forward) {
+		return backward
+	}
+	return forward
+}
+
+// closingBracket returns the index of the bracket closing the filter list
+// s starts with, skipping quoted values.
+func closingBracket(s string) int {
+	quoted := false
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == ']' && !quoted:
+			return i
+		}
+	}
+	return -1
+}
+
+func parseFilters(s string) ([]Filter, error) {
+	filters := []Filter{}
+	for _, term := range splitTerms(s) {
+		filter, err := parseFilter(strings.TrimSpace(term))
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// splitTerms splits a filter list on the commas outside quoted values.
+func splitTerms(s string) []string {
+	terms := []string{}
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == ',' && !quoted:
+			terms = append(terms, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(terms, s[start:])
+}
+
+func parseFilter(term string) (Filter, error) {
+	for i := 0; i < len(term); i++ {
+		for _, op := range operators {
+			if !strings.HasPrefix(term[i:], op) {
+				continue
+			}
+			path := strings.TrimSpace(term[:i])
+			if path == "" {
+				return Filter{}, fmt.Errorf("filter %q: missing attribute", term)
+			}
+			value := strings.TrimSpace(term[i+len(op):])
+			if strings.HasPrefix(value, `"`) {
+				unquoted, err := strconv.Unquote(value)
+				if err != nil {
+					return Filter{}, fmt.Errorf("filter %q: %w", term, err)
+				}
+				value = unquoted
+			}
+			return Filter{Path: strings.Split(path, "."), Operator: op, Value: value}, nil
+		}
+	}
+	return Filter{}, fmt.Errorf("filter %q: expected one of %s", term, strings.Join(operators, " "))
+}
+
+// Evaluate runs the query over the twins of a namespace and returns the
+// twins reached by its last step, sorted by name.
+func (q *Query) Evaluate(instances []dtdlv1.TwinInstance) []*dtdlv1.TwinInstance {
+	byName := map[string]*dtdlv1.TwinInstance{}
+	for i := range instances {
+		byName[instances[i].Name] = &instances[i]
+	}
+
+	current := map[string]*dtdlv1.TwinInstance{}
+	for i, step := range q.Steps {
+		reached := map[string]*dtdlv1.TwinInstance{}
+		switch {
+		case i == 0:
+			for name, twin := range byName {
+				if step.Class == "*" || instance.ClassName(twin) == step.Class {
+					reached[name] = twin
+				}
+			}
+		case step.Reverse:
+			for name, twin := range byName {
+				for _, target := range targets(twin, step.Relationship) {
+					if _, ok := current[target]; ok {
+						reached[name] = twin
+					}
+				}
+			}
+		default:
+			for _, twin := range current {
+				for _, target := range targets(twin, step.Relationship) {
+					if related, ok := byName[target]; ok {
+						reached[target] = related
+					}
+				}
+			}
+		}
+
+		current = map[string]*dtdlv1.TwinInstance{}
+		for name, twin := range reached {
+			if matches(twin, step.Filters) {
+				current[name] = twin
+			}
+		}
+	}
+
+	result := make([]*dtdlv1.TwinInstance, 0, len(current))
+	for _, twin := range current {
+		result = append(result, twin)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func targets(twin *dtdlv1.TwinInstance, relationship string) []string {
+	names := []string{}
+	for _, rel := range twin.Spec.Relationships {
+		if rel.Name == relationship {
+			for _, edge := range rel.Targets {
+				names = append(names, edge.Name)
+			}
+		}
+	}
+	return names
+}
+
+func matches(twin *dtdlv1.TwinInstance, filters []Filter) bool {
+	for _, filter := range filters {
+		value, ok := lookup(twin, filter.Path)
+		if !ok || !compare(value, filter.Operator, filter.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup returns the decoded value at path, or false when the twin has no
+// such value.
+func lookup(twin *dtdlv1.TwinInstance, path []string) (interface{}, bool) {
+	switch path[0] {
+	case "@name":
+		return twin.Name, len(path) == 1
+	case "@class":
+		return instance.ClassName(twin), len(path) == 1
+	}
+
+	raw, ok := twin.Spec.Attributes[path[0]]
+	if !ok {
+		return nil, false
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw.Raw, &value); err != nil {
+		return nil, false
+	}
+	for _, key := range path[1:] {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// compare applies a filter operator. Numbers compare numerically, strings
+// lexically and booleans only for equality; values of other types never
+// match.
+func compare(value interface{}, operator, operand string) bool {
+	var order int
+	switch v := value.(type) {
+	case string:
+		order = strings.Compare(v, operand)
+	case float64:
+		number, err := strconv.ParseFloat(operand, 64)
+		if err != nil {
+			return false
+		}
+		switch {
+		case v < number:
+			order = -1
+		case v > number:
+			order = 1
+		}
+	case bool:
+		b, err := strconv.ParseBool(operand)
+		if err != nil || (operator != "=" && operator != "!=") {
+			return false
+		}
+		if v != b {
+			order = 1
+		}
+	default:
+		return false
+	}
+
+	switch operator {
+	case "=":
+		return order == 0
+	case "!=":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	default:
+		return order >= 0
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+func twin(name, class string, attributes map[string]string, relationships map[string][]string) dtdlv1.TwinInstance {
+	t := dtdlv1.TwinInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: dtdlv1.TwinInstanceSpec{
+			Class:      dtdlv1.TwinClassReference{Name: class},
+			Attributes: map[string]apiextensionsv1.JSON{},
+		},
+	}
+	for key, value := range attributes {
+		t.Spec.Attributes[key] = apiextensionsv1.JSON{Raw: []byte(value)}
+	}
+	for relationship, targets := range relationships {
+		r := dtdlv1.TwinInstanceRelationship{Name: relationship}
+		for _, target := range targets {
+			r.Targets = append(r.Targets, dtdlv1.TwinInstanceEdge{Name: target})
+		}
+		t.Spec.Relationships = append(t.Spec.Relationships, r)
+	}
+	return t
+}
+
+func names(twins []*dtdlv1.TwinInstance) []string {
+	result := []string{}
+	for _, t := range twins {
+		result = append(result, t.Name)
+	}
+	return result
+}
+
+func factoryGraph() []dtdlv1.TwinInstance {
+	return []dtdlv1.TwinInstance{
+		twin("berlin", "Factory", map[string]string{"location": `"Berlin"`, "address": `{"zip": 10115}`},
+			map[string][]string{"machines": {"m1", "m2"}}),
+		twin("porto", "Factory", map[string]string{"location": `"Porto Alegre"`},
+			map[string][]string{"machines": {"m3"}}),
+		twin("m1", "Machine", map[string]string{"state": `"idle"`, "running": "false"}, nil),
+		twin("m2", "Machine", map[string]string{"state": `"busy"`, "running": "true"}, nil),
+		twin("m3", "Machine", map[string]string{"state": `"busy"`, "running": "true"}, nil),
+		twin("f1", "Feeder", nil, map[string][]string{"feeds": {"m2", "m3"}}),
+	}
+}
+
+var _ = Describe("ParseQuery", func() {
+	It("parses steps and filters", func() {
+		q, err := ParseQuery(`Factory[location="Berlin, DE"]->machines[state!=idle, speed>=2]<-feeds`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(q.Steps).To(Equal([]Step{
+			{Class: "Factory", Filters: []Filter{{Path: []string{"location"}, Operator: "=", Value: "Berlin, DE"}}},
+			{Relationship: "machines", Filters: []Filter{
+				{Path: []string{"state"}, Operator: "!=", Value: "idle"},
+				{Path: []string{"speed"}, Operator: ">=", Value: "2"},
+			}},
+			{Relationship: "feeds", Reverse: true},
+		}))
+	})
+
+	It("selects all twins when empty", func() {
+		q, err := ParseQuery("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(q.Steps).To(Equal([]Step{{Class: "*"}}))
+	})
+
+	It("rejects malformed queries", func() {
+		for _, query := range []string{"Factory[location=Berlin", "Factory->", "Factory[=Berlin]", "Factory[location]"} {
+			_, err := ParseQuery(query)
+			Expect(err).To(HaveOccurred(), query)
+		}
+	})
+})
+
+var _ = Describe("Evaluate", func() {
+	evaluate := func(query string) []string {
+		q, err := ParseQuery(query)
+		Expect(err).NotTo(HaveOccurred())
+		return names(q.Evaluate(factoryGraph()))
+	}
+
+	It("selects twins by class", func() {
+		Expect(evaluate("Machine")).To(Equal([]string{"m1", "m2", "m3"}))
+		Expect(evaluate("*[@name<m2]")).To(Equal([]string{"berlin", "f1", "m1"}))
+	})
+
+	It("follows relationships forwards", func() {
+		Expect(evaluate("Factory[location=Berlin]->machines")).To(Equal([]string{"m1", "m2"}))
+		Expect(evaluate("Factory[location=Berlin]->machines[running=true]")).To(Equal([]string{"m2"}))
+	})
+
+	It("follows relationships backwards", func() {
+		Expect(evaluate("Machine[state=busy]<-machines")).To(Equal([]string{"berlin", "porto"}))
+		Expect(evaluate("Factory[location=Berlin]->machines<-feeds")).To(Equal([]string{"f1"}))
+	})
+
+	It("filters on nested values and numbers", func() {
+		Expect(evaluate("Factory[address.zip>10000]")).To(Equal([]string{"berlin"}))
+		Expect(evaluate("Factory[address.zip>20000]")).To(BeEmpty())
+	})
+})
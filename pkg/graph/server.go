@@ -0,0 +1,193 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/access"
+)
+
+// Path prefixes the URL of the twins of a namespace, which is
+// Path + "<namespace>/twins".
+const Path = "/apis/graph.dtdl.digitaltwin/v1/namespaces/"
+
+const (
+	// DefaultLimit is the page size of requests without a limit.
+	DefaultLimit = 100
+	// MaxLimit caps the page size.
+	MaxLimit = 1000
+)
+
+// Server serves graph queries over HTTPS. Twins are read from Reader, which
+// is the informer cache of the manager, and callers must present a bearer
+// token allowed to list the TwinInstances of the namespace they query.
+type Server struct {
+	// Addr is the address the server binds to.
+	Addr string
+	// CertDir holds the tls.crt and tls.key the server is served with, which
+	// are reloaded when they change.
+	CertDir string
+	// Reader lists the TwinInstances.
+	Reader client.Reader
+	// Clientset reviews the tokens and the access of the callers.
+	Clientset kubernetes.Interface
+}
+
+// List is the response to a query.
+type List struct {
+	Items []dtdlv1.TwinInstance `json:"items"`
+	// Continue, when set, is passed back to fetch the next page.
+	Continue string `json:"continue,omitempty"`
+}
+
+// Start serves queries until the context is done, making the server a
+// Runnable of the manager.
+func (s *Server) Start(ctx context.Context) error {
+	watcher, err := certwatcher.New(filepath.Join(s.CertDir, "tls.crt"), filepath.Join(s.CertDir, "tls.key"))
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := watcher.Start(ctx); err != nil {
+			log.FromContext(ctx).Error(err, "Error while watching the graph server certificate")
+		}
+	}()
+
+	server := &http.Server{
+		Addr:              s.Addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+		TLSConfig:         &tls.Config{GetCertificate: watcher.GetCertificate, MinVersion: tls.VersionTLS12},
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdown)
+	}()
+	log.FromContext(ctx).Info("serving twin graph queries", "address", s.Addr)
+	if err := server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// NeedLeaderElection is false, since every replica can answer queries from
+// its own cache.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// ServeHTTP answers GET <Path><namespace>/twins with the twins matching the
+// query parameter, a page of at most limit twins at a time, and GET
+// <Path><namespace>/diagram with the class diagram of the namespace.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, Path), "/")
+	if !strings.HasPrefix(r.URL.Path, Path) || len(parts) != 2 || parts[0] == "" || (parts[1] != "twins" && parts[1] != "diagram") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+	namespace := parts[0]
+
+	if parts[1] == "diagram" {
+		s.serveDiagram(w, r, namespace)
+		return
+	}
+
+	status, err := access.Authorize(r, s.Clientset, namespace, "twininstances")
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	params := r.URL.Query()
+	query, err := ParseQuery(params.Get("query"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := DefaultLimit
+	if value := params.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		if limit > MaxLimit {
+			limit = MaxLimit
+		}
+	}
+	after := ""
+	if value := params.Get("continue"); value != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid continue token")
+			return
+		}
+		after = string(decoded)
+	}
+
+	instances := &dtdlv1.TwinInstanceList{}
+	if err := s.Reader.List(r.Context(), instances, client.InNamespace(namespace)); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	twins := query.Evaluate(instances.Items)
+
+	start := sort.Search(len(twins), func(i int) bool {
+		return twins[i].Name > after
+	})
+	list := List{Items: []dtdlv1.TwinInstance{}}
+	for _, twin := range twins[start:] {
+		if len(list.Items) == limit {
+			list.Continue = base64.RawURLEncoding.EncodeToString([]byte(list.Items[limit-1].Name))
+			break
+		}
+		list.Items = append(list.Items, *twin)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+var _ = Describe("Server", func() {
+	var server *Server
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(dtdlv0.AddToScheme(scheme)).To(Succeed())
+		Expect(dtdlv1.AddToScheme(scheme)).To(Succeed())
+		builder := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&dtdlv0.TwinClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "factory", Namespace: "default"},
+				Spec: dtdlv0.TwinClassSpec{
+					Name:          "Factory",
+					Relationships: []dtdlv0.TwinRelationship{{Name: "machines", Targets: []string{"Machine"}}},
+				},
+			},
+			&dtdlv0.TwinClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: "default"},
+				Spec:       dtdlv0.TwinClassSpec{Name: "Machine"},
+			},
+		)
+		for _, t := range factoryGraph() {
+			t := t
+			builder = builder.WithObjects(&t)
+		}
+
+		clientset := kubefake.NewSimpleClientset()
+		clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+			review.Status.Authenticated = review.Spec.Token != "invalid"
+			review.Status.User.Username = review.Spec.Token
+			return true, review, nil
+		})
+		clientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+			resource := review.Spec.ResourceAttributes.Resource
+			review.Status.Allowed = review.Spec.ResourceAttributes.Verb == "list" &&
+				(review.Spec.User == "reader" && resource == "twininstances" ||
+					review.Spec.User == "architect" && resource == "twinclasses")
+			return true, review, nil
+		})
+
+		server = &Server{Reader: builder.Build(), Clientset: clientset}
+	})
+
+	get := func(token string, params url.Values) (*httptest.ResponseRecorder, List) {
+		request := httptest.NewRequest(http.MethodGet, Path+"default/twins?"+params.Encode(), nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		list := List{}
+		if response.Code == http.StatusOK {
+			Expect(json.Unmarshal(response.Body.Bytes(), &list)).To(Succeed())
+		}
+		return response, list
+	}
+
+	It("authenticates and authorizes callers", func() {
+		response, _ := get("", nil)
+		Expect(response.Code).To(Equal(http.StatusUnauthorized))
+		response, _ = get("invalid", nil)
+		Expect(response.Code).To(Equal(http.StatusUnauthorized))
+		response, _ = get("stranger", nil)
+		Expect(response.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("answers queries", func() {
+		response, list := get("reader", url.Values{"query": {"Factory[location=Berlin]->machines"}})
+		Expect(response.Code).To(Equal(http.StatusOK))
+		Expect(list.Items).To(HaveLen(2))
+		Expect(list.Items[0].Name).To(Equal("m1"))
+		Expect(list.Continue).To(BeEmpty())
+
+		response, _ = get("reader", url.Values{"query": {"Factory["}})
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("paginates results", func() {
+		seen := []string{}
+		params := url.Values{"limit": {"4"}}
+		for {
+			response, list := get("reader", params)
+			Expect(response.Code).To(Equal(http.StatusOK))
+			for _, item := range list.Items {
+				seen = append(seen, item.Name)
+			}
+			if list.Continue == "" {
+				break
+			}
+			params.Set("continue", list.Continue)
+		}
+		Expect(seen).To(Equal([]string{"berlin", "f1", "m1", "m2", "m3", "porto"}))
+	})
+
+	It("serves the class diagram", func() {
+		diagram := func(token, params string) *httptest.ResponseRecorder {
+			request := httptest.NewRequest(http.MethodGet, Path+"default/diagram?"+params, nil)
+			request.Header.Set("Authorization", "Bearer "+token)
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, request)
+			return response
+		}
+
+		response := diagram("reader", "")
+		Expect(response.Code).To(Equal(http.StatusForbidden))
+
+		response = diagram("architect", "")
+		Expect(response.Code).To(Equal(http.StatusOK))
+		Expect(response.Header().Get("Content-Type")).To(Equal("image/svg+xml"))
+		Expect(response.Body.String()).To(ContainSubstring(`<g id="Factory">`))
+
+		response = diagram("architect", "format=mermaid&root=Machine")
+		Expect(response.Code).To(Equal(http.StatusOK))
+		Expect(response.Body.String()).To(Equal("classDiagram\n  class Machine {\n  }\n"))
+
+		response = diagram("architect", "format=dot")
+		Expect(response.Body.String()).To(ContainSubstring(`"Factory" -> "Machine"`))
+
+		Expect(diagram("architect", "format=png").Code).To(Equal(http.StatusBadRequest))
+		Expect(diagram("architect", "root=Pump").Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,100 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Aggregates lists the aggregates Downsample supports. The numeric ones
+// skip samples that are not numbers.
+var Aggregates = []string{"avg", "min", "max", "sum", "count", "first", "last"}
+
+// Downsample groups samples into buckets of step starting at from and
+// reduces each bucket with an aggregate. Buckets are stamped with their
+// start and empty buckets are left out.
+func Downsample(samples []Sample, from time.Time, step time.Duration, aggregate string) ([]Sample, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("step must be positive")
+	}
+	reduce, ok := reducers[aggregate]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregate %q", aggregate)
+	}
+
+	result := []Sample{}
+	for start := 0; start < len(samples); {
+		bucket := from.Add(samples[start].Time.Sub(from) / step * step)
+		end := start
+		for end < len(samples) && samples[end].Time.Before(bucket.Add(step)) {
+			end++
+		}
+		if value, ok := reduce(samples[start:end]); ok {
+			result = append(result, Sample{Time: bucket, Value: value})
+		}
+		start = end
+	}
+	return result, nil
+}
+
+var reducers = map[string]func([]Sample) (json.RawMessage, bool){
+	"avg": numeric(func(values []float64) float64 { return sum(values) / float64(len(values)) }),
+	"min": numeric(func(values []float64) float64 { return fold(values, math.Min) }),
+	"max": numeric(func(values []float64) float64 { return fold(values, math.Max) }),
+	"sum": numeric(sum),
+	"count": func(samples []Sample) (json.RawMessage, bool) {
+		return json.RawMessage(strconv.Itoa(len(samples))), true
+	},
+	"first": func(samples []Sample) (json.RawMessage, bool) { return samples[0].Value, true },
+	"last":  func(samples []Sample) (json.RawMessage, bool) { return samples[len(samples)-1].Value, true },
+}
+
+func numeric(reduce func([]float64) float64) func([]Sample) (json.RawMessage, bool) {
+	return func(samples []Sample) (json.RawMessage, bool) {
+		values := []float64{}
+		for _, sample := range samples {
+			var value float64
+			if err := json.Unmarshal(sample.Value, &value); err == nil {
+				values = append(values, value)
+			}
+		}
+		if len(values) == 0 {
+			return nil, false
+		}
+		return json.RawMessage(strconv.FormatFloat(reduce(values), 'g', -1, 64)), true
+	}
+}
+
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, value := range values {
+		total += value
+	}
+	return total
+}
+
+func fold(values []float64, f func(float64, float64) float64) float64 {
+	result := values[0]
+	for _, value := range values[1:] {
+		result = f(result, value)
+	}
+	return result
+}
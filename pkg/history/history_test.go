@@ -0,0 +1,245 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var start = time.Date(2022, 10, 1, 23, 59, 0, 0, time.UTC)
+
+func values(samples []Sample) []string {
+	result := []string{}
+	for _, sample := range samples {
+		result = append(result, string(sample.Value))
+	}
+	return result
+}
+
+var _ = Describe("Store", func() {
+	var store *Store
+
+	BeforeEach(func() {
+		store = NewStore(GinkgoT().TempDir())
+		DeferCleanup(store.Close)
+		for i, payload := range []string{"20", "21.5", `{"ok": true}`, "not json"} {
+			at := start.Add(time.Duration(i) * 30 * time.Second)
+			Expect(store.Record("dt/plant/Machine/m1/telemetry/temperature", []byte(payload), at)).To(Succeed())
+		}
+		Expect(store.Record("dt/plant/Machine/m1/properties/speed/set", []byte("3"), start)).To(Succeed())
+		Expect(store.Record("dt/plant/Machine/m1/telemetry/spindle/rpm", []byte("1200"), start)).To(Succeed())
+	})
+
+	It("queries ranges across days", func() {
+		samples, err := store.Query("plant", "m1", "temperature", start, start.Add(time.Hour), 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values(samples)).To(Equal([]string{"20", "21.5", `{"ok":true}`, `"not json"`}))
+		Expect(samples[2].Time).To(Equal(start.Add(time.Minute)))
+
+		samples, err = store.Query("plant", "m1", "temperature", start.Add(30*time.Second), start.Add(time.Minute), 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values(samples)).To(Equal([]string{"21.5"}))
+
+		samples, err = store.Query("plant", "m1", "spindle/rpm", start, start.Add(time.Hour), 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values(samples)).To(Equal([]string{"1200"}))
+
+		samples, err = store.Query("plant", "m1", "speed", start, start.Add(time.Hour), 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(samples).To(BeEmpty())
+	})
+
+	It("drops days past the retention of their class", func() {
+		Expect(store.Record("dt/plant/Sensor/s1/telemetry/level", []byte("1"), start)).To(Succeed())
+
+		now := start.Add(36 * time.Hour)
+		Expect(store.Prune(now, func(namespace, class string) time.Duration {
+			if class == "Sensor" {
+				return time.Hour
+			}
+			return 30 * 24 * time.Hour
+		})).To(Succeed())
+
+		samples, err := store.Query("plant", "m1", "temperature", start, now, 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(samples).To(HaveLen(4))
+		samples, err = store.Query("plant", "s1", "level", start, now, 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(samples).To(BeEmpty())
+		_, err = os.Stat(filepath.Join(store.Dir, "plant", "Sensor"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("rejects series escaping the store", func() {
+		Expect(store.Append(Series{Namespace: "..", Class: "Machine", Instance: "m1", Attribute: "a"}, Sample{Time: start, Value: json.RawMessage("1")})).NotTo(Succeed())
+	})
+
+	It("rejects queries outside the store", func() {
+		for _, query := range [][3]string{
+			{"..", "m1", "temperature"},
+			{"*", "m1", "temperature"},
+			{"plant", "..", "temperature"},
+			{"plant", "m[0-9]", "temperature"},
+			{"plant", "m1", "../../other/x/m1/temperature"},
+			{"plant", "m1", "*"},
+		} {
+			_, err := store.Query(query[0], query[1], query[2], start, start.Add(time.Hour), 100)
+			Expect(err).To(MatchError(ErrInvalidQuery), "%v", query)
+		}
+	})
+
+	It("stops reading at the limit", func() {
+		samples, err := store.Query("plant", "m1", "temperature", start, start.Add(time.Hour), 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values(samples)).To(Equal([]string{"20", "21.5"}))
+	})
+
+	It("keeps at most MaxOpenFiles files open", func() {
+		store.MaxOpenFiles = 2
+		Expect(store.Record("dt/plant/Machine/m2/telemetry/temperature", []byte("1"), start)).To(Succeed())
+		Expect(store.Record("dt/plant/Machine/m3/telemetry/temperature", []byte("2"), start)).To(Succeed())
+		Expect(store.files).To(HaveLen(2))
+		Expect(store.lru.Len()).To(Equal(2))
+
+		Expect(store.Record("dt/plant/Machine/m1/telemetry/temperature", []byte("22"), start.Add(2*time.Minute))).To(Succeed())
+		samples, err := store.Query("plant", "m1", "temperature", start, start.Add(time.Hour), 100)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values(samples)).To(HaveLen(5))
+		Expect(store.files).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("Downsample", func() {
+	samples := []Sample{
+		{Time: start, Value: json.RawMessage("1")},
+		{Time: start.Add(20 * time.Second), Value: json.RawMessage("3")},
+		{Time: start.Add(40 * time.Second), Value: json.RawMessage(`"off"`)},
+		{Time: start.Add(130 * time.Second), Value: json.RawMessage("10")},
+	}
+
+	It("reduces buckets with the aggregate", func() {
+		for aggregate, expected := range map[string][]string{
+			"avg":   {"2", "10"},
+			"min":   {"1", "10"},
+			"max":   {"3", "10"},
+			"sum":   {"4", "10"},
+			"count": {"3", "1"},
+			"first": {"1", "10"},
+			"last":  {`"off"`, "10"},
+		} {
+			downsampled, err := Downsample(samples, start, time.Minute, aggregate)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(values(downsampled)).To(Equal(expected), aggregate)
+			Expect(downsampled[1].Time).To(Equal(start.Add(2 * time.Minute)))
+		}
+	})
+
+	It("rejects unknown aggregates and steps", func() {
+		_, err := Downsample(samples, start, time.Minute, "median")
+		Expect(err).To(HaveOccurred())
+		_, err = Downsample(samples, start, 0, "avg")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Server", func() {
+	It("serves range and downsampling queries", func() {
+		store := NewStore(GinkgoT().TempDir())
+		DeferCleanup(store.Close)
+		for i := 0; i < 4; i++ {
+			at := start.Add(time.Duration(i) * 30 * time.Second)
+			Expect(store.Record("dt/plant/Machine/m1/telemetry/temperature", []byte("20"), at)).To(Succeed())
+		}
+		clientset := kubefake.NewSimpleClientset()
+		clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+			review.Status.Authenticated = review.Spec.Token != "invalid"
+			review.Status.User.Username = review.Spec.Token
+			return true, review, nil
+		})
+		clientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+			review.Status.Allowed = review.Spec.User == "reader" && review.Spec.ResourceAttributes.Namespace == "plant" &&
+				review.Spec.ResourceAttributes.Resource == "twininstances" && review.Spec.ResourceAttributes.Verb == "list"
+			return true, review, nil
+		})
+		server := &Server{Store: store, Now: func() time.Time { return start.Add(time.Hour) }, Clientset: clientset}
+
+		getAs := func(token, url string) (int, Response) {
+			request := httptest.NewRequest(http.MethodGet, url, nil)
+			if token != "" {
+				request.Header.Set("Authorization", "Bearer "+token)
+			}
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, request)
+			body := Response{}
+			if response.Code == http.StatusOK {
+				Expect(json.Unmarshal(response.Body.Bytes(), &body)).To(Succeed())
+			}
+			return response.Code, body
+		}
+		get := func(url string) (int, Response) {
+			return getAs("reader", url)
+		}
+
+		code, body := get(Path + "plant/twins/m1/history/temperature")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body.Samples).To(HaveLen(4))
+
+		code, body = get(Path + "plant/twins/m1/history/temperature?from=2022-10-01T23:59:00Z&step=1m&aggregate=count")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(values(body.Samples)).To(Equal([]string{"2", "2"}))
+
+		code, _ = get(Path + "plant/twins/m1/history/temperature?step=1m&aggregate=median")
+		Expect(code).To(Equal(http.StatusBadRequest))
+		code, _ = get(Path + "plant/twins/m1/temperature")
+		Expect(code).To(Equal(http.StatusNotFound))
+		code, _ = get(Path + "plant/twins/*/history/temperature")
+		Expect(code).To(Equal(http.StatusBadRequest))
+
+		code, _ = getAs("", Path+"plant/twins/m1/history/temperature")
+		Expect(code).To(Equal(http.StatusUnauthorized))
+		code, _ = getAs("stranger", Path+"plant/twins/m1/history/temperature")
+		Expect(code).To(Equal(http.StatusForbidden))
+	})
+})
+
+var _ = Describe("Retention", func() {
+	It("falls back to the default", func() {
+		path := filepath.Join(GinkgoT().TempDir(), RetentionConfigKey)
+		Expect(os.WriteFile(path, []byte(`{"classes": {"plant/Sensor": "1h"}}`), 0o644)).To(Succeed())
+
+		retention, err := LoadRetention(path, 24*time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(retention.For("plant", "Sensor")).To(Equal(time.Hour))
+		Expect(retention.For("plant", "Machine")).To(Equal(24 * time.Hour))
+		Expect(retention.For("other", "Sensor")).To(Equal(24 * time.Hour))
+	})
+})
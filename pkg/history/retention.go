@@ -0,0 +1,62 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RetentionConfigKey is the key of the retention configuration in the
+// ConfigMap the operator renders for the history store.
+const RetentionConfigKey = "retention.json"
+
+// Retention is the retention configuration of the history store.
+type Retention struct {
+	// Default applies to the classes without a retention of their own.
+	Default metav1.Duration `json:"default"`
+	// Classes holds the retention of classes, keyed by <namespace>/<class>.
+	Classes map[string]metav1.Duration `json:"classes,omitempty"`
+}
+
+// For returns the retention of the twins of a class.
+func (r *Retention) For(namespace, class string) time.Duration {
+	if retention, ok := r.Classes[namespace+"/"+class]; ok {
+		return retention.Duration
+	}
+	return r.Default.Duration
+}
+
+// LoadRetention reads a retention configuration, keeping def as the default
+// when the file does not set one.
+func LoadRetention(path string, def time.Duration) (*Retention, error) {
+	retention := &Retention{Default: metav1.Duration{Duration: def}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, retention); err != nil {
+		return nil, err
+	}
+	if retention.Default.Duration <= 0 {
+		retention.Default.Duration = def
+	}
+	return retention, nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/agwermann/dt-operator/pkg/access"
+)
+
+// Path prefixes the URL of the history of an attribute, which is
+// Path + "<namespace>/twins/<instance>/history/<attribute>".
+const Path = "/api/v1/namespaces/"
+
+const (
+	// DefaultRange is the range queried when from is not given.
+	DefaultRange = time.Hour
+	// MaxSamples caps the samples of a response.
+	MaxSamples = 10000
+	// MaxDownsampled caps the samples read for a downsampled response.
+	MaxDownsampled = 100 * MaxSamples
+)
+
+// Server serves range and downsampling queries over a store. Callers must
+// present a bearer token allowed to list the TwinInstances of the namespace
+// they query.
+type Server struct {
+	Store *Store
+	// Now returns the default end of a range.
+	Now func() time.Time
+	// Clientset reviews the tokens and the access of the callers.
+	Clientset kubernetes.Interface
+}
+
+// Response is the answer to a history query.
+type Response struct {
+	Samples []Sample `json:"samples"`
+	// Truncated is set when the range held more samples than a response
+	// holds, MaxSamples, or than are read to downsample it, MaxDownsampled.
+	// Only the first samples are returned or downsampled.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// ServeHTTP answers GET <Path><namespace>/twins/<instance>/history/<attribute>
+// with the samples in [from, to), given as RFC 3339 times, downsampled to
+// buckets of step with aggregate when step is set.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, Path), "/", 5)
+	if !strings.HasPrefix(r.URL.Path, Path) || len(parts) != 5 || parts[0] == "" || parts[1] != "twins" ||
+		parts[2] == "" || parts[3] != "history" || parts[4] == "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+	namespace, instance, attribute := parts[0], parts[2], parts[4]
+
+	status, err := access.Authorize(r, s.Clientset, namespace, "twininstances")
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	params := r.URL.Query()
+	to := s.Now()
+	if value := params.Get("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			writeError(w, http.StatusBadRequest, "to: "+err.Error())
+			return
+		}
+	}
+	from := to.Add(-DefaultRange)
+	if value := params.Get("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			writeError(w, http.StatusBadRequest, "from: "+err.Error())
+			return
+		}
+	}
+	if !from.Before(to) {
+		writeError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	var step time.Duration
+	aggregate := params.Get("aggregate")
+	downsample := params.Get("step") != ""
+	if downsample {
+		if step, err = time.ParseDuration(params.Get("step")); err != nil {
+			writeError(w, http.StatusBadRequest, "step: "+err.Error())
+			return
+		}
+		if aggregate == "" {
+			aggregate = "avg"
+		}
+	}
+
+	// One sample more than is kept tells whether the range held more.
+	limit := MaxSamples
+	if downsample {
+		limit = MaxDownsampled
+	}
+	samples, err := s.Store.Query(namespace, instance, attribute, from, to, limit+1)
+	if errors.Is(err, ErrInvalidQuery) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response := Response{Samples: samples}
+	if len(samples) > limit {
+		response.Samples = samples[:limit]
+		response.Truncated = true
+	}
+
+	if downsample {
+		if response.Samples, err = Downsample(response.Samples, from, step, aggregate); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(response.Samples) > MaxSamples {
+			response.Samples = response.Samples[:MaxSamples]
+			response.Truncated = true
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,323 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package history keeps the history of the telemetry and reported
+// properties of twins on disk and answers range queries over it.
+//
+// Every attribute of a twin is a series stored as one file per UTC day,
+//
+//	<dir>/<namespace>/<class>/<instance>/<attribute>/<yyyy-mm-dd>.log
+//
+// holding a line per sample with its time in Unix milliseconds and its value
+// as compact JSON. Retention drops whole days.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/agwermann/dt-operator/pkg/state"
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+const dayLayout = "2006-01-02"
+
+// Series names the history of an attribute of a twin.
+type Series struct {
+	Namespace string
+	Class     string
+	Instance  string
+	Attribute string
+}
+
+// Sample is a value of an attribute at a point in time.
+type Sample struct {
+	Time  time.Time       `json:"time"`
+	Value json.RawMessage `json:"value"`
+}
+
+// DefaultMaxOpenFiles is how many day files a store keeps open for appending
+// unless told otherwise.
+const DefaultMaxOpenFiles = 256
+
+// ErrInvalidQuery is returned by Query for segments that cannot name a
+// series.
+var ErrInvalidQuery = errors.New("invalid history query")
+
+// Store is a history store rooted at Dir.
+type Store struct {
+	Dir string
+	// MaxOpenFiles caps the day files kept open for appending. The least
+	// recently appended to is closed to make room for another.
+	MaxOpenFiles int
+
+	mu sync.Mutex
+	// files holds the open day files, the most recently appended to first.
+	files map[string]*list.Element
+	lru   *list.List
+}
+
+type openFile struct {
+	path string
+	file *os.File
+}
+
+// NewStore returns a store rooted at dir.
+func NewStore(dir string) *Store {
+	return &Store{Dir: dir, MaxOpenFiles: DefaultMaxOpenFiles, files: map[string]*list.Element{}, lru: list.New()}
+}
+
+func (s *Store) seriesDir(series Series) string {
+	return filepath.Join(s.Dir, series.Namespace, series.Class, series.Instance, url.PathEscape(series.Attribute))
+}
+
+// Append adds a sample to a series. Value must be compact JSON.
+func (s *Store) Append(series Series, sample Sample) error {
+	for _, segment := range []string{series.Namespace, series.Class, series.Instance} {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, `/\`) {
+			return fmt.Errorf("invalid series segment %q", segment)
+		}
+	}
+	path := filepath.Join(s.seriesDir(series), sample.Time.UTC().Format(dayLayout)+".log")
+	line := strconv.FormatInt(sample.Time.UnixMilli(), 10) + " " + string(sample.Value) + "\n"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := s.open(path)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(line)
+	return err
+}
+
+// open returns the day file at path, opening it for appending when it is
+// not open yet and closing the least recently used file beyond
+// MaxOpenFiles.
+func (s *Store) open(path string) (*os.File, error) {
+	if element, ok := s.files[path]; ok {
+		s.lru.MoveToFront(element)
+		return element.Value.(*openFile).file, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.files[path] = s.lru.PushFront(&openFile{path: path, file: file})
+	for s.MaxOpenFiles > 0 && s.lru.Len() > s.MaxOpenFiles {
+		s.closeFile(s.lru.Back())
+	}
+	return file, nil
+}
+
+func (s *Store) closeFile(element *list.Element) error {
+	open := s.lru.Remove(element).(*openFile)
+	delete(s.files, open.path)
+	return open.file.Close()
+}
+
+// Record appends the value a message published on a telemetry or reported
+// property topic carries. Other topics are ignored.
+func (s *Store) Record(topic string, payload []byte, at time.Time) error {
+	namespace, class, instance, rest, ok := topics.ParseInstance(topic)
+	if !ok {
+		return nil
+	}
+	attribute, ok := topics.Attribute(rest)
+	if !ok {
+		return nil
+	}
+	series := Series{Namespace: namespace, Class: class, Instance: instance, Attribute: attribute}
+	return s.Append(series, Sample{Time: at, Value: state.Value(payload)})
+}
+
+// Query returns the earliest samples of an attribute of a twin in [from,
+// to), sorted by time, reading no more than limit samples. The class of the
+// twin is not needed, so the history survives the twin moving to another
+// class. The namespace must be a DNS label, the instance an object name, and
+// the attribute a name or slot/name, so that a query never reaches outside
+// the store.
+func (s *Store) Query(namespace, instance, attribute string, from, to time.Time, limit int) ([]Sample, error) {
+	if err := validateQuery(namespace, instance, attribute); err != nil {
+		return nil, err
+	}
+	pattern := filepath.Join(s.Dir, namespace, "*", instance, url.PathEscape(attribute))
+	dirs, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	// Days are read in order, and the samples of a day file in the order
+	// they were appended, so reading stops as soon as limit is reached.
+	samples := []Sample{}
+	for day := from.UTC().Truncate(24 * time.Hour); day.Before(to) && len(samples) < limit; day = day.Add(24 * time.Hour) {
+		daySamples := []Sample{}
+		for _, dir := range dirs {
+			read, err := readDay(filepath.Join(dir, day.Format(dayLayout)+".log"), from, to, limit-len(samples))
+			if err != nil {
+				return nil, err
+			}
+			daySamples = append(daySamples, read...)
+		}
+		sort.SliceStable(daySamples, func(i, j int) bool {
+			return daySamples[i].Time.Before(daySamples[j].Time)
+		})
+		samples = append(samples, daySamples...)
+	}
+	if len(samples) > limit {
+		samples = samples[:limit]
+	}
+	return samples, nil
+}
+
+func validateQuery(namespace, instance, attribute string) error {
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return fmt.Errorf("%w: namespace %q: %s", ErrInvalidQuery, namespace, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Subdomain(instance); len(errs) > 0 {
+		return fmt.Errorf("%w: instance %q: %s", ErrInvalidQuery, instance, strings.Join(errs, ", "))
+	}
+	parts := strings.Split(attribute, "/")
+	if len(parts) > 2 {
+		return fmt.Errorf("%w: attribute %q: must be a name or slot/name", ErrInvalidQuery, attribute)
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `*?[\`) || strings.IndexFunc(part, unicode.IsSpace) >= 0 {
+			return fmt.Errorf("%w: attribute %q: must be a name or slot/name", ErrInvalidQuery, attribute)
+		}
+	}
+	return nil
+}
+
+func readDay(path string, from, to time.Time, limit int) ([]Sample, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	samples := []Sample{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for len(samples) < limit && scanner.Scan() {
+		millis, value, ok := bytes.Cut(scanner.Bytes(), []byte(" "))
+		if !ok {
+			continue
+		}
+		unix, err := strconv.ParseInt(string(millis), 10, 64)
+		if err != nil {
+			continue
+		}
+		t := time.UnixMilli(unix).UTC()
+		if t.Before(from) || !t.Before(to) {
+			continue
+		}
+		samples = append(samples, Sample{Time: t, Value: append(json.RawMessage(nil), value...)})
+	}
+	return samples, scanner.Err()
+}
+
+// Prune drops the days of every series that ended more than the retention
+// of its class before now, and the directories left empty.
+func (s *Store) Prune(now time.Time, retention func(namespace, class string) time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	today := now.UTC().Format(dayLayout)
+	for path, element := range s.files {
+		if !strings.HasSuffix(path, today+".log") {
+			s.closeFile(element)
+		}
+	}
+
+	classDirs, err := filepath.Glob(filepath.Join(s.Dir, "*", "*"))
+	if err != nil {
+		return err
+	}
+	for _, classDir := range classDirs {
+		namespace, class := filepath.Base(filepath.Dir(classDir)), filepath.Base(classDir)
+		cutoff := now.Add(-retention(namespace, class))
+		days, err := filepath.Glob(filepath.Join(classDir, "*", "*", "*.log"))
+		if err != nil {
+			return err
+		}
+		for _, path := range days {
+			day, err := time.Parse(dayLayout, strings.TrimSuffix(filepath.Base(path), ".log"))
+			if err != nil || !day.Add(24*time.Hour).Before(cutoff) {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		removeEmptyDirs(classDir)
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir and the directories below it that hold no
+// files.
+func removeEmptyDirs(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	empty := true
+	for _, entry := range entries {
+		if !entry.IsDir() || !removeEmptyDirs(filepath.Join(dir, entry.Name())) {
+			empty = false
+		}
+	}
+	return empty && os.Remove(dir) == nil
+}
+
+// Close closes the files the store appends to.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for _, element := range s.files {
+		if closeErr := s.closeFile(element); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package inspect renders the twin model for people: class descriptions,
+// the class relationship graph, offline validation reports and the classes
+// and brokers of TwinServices. It backs the kubectl-twin plugin.
+package inspect
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+// DescribeClass writes the effective view of the class version matching
+// reference: its attributes with components flattened, the values of the
+// enums they use, its relationships and commands, and the classes
+// depending on it.
+func DescribeClass(w io.Writer, m *model.Model, reference string) error {
+	class, err := m.Select(reference)
+	if err != nil {
+		return err
+	}
+	resolved, err := m.ResolveClass(class)
+	if err != nil {
+		return err
+	}
+
+	header(w, "Name", class.Spec.Name)
+	if class.Spec.ID != "" {
+		header(w, "ID", class.Spec.ID)
+	}
+	versions := []string{}
+	for _, version := range m.Versions(class.Spec.Name) {
+		versions = append(versions, fmt.Sprint(model.Version(version)))
+	}
+	if len(versions) > 1 {
+		header(w, "Versions", strings.Join(versions, ", "))
+	}
+	for _, component := range resolved.Components {
+		header(w, "Component", component.Name+" ("+component.Class+")")
+	}
+
+	fmt.Fprintln(w, "\nAttributes:")
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "  NAME\tKIND\tSCHEMA\tWRITABLE\tUNIT")
+	enums := map[string]*dtdlv0.TwinEnum{}
+	for _, attribute := range resolved.Attributes {
+		fmt.Fprintf(table, "  %s\t%s\t%s\t%t\t%s\n", attribute.Name, attribute.Kind, SchemaString(&attribute.ResolvedSchema), attribute.Writable, orNone(attribute.Unit))
+		collectEnums(&attribute.ResolvedSchema, enums)
+	}
+	_ = table.Flush()
+
+	if len(resolved.Relationships) > 0 {
+		fmt.Fprintln(w, "\nRelationships:")
+		table = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "  NAME\tTARGETS\tMULTIPLICITY\tINVERSE\tON DELETE")
+		for _, relationship := range resolved.Relationships {
+			fmt.Fprintf(table, "  %s\t%s\t%s\t%s\t%s\n", relationship.Name, targetsString(relationship.Targets),
+				multiplicity(relationship.MinMultiplicity, relationship.MaxMultiplicity), orNone(relationship.Inverse), orNone(string(relationship.OnDelete)))
+		}
+		_ = table.Flush()
+	}
+
+	if len(resolved.Commands) > 0 {
+		fmt.Fprintln(w, "\nCommands:")
+		table = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "  NAME\tREQUEST\tRESPONSE")
+		for _, command := range resolved.Commands {
+			fmt.Fprintf(table, "  %s\t%s\t%s\n", command.Name, payloadString(command.Request), payloadString(command.Response))
+			for _, payload := range []*model.ResolvedAttribute{command.Request, command.Response} {
+				if payload != nil {
+					collectEnums(&payload.ResolvedSchema, enums)
+				}
+			}
+		}
+		_ = table.Flush()
+	}
+
+	if len(enums) > 0 {
+		fmt.Fprintln(w, "\nEnums:")
+		names := make([]string, 0, len(enums))
+		for name := range enums {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s: %s\n", name, enumValues(enums[name]))
+		}
+	}
+
+	dependents := dependentsOf(m, class.Spec.Name)
+	fmt.Fprintln(w, "\nDependents:")
+	if len(dependents) == 0 {
+		fmt.Fprintln(w, "  <none>")
+	}
+	for _, dependent := range dependents {
+		fmt.Fprintf(w, "  %s\n", dependent)
+	}
+	return nil
+}
+
+// SchemaString writes a schema the way it reads in a class:
+// array<double>, map<string>, enum State or object{x: double}.
+func SchemaString(s *model.ResolvedSchema) string {
+	switch s.Type {
+	case dtdlv0.Enumeration:
+		if s.Enum != nil {
+			return "enum " + s.Enum.Spec.Name
+		}
+	case dtdlv0.Array:
+		return "array<" + SchemaString(s.Items) + ">"
+	case dtdlv0.Map:
+		return "map<" + SchemaString(s.Values) + ">"
+	case dtdlv0.Object:
+		fields := []string{}
+		for i := range s.Fields {
+			fields = append(fields, s.Fields[i].Name+": "+SchemaString(&s.Fields[i].ResolvedSchema))
+		}
+		return "object{" + strings.Join(fields, ", ") + "}"
+	}
+	return string(s.Type)
+}
+
+func collectEnums(s *model.ResolvedSchema, enums map[string]*dtdlv0.TwinEnum) {
+	s.Walk(func(nested *model.ResolvedSchema) {
+		if nested.Enum != nil {
+			enums[nested.Enum.Spec.Name] = nested.Enum
+		}
+	})
+}
+
+// enumValues lists the values of an enum with the numbers the operator
+// assigned them, when it did.
+func enumValues(enum *dtdlv0.TwinEnum) string {
+	values := []string{}
+	for _, value := range enum.Spec.Values {
+		if number, ok := enum.Status.ValueNumbers[value]; ok {
+			value = fmt.Sprintf("%s=%d", value, number)
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, ", ")
+}
+
+// dependentsOf lists the classes embedding the named class, declaring an
+// inverse towards it or relating to it, with how they depend on it.
+func dependentsOf(m *model.Model, name string) []string {
+	dependents := []string{}
+	seen := map[string]bool{}
+	add := func(class *dtdlv0.TwinClass, how string) {
+		entry := class.Spec.Name + " (" + how + ")"
+		if !seen[entry] && class.Spec.Name != name {
+			seen[entry] = true
+			dependents = append(dependents, entry)
+		}
+	}
+	for _, class := range m.ClassesEmbedding(name) {
+		add(class, "embeds")
+	}
+	for _, class := range m.ClassesWithInverseTo(name) {
+		add(class, "inverse")
+	}
+	for _, class := range m.Classes() {
+		for i := range class.Spec.Relationships {
+			for _, target := range class.Spec.Relationships[i].AllowedTargets() {
+				if target == name {
+					add(class, "relates")
+				}
+			}
+		}
+	}
+	sort.Strings(dependents)
+	return dependents
+}
+
+func header(w io.Writer, label, value string) {
+	fmt.Fprintf(w, "%-11s%s\n", label+":", value)
+}
+
+func payloadString(payload *model.ResolvedAttribute) string {
+	if payload == nil {
+		return "<none>"
+	}
+	return payload.Name + ": " + SchemaString(&payload.ResolvedSchema)
+}
+
+func targetsString(targets []string) string {
+	if len(targets) == 0 {
+		return "<any>"
+	}
+	return strings.Join(targets, ", ")
+}
+
+// multiplicity writes a cardinality as min..max, with * for no upper bound.
+func multiplicity(min, max int32) string {
+	if max == 0 {
+		return fmt.Sprintf("%d..*", min)
+	}
+	return fmt.Sprintf("%d..%d", min, max)
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+// Graph is the class relationship graph of a model, built from the latest
+// version of every class.
+type Graph struct {
+	Classes []string
+	Edges   []Edge
+}
+
+// Edge leads from a class to a class it relates to, or embeds when
+// Component is set.
+type Edge struct {
+	From      string
+	To        string
+	Name      string
+	Component bool
+	// Multiplicity is the cardinality of a relationship, as min..max.
+	Multiplicity string
+}
+
+// ClassGraph builds the graph of m. Relationships allowing any class have
+// no edge.
+func ClassGraph(m *model.Model) *Graph {
+	g := &Graph{}
+	for _, class := range m.Classes() {
+		g.Classes = append(g.Classes, class.Spec.Name)
+		for i := range class.Spec.Relationships {
+			relationship := &class.Spec.Relationships[i]
+			min, max := relationship.Cardinality()
+			for _, target := range relationship.AllowedTargets() {
+				g.Edges = append(g.Edges, Edge{
+					From:         class.Spec.Name,
+					To:           target,
+					Name:         relationship.Name,
+					Multiplicity: multiplicity(min, max),
+				})
+			}
+		}
+		for _, component := range class.Spec.Components {
+			g.Edges = append(g.Edges, Edge{From: class.Spec.Name, To: component.Class, Name: component.Name, Component: true})
+		}
+	}
+	return g
+}
+
+func (g *Graph) outgoing(class string) []Edge {
+	edges := []Edge{}
+	for _, edge := range g.Edges {
+		if edge.From == class {
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
+
+// roots returns the classes no other class leads to, followed by the first
+// class of every cycle not reachable from them, so that a tree from the
+// roots covers the whole graph.
+func (g *Graph) roots() []string {
+	incoming := map[string]bool{}
+	for _, edge := range g.Edges {
+		if edge.From != edge.To {
+			incoming[edge.To] = true
+		}
+	}
+
+	roots := []string{}
+	reached := map[string]bool{}
+	var reach func(class string)
+	reach = func(class string) {
+		if reached[class] {
+			return
+		}
+		reached[class] = true
+		for _, edge := range g.outgoing(class) {
+			reach(edge.To)
+		}
+	}
+	for _, class := range g.Classes {
+		if !incoming[class] {
+			roots = append(roots, class)
+			reach(class)
+		}
+	}
+	for _, class := range g.Classes {
+		if !reached[class] {
+			roots = append(roots, class)
+			reach(class)
+		}
+	}
+	return roots
+}
+
+// WriteTree writes the graph as a tree from root, or from every root of
+// the graph when root is empty. A class already expanded is marked with
+// (...) instead of being expanded again.
+func WriteTree(w io.Writer, g *Graph, root string) error {
+	roots := g.roots()
+	if root != "" {
+		found := false
+		for _, class := range g.Classes {
+			found = found || class == root
+		}
+		if !found {
+			return fmt.Errorf("twin class %q not found", root)
+		}
+		roots = []string{root}
+	}
+
+	expanded := map[string]bool{}
+	var walk func(class, prefix string)
+	walk = func(class, prefix string) {
+		expanded[class] = true
+		edges := g.outgoing(class)
+		for i, edge := range edges {
+			branch, indent := "├── ", "│   "
+			if i == len(edges)-1 {
+				branch, indent = "└── ", "    "
+			}
+			label := fmt.Sprintf("%s: %s [%s]", edge.Name, edge.To, edge.Multiplicity)
+			if edge.Component {
+				label = fmt.Sprintf("%s: %s (component)", edge.Name, edge.To)
+			}
+			if expanded[edge.To] && len(g.outgoing(edge.To)) > 0 {
+				fmt.Fprintf(w, "%s%s%s (...)\n", prefix, branch, label)
+				continue
+			}
+			fmt.Fprintf(w, "%s%s%s\n", prefix, branch, label)
+			walk(edge.To, prefix+indent)
+		}
+	}
+	for _, class := range roots {
+		fmt.Fprintln(w, class)
+		walk(class, "")
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+var one = int32(1)
+
+func plant() *model.Model {
+	return model.New([]dtdlv0.TwinClass{
+		{Spec: dtdlv0.TwinClassSpec{
+			Name: "Factory",
+			Relationships: []dtdlv0.TwinRelationship{
+				{Name: "lines", Targets: []string{"Line"}},
+			},
+		}},
+		{Spec: dtdlv0.TwinClassSpec{
+			Name: "Line",
+			Relationships: []dtdlv0.TwinRelationship{
+				{Name: "machines", Targets: []string{"Machine"}, MinMultiplicity: 1},
+			},
+		}},
+		{Spec: dtdlv0.TwinClassSpec{
+			ID:   "dtmi:acme:Machine;2",
+			Name: "Machine",
+			Attributes: []dtdlv0.TwinClassAttributes{
+				{Name: "state", TwinSchema: dtdlv0.TwinSchema{Type: string(dtdlv0.Enumeration), Reference: "State"}, Kind: dtdlv0.Telemetry},
+				{Name: "loads", TwinSchema: dtdlv0.TwinSchema{Type: "array", Items: &dtdlv0.TwinSchema{Type: "double"}}},
+			},
+			Relationships: []dtdlv0.TwinRelationship{
+				{Name: "line", Targets: []string{"Line"}, MaxMultiplicity: &one},
+			},
+			Components: []dtdlv0.TwinComponent{{Name: "motor", Class: "Motor"}},
+		}},
+		{Spec: dtdlv0.TwinClassSpec{
+			Name: "Motor",
+			Attributes: []dtdlv0.TwinClassAttributes{
+				{Name: "speed", TwinSchema: dtdlv0.TwinSchema{Type: "double"}, Writable: true, Unit: "revolutionPerMinute", SemanticType: "AngularVelocity"},
+			},
+		}},
+	}, []dtdlv0.TwinEnum{{
+		Spec:   dtdlv0.TwinEnumSpec{Name: "State", Values: []string{"idle", "busy"}},
+		Status: dtdlv0.TwinEnumStatus{ValueNumbers: map[string]int32{"idle": 1, "busy": 2}},
+	}})
+}
+
+var _ = Describe("DescribeClass", func() {
+	It("shows the effective attributes, enum values and dependents", func() {
+		out := &bytes.Buffer{}
+		Expect(DescribeClass(out, plant(), "Machine")).To(Succeed())
+
+		Expect(out.String()).To(ContainSubstring("ID:        dtmi:acme:Machine;2\n"))
+		Expect(out.String()).To(ContainSubstring("Component: motor (Motor)\n"))
+		Expect(out.String()).To(MatchRegexp(`state\s+telemetry\s+enum State\s+false\s+<none>`))
+		Expect(out.String()).To(MatchRegexp(`loads\s+property\s+array<double>`))
+		Expect(out.String()).To(MatchRegexp(`motor/speed\s+property\s+double\s+true\s+revolutionPerMinute`))
+		Expect(out.String()).To(MatchRegexp(`line\s+Line\s+0\.\.1`))
+		Expect(out.String()).To(ContainSubstring("State: idle=1, busy=2\n"))
+		Expect(out.String()).To(ContainSubstring("Dependents:\n  Line (relates)\n"))
+	})
+
+	It("reports classes that do not resolve", func() {
+		m := model.New([]dtdlv0.TwinClass{{Spec: dtdlv0.TwinClassSpec{
+			Name:       "Machine",
+			Attributes: []dtdlv0.TwinClassAttributes{{Name: "state", TwinSchema: dtdlv0.TwinSchema{Type: string(dtdlv0.Enumeration), Reference: "State"}}},
+		}}}, nil)
+
+		Expect(DescribeClass(&bytes.Buffer{}, m, "Machine")).To(MatchError(ContainSubstring("State")))
+		Expect(DescribeClass(&bytes.Buffer{}, m, "Line")).To(MatchError(ContainSubstring(`no twin class matches "Line"`)))
+	})
+})
+
+var _ = Describe("ClassGraph", func() {
+	It("writes a tree from the roots, marking classes already expanded", func() {
+		out := &bytes.Buffer{}
+		Expect(WriteTree(out, ClassGraph(plant()), "")).To(Succeed())
+
+		Expect(out.String()).To(Equal(`Factory
+└── lines: Line [0..*]
+    └── machines: Machine [1..*]
+        ├── line: Line [0..1] (...)
+        └── motor: Motor (component)
+`))
+	})
+
+	It("writes a tree from a root", func() {
+		out := &bytes.Buffer{}
+		Expect(WriteTree(out, ClassGraph(plant()), "Machine")).To(Succeed())
+
+		Expect(out.String()).To(HavePrefix("Machine\n├── line: Line [0..1]\n│   └── machines: Machine [1..*] (...)\n"))
+		Expect(WriteTree(out, ClassGraph(plant()), "Pump")).To(MatchError(ContainSubstring("Pump")))
+	})
+
+	It("covers cycles no root leads to", func() {
+		m := model.New([]dtdlv0.TwinClass{
+			{Spec: dtdlv0.TwinClassSpec{Name: "A", Relationships: []dtdlv0.TwinRelationship{{Name: "b", Targets: []string{"B"}}}}},
+			{Spec: dtdlv0.TwinClassSpec{Name: "B", Relationships: []dtdlv0.TwinRelationship{{Name: "a", Targets: []string{"A"}}}}},
+		}, nil)
+		out := &bytes.Buffer{}
+		Expect(WriteTree(out, ClassGraph(m), "")).To(Succeed())
+
+		Expect(out.String()).To(Equal("A\n└── b: B [0..*]\n    └── a: A [0..*] (...)\n"))
+	})
+})
+
+var _ = Describe("Validate", func() {
+	It("checks a model directory as a whole", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "model.yaml"), []byte(`apiVersion: dtdl.digitaltwin/v0
+kind: TwinClass
+metadata:
+  name: machine
+spec:
+  id: dtmi:acme:Machine;1
+  name: Machine
+  attributes:
+  - name: state
+    type: enumeration
+    reference: State
+---
+apiVersion: dtdl.digitaltwin/v0
+kind: TwinClass
+metadata:
+  name: machine-copy
+spec:
+  id: dtmi:acme:Machine;1
+  name: Machine
+---
+apiVersion: dtdl.digitaltwin/v0
+kind: TwinEnum
+metadata:
+  name: mode
+spec:
+  name: Mode
+  values: [auto, manual, auto]
+`), 0o644)).To(Succeed())
+
+		manifests, err := model.LoadFiles(dir)
+		Expect(err).NotTo(HaveOccurred())
+		problems := Validate(manifests)
+
+		lines := []string{}
+		for _, problem := range problems {
+			lines = append(lines, problem.String())
+		}
+		Expect(lines).To(ConsistOf(
+			ContainSubstring(`TwinClass machine: spec.attributes[0].reference: Not found: "State"`),
+			ContainSubstring(`TwinClass machine-copy: spec.id: Duplicate value: "dtmi:acme:Machine;1"`),
+			ContainSubstring(`TwinEnum mode: spec.values[2]: Duplicate value: "auto"`),
+		))
+	})
+
+	It("reports names defined in several namespaces", func() {
+		manifests := &model.Manifests{
+			Classes: []dtdlv0.TwinClass{
+				{ObjectMeta: metav1.ObjectMeta{Name: "motor", Namespace: "plant"}, Spec: dtdlv0.TwinClassSpec{Name: "Motor"}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "motor", Namespace: "lab"}, Spec: dtdlv0.TwinClassSpec{Name: "Motor"}},
+			},
+		}
+
+		Expect(Validate(manifests)).To(ConsistOf(
+			WithTransform(Problem.String, Equal(`TwinClass Motor: spec.name: Invalid value: "Motor": defined in namespaces "lab", "plant"`)),
+		))
+	})
+
+	It("summarizes a valid model", func() {
+		manifests := &model.Manifests{
+			Classes: []dtdlv0.TwinClass{{ObjectMeta: metav1.ObjectMeta{Name: "motor"}, Spec: dtdlv0.TwinClassSpec{Name: "Motor"}}},
+		}
+		out := &bytes.Buffer{}
+		WriteProblems(out, manifests, Validate(manifests))
+
+		Expect(out.String()).To(Equal("No problems found in 1 TwinClass and 0 TwinEnum resources\n"))
+	})
+})
+
+var _ = Describe("WriteServices", func() {
+	It("shows the classes and brokers of services", func() {
+		services := []dtdlv0.TwinService{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "monitor", Namespace: "plant"},
+				Spec:       dtdlv0.TwinServiceSpec{Classes: []string{"Machine", "Pump"}, DataSource: "mqtt"},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "archive", Namespace: "plant"},
+				Spec:       dtdlv0.TwinServiceSpec{Classes: []string{"Line"}, DataTarget: "kafka"},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "idle", Namespace: "lab"},
+			},
+		}
+		out := &bytes.Buffer{}
+		brokers := broker.Addresses{Scope: broker.ClusterScope, Default: "broker:1883"}
+		Expect(WriteServices(out, services, map[string]*model.Model{"plant": plant()}, brokers)).To(Succeed())
+
+		Expect(out.String()).To(Equal(`NAMESPACE  NAME     CLASSES                       SOURCE  TARGET  BROKER
+lab        idle     <none>                        <none>  <none>  <none>
+plant      archive  Line                          <none>  kafka   <none>
+plant      monitor  Machine (v2), Pump (missing)  mqtt    <none>  broker:1883
+`))
+	})
+
+	It("shows the broker of the namespace of services", func() {
+		services := []dtdlv0.TwinService{{
+			ObjectMeta: metav1.ObjectMeta{Name: "monitor", Namespace: "plant"},
+			Spec:       dtdlv0.TwinServiceSpec{Classes: []string{"Machine"}, DataTarget: "mqtt"},
+		}}
+		out := &bytes.Buffer{}
+		brokers := broker.Addresses{Scope: broker.NamespaceScope}
+		Expect(WriteServices(out, services, map[string]*model.Model{"plant": plant()}, brokers)).To(Succeed())
+
+		Expect(out.String()).To(Equal(`NAMESPACE  NAME     CLASSES       SOURCE  TARGET  BROKER
+plant      monitor  Machine (v2)  <none>  mqtt    tcp://mqtt-broker-service.plant:1883
+`))
+	})
+})
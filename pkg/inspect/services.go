@@ -0,0 +1,77 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+// MQTT is the data source and target of services using the broker.
+const MQTT = "mqtt"
+
+// WriteServices writes the class versions every service selects, and the
+// broker of those using MQTT. Models holds the model of every namespace.
+func WriteServices(w io.Writer, services []dtdlv0.TwinService, models map[string]*model.Model, brokers broker.Addresses) error {
+	services = append([]dtdlv0.TwinService(nil), services...)
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		return services[i].Name < services[j].Name
+	})
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "NAMESPACE\tNAME\tCLASSES\tSOURCE\tTARGET\tBROKER")
+	for _, service := range services {
+		m, ok := models[service.Namespace]
+		if !ok {
+			m = model.New(nil, nil)
+		}
+		classes := []string{}
+		for _, reference := range service.Spec.Classes {
+			classes = append(classes, selectedClass(m, reference))
+		}
+		serviceBroker := "<none>"
+		if service.Spec.DataSource == MQTT || service.Spec.DataTarget == MQTT {
+			serviceBroker = brokers.For(service.Namespace, service.Name)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", service.Namespace, service.Name, orNone(strings.Join(classes, ", ")),
+			orNone(service.Spec.DataSource), orNone(service.Spec.DataTarget), serviceBroker)
+	}
+	return table.Flush()
+}
+
+// selectedClass writes a class reference with the version it selects, or
+// marks it missing.
+func selectedClass(m *model.Model, reference string) string {
+	class, err := m.Select(reference)
+	if err != nil {
+		return reference + " (missing)"
+	}
+	if version := model.Version(class); version > 0 {
+		return fmt.Sprintf("%s (v%d)", reference, version)
+	}
+	return reference
+}
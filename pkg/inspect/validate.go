@@ -0,0 +1,113 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+// Problem is an error found in a resource of a model.
+type Problem struct {
+	Kind string
+	Name string
+	Err  *field.Error
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s %s: %s", p.Kind, p.Name, p.Err.Error())
+}
+
+// Validate checks a model read from manifests as a whole: every class
+// version must resolve against the classes and enums of the manifests, and
+// class IDs and enum names must be unique. Names defined in several
+// namespaces are reported, and the rest is checked as if they were not.
+func Validate(manifests *model.Manifests) []Problem {
+	m := model.New(manifests.Classes, manifests.Enums)
+	problems := []Problem{}
+
+	for _, conflict := range model.Conflicts(manifests.Classes, manifests.Enums) {
+		err := field.Invalid(field.NewPath("spec", "name"), conflict.Name, "defined in namespaces "+strings.Join(conflict.Namespaces, ", "))
+		problems = append(problems, Problem{Kind: conflict.Kind, Name: conflict.Name, Err: err})
+	}
+
+	ids := map[string]bool{}
+	for i := range manifests.Classes {
+		class := &manifests.Classes[i]
+		name := resourceName(class.Name, class.Spec.Name)
+		for _, err := range m.Validate(class) {
+			problems = append(problems, Problem{Kind: "TwinClass", Name: name, Err: err})
+		}
+		if class.Spec.ID == "" {
+			continue
+		}
+		if ids[class.Spec.ID] {
+			problems = append(problems, Problem{Kind: "TwinClass", Name: name, Err: field.Duplicate(field.NewPath("spec", "id"), class.Spec.ID)})
+		}
+		ids[class.Spec.ID] = true
+	}
+
+	enums := map[string]bool{}
+	for i := range manifests.Enums {
+		enum := &manifests.Enums[i]
+		name := resourceName(enum.Name, enum.Spec.Name)
+		if enums[enum.Spec.Name] {
+			problems = append(problems, Problem{Kind: "TwinEnum", Name: name, Err: field.Duplicate(field.NewPath("spec", "name"), enum.Spec.Name)})
+		}
+		enums[enum.Spec.Name] = true
+		problems = append(problems, validateEnumValues(enum, name)...)
+	}
+	return problems
+}
+
+func validateEnumValues(enum *dtdlv0.TwinEnum, name string) []Problem {
+	problems := []Problem{}
+	values := map[string]bool{}
+	for i, value := range enum.Spec.Values {
+		if values[value] {
+			problems = append(problems, Problem{Kind: "TwinEnum", Name: name, Err: field.Duplicate(field.NewPath("spec", "values").Index(i), value)})
+		}
+		values[value] = true
+	}
+	return problems
+}
+
+// WriteProblems writes one line per problem, or a summary of the model when
+// there is none.
+func WriteProblems(w io.Writer, manifests *model.Manifests, problems []Problem) {
+	for _, problem := range problems {
+		fmt.Fprintln(w, problem)
+	}
+	if len(problems) == 0 {
+		fmt.Fprintf(w, "No problems found in %d TwinClass and %d TwinEnum resources\n", len(manifests.Classes), len(manifests.Enums))
+	}
+}
+
+// resourceName prefers the object name, which manifests written for the
+// model alone may leave out.
+func resourceName(objectName, specName string) string {
+	if objectName != "" {
+		return objectName
+	}
+	return specName
+}
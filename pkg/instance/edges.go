@@ -0,0 +1,133 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/dtmi"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+// Edge relates the Source TwinInstance to the Target TwinInstance through
+// one of the relationships of the source class.
+type Edge struct {
+	Source       string
+	Relationship string
+	Target       string
+}
+
+// ClassName returns the name of the class an instance selects, or an empty
+// string when its class reference is malformed.
+func ClassName(twinInstance *dtdlv1.TwinInstance) string {
+	ref, err := dtmi.ParseReference(twinInstance.Spec.Class.String())
+	if err != nil {
+		return ""
+	}
+	return ref.Name
+}
+
+// CheckEdges checks the edges of an instance against the other instances of
+// its namespace, indexed by name: every relationship must reach at least
+// minMultiplicity twins, and every target must exist and belong to one of
+// the classes the relationship allows. Missing targets are reported as
+// field.ErrorTypeNotFound and returned as dangling edges as well.
+func CheckEdges(class *model.ResolvedClass, twinInstance *dtdlv1.TwinInstance, instances map[string]*dtdlv1.TwinInstance) (field.ErrorList, []dtdlv1.DanglingEdge) {
+	errs := field.ErrorList{}
+	dangling := []dtdlv1.DanglingEdge{}
+
+	edges := map[string][]dtdlv1.TwinInstanceEdge{}
+	paths := map[string]*field.Path{}
+	for i, relationship := range twinInstance.Spec.Relationships {
+		edges[relationship.Name] = relationship.Targets
+		paths[relationship.Name] = field.NewPath("spec", "relationships").Index(i).Child("targets")
+	}
+
+	for _, relationship := range class.Relationships {
+		path, ok := paths[relationship.Name]
+		if !ok {
+			if relationship.MinMultiplicity > 0 {
+				errs = append(errs, field.Required(field.NewPath("spec", "relationships"),
+					fmt.Sprintf("relationship %q needs at least %d targets", relationship.Name, relationship.MinMultiplicity)))
+			}
+			continue
+		}
+		if len(edges[relationship.Name]) < int(relationship.MinMultiplicity) {
+			errs = append(errs, field.Invalid(path, len(edges[relationship.Name]), fmt.Sprintf("must have at least %d items", relationship.MinMultiplicity)))
+		}
+
+		for j, edge := range edges[relationship.Name] {
+			target, ok := instances[edge.Name]
+			if !ok {
+				errs = append(errs, field.NotFound(path.Index(j).Child("name"), edge.Name))
+				dangling = append(dangling, dtdlv1.DanglingEdge{Relationship: relationship.Name, Target: edge.Name})
+				continue
+			}
+			targetClass := ClassName(target)
+			if targetClass != "" && len(relationship.Targets) > 0 && indexOf(relationship.Targets, targetClass) < 0 {
+				errs = append(errs, field.Invalid(path.Index(j).Child("name"), edge.Name,
+					fmt.Sprintf("is a %s, not a %s", targetClass, strings.Join(relationship.Targets, " or "))))
+			}
+		}
+	}
+	return errs, dangling
+}
+
+// IncomingEdges returns the edges of instances leading to the named
+// instance, in the order of instances. Edges of an instance to itself are
+// left out.
+func IncomingEdges(instances []dtdlv1.TwinInstance, target string) []Edge {
+	incoming := []Edge{}
+	for _, source := range instances {
+		if source.Name == target {
+			continue
+		}
+		for _, relationship := range source.Spec.Relationships {
+			for _, edge := range relationship.Targets {
+				if edge.Name == target {
+					incoming = append(incoming, Edge{Source: source.Name, Relationship: relationship.Name, Target: target})
+				}
+			}
+		}
+	}
+	return incoming
+}
+
+// RemoveEdge removes the edge to target from a relationship of spec,
+// reporting whether it was present.
+func RemoveEdge(spec *dtdlv1.TwinInstanceSpec, relationship, target string) bool {
+	removed := false
+	for i := range spec.Relationships {
+		if spec.Relationships[i].Name != relationship {
+			continue
+		}
+		kept := []dtdlv1.TwinInstanceEdge{}
+		for _, edge := range spec.Relationships[i].Targets {
+			if edge.Name == target {
+				removed = true
+				continue
+			}
+			kept = append(kept, edge)
+		}
+		spec.Relationships[i].Targets = kept
+	}
+	return removed
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+func twin(name, class string, relationships ...dtdlv1.TwinInstanceRelationship) dtdlv1.TwinInstance {
+	return dtdlv1.TwinInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: dtdlv1.TwinInstanceSpec{
+			Class:         dtdlv1.TwinClassReference{Name: class},
+			Relationships: relationships,
+		},
+	}
+}
+
+func edges(relationship string, targets ...string) dtdlv1.TwinInstanceRelationship {
+	r := dtdlv1.TwinInstanceRelationship{Name: relationship}
+	for _, target := range targets {
+		r.Targets = append(r.Targets, dtdlv1.TwinInstanceEdge{Name: target})
+	}
+	return r
+}
+
+var _ = Describe("CheckEdges", func() {
+	var class *model.ResolvedClass
+	var instances map[string]*dtdlv1.TwinInstance
+
+	BeforeEach(func() {
+		classes := []dtdlv0.TwinClass{{Spec: dtdlv0.TwinClassSpec{
+			Name: "Factory",
+			Relationships: []dtdlv0.TwinRelationship{
+				{Name: "machines", Multiplicity: dtdlv0.MANY, Reference: "Machine"},
+				{Name: "supplies", MinMultiplicity: 1, Targets: []string{"Warehouse"}},
+			},
+		}}}
+		var err error
+		class, err = model.New(classes, nil).Resolve("Factory")
+		Expect(err).NotTo(HaveOccurred())
+
+		press := twin("press-1", "dtmi:acme:Machine;>=1")
+		robot := twin("robot-1", "Robot")
+		warehouse := twin("central", "Warehouse")
+		instances = map[string]*dtdlv1.TwinInstance{"press-1": &press, "robot-1": &robot, "central": &warehouse}
+	})
+
+	It("accepts existing targets of the allowed classes", func() {
+		factory := twin("plant", "Factory", edges("machines", "press-1"), edges("supplies", "central"))
+		errs, dangling := CheckEdges(class, &factory, instances)
+		Expect(errs).To(BeEmpty())
+		Expect(dangling).To(BeEmpty())
+	})
+
+	It("reports missing targets as dangling edges", func() {
+		factory := twin("plant", "Factory", edges("machines", "press-1", "press-2"), edges("supplies", "central"))
+		errs, dangling := CheckEdges(class, &factory, instances)
+		Expect(errs.ToAggregate()).To(MatchError(`spec.relationships[0].targets[1].name: Not found: "press-2"`))
+		Expect(dangling).To(Equal([]dtdlv1.DanglingEdge{{Relationship: "machines", Target: "press-2"}}))
+	})
+
+	It("reports targets of other classes", func() {
+		factory := twin("plant", "Factory", edges("machines", "robot-1"), edges("supplies", "central"))
+		errs, _ := CheckEdges(class, &factory, instances)
+		Expect(errs.ToAggregate()).To(MatchError(`spec.relationships[0].targets[0].name: Invalid value: "robot-1": is a Robot, not a Machine`))
+	})
+
+	It("reports relationships below their minMultiplicity", func() {
+		factory := twin("plant", "Factory", edges("machines", "press-1"))
+		errs, _ := CheckEdges(class, &factory, instances)
+		Expect(errs.ToAggregate()).To(MatchError(`spec.relationships: Required value: relationship "supplies" needs at least 1 targets`))
+
+		factory = twin("plant", "Factory", edges("supplies"))
+		errs, _ = CheckEdges(class, &factory, instances)
+		Expect(errs.ToAggregate()).To(MatchError(`spec.relationships[0].targets: Invalid value: 0: must have at least 1 items`))
+	})
+})
+
+var _ = Describe("IncomingEdges", func() {
+	It("finds the edges leading to an instance", func() {
+		instances := []dtdlv1.TwinInstance{
+			twin("plant", "Factory", edges("machines", "press-1", "press-2")),
+			twin("press-1", "Machine", edges("feeds", "press-2"), edges("self", "press-1")),
+			twin("press-2", "Machine", edges("feeds", "press-2")),
+		}
+		Expect(IncomingEdges(instances, "press-2")).To(Equal([]Edge{
+			{Source: "plant", Relationship: "machines", Target: "press-2"},
+			{Source: "press-1", Relationship: "feeds", Target: "press-2"},
+		}))
+	})
+})
+
+var _ = Describe("RemoveEdge", func() {
+	It("removes the edge from its relationship only", func() {
+		factory := twin("plant", "Factory", edges("machines", "press-1", "press-2"), edges("spares", "press-1"))
+		Expect(RemoveEdge(&factory.Spec, "machines", "press-1")).To(BeTrue())
+		Expect(factory.Spec.Relationships).To(Equal([]dtdlv1.TwinInstanceRelationship{
+			edges("machines", "press-2"),
+			edges("spares", "press-1"),
+		}))
+		Expect(RemoveEdge(&factory.Spec, "machines", "press-1")).To(BeFalse())
+	})
+})
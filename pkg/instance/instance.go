@@ -0,0 +1,256 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package instance checks twin instances against their classes.
+package instance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+// Validate checks the attribute values and relationships of an instance
+// against its resolved class. Values must match the schema of their
+// property, with enumeration values among the symbols of their enum, and
+// edges must belong to relationships of the class without exceeding their
+// maxMultiplicity. Numeric values of attributes with a unit may be given as
+// a quantity in another unit, which is normalised to the declared unit
+// before it is checked. Whether the related twins exist is checked by
+// CheckEdges. Errors carry the field path of the offending value within the
+// instance.
+func Validate(class *model.ResolvedClass, spec *dtdlv1.TwinInstanceSpec) field.ErrorList {
+	errs := field.ErrorList{}
+
+	attributes := map[string]*model.ResolvedAttribute{}
+	for i := range class.Attributes {
+		attributes[class.Attributes[i].Name] = &class.Attributes[i]
+	}
+	attributesPath := field.NewPath("spec", "attributes")
+	for _, name := range valueNames(spec.Attributes) {
+		path := attributesPath.Key(name)
+		attribute, ok := attributes[name]
+		if !ok {
+			errs = append(errs, field.Invalid(path, name, fmt.Sprintf("is not a property of twin class %q", class.Name)))
+			continue
+		}
+		if attribute.Kind == dtdlv0.Telemetry {
+			errs = append(errs, field.Forbidden(path, "telemetry is reported by the twin and cannot be set"))
+			continue
+		}
+		raw, err := Normalize(attribute, spec.Attributes[name].Raw)
+		if err != nil {
+			errs = append(errs, field.Invalid(path, string(spec.Attributes[name].Raw), err.Error()))
+			continue
+		}
+		errs = append(errs, validateJSON(&attribute.ResolvedSchema, apiextensionsv1.JSON{Raw: raw}, path)...)
+	}
+
+	relationships := map[string]*model.ResolvedRelationship{}
+	for i := range class.Relationships {
+		relationships[class.Relationships[i].Name] = &class.Relationships[i]
+	}
+	seen := map[string]bool{}
+	for i, instanceRelationship := range spec.Relationships {
+		path := field.NewPath("spec", "relationships").Index(i)
+		if seen[instanceRelationship.Name] {
+			errs = append(errs, field.Duplicate(path.Child("name"), instanceRelationship.Name))
+			continue
+		}
+		seen[instanceRelationship.Name] = true
+
+		relationship, ok := relationships[instanceRelationship.Name]
+		if !ok {
+			errs = append(errs, field.Invalid(path.Child("name"), instanceRelationship.Name, fmt.Sprintf("is not a relationship of twin class %q", class.Name)))
+			continue
+		}
+		errs = append(errs, validateEdges(relationship, instanceRelationship.Targets, path.Child("targets"))...)
+	}
+	return errs
+}
+
+func validateEdges(relationship *model.ResolvedRelationship, edges []dtdlv1.TwinInstanceEdge, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	properties := &model.ResolvedSchema{Type: dtdlv0.Object, Fields: relationship.Properties}
+	if relationship.MaxMultiplicity > 0 && len(edges) > int(relationship.MaxMultiplicity) {
+		errs = append(errs, field.TooMany(path, len(edges), int(relationship.MaxMultiplicity)))
+	}
+
+	targets := map[string]bool{}
+	for j, edge := range edges {
+		edgePath := path.Index(j)
+		if edge.Name == "" {
+			errs = append(errs, field.Required(edgePath.Child("name"), ""))
+		} else if targets[edge.Name] {
+			errs = append(errs, field.Duplicate(edgePath.Child("name"), edge.Name))
+		}
+		targets[edge.Name] = true
+
+		for _, name := range valueNames(edge.Properties) {
+			errs = append(errs, validateField(properties, name, edge.Properties[name], edgePath.Child("properties").Key(name))...)
+		}
+	}
+	return errs
+}
+
+// validateField checks the value of the named field of an object schema.
+func validateField(object *model.ResolvedSchema, name string, value apiextensionsv1.JSON, path *field.Path) field.ErrorList {
+	schema := fieldSchema(object, name)
+	if schema == nil {
+		return field.ErrorList{field.Invalid(path, name, "is not a declared field")}
+	}
+	return validateJSON(schema, value, path)
+}
+
+func fieldSchema(object *model.ResolvedSchema, name string) *model.ResolvedSchema {
+	for i := range object.Fields {
+		if object.Fields[i].Name == name {
+			return &object.Fields[i].ResolvedSchema
+		}
+	}
+	return nil
+}
+
+func validateJSON(schema *model.ResolvedSchema, value apiextensionsv1.JSON, path *field.Path) field.ErrorList {
+	decoder := json.NewDecoder(bytes.NewReader(value.Raw))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return field.ErrorList{field.Invalid(path, string(value.Raw), "is not valid JSON")}
+	}
+	return validateValue(schema, decoded, path)
+}
+
+// validateValue checks a decoded JSON value against schema. Object fields
+// are optional, but unknown fields are rejected, as are enumeration values
+// whose twin enum did not resolve.
+func validateValue(schema *model.ResolvedSchema, value interface{}, path *field.Path) field.ErrorList {
+	if value == nil {
+		return field.ErrorList{field.Invalid(path, value, "must not be null")}
+	}
+
+	mismatch := func() field.ErrorList {
+		return field.ErrorList{field.Invalid(path, value, fmt.Sprintf("must be of type %q", schema.Type))}
+	}
+
+	switch schema.Type {
+	case dtdlv0.Integer:
+		number, ok := value.(json.Number)
+		if !ok {
+			return mismatch()
+		}
+		if _, err := strconv.ParseInt(number.String(), 10, 64); err != nil {
+			return mismatch()
+		}
+	case dtdlv0.Double:
+		if _, ok := value.(json.Number); !ok {
+			return mismatch()
+		}
+	case dtdlv0.Boolean:
+		if _, ok := value.(bool); !ok {
+			return mismatch()
+		}
+	case dtdlv0.String:
+		if _, ok := value.(string); !ok {
+			return mismatch()
+		}
+	case dtdlv0.Enumeration:
+		symbol, ok := value.(string)
+		if !ok {
+			return mismatch()
+		}
+		if schema.Enum == nil {
+			return field.ErrorList{field.Invalid(path, symbol, "the twin enum of the attribute is not resolved")}
+		}
+		if indexOf(schema.Enum.Spec.Values, symbol) < 0 {
+			return field.ErrorList{field.NotSupported(path, symbol, schema.Enum.Spec.Values)}
+		}
+	case dtdlv0.Array:
+		items, ok := value.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		errs := field.ErrorList{}
+		for i, item := range items {
+			errs = append(errs, validateValue(schema.Items, item, path.Index(i))...)
+		}
+		return errs
+	case dtdlv0.Map:
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		errs := field.ErrorList{}
+		for _, key := range sortedKeys(values) {
+			errs = append(errs, validateValue(schema.Values, values[key], path.Key(key))...)
+		}
+		return errs
+	case dtdlv0.Object:
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		errs := field.ErrorList{}
+		for _, name := range sortedKeys(fields) {
+			fieldType := fieldSchema(schema, name)
+			if fieldType == nil {
+				errs = append(errs, field.Invalid(path.Child(name), name, "is not a declared field"))
+				continue
+			}
+			errs = append(errs, validateValue(fieldType, fields[name], path.Child(name))...)
+		}
+		return errs
+	}
+	return nil
+}
+
+// valueNames returns the names of a set of values in order, so that errors
+// are reported deterministically.
+func valueNames(values map[string]apiextensionsv1.JSON) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+func value(raw string) apiextensionsv1.JSON {
+	return apiextensionsv1.JSON{Raw: []byte(raw)}
+}
+
+var _ = Describe("Validate", func() {
+	var class *model.ResolvedClass
+
+	BeforeEach(func() {
+		enums := []dtdlv0.TwinEnum{{Spec: dtdlv0.TwinEnumSpec{Name: "State", Values: []string{"idle", "busy"}}}}
+		one := int32(1)
+		classes := []dtdlv0.TwinClass{{Spec: dtdlv0.TwinClassSpec{
+			Name: "Machine",
+			Attributes: []dtdlv0.TwinClassAttributes{
+				{Name: "temperature", Kind: dtdlv0.Telemetry, TwinSchema: dtdlv0.TwinSchema{Type: "double"}},
+				{Name: "serial", TwinSchema: dtdlv0.TwinSchema{Type: "string"}},
+				{Name: "spindles", TwinSchema: dtdlv0.TwinSchema{Type: "integer"}},
+				{Name: "reach", TwinSchema: dtdlv0.TwinSchema{Type: "double"}, SemanticType: "Distance", Unit: "metre"},
+				{Name: "state", TwinSchema: dtdlv0.TwinSchema{Type: "enumeration", Reference: "State"}},
+				{Name: "position", TwinSchema: dtdlv0.TwinSchema{Type: "object", Fields: []dtdlv0.TwinSchemaField{
+					{Name: "x", TwinSchema: dtdlv0.TwinSchema{Type: "double"}},
+					{Name: "y", TwinSchema: dtdlv0.TwinSchema{Type: "double"}},
+				}}},
+				{Name: "loads", TwinSchema: dtdlv0.TwinSchema{Type: "map", Values: &dtdlv0.TwinSchema{
+					Type: "array", Items: &dtdlv0.TwinSchema{Type: "boolean"},
+				}}},
+			},
+			Relationships: []dtdlv0.TwinRelationship{
+				{Name: "installedIn", MaxMultiplicity: &one, Properties: []dtdlv0.TwinSchemaField{
+					{Name: "since", TwinSchema: dtdlv0.TwinSchema{Type: "string"}},
+				}},
+				{Name: "feeds"},
+			},
+		}}}
+
+		var err error
+		class, err = model.New(classes, enums).Resolve("Machine")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("accepts values matching their schemas", func() {
+		spec := &dtdlv1.TwinInstanceSpec{
+			Attributes: map[string]apiextensionsv1.JSON{
+				"serial":   value(`"M-1"`),
+				"spindles": value(`4`),
+				"reach":    value(`{"value": 1.5, "unit": "kilometre"}`),
+				"state":    value(`"busy"`),
+				"position": value(`{"x": 1.5}`),
+				"loads":    value(`{"front": [true, false]}`),
+			},
+			Relationships: []dtdlv1.TwinInstanceRelationship{
+				{Name: "installedIn", Targets: []dtdlv1.TwinInstanceEdge{
+					{Name: "plant-1", Properties: map[string]apiextensionsv1.JSON{"since": value(`"2022-01-01"`)}},
+				}},
+				{Name: "feeds", Targets: []dtdlv1.TwinInstanceEdge{{Name: "press-1"}, {Name: "press-2"}}},
+			},
+		}
+		Expect(Validate(class, spec)).To(BeEmpty())
+	})
+
+	DescribeTable("reports invalid attribute values with their field path",
+		func(name, raw, message string) {
+			spec := &dtdlv1.TwinInstanceSpec{Attributes: map[string]apiextensionsv1.JSON{name: value(raw)}}
+			Expect(Validate(class, spec).ToAggregate()).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("unknown property", "color", `"red"`,
+			`spec.attributes[color]: Invalid value: "color": is not a property of twin class "Machine"`),
+		Entry("telemetry", "temperature", `20`,
+			`spec.attributes[temperature]: Forbidden: telemetry is reported by the twin and cannot be set`),
+		Entry("string for integer", "spindles", `"4"`,
+			`spec.attributes[spindles]: Invalid value: "4": must be of type "integer"`),
+		Entry("fraction for integer", "spindles", `4.5`,
+			`spec.attributes[spindles]: Invalid value: 4.5: must be of type "integer"`),
+		Entry("symbol outside the enum", "state", `"broken"`,
+			`spec.attributes[state]: Unsupported value: "broken": supported values: "idle", "busy"`),
+		Entry("undeclared object field", "position", `{"z": 1}`,
+			`spec.attributes[position].z: Invalid value: "z": is not a declared field`),
+		Entry("nested mismatch", "loads", `{"front": [true, 1]}`,
+			`spec.attributes[loads][front][1]: Invalid value: 1: must be of type "boolean"`),
+		Entry("quantity in a unit of another kind", "reach", `{"value": 1, "unit": "second"}`,
+			`spec.attributes[reach]: Invalid value: "{\"value\": 1, \"unit\": \"second\"}": cannot convert second (TimeUnit) to metre (LengthUnit)`),
+		Entry("quantity in an unknown unit", "reach", `{"value": 1, "unit": "kelvinish"}`,
+			`unknown unit "kelvinish"`),
+		Entry("null", "serial", `null`,
+			`spec.attributes[serial]: Invalid value: "null": must not be null`),
+	)
+
+	It("rejects enumeration values when the twin enum is not resolved", func() {
+		schema := &model.ResolvedSchema{Type: dtdlv0.Enumeration}
+		errs := validateValue(schema, "busy", field.NewPath("spec", "attributes").Key("state"))
+		Expect(errs.ToAggregate()).To(MatchError(`spec.attributes[state]: Invalid value: "busy": the twin enum of the attribute is not resolved`))
+	})
+
+	It("normalises quantities to the declared unit", func() {
+		for _, attribute := range class.Attributes {
+			if attribute.Name == "reach" {
+				Expect(Normalize(&attribute, []byte(`{"value": 1.5, "unit": "km"}`))).To(BeEquivalentTo("1500"))
+				Expect(Normalize(&attribute, []byte(`21.5`))).To(BeEquivalentTo("21.5"))
+			}
+		}
+	})
+
+	DescribeTable("reports invalid relationships with their field path",
+		func(relationships []dtdlv1.TwinInstanceRelationship, message string) {
+			spec := &dtdlv1.TwinInstanceSpec{Relationships: relationships}
+			Expect(Validate(class, spec).ToAggregate()).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("unknown relationship",
+			[]dtdlv1.TwinInstanceRelationship{{Name: "owns"}},
+			`spec.relationships[0].name: Invalid value: "owns": is not a relationship of twin class "Machine"`),
+		Entry("duplicate relationship",
+			[]dtdlv1.TwinInstanceRelationship{{Name: "feeds"}, {Name: "feeds"}},
+			`spec.relationships[1].name: Duplicate value: "feeds"`),
+		Entry("too many targets",
+			[]dtdlv1.TwinInstanceRelationship{{Name: "installedIn", Targets: []dtdlv1.TwinInstanceEdge{{Name: "plant-1"}, {Name: "plant-2"}}}},
+			`spec.relationships[0].targets: Too many: 2: must have at most 1 items`),
+		Entry("duplicate target",
+			[]dtdlv1.TwinInstanceRelationship{{Name: "feeds", Targets: []dtdlv1.TwinInstanceEdge{{Name: "press-1"}, {Name: "press-1"}}}},
+			`spec.relationships[0].targets[1].name: Duplicate value: "press-1"`),
+		Entry("invalid edge property",
+			[]dtdlv1.TwinInstanceRelationship{{Name: "installedIn", Targets: []dtdlv1.TwinInstanceEdge{
+				{Name: "plant-1", Properties: map[string]apiextensionsv1.JSON{"since": value(`2022`)}},
+			}}},
+			`spec.relationships[0].targets[0].properties[since]: Invalid value: 2022: must be of type "string"`),
+		Entry("undeclared edge property",
+			[]dtdlv1.TwinInstanceRelationship{{Name: "feeds", Targets: []dtdlv1.TwinInstanceEdge{
+				{Name: "press-1", Properties: map[string]apiextensionsv1.JSON{"rate": value(`1`)}},
+			}}},
+			`spec.relationships[0].targets[0].properties[rate]: Invalid value: "rate": is not a declared field`),
+	)
+})
@@ -0,0 +1,268 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+// InverseEdgesAnnotation records, on the twin holding them, the edges of
+// relationships with an inverse that were last seen on both sides, as a
+// JSON object mapping relationship names to target names. It tells an edge
+// added on one side from an edge removed from the other.
+const InverseEdgesAnnotation = "dtdl.digitaltwin/inverse-edges"
+
+// InverseSync is the outcome of SyncInverses.
+type InverseSync struct {
+	// Source is the updated source instance, or nil when it is unchanged.
+	Source *dtdlv1.TwinInstance
+	// Targets are the updated target instances, sorted by name.
+	Targets []*dtdlv1.TwinInstance
+	// Conflicts describe the edges that could not be mirrored.
+	Conflicts []string
+}
+
+// SyncInverses mirrors the edges of the relationships of source declaring
+// an inverse. An edge present on one side only is added to the other side,
+// unless it was recorded in InverseEdgesAnnotation, in which case it was
+// removed from the other side and is removed here as well. Edges to twins
+// that do not exist are left alone. instances holds the instances of the
+// namespace by name; classOf resolves the class of an instance, returning
+// nil when it does not resolve.
+func SyncInverses(class *model.ResolvedClass, source *dtdlv1.TwinInstance, instances map[string]*dtdlv1.TwinInstance, classOf func(*dtdlv1.TwinInstance) *model.ResolvedClass) (*InverseSync, error) {
+	synced, err := syncedEdges(source)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &InverseSync{}
+	updatedSource := source.DeepCopy()
+	updatedTargets := map[string]*dtdlv1.TwinInstance{}
+	target := func(name string) *dtdlv1.TwinInstance {
+		if updated, ok := updatedTargets[name]; ok {
+			return updated
+		}
+		return instances[name]
+	}
+	edit := func(name string) *dtdlv1.TwinInstance {
+		if _, ok := updatedTargets[name]; !ok {
+			updatedTargets[name] = instances[name].DeepCopy()
+		}
+		return updatedTargets[name]
+	}
+
+	nowSynced := map[string][]string{}
+	for _, relationship := range class.Relationships {
+		if relationship.Inverse == "" {
+			continue
+		}
+		wasSynced := synced[relationship.Name]
+
+		for _, name := range candidates(relationship, source, instances, wasSynced) {
+			other := target(name)
+			if other == nil || !other.DeletionTimestamp.IsZero() {
+				continue
+			}
+			// Recorded edges are kept while the other side cannot be
+			// checked, so that they are not mistaken for new ones later.
+			recorded := indexOf(wasSynced, name) >= 0
+			keep := func() {
+				if recorded {
+					nowSynced[relationship.Name] = append(nowSynced[relationship.Name], name)
+				}
+			}
+			otherClass := classOf(other)
+			if otherClass == nil {
+				keep()
+				continue
+			}
+			if len(relationship.Targets) > 0 && indexOf(relationship.Targets, otherClass.Name) < 0 {
+				continue
+			}
+			inverse := findRelationship(otherClass, relationship.Inverse)
+			if inverse == nil {
+				result.Conflicts = append(result.Conflicts, fmt.Sprintf("twin class %q of %s has no relationship %q", otherClass.Name, name, relationship.Inverse))
+				keep()
+				continue
+			}
+
+			forward := hasEdge(&updatedSource.Spec, relationship.Name, name)
+			backward := hasEdge(&other.Spec, inverse.Name, source.Name)
+			switch {
+			case forward && backward:
+				nowSynced[relationship.Name] = append(nowSynced[relationship.Name], name)
+			case forward && recorded:
+				RemoveEdge(&updatedSource.Spec, relationship.Name, name)
+			case forward:
+				if conflict := full(inverse, &other.Spec, name); conflict != "" {
+					result.Conflicts = append(result.Conflicts, conflict)
+					continue
+				}
+				AddEdge(&edit(name).Spec, inverse.Name, source.Name)
+				nowSynced[relationship.Name] = append(nowSynced[relationship.Name], name)
+			case backward && recorded:
+				RemoveEdge(&edit(name).Spec, inverse.Name, source.Name)
+			case backward:
+				if conflict := full(&relationship, &updatedSource.Spec, source.Name); conflict != "" {
+					result.Conflicts = append(result.Conflicts, conflict)
+					continue
+				}
+				AddEdge(&updatedSource.Spec, relationship.Name, name)
+				nowSynced[relationship.Name] = append(nowSynced[relationship.Name], name)
+			}
+		}
+	}
+
+	annotation, err := json.Marshal(nowSynced)
+	if err != nil {
+		return nil, err
+	}
+	if len(nowSynced) == 0 {
+		delete(updatedSource.Annotations, InverseEdgesAnnotation)
+	} else {
+		if updatedSource.Annotations == nil {
+			updatedSource.Annotations = map[string]string{}
+		}
+		updatedSource.Annotations[InverseEdgesAnnotation] = string(annotation)
+	}
+	if !equalInstances(source, updatedSource) {
+		result.Source = updatedSource
+	}
+
+	names := make([]string, 0, len(updatedTargets))
+	for name := range updatedTargets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result.Targets = append(result.Targets, updatedTargets[name])
+	}
+	return result, nil
+}
+
+// candidates returns the names of the twins that may share an edge with
+// source through relationship: its targets, the recorded edges and the
+// twins relating back to it through the inverse.
+func candidates(relationship model.ResolvedRelationship, source *dtdlv1.TwinInstance, instances map[string]*dtdlv1.TwinInstance, recorded []string) []string {
+	names := map[string]bool{}
+	for _, name := range recorded {
+		names[name] = true
+	}
+	for _, rel := range source.Spec.Relationships {
+		if rel.Name == relationship.Name {
+			for _, edge := range rel.Targets {
+				names[edge.Name] = true
+			}
+		}
+	}
+	for name, other := range instances {
+		if name != source.Name && hasEdge(&other.Spec, relationship.Inverse, source.Name) {
+			names[name] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// full describes the conflict of adding an edge to a relationship already
+// holding its maxMultiplicity edges, or returns an empty string.
+func full(relationship *model.ResolvedRelationship, spec *dtdlv1.TwinInstanceSpec, holder string) string {
+	if relationship.MaxMultiplicity == 0 {
+		return ""
+	}
+	targets := []string{}
+	for _, rel := range spec.Relationships {
+		if rel.Name == relationship.Name {
+			for _, edge := range rel.Targets {
+				targets = append(targets, edge.Name)
+			}
+		}
+	}
+	if len(targets) < int(relationship.MaxMultiplicity) {
+		return ""
+	}
+	return fmt.Sprintf("relationship %q of %s already holds its maxMultiplicity of %d: %s", relationship.Name, holder, relationship.MaxMultiplicity, strings.Join(targets, ", "))
+}
+
+// AddEdge adds an edge to target to a relationship of spec, unless present.
+func AddEdge(spec *dtdlv1.TwinInstanceSpec, relationship, target string) {
+	if hasEdge(spec, relationship, target) {
+		return
+	}
+	for i := range spec.Relationships {
+		if spec.Relationships[i].Name == relationship {
+			spec.Relationships[i].Targets = append(spec.Relationships[i].Targets, dtdlv1.TwinInstanceEdge{Name: target})
+			return
+		}
+	}
+	spec.Relationships = append(spec.Relationships, dtdlv1.TwinInstanceRelationship{
+		Name:    relationship,
+		Targets: []dtdlv1.TwinInstanceEdge{{Name: target}},
+	})
+}
+
+func hasEdge(spec *dtdlv1.TwinInstanceSpec, relationship, target string) bool {
+	for _, rel := range spec.Relationships {
+		if rel.Name != relationship {
+			continue
+		}
+		for _, edge := range rel.Targets {
+			if edge.Name == target {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func findRelationship(class *model.ResolvedClass, name string) *model.ResolvedRelationship {
+	for i := range class.Relationships {
+		if class.Relationships[i].Name == name {
+			return &class.Relationships[i]
+		}
+	}
+	return nil
+}
+
+func equalInstances(a, b *dtdlv1.TwinInstance) bool {
+	return equality.Semantic.DeepEqual(a.Spec, b.Spec) && equality.Semantic.DeepEqual(a.Annotations, b.Annotations)
+}
+
+func syncedEdges(twinInstance *dtdlv1.TwinInstance) (map[string][]string, error) {
+	synced := map[string][]string{}
+	annotation, ok := twinInstance.Annotations[InverseEdgesAnnotation]
+	if !ok {
+		return synced, nil
+	}
+	if err := json.Unmarshal([]byte(annotation), &synced); err != nil {
+		return nil, fmt.Errorf("annotation %s: %w", InverseEdgesAnnotation, err)
+	}
+	return synced, nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+var _ = Describe("SyncInverses", func() {
+	var m *model.Model
+	var instances map[string]*dtdlv1.TwinInstance
+
+	classOf := func(twinInstance *dtdlv1.TwinInstance) *model.ResolvedClass {
+		resolved, err := m.Resolve(ClassName(twinInstance))
+		if err != nil {
+			return nil
+		}
+		return resolved
+	}
+	sync := func(name string) *InverseSync {
+		source := instances[name]
+		result, err := SyncInverses(classOf(source), source, instances, classOf)
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+	add := func(twinInstance dtdlv1.TwinInstance) {
+		instances[twinInstance.Name] = &twinInstance
+	}
+
+	BeforeEach(func() {
+		one := int32(1)
+		m = model.New([]dtdlv0.TwinClass{
+			{Spec: dtdlv0.TwinClassSpec{Name: "Factory", Relationships: []dtdlv0.TwinRelationship{
+				{Name: "machines", Targets: []string{"Machine"}, Inverse: "factory"},
+			}}},
+			{Spec: dtdlv0.TwinClassSpec{Name: "Machine", Relationships: []dtdlv0.TwinRelationship{
+				{Name: "factory", MaxMultiplicity: &one, Targets: []string{"Factory"}},
+			}}},
+		}, nil)
+		instances = map[string]*dtdlv1.TwinInstance{}
+		add(twin("m1", "Machine"))
+		add(twin("m2", "Machine"))
+	})
+
+	It("adds the inverse of new edges and records them", func() {
+		add(twin("a", "Factory", edges("machines", "m1", "m2")))
+		result := sync("a")
+
+		Expect(result.Conflicts).To(BeEmpty())
+		Expect(result.Targets).To(HaveLen(2))
+		Expect(result.Targets[0].Spec.Relationships).To(Equal([]dtdlv1.TwinInstanceRelationship{edges("factory", "a")}))
+		Expect(result.Targets[1].Name).To(Equal("m2"))
+		Expect(result.Source.Annotations).To(HaveKeyWithValue(InverseEdgesAnnotation, `{"machines":["m1","m2"]}`))
+	})
+
+	It("adds edges created on the inverse side", func() {
+		add(twin("a", "Factory"))
+		add(twin("m1", "Machine", edges("factory", "a")))
+		result := sync("a")
+
+		Expect(result.Targets).To(BeEmpty())
+		Expect(result.Source.Spec.Relationships).To(Equal([]dtdlv1.TwinInstanceRelationship{edges("machines", "m1")}))
+	})
+
+	It("removes the inverse of removed edges", func() {
+		add(twin("a", "Factory"))
+		instances["a"].Annotations = map[string]string{InverseEdgesAnnotation: `{"machines":["m1"]}`}
+		add(twin("m1", "Machine", edges("factory", "a")))
+		result := sync("a")
+
+		Expect(result.Targets).To(HaveLen(1))
+		Expect(result.Targets[0].Spec.Relationships).To(Equal([]dtdlv1.TwinInstanceRelationship{{Name: "factory", Targets: []dtdlv1.TwinInstanceEdge{}}}))
+		Expect(result.Source.Annotations).NotTo(HaveKey(InverseEdgesAnnotation))
+	})
+
+	It("removes edges whose inverse was removed", func() {
+		add(twin("a", "Factory", edges("machines", "m1")))
+		instances["a"].Annotations = map[string]string{InverseEdgesAnnotation: `{"machines":["m1"]}`}
+		result := sync("a")
+
+		Expect(result.Targets).To(BeEmpty())
+		Expect(result.Source.Spec.Relationships).To(Equal([]dtdlv1.TwinInstanceRelationship{{Name: "machines", Targets: []dtdlv1.TwinInstanceEdge{}}}))
+	})
+
+	It("leaves consistent edges alone", func() {
+		add(twin("a", "Factory", edges("machines", "m1")))
+		instances["a"].Annotations = map[string]string{InverseEdgesAnnotation: `{"machines":["m1"]}`}
+		add(twin("m1", "Machine", edges("factory", "a")))
+		result := sync("a")
+
+		Expect(result.Source).To(BeNil())
+		Expect(result.Targets).To(BeEmpty())
+	})
+
+	It("reports a second source of an inverse one relationship", func() {
+		add(twin("a", "Factory", edges("machines", "m1")))
+		add(twin("b", "Factory", edges("machines", "m1")))
+		add(twin("m1", "Machine", edges("factory", "a")))
+		result := sync("b")
+
+		Expect(result.Targets).To(BeEmpty())
+		Expect(result.Conflicts).To(ConsistOf(`relationship "factory" of m1 already holds its maxMultiplicity of 1: a`))
+	})
+
+	It("leaves edges to missing twins alone", func() {
+		add(twin("a", "Factory", edges("machines", "m3")))
+		instances["a"].Annotations = map[string]string{InverseEdgesAnnotation: `{"machines":["m3"]}`}
+		result := sync("a")
+
+		Expect(result.Source.Spec).To(Equal(instances["a"].Spec))
+		Expect(result.Source.Annotations).NotTo(HaveKey(InverseEdgesAnnotation))
+	})
+})
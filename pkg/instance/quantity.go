@@ -0,0 +1,66 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instance
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/units"
+)
+
+// quantity is a number given together with its unit, such as
+// {"value": 70, "unit": "degreeFahrenheit"}.
+type quantity struct {
+	Value *json.Number `json:"value"`
+	Unit  string       `json:"unit"`
+}
+
+// Normalize converts a value of a numeric attribute with a declared unit,
+// given as a quantity in another unit of the same kind, to a plain number in
+// the declared unit. Other values are returned unchanged, for Validate to
+// check against the schema. Units that are unknown or of another kind are
+// an error.
+func Normalize(attribute *model.ResolvedAttribute, raw []byte) ([]byte, error) {
+	if attribute.Unit == "" || (attribute.Type != dtdlv0.Integer && attribute.Type != dtdlv0.Double) {
+		return raw, nil
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		return raw, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	var q quantity
+	if err := decoder.Decode(&q); err != nil || q.Value == nil {
+		return raw, nil
+	}
+	value, err := q.Value.Float64()
+	if err != nil {
+		return raw, nil
+	}
+
+	normalized, err := units.Normalize(value, q.Unit, attribute.Unit)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strconv.FormatFloat(normalized, 'f', -1, 64)), nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+)
+
+// ClassesUsingEnum returns the classes whose resolution depends on the named
+// enum: those referencing it from any schema, and those embedding such a
+// class. The spec is inspected as written, so classes that fail to resolve
+// are included. Classes are sorted by name and version.
+func (m *Model) ClassesUsingEnum(enum string) []*dtdlv0.TwinClass {
+	return m.dependents(func(class *dtdlv0.TwinClass) bool {
+		return referencesEnum(class, enum)
+	})
+}
+
+// ClassesEmbedding returns the classes embedding the named class, directly
+// or through other components, sorted by name and version.
+func (m *Model) ClassesEmbedding(name string) []*dtdlv0.TwinClass {
+	return m.dependents(func(class *dtdlv0.TwinClass) bool {
+		return embeds(class, name)
+	})
+}
+
+// ClassesWithInverseTo returns the classes with a relationship to the named
+// class declaring an inverse, whose resolution checks that the named class
+// declares it, and the classes embedding them, sorted by name and version.
+func (m *Model) ClassesWithInverseTo(name string) []*dtdlv0.TwinClass {
+	return m.dependents(func(class *dtdlv0.TwinClass) bool {
+		for _, relationship := range class.Spec.Relationships {
+			if relationship.Inverse != "" && indexOf(relationship.AllowedTargets(), name) >= 0 {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// dependents returns the classes matching direct and the classes embedding
+// them transitively. Every version of a matching class is included.
+func (m *Model) dependents(direct func(*dtdlv0.TwinClass) bool) []*dtdlv0.TwinClass {
+	classes := []*dtdlv0.TwinClass{}
+	for _, latest := range m.Classes() {
+		classes = append(classes, m.Versions(latest.Spec.Name)...)
+	}
+	found := map[string]bool{}
+	queue := []string{}
+	for _, class := range classes {
+		if direct(class) {
+			found[class.Spec.Name] = true
+			queue = append(queue, class.Spec.Name)
+		}
+	}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, class := range classes {
+			if !found[class.Spec.Name] && embeds(class, name) {
+				found[class.Spec.Name] = true
+				queue = append(queue, class.Spec.Name)
+			}
+		}
+	}
+
+	result := []*dtdlv0.TwinClass{}
+	for _, class := range classes {
+		if found[class.Spec.Name] {
+			result = append(result, class)
+		}
+	}
+	return result
+}
+
+func embeds(class *dtdlv0.TwinClass, name string) bool {
+	for _, component := range class.Spec.Components {
+		if component.Class == name {
+			return true
+		}
+	}
+	return false
+}
+
+func referencesEnum(class *dtdlv0.TwinClass, enum string) bool {
+	for i := range class.Spec.Attributes {
+		if schemaReferences(&class.Spec.Attributes[i].TwinSchema, enum) {
+			return true
+		}
+	}
+	for _, command := range class.Spec.Commands {
+		for _, payload := range []*dtdlv0.TwinCommandPayload{command.Request, command.Response} {
+			if payload != nil && schemaReferences(&payload.TwinSchema, enum) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func schemaReferences(schema *dtdlv0.TwinSchema, enum string) bool {
+	if schema.Reference == enum {
+		return true
+	}
+	if schema.Items != nil && schemaReferences(schema.Items, enum) {
+		return true
+	}
+	if schema.Values != nil && schemaReferences(schema.Values, enum) {
+		return true
+	}
+	for i := range schema.Fields {
+		if schemaReferences(&schema.Fields[i].TwinSchema, enum) {
+			return true
+		}
+	}
+	return false
+}
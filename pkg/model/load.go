@@ -0,0 +1,219 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+// Manifests holds the twin resources decoded from a set of manifest files.
+type Manifests struct {
+	Classes []dtdlv0.TwinClass
+	Enums   []dtdlv0.TwinEnum
+}
+
+// Model builds a Model from the decoded classes and enums. It fails when
+// the manifests define the same class or enum name in several namespaces.
+func (m *Manifests) Model() (*Model, error) {
+	return Build(m.Classes, m.Enums)
+}
+
+// LoadFiles decodes the twin resources found in the given files and
+// directories. Directories are walked recursively for .yaml, .yml and .json
+// files; documents of other kinds are ignored.
+func LoadFiles(paths ...string) (*Manifests, error) {
+	manifests := &Manifests{}
+
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || !isManifestFile(file) {
+				return nil
+			}
+			return manifests.loadFile(file)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return manifests, nil
+}
+
+// Load lists the twin resources visible to the given reader. It fails when
+// they span namespaces defining the same class or enum name, which
+// LoadByNamespace keeps apart.
+func Load(ctx context.Context, reader client.Reader, opts ...client.ListOption) (*Model, error) {
+	classes := &dtdlv0.TwinClassList{}
+	if err := reader.List(ctx, classes, opts...); err != nil {
+		return nil, err
+	}
+
+	enums := &dtdlv0.TwinEnumList{}
+	if err := reader.List(ctx, enums, opts...); err != nil {
+		return nil, err
+	}
+
+	return Build(classes.Items, enums.Items)
+}
+
+// LoadByNamespace lists the twin resources of all namespaces and returns one
+// Model per namespace.
+func LoadByNamespace(ctx context.Context, reader client.Reader) (map[string]*Model, error) {
+	classes := &dtdlv0.TwinClassList{}
+	if err := reader.List(ctx, classes); err != nil {
+		return nil, err
+	}
+
+	enums := &dtdlv0.TwinEnumList{}
+	if err := reader.List(ctx, enums); err != nil {
+		return nil, err
+	}
+
+	manifests := map[string]*Manifests{}
+	forNamespace := func(namespace string) *Manifests {
+		if _, ok := manifests[namespace]; !ok {
+			manifests[namespace] = &Manifests{}
+		}
+		return manifests[namespace]
+	}
+	for _, class := range classes.Items {
+		m := forNamespace(class.Namespace)
+		m.Classes = append(m.Classes, class)
+	}
+	for _, enum := range enums.Items {
+		m := forNamespace(enum.Namespace)
+		m.Enums = append(m.Enums, enum)
+	}
+
+	models := map[string]*Model{}
+	for namespace, m := range manifests {
+		models[namespace] = New(m.Classes, m.Enums)
+	}
+	return models, nil
+}
+
+func isManifestFile(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+func (m *Manifests) loadFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(f))
+	for {
+		document, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if err := m.decode(document); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+}
+
+func (m *Manifests) decode(document []byte) error {
+	typeMeta := metav1.TypeMeta{}
+	if err := yaml.Unmarshal(document, &typeMeta); err != nil {
+		return err
+	}
+
+	switch typeMeta.GroupVersionKind().GroupVersion() {
+	case dtdlv0.GroupVersion:
+		return m.decodeV0(typeMeta.Kind, document)
+	case dtdlv1.GroupVersion:
+		return m.decodeV1(typeMeta.Kind, document)
+	}
+	return nil
+}
+
+func (m *Manifests) decodeV0(kind string, document []byte) error {
+	switch kind {
+	case "TwinClass":
+		class := dtdlv0.TwinClass{}
+		if err := yaml.UnmarshalStrict(document, &class); err != nil {
+			return err
+		}
+		m.Classes = append(m.Classes, class)
+	case "TwinEnum":
+		enum := dtdlv0.TwinEnum{}
+		if err := yaml.UnmarshalStrict(document, &enum); err != nil {
+			return err
+		}
+		m.Enums = append(m.Enums, enum)
+	}
+
+	return nil
+}
+
+// decodeV1 decodes v1 documents and converts them to v0, the version the
+// model works with.
+func (m *Manifests) decodeV1(kind string, document []byte) error {
+	switch kind {
+	case "TwinClass":
+		hub := &dtdlv1.TwinClass{}
+		if err := yaml.UnmarshalStrict(document, hub); err != nil {
+			return err
+		}
+		class := dtdlv0.TwinClass{}
+		if err := class.ConvertFrom(hub); err != nil {
+			return err
+		}
+		m.Classes = append(m.Classes, class)
+	case "TwinEnum":
+		hub := &dtdlv1.TwinEnum{}
+		if err := yaml.UnmarshalStrict(document, hub); err != nil {
+			return err
+		}
+		enum := dtdlv0.TwinEnum{}
+		if err := enum.ConvertFrom(hub); err != nil {
+			return err
+		}
+		m.Enums = append(m.Enums, enum)
+	}
+
+	return nil
+}
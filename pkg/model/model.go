@@ -0,0 +1,775 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package model indexes TwinClasses and TwinEnums and resolves classes into
+// the effective view used by the generators and controllers.
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/dtmi"
+	"github.com/agwermann/dt-operator/pkg/units"
+)
+
+// Model is an index of TwinClasses and TwinEnums keyed by their spec name.
+// Classes may come in several versions, told apart by the version of their
+// DTMI.
+type Model struct {
+	classes  map[string]*dtdlv0.TwinClass
+	versions map[string][]*dtdlv0.TwinClass
+	enums    map[string]*dtdlv0.TwinEnum
+}
+
+// ResolvedClass is a TwinClass with its attribute types resolved and its
+// components flattened: the contents of an embedded class appear in the
+// embedding class, named <slot>/<name>.
+type ResolvedClass struct {
+	Name          string
+	Attributes    []ResolvedAttribute
+	Relationships []ResolvedRelationship
+	Commands      []ResolvedCommand
+	Components    []dtdlv0.TwinComponent
+}
+
+// ComponentSeparator joins a component slot and the name of a content of the
+// embedded class. Content names cannot contain it.
+const ComponentSeparator = "/"
+
+// ResolvedAttribute is an attribute with its schema resolved.
+type ResolvedAttribute struct {
+	Name string
+	ResolvedSchema
+	Kind         dtdlv0.ContentKind
+	Writable     bool
+	SemanticType string
+	Unit         string
+	FieldNumber  int32
+}
+
+// ResolvedSchema is a checked TwinSchema. Enumerations carry the referenced
+// TwinEnum, arrays their Items, maps their Values and objects their Fields.
+type ResolvedSchema struct {
+	Type   dtdlv0.PrimitiveTypes
+	Enum   *dtdlv0.TwinEnum
+	Items  *ResolvedSchema
+	Values *ResolvedSchema
+	Fields []ResolvedField
+}
+
+// ResolvedField is a field of an object schema.
+type ResolvedField struct {
+	Name string
+	ResolvedSchema
+	FieldNumber int32
+}
+
+// Walk calls fn for s and every schema nested in it, parents first.
+func (s *ResolvedSchema) Walk(fn func(*ResolvedSchema)) {
+	fn(s)
+	if s.Items != nil {
+		s.Items.Walk(fn)
+	}
+	if s.Values != nil {
+		s.Values.Walk(fn)
+	}
+	for i := range s.Fields {
+		s.Fields[i].Walk(fn)
+	}
+}
+
+// ResolvedRelationship is a relationship with its cardinality settled and
+// its properties resolved. MaxMultiplicity is 0 when unbounded.
+type ResolvedRelationship struct {
+	Name            string
+	MinMultiplicity int32
+	MaxMultiplicity int32
+	Targets         []string
+	Properties      []ResolvedField
+	Inverse         string
+	OnDelete        dtdlv0.DeletionPolicy
+}
+
+// RelationshipTarget is the field holding the ID of the related twin in
+// edges carrying properties, numbered RelationshipTargetNumber. Relationship
+// properties cannot use either.
+const (
+	RelationshipTarget       = "target"
+	RelationshipTargetNumber = 1
+)
+
+// Many reports whether an instance can relate to more than one twin.
+func (r *ResolvedRelationship) Many() bool {
+	return r.MaxMultiplicity != 1
+}
+
+// Schema returns the schema of the relationship value: the ID of the
+// related twin, or an object holding it with the edge properties, wrapped
+// in an array when the relationship is many-valued.
+func (r *ResolvedRelationship) Schema() *ResolvedSchema {
+	edge := ResolvedSchema{Type: dtdlv0.String}
+	if len(r.Properties) > 0 {
+		edge = ResolvedSchema{
+			Type:   dtdlv0.Object,
+			Fields: append([]ResolvedField{{Name: RelationshipTarget, ResolvedSchema: edge, FieldNumber: RelationshipTargetNumber}}, r.Properties...),
+		}
+	}
+	if r.Many() {
+		return &ResolvedSchema{Type: dtdlv0.Array, Items: &edge}
+	}
+	return &edge
+}
+
+// ResolvedCommand is a command with its payload schemas resolved. Payloads
+// are represented as attributes without a content kind.
+type ResolvedCommand struct {
+	Name     string
+	Request  *ResolvedAttribute
+	Response *ResolvedAttribute
+}
+
+// Telemetry returns the telemetry attributes of the class.
+func (c *ResolvedClass) Telemetry() []ResolvedAttribute {
+	return c.attributesOfKind(dtdlv0.Telemetry)
+}
+
+// Properties returns the property attributes of the class.
+func (c *ResolvedClass) Properties() []ResolvedAttribute {
+	return c.attributesOfKind(dtdlv0.Property)
+}
+
+func (c *ResolvedClass) attributesOfKind(kind dtdlv0.ContentKind) []ResolvedAttribute {
+	attributes := []ResolvedAttribute{}
+	for _, attribute := range c.Attributes {
+		if attribute.Kind == kind {
+			attributes = append(attributes, attribute)
+		}
+	}
+	return attributes
+}
+
+// New indexes the given classes and enums. When several versions of a class
+// are given, its name refers to the latest one.
+func New(classes []dtdlv0.TwinClass, enums []dtdlv0.TwinEnum) *Model {
+	m := &Model{
+		classes:  map[string]*dtdlv0.TwinClass{},
+		versions: map[string][]*dtdlv0.TwinClass{},
+		enums:    map[string]*dtdlv0.TwinEnum{},
+	}
+	for i := range classes {
+		m.versions[classes[i].Spec.Name] = append(m.versions[classes[i].Spec.Name], &classes[i])
+	}
+	for name, versions := range m.versions {
+		sort.SliceStable(versions, func(i, j int) bool {
+			return Version(versions[i]) < Version(versions[j])
+		})
+		m.classes[name] = versions[len(versions)-1]
+	}
+	for i := range enums {
+		m.enums[enums[i].Spec.Name] = &enums[i]
+	}
+	return m
+}
+
+// Conflict is a class or enum name defined in several namespaces. Models
+// index classes and enums by spec name, so resources of one namespace would
+// silently replace those of the other.
+type Conflict struct {
+	Kind       string
+	Name       string
+	Namespaces []string
+}
+
+func (c Conflict) Error() string {
+	return fmt.Sprintf("%s %q is defined in namespaces %s, load one namespace at a time", c.Kind, c.Name, strings.Join(c.Namespaces, ", "))
+}
+
+// Conflicts returns the class and enum names defined in more than one
+// namespace, sorted by kind and name.
+func Conflicts(classes []dtdlv0.TwinClass, enums []dtdlv0.TwinEnum) []Conflict {
+	namespaces := map[[2]string]map[string]bool{}
+	add := func(kind, name, namespace string) {
+		key := [2]string{kind, name}
+		if namespaces[key] == nil {
+			namespaces[key] = map[string]bool{}
+		}
+		namespaces[key][namespace] = true
+	}
+	for i := range classes {
+		add("TwinClass", classes[i].Spec.Name, classes[i].Namespace)
+	}
+	for i := range enums {
+		add("TwinEnum", enums[i].Spec.Name, enums[i].Namespace)
+	}
+
+	conflicts := []Conflict{}
+	for key, set := range namespaces {
+		if len(set) < 2 {
+			continue
+		}
+		conflict := Conflict{Kind: key[0], Name: key[1]}
+		for namespace := range set {
+			conflict.Namespaces = append(conflict.Namespaces, strconv.Quote(namespace))
+		}
+		sort.Strings(conflict.Namespaces)
+		conflicts = append(conflicts, conflict)
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Kind != conflicts[j].Kind {
+			return conflicts[i].Kind < conflicts[j].Kind
+		}
+		return conflicts[i].Name < conflicts[j].Name
+	})
+	return conflicts
+}
+
+// Build indexes classes and enums like New, but fails when resources of
+// different namespaces share a spec name.
+func Build(classes []dtdlv0.TwinClass, enums []dtdlv0.TwinEnum) (*Model, error) {
+	if conflicts := Conflicts(classes, enums); len(conflicts) > 0 {
+		return nil, conflicts[0]
+	}
+	return New(classes, enums), nil
+}
+
+// Version returns the version of the class DTMI, or 0 when the class has no
+// valid ID.
+func Version(class *dtdlv0.TwinClass) int {
+	id, err := dtmi.Parse(class.Spec.ID)
+	if err != nil {
+		return 0
+	}
+	return id.Version
+}
+
+// Class looks up the latest version of a class by its spec name.
+func (m *Model) Class(name string) (*dtdlv0.TwinClass, bool) {
+	class, ok := m.classes[name]
+	return class, ok
+}
+
+// Enum looks up an enum by its spec name.
+func (m *Model) Enum(name string) (*dtdlv0.TwinEnum, bool) {
+	enum, ok := m.enums[name]
+	return enum, ok
+}
+
+// Versions returns the versions of the named class, oldest first.
+func (m *Model) Versions(name string) []*dtdlv0.TwinClass {
+	return m.versions[name]
+}
+
+// Select returns the latest class version matching a class reference, as
+// written in TwinService classes. References constraining the version or
+// naming a DTMI only match classes with an ID.
+func (m *Model) Select(reference string) (*dtdlv0.TwinClass, error) {
+	ref, err := dtmi.ParseReference(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := m.versions[ref.Name]
+	for i := len(versions) - 1; i >= 0; i-- {
+		class := versions[i]
+		if ref.Path == "" && len(ref.Constraints) == 0 {
+			return class, nil
+		}
+		id, err := dtmi.Parse(class.Spec.ID)
+		if err != nil {
+			continue
+		}
+		if (ref.Path == "" || ref.Path == id.Path) && ref.Matches(id.Version) {
+			return class, nil
+		}
+	}
+	return nil, fmt.Errorf("no twin class matches %q", reference)
+}
+
+// Classes returns the latest version of every class, sorted by name.
+func (m *Model) Classes() []*dtdlv0.TwinClass {
+	classes := make([]*dtdlv0.TwinClass, 0, len(m.classes))
+	for _, class := range m.classes {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		return classes[i].Spec.Name < classes[j].Spec.Name
+	})
+	return classes
+}
+
+// Enums returns all enums sorted by name.
+func (m *Model) Enums() []*dtdlv0.TwinEnum {
+	enums := make([]*dtdlv0.TwinEnum, 0, len(m.enums))
+	for _, enum := range m.enums {
+		enums = append(enums, enum)
+	}
+	sort.Slice(enums, func(i, j int) bool {
+		return enums[i].Spec.Name < enums[j].Spec.Name
+	})
+	return enums
+}
+
+// Resolve returns the effective view of the named class.
+func (m *Model) Resolve(name string) (*ResolvedClass, error) {
+	class, ok := m.classes[name]
+	if !ok {
+		return nil, fmt.Errorf("twin class %q not found", name)
+	}
+	return m.ResolveClass(class)
+}
+
+// MaxSchemaDepth is the number of levels arrays, maps and objects may nest.
+const MaxSchemaDepth = 5
+
+// nameSeparators cannot appear in content names: dots separate the segments
+// of field paths, slashes component slots, and the others are special in
+// MQTT topics.
+const nameSeparators = "./+#"
+
+var supportedTypes = []string{
+	string(dtdlv0.Integer),
+	string(dtdlv0.String),
+	string(dtdlv0.Boolean),
+	string(dtdlv0.Double),
+	string(dtdlv0.Enumeration),
+	string(dtdlv0.Array),
+	string(dtdlv0.Map),
+	string(dtdlv0.Object),
+}
+
+var deletionPolicies = []string{
+	string(dtdlv0.BlockDeletion),
+	string(dtdlv0.CascadeDeletion),
+	string(dtdlv0.NullifyEdge),
+}
+
+// ResolveClass returns the effective view of class, resolving its
+// references against the model and flattening its components. Errors carry
+// the field path of the offending value within the class.
+func (m *Model) ResolveClass(class *dtdlv0.TwinClass) (*ResolvedClass, error) {
+	resolved, errs := m.resolveClass(class, []string{class.Spec.Name})
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("twin class %q: %w", class.Spec.Name, errs.ToAggregate())
+	}
+	return resolved, nil
+}
+
+// Validate returns the errors ResolveClass would report for class. Errors
+// about enums and classes missing from the model have type
+// field.ErrorTypeNotFound, so that callers checking a class on its own can
+// filter them out.
+func (m *Model) Validate(class *dtdlv0.TwinClass) field.ErrorList {
+	_, errs := m.resolveClass(class, []string{class.Spec.Name})
+	return errs
+}
+
+// resolveClass resolves class, which is embedded along the chain of class
+// names in stack.
+func (m *Model) resolveClass(class *dtdlv0.TwinClass, stack []string) (*ResolvedClass, field.ErrorList) {
+	resolved := &ResolvedClass{
+		Name:       class.Spec.Name,
+		Components: class.Spec.Components,
+	}
+
+	specPath := field.NewPath("spec")
+	errs := validateID(class, specPath.Child("id"))
+	names := map[string]*field.Path{}
+	addName := func(name string, path *field.Path) {
+		errs = append(errs, validateName(name, path)...)
+		if previous, ok := names[name]; ok {
+			errs = append(errs, field.Invalid(path, name, "name is already used by "+previous.String()))
+			return
+		}
+		names[name] = path
+	}
+
+	for i, attribute := range class.Spec.Attributes {
+		path := specPath.Child("attributes").Index(i)
+		addName(attribute.Name, path.Child("name"))
+
+		attr, attrErrs := m.resolveAttribute(attribute, path)
+		errs = append(errs, attrErrs...)
+		resolved.Attributes = append(resolved.Attributes, attr)
+	}
+
+	for i, relationship := range class.Spec.Relationships {
+		path := specPath.Child("relationships").Index(i)
+		addName(relationship.Name, path.Child("name"))
+
+		rel, relErrs := m.resolveRelationship(relationship, path)
+		errs = append(errs, relErrs...)
+		resolved.Relationships = append(resolved.Relationships, rel)
+	}
+
+	for i, command := range class.Spec.Commands {
+		path := specPath.Child("commands").Index(i)
+		addName(command.Name, path.Child("name"))
+
+		cmd, cmdErrs := m.resolveCommand(command, path)
+		errs = append(errs, cmdErrs...)
+		resolved.Commands = append(resolved.Commands, cmd)
+	}
+
+	for i, component := range class.Spec.Components {
+		path := specPath.Child("components").Index(i)
+		addName(component.Name, path.Child("name"))
+
+		embedded, ok := m.classes[component.Class]
+		if !ok {
+			errs = append(errs, notFound(path.Child("class"), component.Class, "unknown twin class"))
+			continue
+		}
+		if cycle := indexOf(stack, component.Class); cycle >= 0 {
+			chain := append(append([]string(nil), stack[cycle:]...), component.Class)
+			errs = append(errs, field.Invalid(path.Child("class"), component.Class, "recursive composition "+strings.Join(chain, " -> ")))
+			continue
+		}
+
+		inner, innerErrs := m.resolveClass(embedded, append(append([]string(nil), stack...), component.Class))
+		if len(innerErrs) > 0 {
+			errs = append(errs, field.Invalid(path.Child("class"), component.Class, innerErrs.ToAggregate().Error()))
+			continue
+		}
+		resolved.embed(component.Name, inner)
+	}
+
+	return resolved, errs
+}
+
+// embed adds the contents of inner under slot. Field numbers pinned in the
+// embedded class apply to its own schemas and are not carried over.
+func (c *ResolvedClass) embed(slot string, inner *ResolvedClass) {
+	prefix := slot + ComponentSeparator
+	for _, attribute := range inner.Attributes {
+		attribute.Name = prefix + attribute.Name
+		attribute.FieldNumber = 0
+		c.Attributes = append(c.Attributes, attribute)
+	}
+	for _, relationship := range inner.Relationships {
+		relationship.Name = prefix + relationship.Name
+		c.Relationships = append(c.Relationships, relationship)
+	}
+	for _, command := range inner.Commands {
+		command.Name = prefix + command.Name
+		c.Commands = append(c.Commands, command)
+	}
+}
+
+func validateID(class *dtdlv0.TwinClass, path *field.Path) field.ErrorList {
+	if class.Spec.ID == "" {
+		return field.ErrorList{}
+	}
+	id, err := dtmi.Parse(class.Spec.ID)
+	if err != nil {
+		return field.ErrorList{field.Invalid(path, class.Spec.ID, err.Error())}
+	}
+	if id.Name() != class.Spec.Name {
+		return field.ErrorList{field.Invalid(path, class.Spec.ID, fmt.Sprintf("last segment must be the class name %q", class.Spec.Name))}
+	}
+	return field.ErrorList{}
+}
+
+// notFound is field.NotFound with a detail message.
+func notFound(path *field.Path, value interface{}, detail string) *field.Error {
+	err := field.NotFound(path, value)
+	err.Detail = detail
+	return err
+}
+
+func indexOf(names []string, name string) int {
+	for i := range names {
+		if names[i] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Model) resolveAttribute(attribute dtdlv0.TwinClassAttributes, path *field.Path) (ResolvedAttribute, field.ErrorList) {
+	attr := ResolvedAttribute{
+		Name:         attribute.Name,
+		Kind:         attribute.Kind,
+		Writable:     attribute.Writable,
+		SemanticType: attribute.SemanticType,
+		Unit:         attribute.Unit,
+		FieldNumber:  attribute.FieldNumber,
+	}
+
+	errs := field.ErrorList{}
+	if attr.Kind == "" {
+		attr.Kind = dtdlv0.Property
+	}
+	if attr.Kind == dtdlv0.Telemetry && attr.Writable {
+		errs = append(errs, field.Invalid(path.Child("writable"), true, "telemetry cannot be writable"))
+	}
+
+	var schemaErrs field.ErrorList
+	attr.ResolvedSchema, schemaErrs = m.resolveSchema(attribute.TwinSchema, path, 1)
+	errs = append(errs, schemaErrs...)
+	return attr, append(errs, validateUnit(attr, path)...)
+}
+
+// validateUnit checks the semantic type and unit of an attribute against
+// the DTDL vocabulary and against each other.
+func validateUnit(attr ResolvedAttribute, path *field.Path) field.ErrorList {
+	if attr.SemanticType == "" && attr.Unit == "" {
+		return nil
+	}
+
+	errs := field.ErrorList{}
+	if attr.Type != dtdlv0.Integer && attr.Type != dtdlv0.Double {
+		child, value := "unit", attr.Unit
+		if attr.SemanticType != "" {
+			child, value = "semanticType", attr.SemanticType
+		}
+		errs = append(errs, field.Invalid(path.Child(child), value, "only integer and double attributes can have a semantic type or unit"))
+	}
+
+	semanticType := attr.SemanticType
+	if semanticType != "" {
+		if _, ok := units.Lookup(semanticType); !ok {
+			errs = append(errs, field.Invalid(path.Child("semanticType"), semanticType, "unknown DTDL semantic type"))
+			semanticType = ""
+		}
+	}
+	if attr.Unit != "" {
+		if err := units.Check(semanticType, attr.Unit); err != nil {
+			errs = append(errs, field.Invalid(path.Child("unit"), attr.Unit, err.Error()))
+		}
+	}
+	return errs
+}
+
+func (m *Model) resolveRelationship(relationship dtdlv0.TwinRelationship, path *field.Path) (ResolvedRelationship, field.ErrorList) {
+	min, max := relationship.Cardinality()
+	rel := ResolvedRelationship{
+		Name:            relationship.Name,
+		MinMultiplicity: min,
+		MaxMultiplicity: max,
+		Targets:         relationship.AllowedTargets(),
+		Inverse:         relationship.Inverse,
+		OnDelete:        relationship.OnDelete,
+	}
+
+	errs := field.ErrorList{}
+	if relationship.MaxMultiplicity != nil {
+		maxPath := path.Child("maxMultiplicity")
+		switch {
+		case max < 1:
+			errs = append(errs, field.Invalid(maxPath, max, "must be at least 1"))
+		case relationship.Multiplicity == dtdlv0.ONE && max != 1,
+			relationship.Multiplicity == dtdlv0.MANY && max == 1:
+			errs = append(errs, field.Invalid(maxPath, max, fmt.Sprintf("contradicts multiplicity %q", relationship.Multiplicity)))
+		case min > max:
+			errs = append(errs, field.Invalid(path.Child("minMultiplicity"), min, fmt.Sprintf("exceeds maxMultiplicity %d", max)))
+		}
+	}
+	if min < 0 {
+		errs = append(errs, field.Invalid(path.Child("minMultiplicity"), min, "must not be negative"))
+	}
+
+	switch relationship.OnDelete {
+	case "", dtdlv0.BlockDeletion, dtdlv0.CascadeDeletion, dtdlv0.NullifyEdge:
+	default:
+		errs = append(errs, field.NotSupported(path.Child("onDelete"), relationship.OnDelete, deletionPolicies))
+	}
+
+	seen := map[string]bool{}
+	for i, target := range relationship.Targets {
+		targetPath := path.Child("targets").Index(i)
+		if target == "" {
+			errs = append(errs, field.Required(targetPath, ""))
+		} else if seen[target] {
+			errs = append(errs, field.Duplicate(targetPath, target))
+		}
+		seen[target] = true
+	}
+
+	if relationship.Inverse != "" {
+		errs = append(errs, m.validateInverse(relationship, path)...)
+	}
+
+	names := map[string]bool{}
+	for i, property := range relationship.Properties {
+		propertyPath := path.Child("properties").Index(i)
+		errs = append(errs, validateName(property.Name, propertyPath.Child("name"))...)
+		if property.Name == RelationshipTarget {
+			errs = append(errs, field.Invalid(propertyPath.Child("name"), property.Name, "is reserved for the ID of the related twin"))
+		} else if names[property.Name] {
+			errs = append(errs, field.Duplicate(propertyPath.Child("name"), property.Name))
+		}
+		names[property.Name] = true
+		if property.FieldNumber == RelationshipTargetNumber {
+			errs = append(errs, field.Invalid(propertyPath.Child("fieldNumber"), property.FieldNumber, "is reserved for the ID of the related twin"))
+		}
+
+		// Properties are fields of the edge object, one level down.
+		schema, schemaErrs := m.resolveSchema(property.TwinSchema, propertyPath, 2)
+		errs = append(errs, schemaErrs...)
+		rel.Properties = append(rel.Properties, ResolvedField{
+			Name:           property.Name,
+			ResolvedSchema: schema,
+			FieldNumber:    property.FieldNumber,
+		})
+	}
+
+	return rel, errs
+}
+
+// validateInverse checks that every target class of a relationship declares
+// its inverse. Target classes missing from the model are skipped, since
+// they may be created later.
+func (m *Model) validateInverse(relationship dtdlv0.TwinRelationship, path *field.Path) field.ErrorList {
+	inversePath := path.Child("inverse")
+	errs := validateName(relationship.Inverse, inversePath)
+	targets := relationship.AllowedTargets()
+	if len(targets) == 0 {
+		return append(errs, field.Required(path.Child("targets"), "relationships with an inverse must list their targets"))
+	}
+
+	for _, target := range targets {
+		class, ok := m.classes[target]
+		if !ok {
+			continue
+		}
+		inverse := findRelationship(class, relationship.Inverse)
+		if inverse == nil {
+			errs = append(errs, notFound(inversePath, relationship.Inverse, fmt.Sprintf("twin class %q has no such relationship", target)))
+		} else if inverse.Inverse != "" && inverse.Inverse != relationship.Name {
+			errs = append(errs, field.Invalid(inversePath, relationship.Inverse,
+				fmt.Sprintf("relationship of twin class %q is the inverse of %q", target, inverse.Inverse)))
+		}
+	}
+	return errs
+}
+
+func findRelationship(class *dtdlv0.TwinClass, name string) *dtdlv0.TwinRelationship {
+	for i := range class.Spec.Relationships {
+		if class.Spec.Relationships[i].Name == name {
+			return &class.Spec.Relationships[i]
+		}
+	}
+	return nil
+}
+
+func (m *Model) resolveCommand(command dtdlv0.TwinCommand, path *field.Path) (ResolvedCommand, field.ErrorList) {
+	cmd := ResolvedCommand{Name: command.Name}
+	errs := field.ErrorList{}
+
+	resolvePayload := func(payload *dtdlv0.TwinCommandPayload, path *field.Path) *ResolvedAttribute {
+		if payload == nil {
+			return nil
+		}
+		errs = append(errs, validateName(payload.Name, path.Child("name"))...)
+		schema, schemaErrs := m.resolveSchema(payload.TwinSchema, path, 1)
+		errs = append(errs, schemaErrs...)
+		return &ResolvedAttribute{Name: payload.Name, ResolvedSchema: schema}
+	}
+
+	cmd.Request = resolvePayload(command.Request, path.Child("request"))
+	cmd.Response = resolvePayload(command.Response, path.Child("response"))
+	return cmd, errs
+}
+
+func (m *Model) resolveSchema(schema dtdlv0.TwinSchema, path *field.Path, depth int) (ResolvedSchema, field.ErrorList) {
+	resolved := ResolvedSchema{Type: dtdlv0.PrimitiveTypes(schema.Type)}
+	errs := field.ErrorList{}
+
+	if depth > MaxSchemaDepth {
+		return resolved, append(errs, field.Forbidden(path, fmt.Sprintf("schemas cannot nest more than %d levels", MaxSchemaDepth)))
+	}
+
+	forbid := func(present bool, name string) {
+		if present {
+			errs = append(errs, field.Forbidden(path.Child(name), fmt.Sprintf("not allowed for type %q", schema.Type)))
+		}
+	}
+	forbid(schema.Reference != "" && resolved.Type != dtdlv0.Enumeration, "reference")
+	forbid(schema.Items != nil && resolved.Type != dtdlv0.Array, "items")
+	forbid(schema.Values != nil && resolved.Type != dtdlv0.Map, "values")
+	forbid(len(schema.Fields) > 0 && resolved.Type != dtdlv0.Object, "fields")
+
+	switch resolved.Type {
+	case dtdlv0.Integer, dtdlv0.String, dtdlv0.Boolean, dtdlv0.Double:
+	case dtdlv0.Enumeration:
+		enum, ok := m.enums[schema.Reference]
+		if !ok {
+			errs = append(errs, notFound(path.Child("reference"), schema.Reference, "unknown twin enum"))
+		}
+		resolved.Enum = enum
+	case dtdlv0.Array:
+		if schema.Items == nil {
+			errs = append(errs, field.Required(path.Child("items"), "arrays must declare the schema of their items"))
+			break
+		}
+		items, itemErrs := m.resolveSchema(*schema.Items, path.Child("items"), depth+1)
+		resolved.Items = &items
+		errs = append(errs, itemErrs...)
+	case dtdlv0.Map:
+		if schema.Values == nil {
+			errs = append(errs, field.Required(path.Child("values"), "maps must declare the schema of their values"))
+			break
+		}
+		values, valueErrs := m.resolveSchema(*schema.Values, path.Child("values"), depth+1)
+		resolved.Values = &values
+		errs = append(errs, valueErrs...)
+	case dtdlv0.Object:
+		if len(schema.Fields) == 0 {
+			errs = append(errs, field.Required(path.Child("fields"), "objects must declare at least one field"))
+			break
+		}
+		names := map[string]bool{}
+		for i, f := range schema.Fields {
+			fieldPath := path.Child("fields").Index(i)
+			errs = append(errs, validateName(f.Name, fieldPath.Child("name"))...)
+			if names[f.Name] {
+				errs = append(errs, field.Duplicate(fieldPath.Child("name"), f.Name))
+			}
+			names[f.Name] = true
+
+			fieldSchema, fieldErrs := m.resolveSchema(f.TwinSchema, fieldPath, depth+1)
+			errs = append(errs, fieldErrs...)
+			resolved.Fields = append(resolved.Fields, ResolvedField{
+				Name:           f.Name,
+				ResolvedSchema: fieldSchema,
+				FieldNumber:    f.FieldNumber,
+			})
+		}
+	case "":
+		errs = append(errs, field.Required(path.Child("type"), ""))
+	default:
+		errs = append(errs, field.NotSupported(path.Child("type"), schema.Type, supportedTypes))
+	}
+
+	return resolved, errs
+}
+
+func validateName(name string, path *field.Path) field.ErrorList {
+	if name == "" {
+		return field.ErrorList{field.Required(path, "")}
+	}
+	if strings.ContainsAny(name, nameSeparators) || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return field.ErrorList{field.Invalid(path, name, "must not contain whitespace or any of "+strconv.Quote(nameSeparators))}
+	}
+	return nil
+}
@@ -0,0 +1,442 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+)
+
+var _ = Describe("ResolveClass", func() {
+	var enums []dtdlv0.TwinEnum
+
+	BeforeEach(func() {
+		enums = []dtdlv0.TwinEnum{{Spec: dtdlv0.TwinEnumSpec{Name: "State", Values: []string{"idle", "busy"}}}}
+	})
+
+	resolve := func(attributes ...dtdlv0.TwinClassAttributes) (*ResolvedClass, error) {
+		class := dtdlv0.TwinClass{Spec: dtdlv0.TwinClassSpec{Name: "Machine", Attributes: attributes}}
+		return New([]dtdlv0.TwinClass{class}, enums).Resolve("Machine")
+	}
+
+	It("resolves nested schemas", func() {
+		resolved, err := resolve(dtdlv0.TwinClassAttributes{
+			Name: "spindles",
+			TwinSchema: dtdlv0.TwinSchema{Type: "array", Items: &dtdlv0.TwinSchema{
+				Type: "object",
+				Fields: []dtdlv0.TwinSchemaField{
+					{Name: "state", TwinSchema: dtdlv0.TwinSchema{Type: "enumeration", Reference: "State"}},
+					{Name: "loads", TwinSchema: dtdlv0.TwinSchema{Type: "map", Values: &dtdlv0.TwinSchema{Type: "double"}}},
+				},
+			}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		spindles := resolved.Attributes[0]
+		Expect(spindles.Type).To(Equal(dtdlv0.Array))
+		Expect(spindles.Kind).To(Equal(dtdlv0.Property))
+		Expect(spindles.Items.Fields).To(HaveLen(2))
+		Expect(spindles.Items.Fields[0].Enum).To(Equal(&enums[0]))
+		Expect(spindles.Items.Fields[1].Values.Type).To(Equal(dtdlv0.Double))
+
+		visited := []dtdlv0.PrimitiveTypes{}
+		spindles.Walk(func(s *ResolvedSchema) { visited = append(visited, s.Type) })
+		Expect(visited).To(Equal([]dtdlv0.PrimitiveTypes{dtdlv0.Array, dtdlv0.Object, dtdlv0.Enumeration, dtdlv0.Map, dtdlv0.Double}))
+	})
+
+	DescribeTable("reports errors with their field path",
+		func(attribute dtdlv0.TwinClassAttributes, message string) {
+			_, err := resolve(attribute)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("unknown type",
+			dtdlv0.TwinClassAttributes{Name: "a", TwinSchema: dtdlv0.TwinSchema{Type: "date"}},
+			`spec.attributes[0].type: Unsupported value: "date"`),
+		Entry("array without items",
+			dtdlv0.TwinClassAttributes{Name: "a", TwinSchema: dtdlv0.TwinSchema{Type: "array"}},
+			`spec.attributes[0].items: Required value`),
+		Entry("nested unknown enum",
+			dtdlv0.TwinClassAttributes{Name: "a", TwinSchema: dtdlv0.TwinSchema{Type: "map", Values: &dtdlv0.TwinSchema{
+				Type:   "object",
+				Fields: []dtdlv0.TwinSchemaField{{Name: "b", TwinSchema: dtdlv0.TwinSchema{Type: "enumeration", Reference: "Mode"}}},
+			}}},
+			`spec.attributes[0].values.fields[0].reference: Not found: "Mode": unknown twin enum`),
+		Entry("duplicate object fields",
+			dtdlv0.TwinClassAttributes{Name: "a", TwinSchema: dtdlv0.TwinSchema{Type: "object", Fields: []dtdlv0.TwinSchemaField{
+				{Name: "b", TwinSchema: dtdlv0.TwinSchema{Type: "string"}},
+				{Name: "b", TwinSchema: dtdlv0.TwinSchema{Type: "string"}},
+			}}},
+			`spec.attributes[0].fields[1].name: Duplicate value: "b"`),
+		Entry("items on a primitive",
+			dtdlv0.TwinClassAttributes{Name: "a", TwinSchema: dtdlv0.TwinSchema{Type: "string", Items: &dtdlv0.TwinSchema{Type: "string"}}},
+			`spec.attributes[0].items: Forbidden: not allowed for type "string"`),
+		Entry("dotted name",
+			dtdlv0.TwinClassAttributes{Name: "a.b", TwinSchema: dtdlv0.TwinSchema{Type: "string"}},
+			`spec.attributes[0].name: Invalid value: "a.b"`),
+		Entry("unit of another semantic type",
+			dtdlv0.TwinClassAttributes{Name: "a", TwinSchema: dtdlv0.TwinSchema{Type: "double"}, SemanticType: "Temperature", Unit: "metre"},
+			`spec.attributes[0].unit: Invalid value: "metre": unit "metre" is a LengthUnit, but semantic type Temperature takes a TemperatureUnit`),
+		Entry("unknown semantic type",
+			dtdlv0.TwinClassAttributes{Name: "a", TwinSchema: dtdlv0.TwinSchema{Type: "double"}, SemanticType: "Warmth"},
+			`spec.attributes[0].semanticType: Invalid value: "Warmth": unknown DTDL semantic type`),
+		Entry("unit on a string",
+			dtdlv0.TwinClassAttributes{Name: "a", TwinSchema: dtdlv0.TwinSchema{Type: "string"}, Unit: "metre"},
+			`spec.attributes[0].unit: Invalid value: "metre": only integer and double attributes can have a semantic type or unit`),
+		Entry("writable telemetry",
+			dtdlv0.TwinClassAttributes{Name: "a", TwinSchema: dtdlv0.TwinSchema{Type: "string"}, Kind: dtdlv0.Telemetry, Writable: true},
+			`spec.attributes[0].writable: Invalid value: true: telemetry cannot be writable`),
+	)
+
+	It("accepts units matching the semantic type", func() {
+		resolved, err := resolve(dtdlv0.TwinClassAttributes{
+			Name:         "temperature",
+			TwinSchema:   dtdlv0.TwinSchema{Type: "double"},
+			SemanticType: "Temperature",
+			Unit:         "degreeCelsius",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolved.Attributes[0].Unit).To(Equal("degreeCelsius"))
+	})
+
+	It("limits the nesting depth", func() {
+		schema := dtdlv0.TwinSchema{Type: "string"}
+		for i := 0; i < MaxSchemaDepth; i++ {
+			inner := schema
+			schema = dtdlv0.TwinSchema{Type: "array", Items: &inner}
+		}
+
+		_, err := resolve(dtdlv0.TwinClassAttributes{Name: "a", TwinSchema: schema})
+		Expect(err).To(MatchError(ContainSubstring("spec.attributes[0].items.items.items.items.items: Forbidden")))
+	})
+
+	It("rejects contents sharing a name", func() {
+		class := dtdlv0.TwinClass{Spec: dtdlv0.TwinClassSpec{
+			Name:          "Machine",
+			Attributes:    []dtdlv0.TwinClassAttributes{{Name: "factory", TwinSchema: dtdlv0.TwinSchema{Type: "string"}}},
+			Relationships: []dtdlv0.TwinRelationship{{Name: "factory", Multiplicity: dtdlv0.ONE, Reference: "Factory"}},
+		}}
+
+		_, err := New(nil, nil).ResolveClass(&class)
+		Expect(err).To(MatchError(ContainSubstring(`spec.relationships[0].name: Invalid value: "factory": name is already used by spec.attributes[0].name`)))
+	})
+})
+
+var _ = Describe("Components", func() {
+	var classes []dtdlv0.TwinClass
+
+	BeforeEach(func() {
+		classes = []dtdlv0.TwinClass{
+			{Spec: dtdlv0.TwinClassSpec{
+				Name:       "Machine",
+				Attributes: []dtdlv0.TwinClassAttributes{{Name: "serial", TwinSchema: dtdlv0.TwinSchema{Type: "string"}}},
+				Components: []dtdlv0.TwinComponent{{Name: "spindle", Class: "Spindle"}},
+			}},
+			{Spec: dtdlv0.TwinClassSpec{
+				Name: "Spindle",
+				Attributes: []dtdlv0.TwinClassAttributes{
+					{Name: "rpm", TwinSchema: dtdlv0.TwinSchema{Type: "double"}, Kind: dtdlv0.Telemetry, FieldNumber: 1},
+				},
+				Commands:   []dtdlv0.TwinCommand{{Name: "stop"}},
+				Components: []dtdlv0.TwinComponent{{Name: "motor", Class: "Motor"}},
+			}},
+			{Spec: dtdlv0.TwinClassSpec{
+				Name:       "Motor",
+				Attributes: []dtdlv0.TwinClassAttributes{{Name: "mode", TwinSchema: dtdlv0.TwinSchema{Type: "enumeration", Reference: "Mode"}}},
+			}},
+		}
+	})
+
+	model := func() *Model {
+		return New(classes, []dtdlv0.TwinEnum{{Spec: dtdlv0.TwinEnumSpec{Name: "Mode", Values: []string{"eco"}}}})
+	}
+
+	It("flattens the contents of nested components under their slots", func() {
+		resolved, err := model().Resolve("Machine")
+		Expect(err).NotTo(HaveOccurred())
+
+		names := []string{}
+		for _, attribute := range resolved.Attributes {
+			names = append(names, attribute.Name)
+		}
+		Expect(names).To(Equal([]string{"serial", "spindle/rpm", "spindle/motor/mode"}))
+		Expect(resolved.Attributes[1].Kind).To(Equal(dtdlv0.Telemetry))
+		Expect(resolved.Attributes[1].FieldNumber).To(BeZero())
+		Expect(resolved.Commands).To(HaveLen(1))
+		Expect(resolved.Commands[0].Name).To(Equal("spindle/stop"))
+	})
+
+	It("detects recursive composition", func() {
+		classes[2].Spec.Components = []dtdlv0.TwinComponent{{Name: "machine", Class: "Machine"}}
+
+		_, err := model().Resolve("Machine")
+		Expect(err).To(MatchError(ContainSubstring("recursive composition Machine -> Spindle -> Motor -> Machine")))
+	})
+
+	It("rejects unknown component classes", func() {
+		classes[0].Spec.Components[0].Class = "Gearbox"
+
+		_, err := model().Resolve("Machine")
+		Expect(err).To(MatchError(ContainSubstring(`spec.components[0].class: Not found: "Gearbox": unknown twin class`)))
+	})
+
+	It("finds the classes depending on an enum or class", func() {
+		m := model()
+
+		using := []string{}
+		for _, class := range m.ClassesUsingEnum("Mode") {
+			using = append(using, class.Spec.Name)
+		}
+		Expect(using).To(Equal([]string{"Machine", "Motor", "Spindle"}))
+
+		embedding := []string{}
+		for _, class := range m.ClassesEmbedding("Spindle") {
+			embedding = append(embedding, class.Spec.Name)
+		}
+		Expect(embedding).To(Equal([]string{"Machine"}))
+	})
+})
+
+var _ = Describe("Relationships", func() {
+	one, three := int32(1), int32(3)
+
+	resolve := func(relationships ...dtdlv0.TwinRelationship) (*ResolvedClass, field.ErrorList) {
+		class := dtdlv0.TwinClass{Spec: dtdlv0.TwinClassSpec{Name: "Line", Relationships: relationships}}
+		return New([]dtdlv0.TwinClass{class}, nil).resolveClass(&class, []string{"Line"})
+	}
+
+	It("maps the legacy multiplicity and reference", func() {
+		resolved, errs := resolve(
+			dtdlv0.TwinRelationship{Name: "head", Multiplicity: dtdlv0.ONE, Reference: "Machine"},
+			dtdlv0.TwinRelationship{Name: "machines", Multiplicity: dtdlv0.MANY, Reference: "Machine", Targets: []string{"Robot", "Machine"}},
+		)
+		Expect(errs).To(BeEmpty())
+
+		Expect(resolved.Relationships[0].MaxMultiplicity).To(Equal(int32(1)))
+		Expect(resolved.Relationships[0].Many()).To(BeFalse())
+		Expect(resolved.Relationships[0].Schema().Type).To(Equal(dtdlv0.String))
+		Expect(resolved.Relationships[1].MaxMultiplicity).To(BeZero())
+		Expect(resolved.Relationships[1].Targets).To(Equal([]string{"Robot", "Machine"}))
+		Expect(resolved.Relationships[1].Schema().Items.Type).To(Equal(dtdlv0.String))
+	})
+
+	It("describes edges with properties as objects", func() {
+		resolved, errs := resolve(dtdlv0.TwinRelationship{
+			Name:            "feeds",
+			MinMultiplicity: 1,
+			MaxMultiplicity: &three,
+			Properties:      []dtdlv0.TwinSchemaField{{Name: "capacity", TwinSchema: dtdlv0.TwinSchema{Type: "integer"}}},
+		})
+		Expect(errs).To(BeEmpty())
+
+		schema := resolved.Relationships[0].Schema()
+		Expect(schema.Type).To(Equal(dtdlv0.Array))
+		Expect(schema.Items.Type).To(Equal(dtdlv0.Object))
+		Expect(schema.Items.Fields[0].Name).To(Equal(RelationshipTarget))
+		Expect(schema.Items.Fields[1].Name).To(Equal("capacity"))
+	})
+
+	DescribeTable("reports invalid relationships",
+		func(relationship dtdlv0.TwinRelationship, expected string) {
+			_, errs := resolve(relationship)
+			Expect(errs.ToAggregate()).To(MatchError(ContainSubstring(expected)))
+		},
+		Entry("conflicting multiplicity",
+			dtdlv0.TwinRelationship{Name: "r", Multiplicity: dtdlv0.ONE, MaxMultiplicity: &three},
+			`spec.relationships[0].maxMultiplicity: Invalid value: 3: contradicts multiplicity "one"`),
+		Entry("min above max",
+			dtdlv0.TwinRelationship{Name: "r", MinMultiplicity: 2, MaxMultiplicity: &one},
+			`spec.relationships[0].minMultiplicity: Invalid value: 2: exceeds maxMultiplicity 1`),
+		Entry("duplicate target",
+			dtdlv0.TwinRelationship{Name: "r", Targets: []string{"Machine", "Machine"}},
+			`spec.relationships[0].targets[1]: Duplicate value: "Machine"`),
+		Entry("reserved property",
+			dtdlv0.TwinRelationship{Name: "r", Properties: []dtdlv0.TwinSchemaField{{Name: "target", TwinSchema: dtdlv0.TwinSchema{Type: "string"}}}},
+			`spec.relationships[0].properties[0].name: Invalid value: "target": is reserved`),
+		Entry("invalid property schema",
+			dtdlv0.TwinRelationship{Name: "r", Properties: []dtdlv0.TwinSchemaField{{Name: "p", TwinSchema: dtdlv0.TwinSchema{Type: "array"}}}},
+			`spec.relationships[0].properties[0].items: Required value`),
+		Entry("unknown deletion policy",
+			dtdlv0.TwinRelationship{Name: "r", OnDelete: "Restrict"},
+			`spec.relationships[0].onDelete: Unsupported value: "Restrict"`),
+	)
+})
+
+var _ = Describe("Inverse relationships", func() {
+	machine := func(relationships ...dtdlv0.TwinRelationship) dtdlv0.TwinClass {
+		return dtdlv0.TwinClass{Spec: dtdlv0.TwinClassSpec{Name: "Machine", Relationships: relationships}}
+	}
+	factory := dtdlv0.TwinClass{Spec: dtdlv0.TwinClassSpec{Name: "Factory", Relationships: []dtdlv0.TwinRelationship{
+		{Name: "machines", Targets: []string{"Machine"}, Inverse: "factory"},
+	}}}
+
+	It("resolves relationships whose targets declare the inverse", func() {
+		m := New([]dtdlv0.TwinClass{factory, machine(dtdlv0.TwinRelationship{Name: "factory", Targets: []string{"Factory"}})}, nil)
+		resolved, err := m.Resolve("Factory")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolved.Relationships[0].Inverse).To(Equal("factory"))
+		Expect(m.ClassesWithInverseTo("Machine")).To(ConsistOf(HaveField("Spec.Name", "Factory")))
+	})
+
+	It("skips target classes that do not exist yet", func() {
+		Expect(New([]dtdlv0.TwinClass{factory}, nil).Validate(&factory)).To(BeEmpty())
+	})
+
+	DescribeTable("reports invalid inverses",
+		func(target dtdlv0.TwinClass, relationship dtdlv0.TwinRelationship, expected string) {
+			class := dtdlv0.TwinClass{Spec: dtdlv0.TwinClassSpec{Name: "Factory", Relationships: []dtdlv0.TwinRelationship{relationship}}}
+			errs := New([]dtdlv0.TwinClass{class, target}, nil).Validate(&class)
+			Expect(errs.ToAggregate()).To(MatchError(ContainSubstring(expected)))
+		},
+		Entry("missing inverse",
+			machine(),
+			dtdlv0.TwinRelationship{Name: "machines", Targets: []string{"Machine"}, Inverse: "factory"},
+			`spec.relationships[0].inverse: Not found: "factory": twin class "Machine" has no such relationship`),
+		Entry("inverse of another relationship",
+			machine(dtdlv0.TwinRelationship{Name: "factory", Inverse: "spares"}),
+			dtdlv0.TwinRelationship{Name: "machines", Targets: []string{"Machine"}, Inverse: "factory"},
+			`spec.relationships[0].inverse: Invalid value: "factory": relationship of twin class "Machine" is the inverse of "spares"`),
+		Entry("inverse without targets",
+			machine(),
+			dtdlv0.TwinRelationship{Name: "machines", Inverse: "factory"},
+			`spec.relationships[0].targets: Required value: relationships with an inverse must list their targets`),
+	)
+})
+
+var _ = Describe("Versions", func() {
+	var m *Model
+
+	BeforeEach(func() {
+		class := func(id string) dtdlv0.TwinClass {
+			return dtdlv0.TwinClass{Spec: dtdlv0.TwinClassSpec{ID: id, Name: "Factory"}}
+		}
+		m = New([]dtdlv0.TwinClass{
+			class("dtmi:acme:Factory;3"),
+			class(""),
+			class("dtmi:acme:Factory;1"),
+			class("dtmi:other:Factory;2"),
+		}, nil)
+	})
+
+	It("orders versions and resolves names to the latest", func() {
+		ids := []string{}
+		for _, class := range m.Versions("Factory") {
+			ids = append(ids, class.Spec.ID)
+		}
+		Expect(ids).To(Equal([]string{"", "dtmi:acme:Factory;1", "dtmi:other:Factory;2", "dtmi:acme:Factory;3"}))
+
+		latest, ok := m.Class("Factory")
+		Expect(ok).To(BeTrue())
+		Expect(latest.Spec.ID).To(Equal("dtmi:acme:Factory;3"))
+		Expect(m.Classes()).To(HaveLen(1))
+	})
+
+	DescribeTable("selects class versions",
+		func(reference, expected string) {
+			class, err := m.Select(reference)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(class.Spec.ID).To(Equal(expected))
+		},
+		Entry("by name", "Factory", "dtmi:acme:Factory;3"),
+		Entry("by DTMI path", "dtmi:other:Factory", "dtmi:other:Factory;2"),
+		Entry("pinned", "dtmi:acme:Factory;1", "dtmi:acme:Factory;1"),
+		Entry("range", "Factory;<3", "dtmi:other:Factory;2"),
+		Entry("range within a path", "dtmi:acme:Factory;>=1,<3", "dtmi:acme:Factory;1"),
+	)
+
+	It("reports references matching no version", func() {
+		_, err := m.Select("dtmi:acme:Factory;2")
+		Expect(err).To(MatchError(`no twin class matches "dtmi:acme:Factory;2"`))
+	})
+})
+
+var _ = Describe("LoadFiles", func() {
+	It("converts v1 documents", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "model.yaml"), []byte(`apiVersion: dtdl.digitaltwin/v1
+kind: TwinEnum
+metadata:
+  name: state
+spec:
+  name: State
+  values: [idle, busy]
+---
+apiVersion: dtdl.digitaltwin/v1
+kind: TwinClass
+metadata:
+  name: machine
+spec:
+  name: Machine
+  attributes:
+    - name: state
+      type: enumeration
+      reference: State
+  relationships:
+    - name: line
+      maxMultiplicity: 1
+      targets: [Line]
+`), 0o644)).To(Succeed())
+
+		manifests, err := LoadFiles(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifests.Enums).To(HaveLen(1))
+
+		m, err := manifests.Model()
+		Expect(err).NotTo(HaveOccurred())
+		resolved, err := m.Resolve("Machine")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolved.Attributes[0].Enum.Spec.Values).To(Equal([]string{"idle", "busy"}))
+		Expect(resolved.Relationships[0].Many()).To(BeFalse())
+	})
+})
+
+var _ = Describe("Build", func() {
+	class := func(namespace, name string) dtdlv0.TwinClass {
+		return dtdlv0.TwinClass{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: strings.ToLower(name)},
+			Spec:       dtdlv0.TwinClassSpec{Name: name},
+		}
+	}
+
+	It("indexes the classes of a namespace", func() {
+		m, err := Build([]dtdlv0.TwinClass{class("plant", "Machine"), class("plant", "Line")}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Classes()).To(HaveLen(2))
+	})
+
+	It("rejects names defined in several namespaces", func() {
+		classes := []dtdlv0.TwinClass{class("plant", "Machine"), class("lab", "Machine"), class("lab", "Line")}
+		enums := []dtdlv0.TwinEnum{
+			{ObjectMeta: metav1.ObjectMeta{Namespace: "plant"}, Spec: dtdlv0.TwinEnumSpec{Name: "State"}},
+			{ObjectMeta: metav1.ObjectMeta{Namespace: "lab"}, Spec: dtdlv0.TwinEnumSpec{Name: "State"}},
+		}
+		Expect(Conflicts(classes, enums)).To(Equal([]Conflict{
+			{Kind: "TwinClass", Name: "Machine", Namespaces: []string{`"lab"`, `"plant"`}},
+			{Kind: "TwinEnum", Name: "State", Namespaces: []string{`"lab"`, `"plant"`}},
+		}))
+
+		_, err := Build(classes, enums)
+		Expect(err).To(MatchError(`TwinClass "Machine" is defined in namespaces "lab", "plant", load one namespace at a time`))
+	})
+})
@@ -0,0 +1,291 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package monitoring exposes Prometheus metrics on the health of the twin
+// model and of the operator.
+package monitoring
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+// SchemaReadyCondition is the condition the TwinClass reconciler sets once a
+// class resolved and its schemas are published.
+const SchemaReadyCondition = "SchemaReady"
+
+var (
+	classesDesc = prometheus.NewDesc("dt_twin_classes",
+		"Number of TwinClass versions, by namespace and whether their schemas are published.",
+		[]string{"namespace", "ready"}, nil)
+	enumsDesc = prometheus.NewDesc("dt_twin_enums",
+		"Number of TwinEnums, by namespace and whether every value is numbered.",
+		[]string{"namespace", "ready"}, nil)
+	servicesDesc = prometheus.NewDesc("dt_twin_services",
+		"Number of TwinServices, by namespace and whether all their classes resolve.",
+		[]string{"namespace", "ready"}, nil)
+	unresolvedDesc = prometheus.NewDesc("dt_unresolved_references",
+		"Number of references to missing classes, enums and instances, by namespace and kind of the referring resource.",
+		[]string{"namespace", "kind"}, nil)
+	brokerUpDesc = prometheus.NewDesc("dt_broker_up",
+		"Whether the MQTT broker Deployment has an available replica.",
+		nil, nil)
+	workloadAvailableDesc = prometheus.NewDesc("dt_twin_service_workload_available",
+		"Whether the workload of a TwinService exists and all its replicas are available.",
+		[]string{"namespace", "service"}, nil)
+	workloadReplicasDesc = prometheus.NewDesc("dt_twin_service_workload_replicas",
+		"Desired replicas of the workload of a TwinService.",
+		[]string{"namespace", "service"}, nil)
+	workloadAvailableReplicasDesc = prometheus.NewDesc("dt_twin_service_workload_available_replicas",
+		"Available replicas of the workload of a TwinService.",
+		[]string{"namespace", "service"}, nil)
+)
+
+// Collector computes the twin model metrics from the resources visible to
+// Reader on every scrape.
+type Collector struct {
+	Reader client.Reader
+	// Broker is the broker Deployment of the cluster. dt_broker_up is left
+	// out when it is empty, as brokers of namespaces and services report
+	// their availability in the status of their TwinBroker.
+	Broker types.NamespacedName
+	// Timeout bounds the reads of a scrape.
+	Timeout time.Duration
+}
+
+// Describe sends the descriptors of the metrics of the collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		classesDesc, enumsDesc, servicesDesc, unresolvedDesc, brokerUpDesc,
+		workloadAvailableDesc, workloadReplicasDesc, workloadAvailableReplicasDesc,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect reads the twin resources and sends their metrics. Metrics whose
+// resources cannot be read are left out of the scrape.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	logger := log.FromContext(ctx).WithName("monitoring")
+
+	if err := c.collectModel(ctx, ch); err != nil {
+		logger.Error(err, "Unable to collect twin model metrics")
+	}
+	if err := c.collectBroker(ctx, ch); err != nil {
+		logger.Error(err, "Unable to collect broker metrics")
+	}
+}
+
+// counts holds a gauge value per namespace and label value.
+type counts map[[2]string]float64
+
+func (c counts) add(namespace, label string, n int) {
+	c[[2]string{namespace, label}] += float64(n)
+}
+
+func (c counts) send(ch chan<- prometheus.Metric, desc *prometheus.Desc) {
+	for labels, value := range c {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels[0], labels[1])
+	}
+}
+
+func (c *Collector) collectModel(ctx context.Context, ch chan<- prometheus.Metric) error {
+	models, err := model.LoadByNamespace(ctx, c.Reader)
+	if err != nil {
+		return err
+	}
+	twinServices := &dtdlv1.TwinServiceList{}
+	if err := c.Reader.List(ctx, twinServices); err != nil {
+		return err
+	}
+	twinInstances := &dtdlv1.TwinInstanceList{}
+	if err := c.Reader.List(ctx, twinInstances); err != nil {
+		return err
+	}
+	modelOf := func(namespace string) *model.Model {
+		if m, ok := models[namespace]; ok {
+			return m
+		}
+		return model.New(nil, nil)
+	}
+
+	classes, enums, services, unresolved := counts{}, counts{}, counts{}, counts{}
+	for namespace, m := range models {
+		unresolved.add(namespace, "TwinClass", 0)
+		for _, latest := range m.Classes() {
+			for _, class := range m.Versions(latest.Spec.Name) {
+				classes.add(namespace, conditionLabel(class.Status.Conditions, SchemaReadyCondition), 1)
+				unresolved.add(namespace, "TwinClass", len(missingReferences(m.Validate(class))))
+			}
+		}
+		for _, enum := range m.Enums() {
+			enums.add(namespace, boolLabel(enumNumbered(enum)), 1)
+		}
+	}
+
+	for i := range twinServices.Items {
+		twinService := &twinServices.Items[i]
+		m := modelOf(twinService.Namespace)
+		ready := true
+		unresolved.add(twinService.Namespace, "TwinService", 0)
+		for _, reference := range twinService.Spec.Classes {
+			class, err := m.Select(reference.String())
+			if err != nil {
+				unresolved.add(twinService.Namespace, "TwinService", 1)
+				ready = false
+			} else if _, err := m.ResolveClass(class); err != nil {
+				ready = false
+			}
+		}
+		services.add(twinService.Namespace, boolLabel(ready), 1)
+
+		if err := c.collectWorkload(ctx, ch, twinService); err != nil {
+			log.FromContext(ctx).WithName("monitoring").Error(err, "Unable to collect workload metrics", "TwinService", client.ObjectKeyFromObject(twinService))
+		}
+	}
+
+	for i := range twinInstances.Items {
+		twinInstance := &twinInstances.Items[i]
+		missing := len(twinInstance.Status.DanglingEdges)
+		if _, err := modelOf(twinInstance.Namespace).Select(twinInstance.Spec.Class.String()); err != nil {
+			missing++
+		}
+		unresolved.add(twinInstance.Namespace, "TwinInstance", missing)
+	}
+
+	classes.send(ch, classesDesc)
+	enums.send(ch, enumsDesc)
+	services.send(ch, servicesDesc)
+	unresolved.send(ch, unresolvedDesc)
+	return nil
+}
+
+// collectWorkload sends the availability of the workload of a service that
+// runs one: a service with a pod template or autoscaling. Only Deployments
+// and StatefulSets are read. Services whose workload cannot be read are
+// left out, without holding back the metrics of the others.
+func (c *Collector) collectWorkload(ctx context.Context, ch chan<- prometheus.Metric, twinService *dtdlv1.TwinService) error {
+	if len(twinService.Spec.Template.Spec.Containers) == 0 && twinService.Spec.Autoscaling == nil {
+		return nil
+	}
+	workload := twinService.Workload()
+	key := types.NamespacedName{Namespace: twinService.Namespace, Name: workload.Name}
+
+	var desired *int32
+	var available int32
+	var err error
+	switch {
+	case workload.APIVersion == "apps/v1" && workload.Kind == "Deployment":
+		deployment := &appsv1.Deployment{}
+		if err = c.Reader.Get(ctx, key, deployment); err == nil {
+			desired, available = deployment.Spec.Replicas, deployment.Status.AvailableReplicas
+		}
+	case workload.APIVersion == "apps/v1" && workload.Kind == "StatefulSet":
+		statefulSet := &appsv1.StatefulSet{}
+		if err = c.Reader.Get(ctx, key, statefulSet); err == nil {
+			desired, available = statefulSet.Spec.Replicas, statefulSet.Status.AvailableReplicas
+		}
+	default:
+		return nil
+	}
+
+	labels := []string{twinService.Namespace, twinService.Name}
+	if apierrors.IsNotFound(err) {
+		ch <- prometheus.MustNewConstMetric(workloadAvailableDesc, prometheus.GaugeValue, 0, labels...)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	replicas := int32(1)
+	if desired != nil {
+		replicas = *desired
+	}
+	ch <- prometheus.MustNewConstMetric(workloadAvailableDesc, prometheus.GaugeValue, boolValue(available >= replicas), labels...)
+	ch <- prometheus.MustNewConstMetric(workloadReplicasDesc, prometheus.GaugeValue, float64(replicas), labels...)
+	ch <- prometheus.MustNewConstMetric(workloadAvailableReplicasDesc, prometheus.GaugeValue, float64(available), labels...)
+	return nil
+}
+
+func (c *Collector) collectBroker(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if c.Broker.Name == "" {
+		return nil
+	}
+	deployment := &appsv1.Deployment{}
+	err := c.Reader.Get(ctx, c.Broker, deployment)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(brokerUpDesc, prometheus.GaugeValue, boolValue(err == nil && deployment.Status.AvailableReplicas > 0))
+	return nil
+}
+
+// missingReferences returns the errors about enums and classes missing from
+// the model.
+func missingReferences(errs field.ErrorList) field.ErrorList {
+	return errs.Filter(func(err error) bool {
+		fieldErr, ok := err.(*field.Error)
+		return !ok || fieldErr.Type != field.ErrorTypeNotFound
+	})
+}
+
+// enumNumbered reports whether every value of an enum has a number.
+func enumNumbered(enum *dtdlv0.TwinEnum) bool {
+	for _, value := range enum.Spec.Values {
+		if _, ok := enum.Status.ValueNumbers[value]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func conditionLabel(conditions []metav1.Condition, conditionType string) string {
+	condition := meta.FindStatusCondition(conditions, conditionType)
+	if condition == nil {
+		return "unknown"
+	}
+	return strings.ToLower(string(condition.Status))
+}
+
+func boolLabel(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
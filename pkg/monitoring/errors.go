@@ -0,0 +1,87 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"context"
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Categories of reconcile errors.
+const (
+	CategoryConflict  = "conflict"
+	CategoryNotFound  = "not_found"
+	CategoryExists    = "already_exists"
+	CategoryForbidden = "forbidden"
+	CategoryInvalid   = "invalid"
+	CategoryTimeout   = "timeout"
+	CategoryModel     = "model"
+	CategoryOther     = "other"
+)
+
+// ReconcileErrors counts the errors returned by reconcilers, by controller
+// and category.
+var ReconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "dt_reconcile_errors_total",
+	Help: "Number of reconcile errors, by controller and category.",
+}, []string{"controller", "category"})
+
+func init() {
+	metrics.Registry.MustRegister(ReconcileErrors)
+}
+
+// Instrument counts the errors the reconciler returns in ReconcileErrors.
+func Instrument(controller string, reconciler reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		result, err := reconciler.Reconcile(ctx, req)
+		if err != nil {
+			ReconcileErrors.WithLabelValues(controller, Categorize(err)).Inc()
+		}
+		return result, err
+	})
+}
+
+// Categorize returns the category of a reconcile error. API errors are
+// categorized by status; errors carrying field errors, as returned when the
+// twin model does not resolve, are model errors.
+func Categorize(err error) string {
+	var aggregate utilerrors.Aggregate
+	switch {
+	case apierrors.IsConflict(err):
+		return CategoryConflict
+	case apierrors.IsNotFound(err):
+		return CategoryNotFound
+	case apierrors.IsAlreadyExists(err):
+		return CategoryExists
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		return CategoryForbidden
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return CategoryInvalid
+	case apierrors.IsTimeout(err), apierrors.IsServerTimeout(err), apierrors.IsTooManyRequests(err),
+		errors.Is(err, context.DeadlineExceeded):
+		return CategoryTimeout
+	case errors.As(err, &aggregate):
+		return CategoryModel
+	}
+	return CategoryOther
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+var _ = Describe("Collector", func() {
+	It("reports the health of the twin model", func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(dtdlv0.AddToScheme(scheme)).To(Succeed())
+		Expect(dtdlv1.AddToScheme(scheme)).To(Succeed())
+
+		replicas := int32(2)
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&dtdlv0.TwinEnum{
+				ObjectMeta: metav1.ObjectMeta{Name: "state", Namespace: "plant"},
+				Spec:       dtdlv0.TwinEnumSpec{Name: "State", Values: []string{"on", "off"}},
+				Status:     dtdlv0.TwinEnumStatus{ValueNumbers: map[string]int32{"on": 1, "off": 2}},
+			},
+			&dtdlv0.TwinClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: "plant"},
+				Spec: dtdlv0.TwinClassSpec{
+					Name:       "Machine",
+					Attributes: []dtdlv0.TwinClassAttributes{{Name: "state", TwinSchema: dtdlv0.TwinSchema{Type: string(dtdlv0.Enumeration), Reference: "State"}}},
+				},
+				Status: dtdlv0.TwinClassStatus{Conditions: []metav1.Condition{{
+					Type: SchemaReadyCondition, Status: metav1.ConditionTrue, Reason: "Published",
+				}}},
+			},
+			&dtdlv0.TwinClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "line", Namespace: "plant"},
+				Spec: dtdlv0.TwinClassSpec{
+					Name:       "Line",
+					Attributes: []dtdlv0.TwinClassAttributes{{Name: "mode", TwinSchema: dtdlv0.TwinSchema{Type: string(dtdlv0.Enumeration), Reference: "Mode"}}},
+				},
+			},
+			&dtdlv1.TwinService{
+				ObjectMeta: metav1.ObjectMeta{Name: "monitor", Namespace: "plant"},
+				Spec: dtdlv1.TwinServiceSpec{
+					Classes:  []dtdlv1.TwinClassReference{{Name: "Machine"}, {Name: "Press"}},
+					Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "monitor"}}}},
+				},
+			},
+			&dtdlv1.TwinService{
+				ObjectMeta: metav1.ObjectMeta{Name: "archive", Namespace: "plant"},
+				Spec: dtdlv1.TwinServiceSpec{
+					Classes:  []dtdlv1.TwinClassReference{{Name: "Machine"}},
+					Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "archive"}}}},
+				},
+			},
+			&appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "archive", Namespace: "plant"},
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+				Status:     appsv1.DeploymentStatus{AvailableReplicas: 1},
+			},
+			&dtdlv1.TwinInstance{
+				ObjectMeta: metav1.ObjectMeta{Name: "m1", Namespace: "plant"},
+				Spec:       dtdlv1.TwinInstanceSpec{Class: dtdlv1.TwinClassReference{Name: "Machine"}},
+				Status:     dtdlv1.TwinInstanceStatus{DanglingEdges: []dtdlv1.DanglingEdge{{Relationship: "line", Target: "l1"}}},
+			},
+			&dtdlv1.TwinInstance{
+				ObjectMeta: metav1.ObjectMeta{Name: "p1", Namespace: "plant"},
+				Spec:       dtdlv1.TwinInstanceSpec{Class: dtdlv1.TwinClassReference{Name: "Press"}},
+			},
+		).Build()
+
+		collector := &Collector{
+			Reader:  c,
+			Broker:  types.NamespacedName{Namespace: "mqtt", Name: "mqtt-broker-deployment"},
+			Timeout: time.Second,
+		}
+		Expect(testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP dt_broker_up Whether the MQTT broker Deployment has an available replica.
+# TYPE dt_broker_up gauge
+dt_broker_up 0
+# HELP dt_twin_classes Number of TwinClass versions, by namespace and whether their schemas are published.
+# TYPE dt_twin_classes gauge
+dt_twin_classes{namespace="plant",ready="true"} 1
+dt_twin_classes{namespace="plant",ready="unknown"} 1
+# HELP dt_twin_enums Number of TwinEnums, by namespace and whether every value is numbered.
+# TYPE dt_twin_enums gauge
+dt_twin_enums{namespace="plant",ready="true"} 1
+# HELP dt_twin_service_workload_available Whether the workload of a TwinService exists and all its replicas are available.
+# TYPE dt_twin_service_workload_available gauge
+dt_twin_service_workload_available{namespace="plant",service="archive"} 0
+dt_twin_service_workload_available{namespace="plant",service="monitor"} 0
+# HELP dt_twin_service_workload_available_replicas Available replicas of the workload of a TwinService.
+# TYPE dt_twin_service_workload_available_replicas gauge
+dt_twin_service_workload_available_replicas{namespace="plant",service="archive"} 1
+# HELP dt_twin_service_workload_replicas Desired replicas of the workload of a TwinService.
+# TYPE dt_twin_service_workload_replicas gauge
+dt_twin_service_workload_replicas{namespace="plant",service="archive"} 2
+# HELP dt_twin_services Number of TwinServices, by namespace and whether all their classes resolve.
+# TYPE dt_twin_services gauge
+dt_twin_services{namespace="plant",ready="false"} 1
+dt_twin_services{namespace="plant",ready="true"} 1
+# HELP dt_unresolved_references Number of references to missing classes, enums and instances, by namespace and kind of the referring resource.
+# TYPE dt_unresolved_references gauge
+dt_unresolved_references{kind="TwinClass",namespace="plant"} 1
+dt_unresolved_references{kind="TwinInstance",namespace="plant"} 2
+dt_unresolved_references{kind="TwinService",namespace="plant"} 1
+`))).To(Succeed())
+	})
+
+	It("leaves the broker out without a cluster broker", func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(dtdlv0.AddToScheme(scheme)).To(Succeed())
+		Expect(dtdlv1.AddToScheme(scheme)).To(Succeed())
+
+		collector := &Collector{
+			Reader:  fake.NewClientBuilder().WithScheme(scheme).Build(),
+			Timeout: time.Second,
+		}
+		Expect(testutil.CollectAndCompare(collector, strings.NewReader(""), "dt_broker_up")).To(Succeed())
+	})
+
+	It("keeps collecting when a workload cannot be read", func() {
+		scheme := runtime.NewScheme()
+		Expect(dtdlv0.AddToScheme(scheme)).To(Succeed())
+		Expect(dtdlv1.AddToScheme(scheme)).To(Succeed())
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&dtdlv1.TwinService{
+				ObjectMeta: metav1.ObjectMeta{Name: "monitor", Namespace: "plant"},
+				Spec: dtdlv1.TwinServiceSpec{
+					Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "monitor"}}}},
+				},
+			},
+			&dtdlv1.TwinService{
+				ObjectMeta: metav1.ObjectMeta{Name: "archive", Namespace: "plant"},
+			},
+		).Build()
+
+		collector := &Collector{Reader: c, Timeout: time.Second}
+		Expect(testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP dt_twin_services Number of TwinServices, by namespace and whether all their classes resolve.
+# TYPE dt_twin_services gauge
+dt_twin_services{namespace="plant",ready="true"} 2
+`), "dt_twin_services", "dt_twin_service_workload_available")).To(Succeed())
+	})
+})
+
+var _ = Describe("Reconcile errors", func() {
+	resource := schema.GroupResource{Group: "dtdl.digitaltwin", Resource: "twinclasses"}
+
+	It("categorizes errors", func() {
+		Expect(Categorize(apierrors.NewConflict(resource, "machine", fmt.Errorf("changed")))).To(Equal(CategoryConflict))
+		Expect(Categorize(apierrors.NewNotFound(resource, "machine"))).To(Equal(CategoryNotFound))
+		Expect(Categorize(apierrors.NewForbidden(resource, "machine", fmt.Errorf("denied")))).To(Equal(CategoryForbidden))
+		Expect(Categorize(fmt.Errorf("wrapped: %w", context.DeadlineExceeded))).To(Equal(CategoryTimeout))
+		modelErr := field.ErrorList{field.NotFound(field.NewPath("spec"), "Mode")}.ToAggregate()
+		Expect(Categorize(fmt.Errorf("twin class %q: %w", "Line", modelErr))).To(Equal(CategoryModel))
+		Expect(Categorize(fmt.Errorf("broken"))).To(Equal(CategoryOther))
+	})
+
+	It("counts the errors of instrumented reconcilers", func() {
+		reconciler := Instrument("test", reconcile.Func(func(context.Context, reconcile.Request) (reconcile.Result, error) {
+			return reconcile.Result{}, apierrors.NewNotFound(resource, "machine")
+		}))
+		before := testutil.ToFloat64(ReconcileErrors.WithLabelValues("test", CategoryNotFound))
+		_, err := reconciler.Reconcile(context.Background(), reconcile.Request{})
+		Expect(err).To(HaveOccurred())
+		Expect(testutil.ToFloat64(ReconcileErrors.WithLabelValues("test", CategoryNotFound))).To(Equal(before + 1))
+	})
+})
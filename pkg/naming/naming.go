@@ -0,0 +1,174 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package naming converts model identifiers into the identifier styles of
+// the generated languages, and shortens the names of generated objects.
+package naming
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode"
+)
+
+var initialisms = map[string]bool{
+	"API":  true,
+	"HTTP": true,
+	"ID":   true,
+	"IP":   true,
+	"JSON": true,
+	"URL":  true,
+	"UUID": true,
+}
+
+// GoName turns a model identifier such as "spindle_speed", "tool-id" or
+// "Factory Type" into an exported Go identifier.
+func GoName(name string) string {
+	var b strings.Builder
+	for _, word := range SplitWords(name) {
+		upper := strings.ToUpper(word)
+		if initialisms[upper] {
+			b.WriteString(upper)
+			continue
+		}
+		runes := []rune(word)
+		b.WriteRune(unicode.ToUpper(runes[0]))
+		b.WriteString(string(runes[1:]))
+	}
+
+	return prefixDigit(b.String())
+}
+
+// SnakeCase turns a model identifier into a lower snake case identifier.
+func SnakeCase(name string) string {
+	return joinWords(name, strings.ToLower)
+}
+
+// UpperSnakeCase turns a model identifier into an upper snake case identifier.
+func UpperSnakeCase(name string) string {
+	return joinWords(name, strings.ToUpper)
+}
+
+func joinWords(name string, convert func(string) string) string {
+	words := SplitWords(name)
+	for i, word := range words {
+		words[i] = convert(word)
+	}
+	return prefixDigit(strings.Join(words, "_"))
+}
+
+func prefixDigit(name string) string {
+	if name == "" {
+		return "X"
+	}
+	if unicode.IsDigit([]rune(name)[0]) {
+		return "X" + name
+	}
+	return name
+}
+
+// SplitWords splits an identifier on non alphanumeric characters and on
+// lower to upper case transitions.
+func SplitWords(name string) []string {
+	var words []string
+	var current []rune
+
+	flush := func() {
+		if len(current) > 0 {
+			words = append(words, string(current))
+			current = nil
+		}
+	}
+
+	for _, r := range name {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+		case unicode.IsUpper(r) && len(current) > 0 && unicode.IsLower(current[len(current)-1]):
+			flush()
+			current = append(current, r)
+		default:
+			current = append(current, r)
+		}
+	}
+	flush()
+
+	return words
+}
+
+// Identifier returns name unchanged when it is already a valid identifier
+// in most schema languages ([A-Za-z_][A-Za-z0-9_]*), and otherwise joins its
+// words with underscores.
+func Identifier(name string) string {
+	valid := name != ""
+	for i, r := range name {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || r == '_' || (i > 0 && unicode.IsDigit(r))) {
+			valid = false
+			break
+		}
+	}
+	if valid {
+		return name
+	}
+	return prefixDigit(strings.Join(SplitWords(name), "_"))
+}
+
+// hashLength is the number of hexadecimal digits of the hash Truncate ends
+// shortened names with.
+const hashLength = 8
+
+// Truncate returns name unchanged when it has at most max characters, and
+// otherwise cuts it and ends it with a hash of the whole name, so that
+// distinct long names stay distinct. It is meant for the names of generated
+// Kubernetes objects, such as the 63 characters of a DNS label.
+func Truncate(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	return hashed(name, name, max)
+}
+
+// DNSLabel returns name shortened by Truncate when it only holds the lower
+// case letters, digits and dashes of a DNS-1035 label and starts with a
+// letter. Otherwise it replaces the other characters, such as the dots of
+// object names, with dashes, prefixes it with an x when it does not start
+// with a letter, and ends it with a hash of name, so that names differing
+// only in those characters stay distinct.
+func DNSLabel(name string, max int) string {
+	label := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(name))
+	if label == "" || label[0] < 'a' || label[0] > 'z' {
+		label = "x" + label
+	}
+	if label == name {
+		return Truncate(name, max)
+	}
+	return hashed(label, name, max)
+}
+
+// hashed ends label, cut to fit max characters, with a hash of name.
+func hashed(label, name string, max int) string {
+	sum := sha256.Sum256([]byte(name))
+	if len(label) > max-hashLength-1 {
+		label = label[:max-hashLength-1]
+	}
+	return strings.TrimRight(label, "-.") + "-" + hex.EncodeToString(sum[:])[:hashLength]
+}
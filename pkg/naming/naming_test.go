@@ -0,0 +1,147 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package naming
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var _ = Describe("SplitWords", func() {
+	DescribeTable("splits identifiers into words",
+		func(name string, words []string) {
+			Expect(SplitWords(name)).To(Equal(words))
+		},
+		Entry("underscores", "spindle_speed", []string{"spindle", "speed"}),
+		Entry("dashes and dots", "tool-id.v2", []string{"tool", "id", "v2"}),
+		Entry("spaces", "Factory Type", []string{"Factory", "Type"}),
+		Entry("repeated separators", "__x__", []string{"x"}),
+		Entry("lower to upper case", "spindleSpeed", []string{"spindle", "Speed"}),
+		Entry("trailing acronym", "userID", []string{"user", "ID"}),
+		Entry("leading acronym", "HTTPServer", []string{"HTTPServer"}),
+		Entry("trailing digit", "temperature2", []string{"temperature2"}),
+		Entry("digit before upper case", "v2Beta", []string{"v2Beta"}),
+		Entry("leading digit", "3d_model", []string{"3d", "model"}),
+		Entry("empty", "", []string(nil)),
+	)
+})
+
+var _ = Describe("GoName", func() {
+	DescribeTable("turns identifiers into exported Go identifiers",
+		func(name, goName string) {
+			Expect(GoName(name)).To(Equal(goName))
+		},
+		Entry("snake case", "spindle_speed", "SpindleSpeed"),
+		Entry("words", "Factory Type", "FactoryType"),
+		Entry("camel case", "spindleSpeed", "SpindleSpeed"),
+		Entry("initialism", "tool-id", "ToolID"),
+		Entry("leading initialism", "api_key", "APIKey"),
+		Entry("initialism word", "httpURL", "HTTPURL"),
+		Entry("kept acronym", "URLPath", "URLPath"),
+		Entry("digits", "tool-id.v2", "ToolIDV2"),
+		Entry("leading digit", "3d_model", "X3dModel"),
+		Entry("empty", "", "X"),
+	)
+})
+
+var _ = Describe("SnakeCase", func() {
+	DescribeTable("joins words with underscores",
+		func(name, snake, upperSnake string) {
+			Expect(SnakeCase(name)).To(Equal(snake))
+			Expect(UpperSnakeCase(name)).To(Equal(upperSnake))
+		},
+		Entry("camel case", "spindleSpeed", "spindle_speed", "SPINDLE_SPEED"),
+		Entry("dashes", "tool-id", "tool_id", "TOOL_ID"),
+		Entry("acronym", "httpURL", "http_url", "HTTP_URL"),
+		Entry("leading digit", "3d_model", "X3d_model", "X3D_MODEL"),
+	)
+})
+
+var _ = Describe("Identifier", func() {
+	DescribeTable("returns schema identifiers",
+		func(name, identifier string) {
+			Expect(Identifier(name)).To(Equal(identifier))
+		},
+		Entry("valid snake case", "spindle_speed", "spindle_speed"),
+		Entry("valid camel case", "spindleSpeed", "spindleSpeed"),
+		Entry("leading underscores", "__x__", "__x__"),
+		Entry("dashes", "tool-id", "tool_id"),
+		Entry("dots", "tool-id.v2", "tool_id_v2"),
+		Entry("spaces", "Factory Type", "Factory_Type"),
+		Entry("leading digit", "3d_model", "X3d_model"),
+		Entry("empty", "", "X"),
+	)
+})
+
+var _ = Describe("Truncate", func() {
+	DescribeTable("keeps names within the limit",
+		func(name string, max int) {
+			Expect(Truncate(name, max)).To(Equal(name))
+		},
+		Entry("short label", "mqtt-broker-service", validation.DNS1035LabelMaxLength),
+		Entry("label at the limit", strings.Repeat("a", 63), validation.DNS1035LabelMaxLength),
+		Entry("subdomain at the limit", strings.Repeat("a", 253), validation.DNS1123SubdomainMaxLength),
+	)
+
+	DescribeTable("shortens names over the limit",
+		func(name string, max int, prefix string) {
+			truncated := Truncate(name, max)
+			Expect(truncated).To(HaveLen(max))
+			Expect(truncated).To(HavePrefix(prefix))
+			Expect(truncated).To(MatchRegexp(`-[0-9a-f]{8}$`))
+		},
+		Entry("label", strings.Repeat("a", 64), validation.DNS1035LabelMaxLength, strings.Repeat("a", 54)+"-"),
+		Entry("subdomain", strings.Repeat("a", 254), validation.DNS1123SubdomainMaxLength, strings.Repeat("a", 244)+"-"),
+	)
+
+	It("keeps distinct long names distinct", func() {
+		first := strings.Repeat("a", 60) + "-first"
+		second := strings.Repeat("a", 60) + "-second"
+		Expect(Truncate(first, validation.DNS1035LabelMaxLength)).NotTo(Equal(Truncate(second, validation.DNS1035LabelMaxLength)))
+	})
+
+	It("does not end the cut name with a dash", func() {
+		name := strings.Repeat("a", 53) + "--" + strings.Repeat("b", 20)
+		Expect(Truncate(name, validation.DNS1035LabelMaxLength)).To(MatchRegexp(`^a{53}-[0-9a-f]{8}$`))
+	})
+})
+
+var _ = Describe("DNSLabel", func() {
+	It("keeps valid labels", func() {
+		Expect(DNSLabel("alarms-mqtt-broker", validation.DNS1035LabelMaxLength)).To(Equal("alarms-mqtt-broker"))
+	})
+
+	DescribeTable("rewrites invalid labels",
+		func(name, prefix string) {
+			label := DNSLabel(name, validation.DNS1035LabelMaxLength)
+			Expect(label).To(MatchRegexp(`^` + prefix + `-[0-9a-f]{8}$`))
+			Expect(validation.IsDNS1035Label(label)).To(BeEmpty())
+		},
+		Entry("dots", "svc.v2-mqtt-broker", "svc-v2-mqtt-broker"),
+		Entry("upper case", "Svc", "svc"),
+		Entry("leading digit", "1svc", "x1svc"),
+	)
+
+	It("keeps rewritten labels within the limit", func() {
+		label := DNSLabel(strings.Repeat("a.", 40), validation.DNS1035LabelMaxLength)
+		Expect(len(label)).To(BeNumerically("<=", validation.DNS1035LabelMaxLength))
+		Expect(validation.IsDNS1035Label(label)).To(BeEmpty())
+	})
+})
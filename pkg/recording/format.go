@@ -0,0 +1,302 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package recording records the MQTT traffic of twin topics to a compact
+// file and replays it back to a broker.
+//
+// A recording starts with Magic and the start time in Unix milliseconds as
+// a uvarint, followed by one record per message:
+//
+//	uvarint  milliseconds since the previous message
+//	uvarint  topic index; an index not seen before is followed by the
+//	         topic, as a uvarint length and its bytes
+//	byte     flags, FlagRetained when the message was retained
+//	uvarint  payload length
+//	bytes    payload
+//
+// Topics are written once and referred to by index afterwards, so that
+// recordings of many small messages stay small.
+package recording
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Magic opens every recording, and holds the format version.
+const Magic = "DTREC\x01"
+
+// FileName is the name of the recording on the volume of a TwinRecording.
+const FileName = "recording.dtrec"
+
+// FlagRetained marks retained messages.
+const FlagRetained = 1
+
+// MaxPayload bounds the payload of a record, so that corrupt lengths are
+// not allocated.
+const MaxPayload = 256 * 1024 * 1024
+
+// Message is a recorded message.
+type Message struct {
+	At       time.Time
+	Topic    string
+	Payload  []byte
+	Retained bool
+}
+
+// Writer appends messages to a recording. It is not safe for concurrent
+// use.
+type Writer struct {
+	w      *bufio.Writer
+	topics map[string]uint64
+	last   time.Time
+}
+
+// NewWriter starts a recording at start.
+func NewWriter(w io.Writer, start time.Time) (*Writer, error) {
+	writer := &Writer{w: bufio.NewWriter(w), topics: map[string]uint64{}, last: start}
+	if _, err := writer.w.WriteString(Magic); err != nil {
+		return nil, err
+	}
+	writer.uvarint(uint64(start.UnixMilli()))
+	return writer, nil
+}
+
+// Append opens the recording at path for writing, creating it when it does
+// not exist. A record cut short by a crash is dropped, and the topics of the
+// recording keep their index.
+func Append(path string, start time.Time) (*Writer, *os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if info.Size() == 0 {
+		writer, err := NewWriter(file, start)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return writer, file, nil
+	}
+
+	reader, err := NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	for {
+		if _, err := reader.Next(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			file.Close()
+			return nil, nil, err
+		}
+	}
+	if err := file.Truncate(reader.offset); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(reader.offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	writer := &Writer{w: bufio.NewWriter(file), topics: map[string]uint64{}, last: reader.last}
+	for i, topic := range reader.topics {
+		writer.topics[topic] = uint64(i)
+	}
+	return writer, file, nil
+}
+
+// Write appends a message. Messages are expected in time order; earlier
+// messages are recorded at the time of the previous one.
+func (w *Writer) Write(message *Message) error {
+	delta := message.At.Sub(w.last).Milliseconds()
+	if delta < 0 {
+		delta = 0
+	}
+	w.last = w.last.Add(time.Duration(delta) * time.Millisecond)
+	w.uvarint(uint64(delta))
+
+	index, ok := w.topics[message.Topic]
+	if !ok {
+		index = uint64(len(w.topics))
+		w.topics[message.Topic] = index
+	}
+	w.uvarint(index)
+	if !ok {
+		w.uvarint(uint64(len(message.Topic)))
+		_, _ = w.w.WriteString(message.Topic)
+	}
+
+	flags := byte(0)
+	if message.Retained {
+		flags |= FlagRetained
+	}
+	_ = w.w.WriteByte(flags)
+	w.uvarint(uint64(len(message.Payload)))
+	_, err := w.w.Write(message.Payload)
+	return err
+}
+
+// Flush writes buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *Writer) uvarint(value uint64) {
+	buffer := [binary.MaxVarintLen64]byte{}
+	n := binary.PutUvarint(buffer[:], value)
+	_, _ = w.w.Write(buffer[:n])
+}
+
+// Reader reads the messages of a recording.
+type Reader struct {
+	r      *countingReader
+	topics []string
+	last   time.Time
+	// offset is the end of the last complete record.
+	offset int64
+}
+
+// NewReader reads the header of a recording.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: &countingReader{r: bufio.NewReader(r)}}
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(reader.r, magic); err != nil || string(magic) != Magic {
+		return nil, errors.New("not a recording")
+	}
+	start, err := binary.ReadUvarint(reader.r)
+	if err != nil {
+		return nil, errors.New("not a recording")
+	}
+	reader.last = time.UnixMilli(int64(start))
+	reader.offset = reader.r.n
+	return reader, nil
+}
+
+// Start returns the time of the last message read, which is the start of
+// the recording before the first one.
+func (r *Reader) Start() time.Time {
+	return r.last
+}
+
+// Next returns the next message, or io.EOF at the end of the recording. A
+// last record cut short, as left by a recorder that crashed, ends the
+// recording as well.
+func (r *Reader) Next() (*Message, error) {
+	message, err := r.next()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.offset = r.r.n
+	return message, nil
+}
+
+func (r *Reader) next() (*Message, error) {
+	delta, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, err
+	}
+	index, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	topics := r.topics
+	switch {
+	case index == uint64(len(topics)):
+		topic, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, string(topic))
+	case index > uint64(len(topics)):
+		return nil, fmt.Errorf("corrupt recording: unknown topic %d", index)
+	}
+	flags, err := r.r.ReadByte()
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	payload, err := r.bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	r.topics = topics
+	r.last = r.last.Add(time.Duration(delta) * time.Millisecond)
+	return &Message{
+		At:       r.last,
+		Topic:    topics[index],
+		Payload:  payload,
+		Retained: flags&FlagRetained != 0,
+	}, nil
+}
+
+func (r *Reader) bytes() ([]byte, error) {
+	length, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	if length > MaxPayload {
+		return nil, fmt.Errorf("corrupt recording: record of %d bytes", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, unexpected(err)
+	}
+	return data, nil
+}
+
+// unexpected turns the end of the recording within a record into
+// io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
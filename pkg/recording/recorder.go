@@ -0,0 +1,139 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package recording
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+// ServiceTopics returns the subscription filters of the input of a
+// TwinService: the telemetry, reported properties and command responses of
+// every class it selects.
+func ServiceTopics(ctx context.Context, reader client.Reader, namespace, name string) ([]string, error) {
+	twinService := &dtdlv0.TwinService{}
+	if err := reader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, twinService); err != nil {
+		return nil, err
+	}
+	m, err := model.Load(ctx, reader, client.InNamespace(namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	layout, err := topics.ForService(m, namespace, twinService.Spec.Classes)
+	if err != nil {
+		return nil, err
+	}
+	inputs := topics.ServiceInputs(layout)
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("twin service %s/%s reads no topics", namespace, name)
+	}
+	return inputs, nil
+}
+
+// Recorder writes the messages of a set of topics of one namespace to a
+// recording.
+type Recorder struct {
+	Writer *Writer
+	// Namespace is the namespace whose twin topics are recorded. Filters
+	// outside of it are refused.
+	Namespace string
+	// Credentials are the credentials of the recorders of Namespace.
+	Credentials broker.Credentials
+	// FlushInterval is how often records are flushed to the file.
+	FlushInterval time.Duration
+
+	mu  sync.Mutex
+	err error
+}
+
+// Record subscribes to the filters with Credentials and records messages
+// until the context is done. Filters that reach beyond the twin topics of
+// Namespace are refused.
+func (r *Recorder) Record(ctx context.Context, address, clientID string, filters []string) error {
+	logger := log.FromContext(ctx)
+
+	subscriptions := map[string]byte{}
+	for _, filter := range filters {
+		if !topics.InNamespace(filter, r.Namespace) {
+			return fmt.Errorf("topic filter %q is outside of the twin topics %s", filter, topics.NamespaceFilter(r.Namespace))
+		}
+		subscriptions[filter] = 0
+	}
+	options := r.Credentials.Apply(mqtt.NewClientOptions()).
+		AddBroker(address).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			token := c.SubscribeMultiple(subscriptions, func(_ mqtt.Client, message mqtt.Message) {
+				r.write(&Message{
+					At:       time.Now(),
+					Topic:    message.Topic(),
+					Payload:  message.Payload(),
+					Retained: message.Retained(),
+				})
+			})
+			if token.Wait() && token.Error() != nil {
+				logger.Error(token.Error(), "Unable to subscribe to recorded topics")
+			}
+		})
+	c := mqtt.NewClient(options)
+	c.Connect()
+	defer c.Disconnect(250)
+
+	ticker := time.NewTicker(r.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return r.flush()
+		case <-ticker.C:
+			if err := r.flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (r *Recorder) write(message *Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.Writer.Write(message)
+	}
+}
+
+func (r *Recorder) flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	return r.Writer.Flush()
+}
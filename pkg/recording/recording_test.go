@@ -0,0 +1,170 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package recording
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+)
+
+func readAll(data []byte) []*Message {
+	reader, err := NewReader(bytes.NewReader(data))
+	Expect(err).NotTo(HaveOccurred())
+	messages := []*Message{}
+	for {
+		message, err := reader.Next()
+		if err == io.EOF {
+			return messages
+		}
+		Expect(err).NotTo(HaveOccurred())
+		messages = append(messages, message)
+	}
+}
+
+var _ = Describe("Recording", func() {
+	start := time.UnixMilli(1665000000000)
+	messages := []*Message{
+		{At: start.Add(10 * time.Millisecond), Topic: "dt/plant/Machine/m1/telemetry/temperature", Payload: []byte("21.5")},
+		{At: start.Add(1500 * time.Millisecond), Topic: "dt/plant/Machine/m1/properties/serial", Payload: []byte(`"M-1"`), Retained: true},
+		{At: start.Add(2 * time.Second), Topic: "dt/plant/Machine/m1/telemetry/temperature", Payload: []byte("21.7")},
+	}
+
+	record := func() []byte {
+		buffer := &bytes.Buffer{}
+		writer, err := NewWriter(buffer, start)
+		Expect(err).NotTo(HaveOccurred())
+		for _, message := range messages {
+			Expect(writer.Write(message)).To(Succeed())
+		}
+		Expect(writer.Flush()).To(Succeed())
+		return buffer.Bytes()
+	}
+
+	It("reads back what was written", func() {
+		data := record()
+		Expect(readAll(data)).To(Equal(messages))
+
+		// The repeated topic is referred to by index.
+		Expect(bytes.Count(data, []byte("telemetry/temperature"))).To(Equal(1))
+	})
+
+	It("rejects files that are not recordings", func() {
+		_, err := NewReader(bytes.NewReader([]byte("{}")))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("ends recordings cut short at the last complete record", func() {
+		data := record()
+		Expect(readAll(data[:len(data)-2])).To(Equal(messages[:2]))
+	})
+
+	It("appends to existing recordings", func() {
+		path := filepath.Join(GinkgoT().TempDir(), FileName)
+		data := record()
+		Expect(os.WriteFile(path, data[:len(data)-2], 0o644)).To(Succeed())
+
+		writer, file, err := Append(path, time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.Write(messages[2])).To(Succeed())
+		Expect(writer.Flush()).To(Succeed())
+		Expect(file.Close()).To(Succeed())
+
+		appended, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(appended).To(Equal(data))
+	})
+
+	It("replays at the requested speed with rewritten topics", func() {
+		reader, err := NewReader(bytes.NewReader(record()))
+		Expect(err).NotTo(HaveOccurred())
+
+		published := []*Message{}
+		waits := []time.Duration{}
+		replayer := &Replayer{
+			Publish: func(message *Message) error {
+				published = append(published, message)
+				return nil
+			},
+			Speed:    10,
+			Rewrites: []Rewrite{{From: "dt/plant/", To: "dt/staging/"}, {From: "dt/", To: "other/"}},
+			Sleep: func(_ context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			},
+		}
+		n, err := replayer.Replay(context.Background(), reader)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(3))
+		Expect(waits).To(Equal([]time.Duration{time.Millisecond, 149 * time.Millisecond, 50 * time.Millisecond}))
+		Expect(published[1].Topic).To(Equal("dt/staging/Machine/m1/properties/serial"))
+		Expect(published[1].Retained).To(BeTrue())
+	})
+
+	It("parses rewrites", func() {
+		Expect(ParseRewrite("dt/a/=dt/b/")).To(Equal(Rewrite{From: "dt/a/", To: "dt/b/"}))
+		_, err := ParseRewrite("dt/a/")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("records the input topics of a service", func() {
+		scheme := runtime.NewScheme()
+		Expect(dtdlv0.AddToScheme(scheme)).To(Succeed())
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&dtdlv0.TwinClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: "plant"},
+				Spec: dtdlv0.TwinClassSpec{
+					Name: "Machine",
+					Attributes: []dtdlv0.TwinClassAttributes{
+						{Name: "temperature", TwinSchema: dtdlv0.TwinSchema{Type: "double"}, Kind: dtdlv0.Telemetry},
+						{Name: "speed", TwinSchema: dtdlv0.TwinSchema{Type: "integer"}, Kind: dtdlv0.Property, Writable: true},
+					},
+				},
+			},
+			&dtdlv0.TwinService{
+				ObjectMeta: metav1.ObjectMeta{Name: "monitor", Namespace: "plant"},
+				Spec:       dtdlv0.TwinServiceSpec{Classes: []string{"Machine"}, DataSource: "mqtt"},
+			},
+		).Build()
+
+		filters, err := ServiceTopics(context.Background(), c, "plant", "monitor")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filters).To(Equal([]string{
+			"dt/plant/Machine/+/properties/speed",
+			"dt/plant/Machine/+/telemetry/temperature",
+		}))
+
+		_, err = ServiceTopics(context.Background(), c, "plant", "missing")
+		Expect(err).To(HaveOccurred())
+	})
+	It("refuses topics outside of the recorded namespace", func() {
+		recorder := &Recorder{Namespace: "plant", FlushInterval: time.Second}
+		err := recorder.Record(context.Background(), "tcp://localhost:1883", "test", []string{"dt/plant/#", "dt/#"})
+		Expect(err).To(MatchError(`topic filter "dt/#" is outside of the twin topics dt/plant/#`))
+	})
+})
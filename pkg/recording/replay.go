@@ -0,0 +1,109 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package recording
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Rewrite replaces the prefix From of replayed topics with To, such as
+// dt/production/ with dt/staging/.
+type Rewrite struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ParseRewrite parses a rewrite written as from=to.
+func ParseRewrite(value string) (Rewrite, error) {
+	from, to, ok := strings.Cut(value, "=")
+	if !ok || from == "" {
+		return Rewrite{}, fmt.Errorf("invalid rewrite %q, expected from=to", value)
+	}
+	return Rewrite{From: from, To: to}, nil
+}
+
+// Apply rewrites a topic with the first rewrite matching it.
+func Apply(rewrites []Rewrite, topic string) string {
+	for _, rewrite := range rewrites {
+		if strings.HasPrefix(topic, rewrite.From) {
+			return rewrite.To + strings.TrimPrefix(topic, rewrite.From)
+		}
+	}
+	return topic
+}
+
+// Replayer publishes the messages of a recording.
+type Replayer struct {
+	Publish func(*Message) error
+	// Speed multiplies the pace of the recording: 1 replays it as recorded
+	// and 10 ten times faster. Messages are published without delay when
+	// Speed is 0.
+	Speed    float64
+	Rewrites []Rewrite
+	// Sleep waits between messages, time.Sleep unless set.
+	Sleep func(context.Context, time.Duration) error
+}
+
+// Replay publishes the messages of reader until the end of the recording
+// or the context is done, returning the number of messages published.
+func (r *Replayer) Replay(ctx context.Context, reader *Reader) (int, error) {
+	sleep := r.Sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	published := 0
+	previous := reader.Start()
+	for {
+		message, err := reader.Next()
+		if err == io.EOF {
+			return published, nil
+		}
+		if err != nil {
+			return published, err
+		}
+		if r.Speed > 0 {
+			if err := sleep(ctx, time.Duration(float64(message.At.Sub(previous))/r.Speed)); err != nil {
+				return published, err
+			}
+		} else if ctx.Err() != nil {
+			return published, ctx.Err()
+		}
+		previous = message.At
+
+		message.Topic = Apply(r.Rewrites, message.Topic)
+		if err := r.Publish(message); err != nil {
+			return published, err
+		}
+		published++
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"encoding/json"
+	"sort"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/naming"
+)
+
+type avroRecord struct {
+	Type      string      `json:"type"`
+	Name      string      `json:"name"`
+	Namespace string      `json:"namespace,omitempty"`
+	Doc       string      `json:"doc,omitempty"`
+	Fields    []avroField `json:"fields"`
+}
+
+type avroField struct {
+	Name    string          `json:"name"`
+	Type    interface{}     `json:"type"`
+	Default json.RawMessage `json:"default,omitempty"`
+}
+
+type avroEnum struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Symbols []string `json:"symbols"`
+}
+
+type avroArray struct {
+	Type  string      `json:"type"`
+	Items interface{} `json:"items"`
+}
+
+type avroMap struct {
+	Type   string      `json:"type"`
+	Values interface{} `json:"values"`
+}
+
+// Avro renders one Avro record schema per message of the class, keyed by
+// record name: the twin state, its telemetry and its command payloads.
+// Fields are ordered by their protobuf field number so that new fields are
+// appended, and every field carries a default so that readers can evolve
+// across versions. Objects become nested records named after the record and
+// field holding them.
+func Avro(class *model.ResolvedClass, numbering Numbering, namespace string) (map[string]string, error) {
+	state, telemetry, err := splitFields(class, numbering)
+	if err != nil {
+		return nil, err
+	}
+
+	typeName := naming.GoName(class.Name)
+	records := []avroRecord{}
+	addRecord := func(name, path, doc string, fields []field) error {
+		g := &avroGenerator{numbering: numbering, definedEnums: map[string]bool{}}
+		record, err := g.record(name, path, fields)
+		if err != nil {
+			return err
+		}
+		record.Namespace = namespace
+		record.Doc = doc
+		records = append(records, record)
+		return nil
+	}
+
+	if err := addRecord(typeName, "", "State of the "+class.Name+" twin class.", state); err != nil {
+		return nil, err
+	}
+	if len(telemetry) > 0 {
+		if err := addRecord(typeName+TelemetrySuffix, "", "Telemetry of the "+class.Name+" twin class.", telemetry); err != nil {
+			return nil, err
+		}
+	}
+	for _, command := range class.Commands {
+		for _, payload := range commandPayloads(class, command) {
+			g := &avroGenerator{numbering: numbering, definedEnums: map[string]bool{}}
+			fieldType, err := g.typeOf(&payload.attribute.ResolvedSchema, payload.message+naming.GoName(payload.attribute.Name), payload.path)
+			if err != nil {
+				return nil, err
+			}
+			record := avroRecord{Type: "record", Name: payload.message, Fields: []avroField{{
+				Name:    naming.Identifier(payload.attribute.Name),
+				Type:    []interface{}{"null", fieldType},
+				Default: json.RawMessage("null"),
+			}}}
+			record.Namespace = namespace
+			record.Doc = "Payload of the " + command.Name + " command of the " + class.Name + " twin class."
+			records = append(records, record)
+		}
+	}
+
+	schemas := map[string]string{}
+	for _, record := range records {
+		out, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		schemas[record.Name] = string(out) + "\n"
+	}
+	return schemas, nil
+}
+
+// avroGenerator renders the types of one top-level record. Avro forbids
+// redefining a named type within a schema, so enums are defined on first
+// use and referenced by name afterwards.
+type avroGenerator struct {
+	numbering    Numbering
+	definedEnums map[string]bool
+}
+
+func (g *avroGenerator) record(name, path string, fields []field) (avroRecord, error) {
+	record := avroRecord{Type: "record", Name: name, Fields: []avroField{}}
+
+	for _, f := range fields {
+		fieldName := naming.Identifier(f.name)
+
+		fieldType, err := g.typeOf(f.schema, name+naming.GoName(f.name), fieldPath(path, f.name))
+		if err != nil {
+			return record, err
+		}
+		// Many-valued relationships default to no related twins.
+		if f.relationship && f.schema.Type == dtdlv0.Array {
+			record.Fields = append(record.Fields, avroField{Name: fieldName, Type: fieldType, Default: json.RawMessage("[]")})
+			continue
+		}
+		record.Fields = append(record.Fields, avroField{Name: fieldName, Type: []interface{}{"null", fieldType}, Default: json.RawMessage("null")})
+	}
+	return record, nil
+}
+
+// typeOf returns the Avro type of s. Records the type needs are named after
+// hint.
+func (g *avroGenerator) typeOf(s *model.ResolvedSchema, hint, path string) (interface{}, error) {
+	switch s.Type {
+	case dtdlv0.Integer:
+		return "long", nil
+	case dtdlv0.Boolean:
+		return "boolean", nil
+	case dtdlv0.Double:
+		return "double", nil
+	case dtdlv0.Enumeration:
+		return g.enum(s.Enum), nil
+	case dtdlv0.Array:
+		items, err := g.typeOf(s.Items, hint+itemSuffix, path)
+		return avroArray{Type: "array", Items: items}, err
+	case dtdlv0.Map:
+		values, err := g.typeOf(s.Values, hint+valueSuffix, path)
+		return avroMap{Type: "map", Values: values}, err
+	case dtdlv0.Object:
+		fields, err := objectFields(s, path, g.numbering)
+		if err != nil {
+			return nil, err
+		}
+		return g.record(hint, path, fields)
+	default:
+		return "string", nil
+	}
+}
+
+// enum returns the enum definition the first time an enum is used and a
+// reference to its name afterwards.
+func (g *avroGenerator) enum(enum *dtdlv0.TwinEnum) interface{} {
+	name := naming.GoName(enum.Spec.Name)
+	if g.definedEnums[name] {
+		return name
+	}
+	g.definedEnums[name] = true
+
+	numbers := EnumValueNumbers(enum)
+	values := append([]string(nil), enum.Spec.Values...)
+	sort.SliceStable(values, func(i, j int) bool {
+		return numbers[values[i]] < numbers[values[j]]
+	})
+
+	symbols := make([]string, len(values))
+	for i, value := range values {
+		symbols[i] = naming.Identifier(value)
+	}
+	return avroEnum{Type: "enum", Name: name, Symbols: symbols}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"encoding/json"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/naming"
+)
+
+// JSONSchemaDialect is the JSON Schema draft the generated schemas follow.
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Annotation keywords carrying the DTDL semantic type and unit of a property.
+const (
+	SemanticTypeKeyword = "x-dtdl-semanticType"
+	UnitKeyword         = "x-dtdl-unit"
+)
+
+// JSONSchema renders one JSON Schema per message of the class, keyed by the
+// same names as the Avro records: the twin state, its telemetry and its
+// command payloads. No property is required, so that partial documents such
+// as a single reported property validate; unknown properties are rejected.
+func JSONSchema(class *model.ResolvedClass) (map[string]string, error) {
+	typeName := naming.GoName(class.Name)
+	state := map[string]interface{}{}
+	telemetry := map[string]interface{}{}
+
+	for i := range class.Attributes {
+		attribute := &class.Attributes[i]
+		property := jsonSchemaOf(&attribute.ResolvedSchema)
+		if attribute.SemanticType != "" {
+			property[SemanticTypeKeyword] = attribute.SemanticType
+		}
+		if attribute.Unit != "" {
+			property[UnitKeyword] = attribute.Unit
+		}
+		if attribute.Kind == dtdlv0.Telemetry {
+			telemetry[attribute.Name] = property
+		} else {
+			state[attribute.Name] = property
+		}
+	}
+	for i := range class.Relationships {
+		state[class.Relationships[i].Name] = jsonSchemaOf(class.Relationships[i].Schema())
+	}
+
+	documents := map[string]map[string]interface{}{
+		typeName: jsonSchemaDocument(typeName, "State of the "+class.Name+" twin class.", state),
+	}
+	if len(telemetry) > 0 {
+		name := typeName + TelemetrySuffix
+		documents[name] = jsonSchemaDocument(name, "Telemetry of the "+class.Name+" twin class.", telemetry)
+	}
+	for _, command := range class.Commands {
+		for _, payload := range commandPayloads(class, command) {
+			documents[payload.message] = jsonSchemaDocument(payload.message, "Payload of the "+command.Name+" command of the "+class.Name+" twin class.", map[string]interface{}{
+				payload.attribute.Name: jsonSchemaOf(&payload.attribute.ResolvedSchema),
+			})
+		}
+	}
+
+	schemas := map[string]string{}
+	for name, document := range documents {
+		out, err := json.MarshalIndent(document, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		schemas[name] = string(out) + "\n"
+	}
+	return schemas, nil
+}
+
+func jsonSchemaDocument(title, description string, properties map[string]interface{}) map[string]interface{} {
+	document := jsonSchemaObject(properties)
+	document["$schema"] = JSONSchemaDialect
+	document["title"] = title
+	document["description"] = description
+	return document
+}
+
+func jsonSchemaObject(properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func jsonSchemaOf(s *model.ResolvedSchema) map[string]interface{} {
+	switch s.Type {
+	case dtdlv0.Integer:
+		return map[string]interface{}{"type": "integer"}
+	case dtdlv0.Boolean:
+		return map[string]interface{}{"type": "boolean"}
+	case dtdlv0.Double:
+		return map[string]interface{}{"type": "number"}
+	case dtdlv0.Enumeration:
+		return map[string]interface{}{"type": "string", "enum": s.Enum.Spec.Values}
+	case dtdlv0.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchemaOf(s.Items)}
+	case dtdlv0.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchemaOf(s.Values)}
+	case dtdlv0.Object:
+		properties := map[string]interface{}{}
+		for i := range s.Fields {
+			properties[s.Fields[i].Name] = jsonSchemaOf(&s.Fields[i].ResolvedSchema)
+		}
+		return jsonSchemaObject(properties)
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+const (
+	maxFieldNumber           = 536870911
+	firstReservedFieldNumber = 19000
+	lastReservedFieldNumber  = 19999
+)
+
+// Numbering is a stable assignment of protobuf numbers to names. Numbers of
+// names that disappear move to Reserved and are never handed out again,
+// unless the same name comes back.
+type Numbering struct {
+	Assigned map[string]int32
+	Reserved map[string]int32
+}
+
+// Assign extends previous so that every name has a number. Existing
+// assignments are kept, pinned numbers are honoured and new names receive
+// the next number after every number ever used, starting at first.
+// Pins that would renumber a field or reuse another field's number are
+// reported as conflicts.
+func Assign(previous Numbering, names []string, pinned map[string]int32, first int32) (Numbering, error) {
+	next := Numbering{
+		Assigned: map[string]int32{},
+		Reserved: map[string]int32{},
+	}
+
+	owners := map[int32]string{}
+	highest := first - 1
+	for name, number := range previous.Assigned {
+		owners[number] = name
+		highest = max32(highest, number)
+	}
+	for name, number := range previous.Reserved {
+		owners[number] = name
+		highest = max32(highest, number)
+	}
+
+	var errs []error
+	pinnedBy := map[int32]string{}
+	for _, name := range sortedKeys(pinned) {
+		number := pinned[name]
+		switch {
+		case number < first || number > maxFieldNumber:
+			errs = append(errs, fmt.Errorf("%q: field number %d is out of range", name, number))
+			continue
+		case number >= firstReservedFieldNumber && number <= lastReservedFieldNumber:
+			errs = append(errs, fmt.Errorf("%q: field number %d is reserved by protobuf", name, number))
+			continue
+		}
+		if other, ok := pinnedBy[number]; ok {
+			errs = append(errs, fmt.Errorf("%q and %q are both pinned to field number %d", other, name, number))
+			continue
+		}
+		pinnedBy[number] = name
+
+		if current, ok := previous.Assigned[name]; ok && current != number {
+			errs = append(errs, fmt.Errorf("%q: cannot renumber field from %d to %d", name, current, number))
+			continue
+		}
+		if owner, ok := owners[number]; ok && owner != name {
+			errs = append(errs, fmt.Errorf("%q: field number %d is already used by %q", name, number, owner))
+		}
+	}
+	if len(errs) > 0 {
+		return previous, utilerrors.NewAggregate(errs)
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	for _, name := range names {
+		number, ok := pinned[name]
+		if !ok {
+			number, ok = previous.Assigned[name]
+		}
+		if !ok {
+			number, ok = previous.Reserved[name]
+		}
+		if ok {
+			next.Assigned[name] = number
+			highest = max32(highest, number)
+		}
+	}
+
+	for _, name := range names {
+		if _, ok := next.Assigned[name]; ok {
+			continue
+		}
+		highest++
+		if highest >= firstReservedFieldNumber && highest <= lastReservedFieldNumber {
+			highest = lastReservedFieldNumber + 1
+		}
+		next.Assigned[name] = highest
+	}
+
+	for name, number := range previous.Assigned {
+		if !wanted[name] {
+			next.Reserved[name] = number
+		}
+	}
+	for name, number := range previous.Reserved {
+		if !wanted[name] {
+			next.Reserved[name] = number
+		}
+	}
+
+	return next, nil
+}
+
+// AssignClass numbers every field of the class. Attributes and
+// relationships are numbered together; the fields of each nested object,
+// including the edges of relationships carrying properties, are
+// numbered within the message generated for that object and recorded under
+// their dotted path. Numbers of nested objects that disappear are reserved
+// so that they are restored if the object comes back.
+func AssignClass(previous Numbering, class *model.ResolvedClass) (Numbering, error) {
+	next := Numbering{
+		Assigned: map[string]int32{},
+		Reserved: map[string]int32{},
+	}
+
+	scopes := messageScopes(class)
+	current := map[string]bool{}
+	var errs []error
+	for _, scope := range scopes {
+		current[scope.path] = true
+
+		numbering, err := Assign(previous.Within(scope.path), scope.names, scope.pinned, 1)
+		if err != nil {
+			if scope.path != "" {
+				err = fmt.Errorf("object %q: %w", scope.path, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		for name, number := range numbering.Assigned {
+			next.Assigned[fieldPath(scope.path, name)] = number
+		}
+		for name, number := range numbering.Reserved {
+			next.Reserved[fieldPath(scope.path, name)] = number
+		}
+	}
+	if len(errs) > 0 {
+		return previous, utilerrors.NewAggregate(errs)
+	}
+
+	for _, numbers := range []map[string]int32{previous.Assigned, previous.Reserved} {
+		for path, number := range numbers {
+			if !current[parentPath(path)] {
+				next.Reserved[path] = number
+			}
+		}
+	}
+	return next, nil
+}
+
+// Within returns the numbers of the fields of the object at path, keyed by
+// their own name. The empty path selects the top-level fields.
+func (n Numbering) Within(path string) Numbering {
+	prefix := fieldPath(path, "")
+	within := func(numbers map[string]int32) map[string]int32 {
+		result := map[string]int32{}
+		for key, number := range numbers {
+			if parentPath(key) == path {
+				result[key[len(prefix):]] = number
+			}
+		}
+		return result
+	}
+	return Numbering{Assigned: within(n.Assigned), Reserved: within(n.Reserved)}
+}
+
+// scope is a message whose fields are numbered together.
+type scope struct {
+	path   string
+	names  []string
+	pinned map[string]int32
+}
+
+func messageScopes(class *model.ResolvedClass) []scope {
+	root := scope{pinned: map[string]int32{}}
+	for _, attribute := range class.Attributes {
+		root.names = append(root.names, attribute.Name)
+		if attribute.FieldNumber != 0 {
+			root.pinned[attribute.Name] = attribute.FieldNumber
+		}
+	}
+	for _, relationship := range class.Relationships {
+		root.names = append(root.names, relationship.Name)
+	}
+
+	scopes := []scope{root}
+	for i := range class.Attributes {
+		scopes = appendObjectScopes(scopes, &class.Attributes[i].ResolvedSchema, class.Attributes[i].Name)
+	}
+	for i := range class.Relationships {
+		scopes = appendObjectScopes(scopes, class.Relationships[i].Schema(), class.Relationships[i].Name)
+	}
+	for _, command := range class.Commands {
+		for _, payload := range commandPayloads(class, command) {
+			scopes = appendObjectScopes(scopes, &payload.attribute.ResolvedSchema, payload.path)
+		}
+	}
+	return scopes
+}
+
+// appendObjectScopes adds a scope for every object reachable from s without
+// crossing another object, recursing into the fields of those objects.
+// Array items and map values share the path of the field holding them.
+func appendObjectScopes(scopes []scope, s *model.ResolvedSchema, path string) []scope {
+	switch s.Type {
+	case dtdlv0.Array:
+		return appendObjectScopes(scopes, s.Items, path)
+	case dtdlv0.Map:
+		return appendObjectScopes(scopes, s.Values, path)
+	case dtdlv0.Object:
+		object := scope{path: path, pinned: map[string]int32{}}
+		for _, f := range s.Fields {
+			object.names = append(object.names, f.Name)
+			if f.FieldNumber != 0 {
+				object.pinned[f.Name] = f.FieldNumber
+			}
+		}
+		scopes = append(scopes, object)
+		for i := range s.Fields {
+			scopes = appendObjectScopes(scopes, &s.Fields[i].ResolvedSchema, fieldPath(path, s.Fields[i].Name))
+		}
+	}
+	return scopes
+}
+
+func fieldPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func parentPath(path string) string {
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+// Equal reports whether both numberings hold the same assignments, treating
+// nil and empty maps alike.
+func (n Numbering) Equal(other Numbering) bool {
+	return equalNumbers(n.Assigned, other.Assigned) && equalNumbers(n.Reserved, other.Reserved)
+}
+
+func equalNumbers(a, b map[string]int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, number := range a {
+		if other, ok := b[name]; !ok || other != number {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]int32) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func max32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,391 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/naming"
+)
+
+// Proto renders a proto3 file for the class: a message holding its
+// properties and relationships, a <Class>Telemetry message for its telemetry
+// and request and response messages for its commands, followed by one enum
+// per referenced TwinEnum. Objects become nested messages, arrays repeated
+// fields and maps map fields; arrays and maps nested directly in another
+// array or map are wrapped in a message, as protobuf cannot express them.
+// Field numbers are taken from numbering, which must cover every field
+// numbered by AssignClass.
+func Proto(class *model.ResolvedClass, numbering Numbering, pkg string) (string, error) {
+	state, telemetry, err := splitFields(class, numbering)
+	if err != nil {
+		return "", err
+	}
+
+	typeName := naming.GoName(class.Name)
+	messages := []*protoMessage{}
+	addMessage := func(name string, fields []field) error {
+		message, err := newProtoMessage(name, "", fields, numbering)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, message)
+		return nil
+	}
+
+	if err := addMessage(typeName, state); err != nil {
+		return "", err
+	}
+	if len(telemetry) > 0 {
+		if err := addMessage(typeName+TelemetrySuffix, telemetry); err != nil {
+			return "", err
+		}
+	}
+	for _, command := range class.Commands {
+		for _, payload := range commandPayloads(class, command) {
+			message := &protoMessage{name: payload.message}
+			fieldType, err := message.fieldType(&payload.attribute.ResolvedSchema, naming.GoName(payload.attribute.Name), payload.path, numbering)
+			if err != nil {
+				return "", err
+			}
+			message.fields = []protoField{{name: payload.attribute.Name, number: 1, fieldType: fieldType}}
+			messages = append(messages, message)
+		}
+	}
+
+	b := &strings.Builder{}
+	b.WriteString("// Code generated by dt-operator. DO NOT EDIT.\n")
+	b.WriteString("syntax = \"proto3\";\n\n")
+	fmt.Fprintf(b, "package %s;\n", pkg)
+	for _, message := range messages {
+		b.WriteString("\n")
+		message.write(b, "")
+	}
+	for _, enum := range referencedEnums(class) {
+		b.WriteString("\n")
+		writeProtoEnum(b, enum)
+	}
+
+	return b.String(), nil
+}
+
+// TelemetrySuffix is appended to the class name to form the name of the
+// message carrying its telemetry.
+const TelemetrySuffix = "Telemetry"
+
+// Suffixes of the messages generated for array items and map values.
+const (
+	itemSuffix  = "Item"
+	valueSuffix = "Value"
+)
+
+type protoMessage struct {
+	name     string
+	reserved map[string]int32
+	nested   []*protoMessage
+	fields   []protoField
+}
+
+type protoField struct {
+	name      string
+	number    int32
+	fieldType string
+}
+
+// newProtoMessage builds the message for the fields of the object at path,
+// declaring the nested messages their schemas need.
+func newProtoMessage(name, path string, fields []field, numbering Numbering) (*protoMessage, error) {
+	message := &protoMessage{name: name, reserved: map[string]int32{}}
+	for fieldName, number := range numbering.Within(path).Reserved {
+		message.reserved[naming.SnakeCase(fieldName)] = number
+	}
+
+	names := map[string]string{}
+	for _, f := range fields {
+		fieldName := naming.SnakeCase(f.name)
+		if previous, ok := names[fieldName]; ok {
+			return nil, fmt.Errorf("fields %q and %q both map to %s.%s", previous, f.name, name, fieldName)
+		}
+		names[fieldName] = f.name
+
+		fieldType, err := message.fieldType(f.schema, naming.GoName(f.name), fieldPath(path, f.name), numbering)
+		if err != nil {
+			return nil, err
+		}
+		message.fields = append(message.fields, protoField{name: f.name, number: f.number, fieldType: fieldType})
+	}
+	return message, nil
+}
+
+// fieldType returns the type of a field holding s. Messages the type needs
+// are nested in m and named after hint.
+func (m *protoMessage) fieldType(s *model.ResolvedSchema, hint, path string, numbering Numbering) (string, error) {
+	switch s.Type {
+	case dtdlv0.Integer:
+		return "int64", nil
+	case dtdlv0.Boolean:
+		return "bool", nil
+	case dtdlv0.Double:
+		return "double", nil
+	case dtdlv0.Enumeration:
+		return naming.GoName(s.Enum.Spec.Name), nil
+	case dtdlv0.Array:
+		items, err := m.elementType(s.Items, hint+itemSuffix, path, numbering)
+		return "repeated " + items, err
+	case dtdlv0.Map:
+		values, err := m.elementType(s.Values, hint+valueSuffix, path, numbering)
+		return "map<string, " + values + ">", err
+	case dtdlv0.Object:
+		fields, err := objectFields(s, path, numbering)
+		if err != nil {
+			return "", err
+		}
+		nested, err := newProtoMessage(hint, path, fields, numbering)
+		if err != nil {
+			return "", err
+		}
+		m.nested = append(m.nested, nested)
+		return hint, nil
+	default:
+		return "string", nil
+	}
+}
+
+// elementType returns the type of array items or map values, wrapping
+// arrays and maps in a message holding them as its only field.
+func (m *protoMessage) elementType(s *model.ResolvedSchema, hint, path string, numbering Numbering) (string, error) {
+	if s.Type != dtdlv0.Array && s.Type != dtdlv0.Map {
+		return m.fieldType(s, hint, path, numbering)
+	}
+
+	wrapper := &protoMessage{name: hint}
+	fieldType, err := wrapper.fieldType(s, hint, path, numbering)
+	if err != nil {
+		return "", err
+	}
+	name := "items"
+	if s.Type == dtdlv0.Map {
+		name = "values"
+	}
+	wrapper.fields = []protoField{{name: name, number: 1, fieldType: fieldType}}
+	m.nested = append(m.nested, wrapper)
+	return hint, nil
+}
+
+func (m *protoMessage) write(b *strings.Builder, indent string) {
+	fmt.Fprintf(b, "%smessage %s {\n", indent, m.name)
+	writeReserved(b, indent+"  ", m.reserved)
+	for _, nested := range m.nested {
+		nested.write(b, indent+"  ")
+	}
+	for _, f := range m.fields {
+		fieldName := naming.SnakeCase(f.name)
+		options := ""
+		if fieldName != f.name {
+			options = fmt.Sprintf(" [json_name = %q]", f.name)
+		}
+		fmt.Fprintf(b, "%s  %s %s = %d%s;\n", indent, f.fieldType, fieldName, f.number, options)
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+type payload struct {
+	message   string
+	path      string
+	attribute *model.ResolvedAttribute
+}
+
+// commandPayloads names the request and response messages of a command.
+// Their path is the numbering scope of objects nested in the payload.
+func commandPayloads(class *model.ResolvedClass, command model.ResolvedCommand) []payload {
+	prefix := naming.GoName(class.Name) + naming.GoName(command.Name)
+	payloads := []payload{}
+	if command.Request != nil {
+		payloads = append(payloads, payload{message: prefix + "Request", path: command.Name + ".request", attribute: command.Request})
+	}
+	if command.Response != nil {
+		payloads = append(payloads, payload{message: prefix + "Response", path: command.Name + ".response", attribute: command.Response})
+	}
+	return payloads
+}
+
+// splitFields numbers the fields of the class and separates telemetry from
+// the properties and relationships that make up the twin state.
+func splitFields(class *model.ResolvedClass, numbering Numbering) ([]field, []field, error) {
+	fields, err := numberedFields(class, numbering)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state := []field{}
+	telemetry := []field{}
+	for _, f := range fields {
+		if f.kind == dtdlv0.Telemetry {
+			telemetry = append(telemetry, f)
+		} else {
+			state = append(state, f)
+		}
+	}
+	return state, telemetry, nil
+}
+
+// field is a numbered field of a message.
+type field struct {
+	name         string
+	number       int32
+	kind         dtdlv0.ContentKind
+	schema       *model.ResolvedSchema
+	relationship bool
+}
+
+func numberedFields(class *model.ResolvedClass, numbering Numbering) ([]field, error) {
+	fields := []field{}
+	for i := range class.Attributes {
+		fields = append(fields, field{
+			name:   class.Attributes[i].Name,
+			kind:   class.Attributes[i].Kind,
+			schema: &class.Attributes[i].ResolvedSchema,
+		})
+	}
+	for i := range class.Relationships {
+		fields = append(fields, field{
+			name:         class.Relationships[i].Name,
+			schema:       class.Relationships[i].Schema(),
+			relationship: true,
+		})
+	}
+	return numberFields(fields, "", numbering)
+}
+
+// objectFields returns the numbered fields of the object schema at path.
+func objectFields(s *model.ResolvedSchema, path string, numbering Numbering) ([]field, error) {
+	fields := []field{}
+	for i := range s.Fields {
+		fields = append(fields, field{
+			name:   s.Fields[i].Name,
+			schema: &s.Fields[i].ResolvedSchema,
+		})
+	}
+	return numberFields(fields, path, numbering)
+}
+
+func numberFields(fields []field, path string, numbering Numbering) ([]field, error) {
+	for i := range fields {
+		name := fieldPath(path, fields[i].name)
+		number, ok := numbering.Assigned[name]
+		if !ok {
+			return nil, fmt.Errorf("field %q has no field number", name)
+		}
+		fields[i].number = number
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].number < fields[j].number
+	})
+	return fields, nil
+}
+
+// writeReserved renders reserved statements for the given identifiers and
+// their numbers.
+func writeReserved(b *strings.Builder, indent string, reserved map[string]int32) {
+	if len(reserved) == 0 {
+		return
+	}
+
+	names := sortedKeys(reserved)
+	numbers := make([]int, 0, len(names))
+	for _, name := range names {
+		numbers = append(numbers, int(reserved[name]))
+	}
+	sort.Ints(numbers)
+
+	numberList := make([]string, len(numbers))
+	for i, number := range numbers {
+		numberList[i] = fmt.Sprint(number)
+	}
+	nameList := make([]string, len(names))
+	for i, name := range names {
+		nameList[i] = fmt.Sprintf("%q", name)
+	}
+
+	fmt.Fprintf(b, "%sreserved %s;\n", indent, strings.Join(numberList, ", "))
+	fmt.Fprintf(b, "%sreserved %s;\n", indent, strings.Join(nameList, ", "))
+}
+
+func writeProtoEnum(b *strings.Builder, enum *dtdlv0.TwinEnum) {
+	prefix := naming.UpperSnakeCase(enum.Spec.Name)
+	numbers := EnumValueNumbers(enum)
+
+	fmt.Fprintf(b, "enum %s {\n", naming.GoName(enum.Spec.Name))
+	fmt.Fprintf(b, "  %s_UNSPECIFIED = 0;\n", prefix)
+
+	reserved := map[string]int32{}
+	for value, number := range enum.Status.ReservedValueNumbers {
+		reserved[prefix+"_"+naming.UpperSnakeCase(value)] = number
+	}
+	writeReserved(b, "  ", reserved)
+
+	values := append([]string(nil), enum.Spec.Values...)
+	sort.SliceStable(values, func(i, j int) bool {
+		return numbers[values[i]] < numbers[values[j]]
+	})
+	for _, value := range values {
+		fmt.Fprintf(b, "  %s_%s = %d;\n", prefix, naming.UpperSnakeCase(value), numbers[value])
+	}
+	b.WriteString("}\n")
+}
+
+// EnumValueNumbers returns the protobuf number of every enum value. Numbers
+// recorded in the enum status are used where present so that reordering
+// values does not renumber them.
+func EnumValueNumbers(enum *dtdlv0.TwinEnum) map[string]int32 {
+	previous := Numbering{
+		Assigned: enum.Status.ValueNumbers,
+		Reserved: enum.Status.ReservedValueNumbers,
+	}
+	// Without pinned numbers Assign cannot report a conflict.
+	numbering, _ := Assign(previous, enum.Spec.Values, nil, 1)
+	return numbering.Assigned
+}
+
+func referencedEnums(class *model.ResolvedClass) []*dtdlv0.TwinEnum {
+	seen := map[string]bool{}
+	enums := []*dtdlv0.TwinEnum{}
+	add := func(s *model.ResolvedSchema) {
+		if s.Enum == nil || seen[s.Enum.Spec.Name] {
+			return
+		}
+		seen[s.Enum.Spec.Name] = true
+		enums = append(enums, s.Enum)
+	}
+
+	for i := range class.Attributes {
+		class.Attributes[i].Walk(add)
+	}
+	for _, command := range class.Commands {
+		for _, payload := range []*model.ResolvedAttribute{command.Request, command.Response} {
+			if payload != nil {
+				payload.Walk(add)
+			}
+		}
+	}
+	return enums
+}
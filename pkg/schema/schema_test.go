@@ -0,0 +1,413 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+var _ = Describe("Assign", func() {
+	It("keeps existing numbers and appends new fields", func() {
+		previous := Numbering{Assigned: map[string]int32{"name": 1, "location": 2}}
+
+		numbering, err := Assign(previous, []string{"location", "type", "name"}, nil, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numbering.Assigned).To(Equal(map[string]int32{"name": 1, "location": 2, "type": 3}))
+		Expect(numbering.Reserved).To(BeEmpty())
+	})
+
+	It("reserves removed fields and never reuses their numbers", func() {
+		previous := Numbering{Assigned: map[string]int32{"name": 1, "location": 2}}
+
+		numbering, err := Assign(previous, []string{"name", "site"}, nil, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numbering.Assigned).To(Equal(map[string]int32{"name": 1, "site": 3}))
+		Expect(numbering.Reserved).To(Equal(map[string]int32{"location": 2}))
+
+		numbering, err = Assign(numbering, []string{"name", "site", "location"}, nil, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numbering.Assigned).To(HaveKeyWithValue("location", int32(2)))
+		Expect(numbering.Reserved).To(BeEmpty())
+	})
+
+	It("skips the range reserved by protobuf", func() {
+		previous := Numbering{Assigned: map[string]int32{"name": 18999}}
+
+		numbering, err := Assign(previous, []string{"name", "location"}, nil, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numbering.Assigned).To(HaveKeyWithValue("location", int32(20000)))
+	})
+
+	DescribeTable("rejects renumbering conflicts",
+		func(pinned map[string]int32, message string) {
+			previous := Numbering{
+				Assigned: map[string]int32{"name": 1, "location": 2},
+				Reserved: map[string]int32{"site": 3},
+			}
+
+			_, err := Assign(previous, []string{"name", "location", "type"}, pinned, 1)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("renumbered field", map[string]int32{"name": 4}, `"name": cannot renumber field from 1 to 4`),
+		Entry("number of another field", map[string]int32{"type": 2}, `field number 2 is already used by "location"`),
+		Entry("number of a removed field", map[string]int32{"type": 3}, `field number 3 is already used by "site"`),
+		Entry("duplicate pins", map[string]int32{"type": 7, "location": 7}, `are both pinned to field number 7`),
+		Entry("protobuf reserved range", map[string]int32{"type": 19500}, `reserved by protobuf`),
+	)
+})
+
+var _ = Describe("Generators", func() {
+	var resolved *model.ResolvedClass
+	var numbering Numbering
+
+	BeforeEach(func() {
+		m := model.New([]dtdlv0.TwinClass{{
+			Spec: dtdlv0.TwinClassSpec{
+				Name: "Factory",
+				Attributes: []dtdlv0.TwinClassAttributes{
+					{Name: "name", TwinSchema: dtdlv0.TwinSchema{Type: "string"}},
+					{Name: "type", TwinSchema: dtdlv0.TwinSchema{Type: "enumeration", Reference: "FactoryType"}},
+					{Name: "employeeCount", TwinSchema: dtdlv0.TwinSchema{Type: "integer"}},
+					{Name: "power", TwinSchema: dtdlv0.TwinSchema{Type: "double"}, Kind: dtdlv0.Telemetry},
+				},
+				Commands: []dtdlv0.TwinCommand{{
+					Name:     "shutdown",
+					Request:  &dtdlv0.TwinCommandPayload{Name: "delay", TwinSchema: dtdlv0.TwinSchema{Type: "integer"}},
+					Response: &dtdlv0.TwinCommandPayload{Name: "type", TwinSchema: dtdlv0.TwinSchema{Type: "enumeration", Reference: "FactoryType"}},
+				}},
+				Relationships: []dtdlv0.TwinRelationship{
+					{Name: "machines", Multiplicity: dtdlv0.MANY, Reference: "Machine"},
+				},
+			},
+		}}, []dtdlv0.TwinEnum{{
+			Spec: dtdlv0.TwinEnumSpec{Name: "FactoryType", Values: []string{"Headquarter", "Branch"}},
+			Status: dtdlv0.TwinEnumStatus{
+				ValueNumbers:         map[string]int32{"Headquarter": 2, "Branch": 3},
+				ReservedValueNumbers: map[string]int32{"Plant": 1},
+			},
+		}})
+
+		var err error
+		resolved, err = m.Resolve("Factory")
+		Expect(err).NotTo(HaveOccurred())
+
+		numbering = Numbering{
+			Assigned: map[string]int32{"name": 1, "type": 2, "employeeCount": 4, "machines": 5, "power": 6},
+			Reserved: map[string]int32{"location": 3},
+		}
+	})
+
+	It("renders proto3 messages ordered by field number", func() {
+		proto, err := Proto(resolved, numbering, "digitaltwin.default")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proto).To(Equal(`// Code generated by dt-operator. DO NOT EDIT.
+syntax = "proto3";
+
+package digitaltwin.default;
+
+message Factory {
+  reserved 3;
+  reserved "location";
+  string name = 1;
+  FactoryType type = 2;
+  int64 employee_count = 4 [json_name = "employeeCount"];
+  repeated string machines = 5;
+}
+
+message FactoryTelemetry {
+  reserved 3;
+  reserved "location";
+  double power = 6;
+}
+
+message FactoryShutdownRequest {
+  int64 delay = 1;
+}
+
+message FactoryShutdownResponse {
+  FactoryType type = 1;
+}
+
+enum FactoryType {
+  FACTORY_TYPE_UNSPECIFIED = 0;
+  reserved 1;
+  reserved "FACTORY_TYPE_PLANT";
+  FACTORY_TYPE_HEADQUARTER = 2;
+  FACTORY_TYPE_BRANCH = 3;
+}
+`))
+	})
+
+	It("renders Avro records with defaults", func() {
+		schemas, err := Avro(resolved, numbering, "digitaltwin.default")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(schemas).To(HaveLen(4))
+		Expect(schemas).To(HaveKey("FactoryTelemetry"))
+		Expect(schemas).To(HaveKey("FactoryShutdownRequest"))
+		Expect(schemas).To(HaveKey("FactoryShutdownResponse"))
+
+		record := map[string]interface{}{}
+		Expect(json.Unmarshal([]byte(schemas["Factory"]), &record)).To(Succeed())
+		Expect(record).To(HaveKeyWithValue("name", "Factory"))
+		Expect(record).To(HaveKeyWithValue("namespace", "digitaltwin.default"))
+
+		fields := record["fields"].([]interface{})
+		Expect(fields).To(HaveLen(4))
+		Expect(fields[1]).To(Equal(map[string]interface{}{
+			"name": "type",
+			"type": []interface{}{"null", map[string]interface{}{
+				"type":    "enum",
+				"name":    "FactoryType",
+				"symbols": []interface{}{"Headquarter", "Branch"},
+			}},
+			"default": nil,
+		}))
+		Expect(fields[3]).To(HaveKeyWithValue("default", []interface{}{}))
+	})
+
+	It("fails when a field has no number", func() {
+		delete(numbering.Assigned, "machines")
+
+		_, err := Proto(resolved, numbering, "digitaltwin.default")
+		Expect(err).To(MatchError(`field "machines" has no field number`))
+	})
+})
+
+var _ = Describe("Nested schemas", func() {
+	var resolved *model.ResolvedClass
+
+	BeforeEach(func() {
+		m := model.New([]dtdlv0.TwinClass{{
+			Spec: dtdlv0.TwinClassSpec{
+				Name: "Machine",
+				Attributes: []dtdlv0.TwinClassAttributes{
+					{Name: "position", TwinSchema: dtdlv0.TwinSchema{Type: "object", Fields: []dtdlv0.TwinSchemaField{
+						{Name: "x", TwinSchema: dtdlv0.TwinSchema{Type: "double"}},
+						{Name: "y", TwinSchema: dtdlv0.TwinSchema{Type: "double"}},
+					}}},
+					{Name: "spindles", TwinSchema: dtdlv0.TwinSchema{Type: "array", Items: &dtdlv0.TwinSchema{
+						Type: "object", Fields: []dtdlv0.TwinSchemaField{
+							{Name: "rpm", TwinSchema: dtdlv0.TwinSchema{Type: "integer"}},
+						},
+					}}},
+					{Name: "matrix", TwinSchema: dtdlv0.TwinSchema{Type: "map", Values: &dtdlv0.TwinSchema{
+						Type: "array", Items: &dtdlv0.TwinSchema{Type: "double"},
+					}}},
+				},
+			},
+		}}, nil)
+
+		var err error
+		resolved, err = m.Resolve("Machine")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("numbers nested fields within their object", func() {
+		previous := Numbering{Assigned: map[string]int32{"position": 1, "position.x": 1, "position.z": 2}}
+
+		numbering, err := AssignClass(previous, resolved)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numbering.Assigned).To(Equal(map[string]int32{
+			"position":     1,
+			"spindles":     2,
+			"matrix":       3,
+			"position.x":   1,
+			"position.y":   3,
+			"spindles.rpm": 1,
+		}))
+		Expect(numbering.Reserved).To(Equal(map[string]int32{"position.z": 2}))
+	})
+
+	It("reserves the fields of removed objects", func() {
+		previous := Numbering{Assigned: map[string]int32{"position": 1, "gone": 4, "gone.a": 1}}
+
+		numbering, err := AssignClass(previous, resolved)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numbering.Reserved).To(Equal(map[string]int32{"gone": 4, "gone.a": 1}))
+	})
+
+	It("reports conflicts with the object path", func() {
+		resolved.Attributes[0].Fields[1].FieldNumber = 1
+
+		_, err := AssignClass(Numbering{Assigned: map[string]int32{"position.x": 1}}, resolved)
+		Expect(err).To(MatchError(ContainSubstring(`object "position": "y": field number 1 is already used by "x"`)))
+	})
+
+	It("renders nested messages, repeated and map fields", func() {
+		numbering, err := AssignClass(Numbering{Reserved: map[string]int32{"position.z": 3}}, resolved)
+		Expect(err).NotTo(HaveOccurred())
+
+		proto, err := Proto(resolved, numbering, "digitaltwin.default")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proto).To(Equal(`// Code generated by dt-operator. DO NOT EDIT.
+syntax = "proto3";
+
+package digitaltwin.default;
+
+message Machine {
+  message Position {
+    reserved 3;
+    reserved "z";
+    double x = 4;
+    double y = 5;
+  }
+  message SpindlesItem {
+    int64 rpm = 1;
+  }
+  message MatrixValue {
+    repeated double items = 1;
+  }
+  Position position = 1;
+  repeated SpindlesItem spindles = 2;
+  map<string, MatrixValue> matrix = 3;
+}
+`))
+	})
+
+	It("renders nested Avro records", func() {
+		numbering, err := AssignClass(Numbering{}, resolved)
+		Expect(err).NotTo(HaveOccurred())
+
+		schemas, err := Avro(resolved, numbering, "digitaltwin.default")
+		Expect(err).NotTo(HaveOccurred())
+
+		record := map[string]interface{}{}
+		Expect(json.Unmarshal([]byte(schemas["Machine"]), &record)).To(Succeed())
+		fields := record["fields"].([]interface{})
+		Expect(fields[1]).To(Equal(map[string]interface{}{
+			"name": "spindles",
+			"type": []interface{}{"null", map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "record",
+					"name": "MachineSpindlesItem",
+					"fields": []interface{}{map[string]interface{}{
+						"name":    "rpm",
+						"type":    []interface{}{"null", "long"},
+						"default": nil,
+					}},
+				},
+			}},
+			"default": nil,
+		}))
+		Expect(fields[2]).To(HaveKeyWithValue("type", []interface{}{"null", map[string]interface{}{
+			"type":   "map",
+			"values": map[string]interface{}{"type": "array", "items": "double"},
+		}}))
+	})
+
+	It("renders JSON Schemas", func() {
+		schemas, err := JSONSchema(resolved)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(schemas).To(HaveLen(1))
+
+		document := map[string]interface{}{}
+		Expect(json.Unmarshal([]byte(schemas["Machine"]), &document)).To(Succeed())
+		Expect(document).To(HaveKeyWithValue("$schema", JSONSchemaDialect))
+		Expect(document).To(HaveKeyWithValue("additionalProperties", false))
+		Expect(document["properties"]).To(Equal(map[string]interface{}{
+			"position": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"x": map[string]interface{}{"type": "number"},
+					"y": map[string]interface{}{"type": "number"},
+				},
+				"additionalProperties": false,
+			},
+			"spindles": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type":                 "object",
+					"properties":           map[string]interface{}{"rpm": map[string]interface{}{"type": "integer"}},
+					"additionalProperties": false,
+				},
+			},
+			"matrix": map[string]interface{}{
+				"type": "object",
+				"additionalProperties": map[string]interface{}{
+					"type":  "array",
+					"items": map[string]interface{}{"type": "number"},
+				},
+			},
+		}))
+	})
+})
+
+var _ = Describe("Relationship properties", func() {
+	var resolved *model.ResolvedClass
+
+	BeforeEach(func() {
+		one := int32(1)
+		m := model.New([]dtdlv0.TwinClass{{
+			Spec: dtdlv0.TwinClassSpec{
+				Name: "Line",
+				Relationships: []dtdlv0.TwinRelationship{
+					{Name: "feeds", Targets: []string{"Machine"}, Properties: []dtdlv0.TwinSchemaField{
+						{Name: "capacity", TwinSchema: dtdlv0.TwinSchema{Type: "integer"}, FieldNumber: 5},
+					}},
+					{Name: "head", MaxMultiplicity: &one, Targets: []string{"Machine"}},
+				},
+			},
+		}}, nil)
+
+		var err error
+		resolved, err = m.Resolve("Line")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("numbers the edge fields after the target", func() {
+		numbering, err := AssignClass(Numbering{}, resolved)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(numbering.Assigned).To(Equal(map[string]int32{
+			"feeds":          1,
+			"head":           2,
+			"feeds.target":   1,
+			"feeds.capacity": 5,
+		}))
+	})
+
+	It("renders edges as nested messages and records", func() {
+		numbering, err := AssignClass(Numbering{}, resolved)
+		Expect(err).NotTo(HaveOccurred())
+
+		proto, err := Proto(resolved, numbering, "digitaltwin.default")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(proto).To(ContainSubstring(`message Line {
+  message FeedsItem {
+    string target = 1;
+    int64 capacity = 5;
+  }
+  repeated FeedsItem feeds = 1;
+  string head = 2;
+}`))
+
+		schemas, err := Avro(resolved, numbering, "digitaltwin.default")
+		Expect(err).NotTo(HaveOccurred())
+		record := map[string]interface{}{}
+		Expect(json.Unmarshal([]byte(schemas["Line"]), &record)).To(Succeed())
+		fields := record["fields"].([]interface{})
+		Expect(fields[0]).To(HaveKeyWithValue("default", []interface{}{}))
+		Expect(fields[0].(map[string]interface{})["type"]).To(HaveKeyWithValue("type", "array"))
+		Expect(fields[1]).To(HaveKeyWithValue("type", []interface{}{"null", "string"}))
+	})
+})
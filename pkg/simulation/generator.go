@@ -0,0 +1,163 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulation
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"time"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+// FlipProbability is the chance a random walk flips a boolean per sample.
+const FlipProbability = 0.1
+
+// source produces the values of one attribute of one virtual instance.
+// elapsed is the time since the simulation started.
+type source interface {
+	next(elapsed time.Duration) interface{}
+}
+
+// newSource returns the source of a schema. Every virtual instance gets its
+// own sources, starting from random values so that instances differ.
+func newSource(schema *Schema, generator *Generator, rnd *rand.Rand) source {
+	if generator == nil {
+		fields := map[string]source{}
+		for i := range schema.Fields {
+			fields[schema.Fields[i].Name] = newSource(&schema.Fields[i].Schema, schema.Fields[i].Generator, rnd)
+		}
+		return objectSource(fields)
+	}
+
+	integer := schema.Type == dtdlv0.Integer
+	switch generator.Type {
+	case dtdlv1.RandomWalkGenerator:
+		switch schema.Type {
+		case dtdlv0.Boolean:
+			return &flipSource{value: rnd.Intn(2) == 0, rnd: rnd}
+		case dtdlv0.Enumeration:
+			return &enumWalkSource{values: schema.Enum, index: rnd.Intn(len(schema.Enum)), rnd: rnd}
+		}
+		return &walkSource{
+			generator: generator,
+			value:     generator.Min + rnd.Float64()*(generator.Max-generator.Min),
+			integer:   integer,
+			rnd:       rnd,
+		}
+	case dtdlv1.SineGenerator:
+		return &sineSource{generator: generator, phase: rnd.Float64() * 2 * math.Pi, integer: integer}
+	case dtdlv1.EnumCycleGenerator:
+		return &cycleSource{values: schema.Enum, index: rnd.Intn(len(schema.Enum))}
+	}
+	var value interface{}
+	_ = json.Unmarshal(generator.Value, &value)
+	return constantSource{value: value}
+}
+
+type walkSource struct {
+	generator *Generator
+	value     float64
+	integer   bool
+	rnd       *rand.Rand
+}
+
+func (s *walkSource) next(time.Duration) interface{} {
+	s.value += (s.rnd.Float64()*2 - 1) * s.generator.Step
+	s.value = math.Max(s.generator.Min, math.Min(s.generator.Max, s.value))
+	if s.integer {
+		return int64(math.Round(s.value))
+	}
+	return s.value
+}
+
+type flipSource struct {
+	value bool
+	rnd   *rand.Rand
+}
+
+func (s *flipSource) next(time.Duration) interface{} {
+	if s.rnd.Float64() < FlipProbability {
+		s.value = !s.value
+	}
+	return s.value
+}
+
+type enumWalkSource struct {
+	values []string
+	index  int
+	rnd    *rand.Rand
+}
+
+func (s *enumWalkSource) next(time.Duration) interface{} {
+	s.index += s.rnd.Intn(3) - 1
+	if s.index < 0 {
+		s.index = 0
+	}
+	if s.index >= len(s.values) {
+		s.index = len(s.values) - 1
+	}
+	return s.values[s.index]
+}
+
+type sineSource struct {
+	generator *Generator
+	phase     float64
+	integer   bool
+}
+
+func (s *sineSource) next(elapsed time.Duration) interface{} {
+	middle := (s.generator.Max + s.generator.Min) / 2
+	amplitude := (s.generator.Max - s.generator.Min) / 2
+	angle := 2*math.Pi*float64(elapsed)/float64(s.generator.Period.Duration) + s.phase
+	value := middle + amplitude*math.Sin(angle)
+	if s.integer {
+		return int64(math.Round(value))
+	}
+	return value
+}
+
+type cycleSource struct {
+	values []string
+	index  int
+}
+
+func (s *cycleSource) next(time.Duration) interface{} {
+	value := s.values[s.index%len(s.values)]
+	s.index++
+	return value
+}
+
+type constantSource struct {
+	value interface{}
+}
+
+func (s constantSource) next(time.Duration) interface{} {
+	return s.value
+}
+
+type objectSource map[string]source
+
+func (s objectSource) next(elapsed time.Duration) interface{} {
+	value := map[string]interface{}{}
+	for name, field := range s {
+		value[name] = field.next(elapsed)
+	}
+	return value
+}
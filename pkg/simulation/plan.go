@@ -0,0 +1,352 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package simulation publishes synthetic telemetry and properties for
+// virtual instances of twin classes. The operator resolves a TwinSimulation
+// into a Plan, which the simulator binary runs against the broker.
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+// PlanKey is the key of the plan in the ConfigMap mounted by the simulator.
+const PlanKey = "simulation.json"
+
+// Defaults of generators left unset in a TwinSimulation.
+const (
+	DefaultMin    = 0
+	DefaultMax    = 100
+	DefaultPeriod = time.Minute
+	DefaultString = "simulated"
+)
+
+// Plan is a resolved TwinSimulation: every attribute of every simulated
+// class has a generator.
+type Plan struct {
+	Namespace string `json:"namespace"`
+	// Interval is the time between two samples of a virtual instance.
+	Interval metav1.Duration `json:"interval"`
+	Jitter   metav1.Duration `json:"jitter,omitempty"`
+	Burst    *Burst          `json:"burst,omitempty"`
+	Classes  []Class         `json:"classes"`
+}
+
+// Burst publishes Size samples back to back every Every.
+type Burst struct {
+	Size  int             `json:"size"`
+	Every metav1.Duration `json:"every"`
+}
+
+// Class holds the virtual instances of a class, named <Prefix>-<n>.
+type Class struct {
+	Name       string      `json:"name"`
+	Prefix     string      `json:"prefix"`
+	Instances  int         `json:"instances"`
+	Attributes []Attribute `json:"attributes"`
+}
+
+// Instance returns the name of the i-th virtual instance of the class.
+func (c *Class) Instance(i int) string {
+	return fmt.Sprintf("%s-%d", c.Prefix, i)
+}
+
+// Attribute is a simulated attribute. Properties are published retained,
+// and only when their value changes; telemetry is published every sample.
+type Attribute struct {
+	Name      string     `json:"name"`
+	Property  bool       `json:"property,omitempty"`
+	Schema    Schema     `json:"schema"`
+	Generator *Generator `json:"generator,omitempty"`
+}
+
+// Schema is the part of a resolved schema generators need. Objects
+// without a generator are generated field by field.
+type Schema struct {
+	Type   dtdlv0.PrimitiveTypes `json:"type"`
+	Enum   []string              `json:"enum,omitempty"`
+	Fields []Field               `json:"fields,omitempty"`
+}
+
+// Field is a field of an object schema.
+type Field struct {
+	Name      string     `json:"name"`
+	Schema    Schema     `json:"schema"`
+	Generator *Generator `json:"generator,omitempty"`
+}
+
+// Generator is a TwinGenerator with its defaults applied.
+type Generator struct {
+	Type   dtdlv1.GeneratorType `json:"type"`
+	Min    float64              `json:"min,omitempty"`
+	Max    float64              `json:"max,omitempty"`
+	Step   float64              `json:"step,omitempty"`
+	Period metav1.Duration      `json:"period,omitempty"`
+	Value  json.RawMessage      `json:"value,omitempty"`
+}
+
+// Build resolves a TwinSimulation against the twin model of its namespace.
+// Errors name the field of the simulation at fault.
+func Build(simulation *dtdlv1.TwinSimulation, m *model.Model) (*Plan, error) {
+	spec := &simulation.Spec
+	path := field.NewPath("spec")
+	errs := field.ErrorList{}
+
+	plan := &Plan{
+		Namespace: simulation.Namespace,
+		Interval:  metav1.Duration{Duration: time.Second},
+		Classes:   []Class{},
+	}
+	if spec.Rate != nil {
+		rate := spec.Rate.AsApproximateFloat64()
+		if rate <= 0 {
+			errs = append(errs, field.Invalid(path.Child("rate"), spec.Rate.String(), "must be positive"))
+		} else {
+			plan.Interval.Duration = time.Duration(float64(time.Second) / rate)
+		}
+	}
+	if spec.Jitter != nil {
+		plan.Jitter = *spec.Jitter
+	}
+	if spec.Burst != nil {
+		if spec.Burst.Every.Duration <= 0 {
+			errs = append(errs, field.Invalid(path.Child("burst", "every"), spec.Burst.Every.String(), "must be positive"))
+		}
+		plan.Burst = &Burst{Size: int(spec.Burst.Size), Every: spec.Burst.Every}
+	}
+
+	for i, simulated := range spec.Classes {
+		classPath := path.Child("classes").Index(i)
+		twinClass, err := m.Select(simulated.Class.String())
+		if err != nil {
+			errs = append(errs, field.NotFound(classPath.Child("class"), simulated.Class.String()))
+			continue
+		}
+		resolved, err := m.ResolveClass(twinClass)
+		if err != nil {
+			errs = append(errs, field.Invalid(classPath.Child("class"), simulated.Class.String(), err.Error()))
+			continue
+		}
+
+		class := Class{
+			Name:       resolved.Name,
+			Prefix:     simulation.Name + "-" + strings.ToLower(resolved.Name),
+			Instances:  int(simulated.Instances),
+			Attributes: []Attribute{},
+		}
+		if class.Instances < 1 {
+			class.Instances = 1
+		}
+		known := map[string]bool{}
+		for _, attribute := range resolved.Attributes {
+			known[attribute.Name] = true
+			schema := convertSchema(&attribute.ResolvedSchema)
+			generatorPath := classPath.Child("generators").Key(attribute.Name)
+			generator, err := buildGenerator(simulated.Generators, attribute.Name, &schema, generatorPath)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			class.Attributes = append(class.Attributes, Attribute{
+				Name:      attribute.Name,
+				Property:  attribute.Kind == dtdlv0.Property,
+				Schema:    schema,
+				Generator: generator,
+			})
+		}
+		for name := range simulated.Generators {
+			if !known[name] {
+				errs = append(errs, field.NotFound(classPath.Child("generators").Key(name), name))
+			}
+		}
+		plan.Classes = append(plan.Classes, class)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs.ToAggregate()
+	}
+	return plan, nil
+}
+
+// Instances returns the number of virtual instances of the plan.
+func (p *Plan) Instances() int {
+	instances := 0
+	for _, class := range p.Classes {
+		instances += class.Instances
+	}
+	return instances
+}
+
+func convertSchema(resolved *model.ResolvedSchema) Schema {
+	schema := Schema{Type: resolved.Type}
+	if resolved.Enum != nil {
+		schema.Enum = resolved.Enum.Spec.Values
+	}
+	for i := range resolved.Fields {
+		schema.Fields = append(schema.Fields, Field{
+			Name:   resolved.Fields[i].Name,
+			Schema: convertSchema(&resolved.Fields[i].ResolvedSchema),
+		})
+	}
+	return schema
+}
+
+// buildGenerator returns the generator of an attribute: the one of the
+// simulation when set, the default of its type otherwise. Objects without
+// a generator get default generators for their fields instead.
+func buildGenerator(generators map[string]dtdlv1.TwinGenerator, name string, schema *Schema, path *field.Path) (*Generator, *field.Error) {
+	spec, ok := generators[name]
+	if !ok {
+		return defaultGenerator(schema, path)
+	}
+
+	generator := &Generator{
+		Type:   spec.Type,
+		Min:    DefaultMin,
+		Max:    DefaultMax,
+		Period: metav1.Duration{Duration: DefaultPeriod},
+	}
+	if spec.Min != nil {
+		generator.Min = spec.Min.AsApproximateFloat64()
+	}
+	if spec.Max != nil {
+		generator.Max = spec.Max.AsApproximateFloat64()
+	}
+	if generator.Min > generator.Max {
+		return nil, field.Invalid(path.Child("min"), generator.Min, "must not be greater than max")
+	}
+	generator.Step = (generator.Max - generator.Min) / 100
+	if spec.Step != nil {
+		generator.Step = spec.Step.AsApproximateFloat64()
+	}
+	if spec.Period != nil {
+		if spec.Period.Duration <= 0 {
+			return nil, field.Invalid(path.Child("period"), spec.Period.String(), "must be positive")
+		}
+		generator.Period = *spec.Period
+	}
+
+	numeric := schema.Type == dtdlv0.Integer || schema.Type == dtdlv0.Double
+	switch spec.Type {
+	case dtdlv1.RandomWalkGenerator:
+		if !numeric && schema.Type != dtdlv0.Boolean && schema.Type != dtdlv0.Enumeration {
+			return nil, field.Invalid(path.Child("type"), spec.Type, "a random walk needs a number, a boolean or an enumeration, not "+string(schema.Type))
+		}
+	case dtdlv1.SineGenerator:
+		if !numeric {
+			return nil, field.Invalid(path.Child("type"), spec.Type, "a sine needs a number, not "+string(schema.Type))
+		}
+	case dtdlv1.EnumCycleGenerator:
+		if schema.Type != dtdlv0.Enumeration {
+			return nil, field.Invalid(path.Child("type"), spec.Type, "an enum cycle needs an enumeration, not "+string(schema.Type))
+		}
+	case dtdlv1.ConstantGenerator:
+		if spec.Value == nil {
+			return nil, field.Required(path.Child("value"), "a constant needs a value")
+		}
+		if err := checkValue(schema, spec.Value.Raw); err != nil {
+			return nil, field.Invalid(path.Child("value"), string(spec.Value.Raw), err.Error())
+		}
+		generator.Value = spec.Value.Raw
+		return generator, nil
+	}
+	if schema.Type == dtdlv0.Enumeration && len(schema.Enum) == 0 {
+		return nil, field.Invalid(path, schema.Type, "the enumeration has no values")
+	}
+	return generator, nil
+}
+
+// defaultGenerator walks numbers between DefaultMin and DefaultMax, flips
+// booleans, cycles through enumerations and publishes DefaultString for
+// strings and empty arrays and maps.
+func defaultGenerator(schema *Schema, path *field.Path) (*Generator, *field.Error) {
+	switch schema.Type {
+	case dtdlv0.Boolean:
+		return &Generator{Type: dtdlv1.RandomWalkGenerator}, nil
+	case dtdlv0.Integer, dtdlv0.Double:
+		return &Generator{
+			Type: dtdlv1.RandomWalkGenerator,
+			Min:  DefaultMin,
+			Max:  DefaultMax,
+			Step: (DefaultMax - DefaultMin) / 100,
+		}, nil
+	case dtdlv0.Enumeration:
+		if len(schema.Enum) == 0 {
+			return nil, field.Invalid(path, schema.Type, "the enumeration has no values")
+		}
+		return &Generator{Type: dtdlv1.EnumCycleGenerator}, nil
+	case dtdlv0.String:
+		return &Generator{Type: dtdlv1.ConstantGenerator, Value: json.RawMessage(`"` + DefaultString + `"`)}, nil
+	case dtdlv0.Array:
+		return &Generator{Type: dtdlv1.ConstantGenerator, Value: json.RawMessage(`[]`)}, nil
+	case dtdlv0.Map:
+		return &Generator{Type: dtdlv1.ConstantGenerator, Value: json.RawMessage(`{}`)}, nil
+	}
+
+	for i := range schema.Fields {
+		generator, err := defaultGenerator(&schema.Fields[i].Schema, path.Key(schema.Fields[i].Name))
+		if err != nil {
+			return nil, err
+		}
+		schema.Fields[i].Generator = generator
+	}
+	return nil, nil
+}
+
+// checkValue checks that a constant matches the type of a schema. The
+// contents of arrays, maps and objects are left to the services.
+func checkValue(schema *Schema, raw []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return err
+	}
+
+	ok := false
+	switch schema.Type {
+	case dtdlv0.Integer:
+		number, isNumber := value.(float64)
+		ok = isNumber && number == float64(int64(number))
+	case dtdlv0.Double:
+		_, ok = value.(float64)
+	case dtdlv0.Boolean:
+		_, ok = value.(bool)
+	case dtdlv0.String:
+		_, ok = value.(string)
+	case dtdlv0.Enumeration:
+		text, _ := value.(string)
+		for _, enumValue := range schema.Enum {
+			ok = ok || text == enumValue
+		}
+	case dtdlv0.Array:
+		_, ok = value.([]interface{})
+	case dtdlv0.Map, dtdlv0.Object:
+		_, ok = value.(map[string]interface{})
+	}
+	if !ok {
+		return fmt.Errorf("not a valid %s", schema.Type)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulation
+
+import (
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+func quantity(value string) *resource.Quantity {
+	q := resource.MustParse(value)
+	return &q
+}
+
+var _ = Describe("Simulation", func() {
+	var m *model.Model
+	var twinSimulation *dtdlv1.TwinSimulation
+
+	BeforeEach(func() {
+		m = model.New([]dtdlv0.TwinClass{{Spec: dtdlv0.TwinClassSpec{
+			Name: "Machine",
+			Attributes: []dtdlv0.TwinClassAttributes{
+				{Name: "temperature", TwinSchema: dtdlv0.TwinSchema{Type: "double"}, Kind: dtdlv0.Telemetry},
+				{Name: "speed", TwinSchema: dtdlv0.TwinSchema{Type: "integer"}, Kind: dtdlv0.Telemetry},
+				{Name: "state", TwinSchema: dtdlv0.TwinSchema{Type: "enumeration", Reference: "State"}},
+				{Name: "running", TwinSchema: dtdlv0.TwinSchema{Type: "boolean"}, Kind: dtdlv0.Telemetry},
+				{Name: "serial", TwinSchema: dtdlv0.TwinSchema{Type: "string"}},
+				{Name: "position", TwinSchema: dtdlv0.TwinSchema{Type: "object", Fields: []dtdlv0.TwinSchemaField{
+					{Name: "x", TwinSchema: dtdlv0.TwinSchema{Type: "double"}},
+				}}, Kind: dtdlv0.Telemetry},
+			},
+		}}}, []dtdlv0.TwinEnum{{Spec: dtdlv0.TwinEnumSpec{Name: "State", Values: []string{"idle", "busy", "down"}}}})
+
+		twinSimulation = &dtdlv1.TwinSimulation{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "plant"},
+			Spec: dtdlv1.TwinSimulationSpec{
+				Rate:   quantity("500m"),
+				Jitter: &metav1.Duration{Duration: 100 * time.Millisecond},
+				Classes: []dtdlv1.TwinSimulationClass{{
+					Class:     dtdlv1.TwinClassReference{Name: "Machine"},
+					Instances: 2,
+					Generators: map[string]dtdlv1.TwinGenerator{
+						"temperature": {Type: dtdlv1.SineGenerator, Min: quantity("20"), Max: quantity("30"), Period: &metav1.Duration{Duration: time.Minute}},
+						"speed":       {Type: dtdlv1.RandomWalkGenerator, Min: quantity("0"), Max: quantity("10"), Step: quantity("3")},
+						"serial":      {Type: dtdlv1.ConstantGenerator, Value: &apiextensionsv1.JSON{Raw: []byte(`"M-1"`)}},
+					},
+				}},
+			},
+		}
+	})
+
+	It("resolves simulations into plans", func() {
+		plan, err := Build(twinSimulation, m)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.Interval.Duration).To(Equal(2 * time.Second))
+		Expect(plan.Jitter.Duration).To(Equal(100 * time.Millisecond))
+		Expect(plan.Instances()).To(Equal(2))
+
+		class := plan.Classes[0]
+		Expect(class.Instance(1)).To(Equal("test-machine-1"))
+		Expect(class.Attributes).To(HaveLen(6))
+		Expect(class.Attributes[0].Generator).To(Equal(&Generator{
+			Type: dtdlv1.SineGenerator, Min: 20, Max: 30, Step: 0.1, Period: metav1.Duration{Duration: time.Minute},
+		}))
+		Expect(class.Attributes[2].Property).To(BeTrue())
+		Expect(class.Attributes[2].Schema.Enum).To(Equal([]string{"idle", "busy", "down"}))
+		Expect(class.Attributes[2].Generator.Type).To(Equal(dtdlv1.EnumCycleGenerator))
+		Expect(class.Attributes[5].Generator).To(BeNil())
+		Expect(class.Attributes[5].Schema.Fields[0].Generator.Type).To(Equal(dtdlv1.RandomWalkGenerator))
+	})
+
+	DescribeTable("reports invalid simulations with their field path",
+		func(mutate func(*dtdlv1.TwinSimulation), message string) {
+			mutate(twinSimulation)
+			_, err := Build(twinSimulation, m)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("unknown class", func(s *dtdlv1.TwinSimulation) {
+			s.Spec.Classes[0].Class.Name = "Robot"
+		}, "spec.classes[0].class: Not found"),
+		Entry("unknown attribute", func(s *dtdlv1.TwinSimulation) {
+			s.Spec.Classes[0].Generators["pressure"] = dtdlv1.TwinGenerator{Type: dtdlv1.SineGenerator}
+		}, "spec.classes[0].generators[pressure]: Not found"),
+		Entry("sine of a string", func(s *dtdlv1.TwinSimulation) {
+			s.Spec.Classes[0].Generators["serial"] = dtdlv1.TwinGenerator{Type: dtdlv1.SineGenerator}
+		}, "spec.classes[0].generators[serial].type"),
+		Entry("constant outside the enumeration", func(s *dtdlv1.TwinSimulation) {
+			s.Spec.Classes[0].Generators["state"] = dtdlv1.TwinGenerator{Type: dtdlv1.ConstantGenerator, Value: &apiextensionsv1.JSON{Raw: []byte(`"off"`)}}
+		}, "spec.classes[0].generators[state].value"),
+		Entry("fractional integer constant", func(s *dtdlv1.TwinSimulation) {
+			s.Spec.Classes[0].Generators["speed"] = dtdlv1.TwinGenerator{Type: dtdlv1.ConstantGenerator, Value: &apiextensionsv1.JSON{Raw: []byte(`1.5`)}}
+		}, "not a valid integer"),
+		Entry("inverted range", func(s *dtdlv1.TwinSimulation) {
+			s.Spec.Classes[0].Generators["speed"] = dtdlv1.TwinGenerator{Type: dtdlv1.RandomWalkGenerator, Min: quantity("10"), Max: quantity("1")}
+		}, "spec.classes[0].generators[speed].min"),
+		Entry("zero rate", func(s *dtdlv1.TwinSimulation) {
+			s.Spec.Rate = quantity("0")
+		}, "spec.rate"),
+	)
+
+	It("samples every telemetry and changed properties", func() {
+		plan, err := Build(twinSimulation, m)
+		Expect(err).NotTo(HaveOccurred())
+		twin := NewTwin(plan.Namespace, &plan.Classes[0], plan.Classes[0].Instance(0), rand.New(rand.NewSource(1)))
+
+		values := map[string]interface{}{}
+		retained := map[string]bool{}
+		for _, message := range twin.Sample(0) {
+			var value interface{}
+			Expect(json.Unmarshal(message.Payload, &value)).To(Succeed())
+			values[message.Topic] = value
+			retained[message.Topic] = message.Retained
+		}
+		prefix := "dt/plant/Machine/test-machine-0/"
+		Expect(values).To(HaveLen(6))
+		Expect(values[prefix+"telemetry/temperature"]).To(BeNumerically(">=", 20))
+		Expect(values[prefix+"telemetry/temperature"]).To(BeNumerically("<=", 30))
+		Expect(values[prefix+"properties/serial"]).To(Equal("M-1"))
+		Expect(values[prefix+"properties/state"]).To(BeElementOf("idle", "busy", "down"))
+		Expect(values[prefix+"telemetry/running"]).To(BeAssignableToTypeOf(true))
+		Expect(values[prefix+"telemetry/position"]).To(HaveKey("x"))
+		Expect(retained[prefix+"properties/serial"]).To(BeTrue())
+		Expect(retained[prefix+"telemetry/speed"]).To(BeFalse())
+
+		for i := 1; i < 50; i++ {
+			for _, message := range twin.Sample(time.Duration(i) * time.Second) {
+				Expect(message.Topic).NotTo(Equal(prefix + "properties/serial"))
+				if message.Topic == prefix+"telemetry/speed" {
+					Expect(string(message.Payload)).To(MatchRegexp(`^([0-9]|10)$`))
+				}
+			}
+		}
+	})
+
+	It("cycles through enumerations", func() {
+		source := newSource(&Schema{Type: dtdlv0.Enumeration, Enum: []string{"a", "b"}}, &Generator{Type: dtdlv1.EnumCycleGenerator}, rand.New(rand.NewSource(1)))
+		first := source.next(0)
+		Expect(source.next(0)).NotTo(Equal(first))
+		Expect(source.next(0)).To(Equal(first))
+	})
+
+	It("oscillates sines over their period", func() {
+		generator := &Generator{Type: dtdlv1.SineGenerator, Min: -1, Max: 1, Period: metav1.Duration{Duration: time.Minute}}
+		source := &sineSource{generator: generator}
+		Expect(source.next(0)).To(BeNumerically("~", 0, 1e-9))
+		Expect(source.next(15 * time.Second)).To(BeNumerically("~", 1, 1e-9))
+		Expect(source.next(45 * time.Second)).To(BeNumerically("~", -1, 1e-9))
+	})
+})
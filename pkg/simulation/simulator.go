@@ -0,0 +1,157 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+// Message is a message a virtual instance publishes.
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+}
+
+// Twin is a virtual instance of a class.
+type Twin struct {
+	attributes []*twinAttribute
+}
+
+type twinAttribute struct {
+	topic    string
+	property bool
+	source   source
+	// last is the last published value of a property.
+	last []byte
+}
+
+// NewTwin returns the virtual instance named instance of a class of the
+// plan.
+func NewTwin(namespace string, class *Class, instance string, rnd *rand.Rand) *Twin {
+	prefix := topics.Instance(namespace, class.Name, instance)
+	twin := &Twin{}
+	for i := range class.Attributes {
+		attribute := &class.Attributes[i]
+		topic := prefix + "/telemetry/" + attribute.Name
+		if attribute.Property {
+			topic = prefix + "/properties/" + attribute.Name
+		}
+		twin.attributes = append(twin.attributes, &twinAttribute{
+			topic:    topic,
+			property: attribute.Property,
+			source:   newSource(&attribute.Schema, attribute.Generator, rnd),
+		})
+	}
+	return twin
+}
+
+// Sample returns the messages of one sample: every telemetry, and the
+// properties whose value changed since the last sample.
+func (t *Twin) Sample(elapsed time.Duration) []Message {
+	messages := []Message{}
+	for _, attribute := range t.attributes {
+		payload, err := json.Marshal(attribute.source.next(elapsed))
+		if err != nil {
+			continue
+		}
+		if attribute.property {
+			if bytes.Equal(payload, attribute.last) {
+				continue
+			}
+			attribute.last = payload
+		}
+		messages = append(messages, Message{Topic: attribute.topic, Payload: payload, Retained: attribute.property})
+	}
+	return messages
+}
+
+// Simulator publishes the samples of every virtual instance of a plan.
+type Simulator struct {
+	Client mqtt.Client
+	Plan   *Plan
+	// Seed seeds the random sources of the virtual instances.
+	Seed int64
+}
+
+// Run publishes samples until the context is done. Every virtual instance
+// publishes a sample every interval of the plan, delayed by up to the
+// jitter, and a burst of samples every burst interval.
+func (s *Simulator) Run(ctx context.Context) {
+	logger := log.FromContext(ctx)
+	logger.Info("Simulating twins", "instances", s.Plan.Instances(), "interval", s.Plan.Interval.Duration)
+
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	n := int64(0)
+	for c := range s.Plan.Classes {
+		class := &s.Plan.Classes[c]
+		for i := 0; i < class.Instances; i++ {
+			rnd := rand.New(rand.NewSource(s.Seed + n))
+			n++
+			twin := NewTwin(s.Plan.Namespace, class, class.Instance(i), rnd)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.run(ctx, twin, start, rnd)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func (s *Simulator) run(ctx context.Context, twin *Twin, start time.Time, rnd *rand.Rand) {
+	ticker := time.NewTicker(s.Plan.Interval.Duration)
+	defer ticker.Stop()
+	lastBurst := start
+	for {
+		if jitter := s.Plan.Jitter.Duration; jitter > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(rnd.Int63n(int64(jitter)))):
+			}
+		}
+
+		samples := 1
+		if burst := s.Plan.Burst; burst != nil && time.Since(lastBurst) >= burst.Every.Duration {
+			samples = burst.Size
+			lastBurst = time.Now()
+		}
+		for i := 0; i < samples; i++ {
+			for _, message := range twin.Sample(time.Since(start)) {
+				s.Client.Publish(message.Topic, 0, message.Retained, message.Payload)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
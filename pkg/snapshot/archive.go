@@ -0,0 +1,170 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package snapshot exports the twin model of a namespace into a versioned
+// archive and restores archives in dependency order.
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+// ArchiveVersion is the format version of the archives written by Encode.
+// Decode rejects other versions.
+const ArchiveVersion = "dtdl.digitaltwin/snapshot-v1"
+
+// Archive holds the twin resources of a namespace, stripped of their
+// status and server-set metadata.
+type Archive struct {
+	Version   string      `json:"version"`
+	Namespace string      `json:"namespace"`
+	TakenAt   metav1.Time `json:"takenAt"`
+
+	TwinEnums     []dtdlv1.TwinEnum     `json:"twinEnums,omitempty"`
+	TwinClasses   []dtdlv1.TwinClass    `json:"twinClasses,omitempty"`
+	TwinServices  []dtdlv1.TwinService  `json:"twinServices,omitempty"`
+	TwinInstances []dtdlv1.TwinInstance `json:"twinInstances,omitempty"`
+}
+
+// Export reads the TwinEnums, TwinClasses and TwinServices of a namespace,
+// and its TwinInstances when includeInstances is set.
+func Export(ctx context.Context, reader client.Reader, namespace string, includeInstances bool) (*Archive, error) {
+	archive := &Archive{
+		Version:   ArchiveVersion,
+		Namespace: namespace,
+		TakenAt:   metav1.NewTime(time.Now().UTC().Truncate(time.Second)),
+	}
+
+	twinEnums := &dtdlv1.TwinEnumList{}
+	if err := reader.List(ctx, twinEnums, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, twinEnum := range twinEnums.Items {
+		archive.TwinEnums = append(archive.TwinEnums, dtdlv1.TwinEnum{
+			TypeMeta:   typeMeta("TwinEnum"),
+			ObjectMeta: strip(twinEnum.ObjectMeta),
+			Spec:       twinEnum.Spec,
+		})
+	}
+
+	twinClasses := &dtdlv1.TwinClassList{}
+	if err := reader.List(ctx, twinClasses, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, twinClass := range twinClasses.Items {
+		archive.TwinClasses = append(archive.TwinClasses, dtdlv1.TwinClass{
+			TypeMeta:   typeMeta("TwinClass"),
+			ObjectMeta: strip(twinClass.ObjectMeta),
+			Spec:       twinClass.Spec,
+		})
+	}
+
+	twinServices := &dtdlv1.TwinServiceList{}
+	if err := reader.List(ctx, twinServices, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for _, twinService := range twinServices.Items {
+		archive.TwinServices = append(archive.TwinServices, dtdlv1.TwinService{
+			TypeMeta:   typeMeta("TwinService"),
+			ObjectMeta: strip(twinService.ObjectMeta),
+			Spec:       twinService.Spec,
+		})
+	}
+
+	if includeInstances {
+		twinInstances := &dtdlv1.TwinInstanceList{}
+		if err := reader.List(ctx, twinInstances, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+		for _, twinInstance := range twinInstances.Items {
+			archive.TwinInstances = append(archive.TwinInstances, dtdlv1.TwinInstance{
+				TypeMeta:   typeMeta("TwinInstance"),
+				ObjectMeta: strip(twinInstance.ObjectMeta),
+				Spec:       twinInstance.Spec,
+			})
+		}
+	}
+	return archive, nil
+}
+
+func typeMeta(kind string) metav1.TypeMeta {
+	return metav1.TypeMeta{APIVersion: dtdlv1.GroupVersion.String(), Kind: kind}
+}
+
+// strip keeps the name, labels and annotations of an object, dropping what
+// the API server and the operator set and the namespace, which is chosen on
+// restore.
+func strip(meta metav1.ObjectMeta) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        meta.Name,
+		Labels:      meta.Labels,
+		Annotations: meta.Annotations,
+	}
+}
+
+// Counts returns the number of resources in the archive, keyed by kind.
+func (a *Archive) Counts() map[string]int32 {
+	return map[string]int32{
+		"TwinEnum":     int32(len(a.TwinEnums)),
+		"TwinClass":    int32(len(a.TwinClasses)),
+		"TwinService":  int32(len(a.TwinServices)),
+		"TwinInstance": int32(len(a.TwinInstances)),
+	}
+}
+
+// Encode renders an archive as gzipped JSON.
+func Encode(archive *Archive) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	writer := gzip.NewWriter(buf)
+	if err := json.NewEncoder(writer).Encode(archive); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode reads an archive written by Encode.
+func Decode(data []byte) (*Archive, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("not a snapshot archive: %w", err)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	archive := &Archive{}
+	if err := json.Unmarshal(decoded, archive); err != nil {
+		return nil, err
+	}
+	if archive.Version != ArchiveVersion {
+		return nil, fmt.Errorf("unsupported snapshot archive version %q, expected %q", archive.Version, ArchiveVersion)
+	}
+	return archive, nil
+}
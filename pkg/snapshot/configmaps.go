@@ -0,0 +1,123 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+// SnapshotLabel names the TwinSnapshot a ConfigMap holds a chunk of.
+const SnapshotLabel = "dtdl.digitaltwin/snapshot"
+
+// PartAnnotation holds the index of the chunk in a ConfigMap and the
+// number of chunks, as <index>/<count>.
+const PartAnnotation = "dtdl.digitaltwin/snapshot-part"
+
+// ChunkKey is the binaryData key of the chunk in a ConfigMap.
+const ChunkKey = "archive.part"
+
+// ChunkSize stays below the 1MiB limit of ConfigMaps.
+const ChunkSize = 900 * 1024
+
+// ChunkName returns the name of the ConfigMap holding a chunk of a snapshot.
+func ChunkName(snapshot string, index int) string {
+	return fmt.Sprintf("%s-archive-%d", snapshot, index)
+}
+
+// WriteConfigMaps stores an encoded archive in a chain of ConfigMaps owned
+// by the snapshot and returns their names in order.
+func WriteConfigMaps(ctx context.Context, c client.Client, scheme *runtime.Scheme, snapshot *dtdlv1.TwinSnapshot, data []byte) ([]string, error) {
+	count := (len(data) + ChunkSize - 1) / ChunkSize
+	names := []string{}
+	for index := 0; index < count; index++ {
+		end := (index + 1) * ChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        ChunkName(snapshot.Name, index),
+				Namespace:   snapshot.Namespace,
+				Labels:      map[string]string{SnapshotLabel: snapshot.Name},
+				Annotations: map[string]string{PartAnnotation: fmt.Sprintf("%d/%d", index, count)},
+			},
+			BinaryData: map[string][]byte{ChunkKey: data[index*ChunkSize : end]},
+		}
+		if err := controllerutil.SetControllerReference(snapshot, configMap, scheme); err != nil {
+			return nil, err
+		}
+		if err := c.Create(ctx, configMap); err != nil {
+			return nil, err
+		}
+		names = append(names, configMap.Name)
+	}
+	return names, nil
+}
+
+// ReadConfigMaps joins the chunks of the archive of a snapshot, checking
+// that the chain is complete.
+func ReadConfigMaps(ctx context.Context, reader client.Reader, namespace, snapshot string) ([]byte, error) {
+	configMaps := &corev1.ConfigMapList{}
+	if err := reader.List(ctx, configMaps, client.InNamespace(namespace), client.MatchingLabels{SnapshotLabel: snapshot}); err != nil {
+		return nil, err
+	}
+	if len(configMaps.Items) == 0 {
+		return nil, fmt.Errorf("snapshot %s/%s has no archive", namespace, snapshot)
+	}
+
+	type chunk struct {
+		index int
+		data  []byte
+	}
+	chunks := []chunk{}
+	count := -1
+	for _, configMap := range configMaps.Items {
+		var index, total int
+		if _, err := fmt.Sscanf(configMap.Annotations[PartAnnotation], "%d/%d", &index, &total); err != nil {
+			return nil, fmt.Errorf("ConfigMap %s: invalid %s annotation", configMap.Name, PartAnnotation)
+		}
+		if count >= 0 && total != count {
+			return nil, fmt.Errorf("ConfigMap %s: chunk count %d, expected %d", configMap.Name, total, count)
+		}
+		count = total
+		chunks = append(chunks, chunk{index: index, data: configMap.BinaryData[ChunkKey]})
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].index < chunks[j].index
+	})
+	data := []byte{}
+	for i, chunk := range chunks {
+		if chunk.index != i {
+			return nil, fmt.Errorf("snapshot %s/%s is missing chunk %d", namespace, snapshot, i)
+		}
+		data = append(data, chunk.data...)
+	}
+	if len(chunks) != count {
+		return nil, fmt.Errorf("snapshot %s/%s has %d of %d chunks", namespace, snapshot, len(chunks), count)
+	}
+	return data, nil
+}
@@ -0,0 +1,316 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/dtmi"
+)
+
+// Conflict chooses what happens to a resource of the archive whose name is
+// taken in the target namespace.
+type Conflict string
+
+const (
+	// ConflictSkip keeps the existing resource.
+	ConflictSkip Conflict = "skip"
+	// ConflictOverwrite replaces the spec, labels and annotations of the existing
+	// resource.
+	ConflictOverwrite Conflict = "overwrite"
+	// ConflictRename creates the resource under a free name. Edges to renamed
+	// TwinInstances follow them. Classes and enums are referred to by spec
+	// name and ID, so a renamed copy would collide with the existing one:
+	// they are skipped when their spec is unchanged and rejected otherwise.
+	ConflictRename Conflict = "rename"
+)
+
+// Conflicts lists the supported conflict strategies.
+var Conflicts = []Conflict{ConflictSkip, ConflictOverwrite, ConflictRename}
+
+// Outcome is what Restore did with a resource of the archive.
+type Outcome struct {
+	Kind string
+	Name string
+	// Action is one of created, skipped, overwritten or renamed.
+	Action string
+	// RenamedTo is the name a renamed resource was created under.
+	RenamedTo string
+}
+
+// Restore applies an archive to a namespace in dependency order: TwinEnums,
+// then TwinClasses with the classes they embed or relate to first and
+// versions in ascending order, then TwinServices, then TwinInstances with
+// the targets of their edges first. It stops at the first resource the API
+// server rejects, returning the outcomes so far.
+func Restore(ctx context.Context, c client.Client, archive *Archive, namespace string, conflict Conflict) ([]Outcome, error) {
+	if conflict != ConflictSkip && conflict != ConflictOverwrite && conflict != ConflictRename {
+		return nil, fmt.Errorf("unknown conflict strategy %q", conflict)
+	}
+	r := &restorer{client: c, namespace: namespace, conflict: conflict, renamed: map[string]string{}}
+
+	for i := range archive.TwinEnums {
+		twinEnum := archive.TwinEnums[i].DeepCopy()
+		if err := r.apply(ctx, twinEnum, &dtdlv1.TwinEnum{}, func(existing client.Object) {
+			existing.(*dtdlv1.TwinEnum).Spec = twinEnum.Spec
+		}, func(existing client.Object) bool {
+			return equality.Semantic.DeepEqual(existing.(*dtdlv1.TwinEnum).Spec, twinEnum.Spec)
+		}); err != nil {
+			return r.outcomes, err
+		}
+	}
+	for _, twinClass := range orderClasses(archive.TwinClasses) {
+		twinClass := twinClass.DeepCopy()
+		if err := r.apply(ctx, twinClass, &dtdlv1.TwinClass{}, func(existing client.Object) {
+			existing.(*dtdlv1.TwinClass).Spec = twinClass.Spec
+		}, func(existing client.Object) bool {
+			return equality.Semantic.DeepEqual(existing.(*dtdlv1.TwinClass).Spec, twinClass.Spec)
+		}); err != nil {
+			return r.outcomes, err
+		}
+	}
+	for i := range archive.TwinServices {
+		twinService := archive.TwinServices[i].DeepCopy()
+		if err := r.apply(ctx, twinService, &dtdlv1.TwinService{}, func(existing client.Object) {
+			existing.(*dtdlv1.TwinService).Spec = twinService.Spec
+		}, nil); err != nil {
+			return r.outcomes, err
+		}
+	}
+
+	// Instances are renamed before any is created, so that edges can
+	// follow renamed targets whatever the order.
+	instances := orderInstances(archive.TwinInstances)
+	if conflict == ConflictRename {
+		for _, twinInstance := range instances {
+			name, err := r.freeName(ctx, twinInstance.Name, &dtdlv1.TwinInstance{})
+			if err != nil {
+				return r.outcomes, err
+			}
+			if name != twinInstance.Name {
+				r.renamed[twinInstance.Name] = name
+			}
+		}
+	}
+	for _, twinInstance := range instances {
+		twinInstance := twinInstance.DeepCopy()
+		for i := range twinInstance.Spec.Relationships {
+			for j, edge := range twinInstance.Spec.Relationships[i].Targets {
+				if renamed, ok := r.renamed[edge.Name]; ok {
+					twinInstance.Spec.Relationships[i].Targets[j].Name = renamed
+				}
+			}
+		}
+		if err := r.apply(ctx, twinInstance, &dtdlv1.TwinInstance{}, func(existing client.Object) {
+			existing.(*dtdlv1.TwinInstance).Spec = twinInstance.Spec
+		}, nil); err != nil {
+			return r.outcomes, err
+		}
+	}
+	return r.outcomes, nil
+}
+
+type restorer struct {
+	client    client.Client
+	namespace string
+	conflict  Conflict
+	// renamed maps the names of renamed TwinInstances to their new names.
+	renamed  map[string]string
+	outcomes []Outcome
+}
+
+// apply creates object in the namespace, resolving a name conflict with
+// the strategy of the restorer. setSpec copies the spec of object onto the
+// existing resource. sameSpec is set for kinds that cannot be renamed, and
+// reports whether the existing resource has the spec of object.
+func (r *restorer) apply(ctx context.Context, object client.Object, existing client.Object, setSpec func(client.Object), sameSpec func(client.Object) bool) error {
+	kind := object.GetObjectKind().GroupVersionKind().Kind
+	outcome := Outcome{Kind: kind, Name: object.GetName(), Action: "created"}
+	object.SetNamespace(r.namespace)
+
+	if renamed, ok := r.renamed[object.GetName()]; ok && kind == "TwinInstance" {
+		object.SetName(renamed)
+		outcome.Action, outcome.RenamedTo = "renamed", renamed
+	}
+
+	err := r.client.Get(ctx, client.ObjectKeyFromObject(object), existing)
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return err
+	case r.conflict == ConflictSkip:
+		outcome.Action = "skipped"
+		r.outcomes = append(r.outcomes, outcome)
+		return nil
+	case r.conflict == ConflictOverwrite:
+		setSpec(existing)
+		existing.SetLabels(object.GetLabels())
+		existing.SetAnnotations(object.GetAnnotations())
+		if err := r.client.Update(ctx, existing); err != nil {
+			return fmt.Errorf("%s %s: %w", kind, object.GetName(), err)
+		}
+		outcome.Action = "overwritten"
+		r.outcomes = append(r.outcomes, outcome)
+		return nil
+	case sameSpec != nil && sameSpec(existing):
+		outcome.Action = "skipped"
+		r.outcomes = append(r.outcomes, outcome)
+		return nil
+	case sameSpec != nil:
+		return fmt.Errorf("%s %s exists in namespace %s with another spec, and cannot be renamed as it is referred to by its spec name: restore with the skip or overwrite strategy", kind, object.GetName(), r.namespace)
+	default:
+		name, err := r.freeName(ctx, object.GetName(), existing)
+		if err != nil {
+			return err
+		}
+		object.SetName(name)
+		outcome.Action, outcome.RenamedTo = "renamed", name
+	}
+
+	if err := r.client.Create(ctx, object); err != nil {
+		return fmt.Errorf("%s %s: %w", kind, object.GetName(), err)
+	}
+	r.outcomes = append(r.outcomes, outcome)
+	return nil
+}
+
+// freeName returns name when it is free in the namespace, or the first free
+// name of the form <name>-restored, <name>-restored-2 and so on.
+func (r *restorer) freeName(ctx context.Context, name string, existing client.Object) (string, error) {
+	candidate := name
+	for i := 1; ; i++ {
+		err := r.client.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: candidate}, existing)
+		if apierrors.IsNotFound(err) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		candidate = name + "-restored"
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-restored-%d", name, i)
+		}
+	}
+}
+
+// orderClasses sorts classes so that the classes a class embeds or relates
+// to come before it, and the versions of a class in ascending order.
+// Classes in a cycle keep their name order.
+func orderClasses(classes []dtdlv1.TwinClass) []dtdlv1.TwinClass {
+	byName := map[string][]dtdlv1.TwinClass{}
+	for _, twinClass := range classes {
+		byName[twinClass.Spec.Name] = append(byName[twinClass.Spec.Name], twinClass)
+	}
+	for _, versions := range byName {
+		sort.SliceStable(versions, func(i, j int) bool {
+			return classVersion(&versions[i]) < classVersion(&versions[j])
+		})
+	}
+
+	return topological(sortedKeys(byName), func(name string) []string {
+		dependencies := []string{}
+		for _, twinClass := range byName[name] {
+			references := []string{}
+			for _, component := range twinClass.Spec.Components {
+				references = append(references, component.Class)
+			}
+			for _, relationship := range twinClass.Spec.Relationships {
+				references = append(references, relationship.Targets...)
+			}
+			for _, reference := range references {
+				if ref, err := dtmi.ParseReference(reference); err == nil {
+					dependencies = append(dependencies, ref.Name)
+				}
+			}
+		}
+		return dependencies
+	}, func(name string) []dtdlv1.TwinClass {
+		return byName[name]
+	})
+}
+
+func classVersion(twinClass *dtdlv1.TwinClass) int {
+	id, err := dtmi.Parse(twinClass.Spec.ID)
+	if err != nil {
+		return 0
+	}
+	return id.Version
+}
+
+// orderInstances sorts instances so that the targets of the edges of an
+// instance come before it. Instances in a cycle keep their name order.
+func orderInstances(instances []dtdlv1.TwinInstance) []dtdlv1.TwinInstance {
+	byName := map[string][]dtdlv1.TwinInstance{}
+	for _, twinInstance := range instances {
+		byName[twinInstance.Name] = append(byName[twinInstance.Name], twinInstance)
+	}
+
+	return topological(sortedKeys(byName), func(name string) []string {
+		dependencies := []string{}
+		for _, relationship := range byName[name][0].Spec.Relationships {
+			for _, edge := range relationship.Targets {
+				dependencies = append(dependencies, edge.Name)
+			}
+		}
+		return dependencies
+	}, func(name string) []dtdlv1.TwinInstance {
+		return byName[name]
+	})
+}
+
+// topological visits names depth first, emitting the items of the
+// dependencies of a name before its own. Unknown dependencies are ignored
+// and cycles are broken where they are found.
+func topological[T any](names []string, dependencies func(string) []string, items func(string) []T) []T {
+	known := map[string]bool{}
+	for _, name := range names {
+		known[name] = true
+	}
+	visited := map[string]bool{}
+	ordered := []T{}
+	var visit func(string)
+	visit = func(name string) {
+		if visited[name] || !known[name] {
+			return
+		}
+		visited[name] = true
+		for _, dependency := range dependencies(name) {
+			visit(dependency)
+		}
+		ordered = append(ordered, items(name)...)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return ordered
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+func class(name, spec string, version string, targets ...string) *dtdlv1.TwinClass {
+	twinClass := &dtdlv1.TwinClass{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "plant", ResourceVersion: "7", UID: "uid"},
+		Spec: dtdlv1.TwinClassSpec{
+			Name:          spec,
+			Relationships: []dtdlv1.TwinRelationship{{Name: "related", Targets: targets}},
+		},
+		Status: dtdlv1.TwinClassStatus{SchemaConfigMap: "schema"},
+	}
+	if version != "" {
+		twinClass.Spec.ID = "dtmi:acme:" + spec + ";" + version
+	}
+	return twinClass
+}
+
+func twin(name, class string, targets ...string) *dtdlv1.TwinInstance {
+	twinInstance := &dtdlv1.TwinInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "plant", Finalizers: []string{"dtdl.digitaltwin/relationships"}},
+		Spec:       dtdlv1.TwinInstanceSpec{Class: dtdlv1.TwinClassReference{Name: class}},
+	}
+	if len(targets) > 0 {
+		relationship := dtdlv1.TwinInstanceRelationship{Name: "related"}
+		for _, target := range targets {
+			relationship.Targets = append(relationship.Targets, dtdlv1.TwinInstanceEdge{Name: target})
+		}
+		twinInstance.Spec.Relationships = []dtdlv1.TwinInstanceRelationship{relationship}
+	}
+	return twinInstance
+}
+
+func newScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(dtdlv1.AddToScheme(scheme)).To(Succeed())
+	return scheme
+}
+
+var _ = Describe("Snapshot", func() {
+	var source client.Client
+	var archive *Archive
+
+	BeforeEach(func() {
+		source = fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(
+			&dtdlv1.TwinEnum{
+				ObjectMeta: metav1.ObjectMeta{Name: "state", Namespace: "plant"},
+				Spec:       dtdlv1.TwinEnumSpec{Name: "State", Values: []string{"idle", "busy"}},
+			},
+			class("factory", "Factory", "", "Machine"),
+			class("machine-2", "Machine", "2"),
+			class("machine-1", "Machine", "1"),
+			class("other", "Other", "", "Unknown"),
+			&dtdlv1.TwinService{ObjectMeta: metav1.ObjectMeta{Name: "monitor", Namespace: "plant"}},
+			twin("berlin", "Factory", "m1"),
+			twin("m1", "Machine"),
+			twin("elsewhere", "Machine"),
+		).Build()
+		twinElsewhere := twin("elsewhere", "Machine")
+		twinElsewhere.Namespace = "other"
+		Expect(source.Create(context.Background(), twinElsewhere)).To(Succeed())
+
+		var err error
+		archive, err = Export(context.Background(), source, "plant", true)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("exports the resources of a namespace without server state", func() {
+		Expect(archive.Counts()).To(Equal(map[string]int32{"TwinEnum": 1, "TwinClass": 4, "TwinService": 1, "TwinInstance": 3}))
+		for _, twinClass := range archive.TwinClasses {
+			Expect(twinClass.ResourceVersion).To(BeEmpty())
+			Expect(twinClass.UID).To(BeEmpty())
+			Expect(twinClass.Namespace).To(BeEmpty())
+			Expect(twinClass.Status).To(Equal(dtdlv1.TwinClassStatus{}))
+			Expect(twinClass.Kind).To(Equal("TwinClass"))
+		}
+		Expect(archive.TwinInstances[0].Finalizers).To(BeEmpty())
+
+		withoutInstances, err := Export(context.Background(), source, "plant", false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(withoutInstances.TwinInstances).To(BeEmpty())
+	})
+
+	It("round-trips through the encoding", func() {
+		data, err := Encode(archive)
+		Expect(err).NotTo(HaveOccurred())
+		decoded, err := Decode(data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decoded.Counts()).To(Equal(archive.Counts()))
+		Expect(decoded.TwinClasses[0].Spec).To(Equal(archive.TwinClasses[0].Spec))
+
+		archive.Version = "dtdl.digitaltwin/snapshot-v0"
+		data, err = Encode(archive)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = Decode(data)
+		Expect(err).To(MatchError(ContainSubstring("unsupported snapshot archive version")))
+	})
+
+	It("stores archives in a chain of ConfigMaps", func() {
+		scheme := newScheme()
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+		twinSnapshot := &dtdlv1.TwinSnapshot{ObjectMeta: metav1.ObjectMeta{Name: "before", Namespace: "plant", UID: "uid"}}
+
+		data := make([]byte, 2*ChunkSize+10)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		names, err := WriteConfigMaps(context.Background(), c, scheme, twinSnapshot, data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names).To(Equal([]string{"before-archive-0", "before-archive-1", "before-archive-2"}))
+
+		read, err := ReadConfigMaps(context.Background(), c, "plant", "before")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(Equal(data))
+
+		Expect(c.Delete(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "before-archive-1", Namespace: "plant"}})).To(Succeed())
+		_, err = ReadConfigMaps(context.Background(), c, "plant", "before")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("orders classes and instances by dependency", func() {
+		names := []string{}
+		for _, twinClass := range orderClasses(archive.TwinClasses) {
+			names = append(names, twinClass.Name)
+		}
+		Expect(names).To(Equal([]string{"machine-1", "machine-2", "factory", "other"}))
+
+		names = []string{}
+		for _, twinInstance := range orderInstances(archive.TwinInstances) {
+			names = append(names, twinInstance.Name)
+		}
+		Expect(names).To(Equal([]string{"m1", "berlin", "elsewhere"}))
+	})
+
+	Describe("Restore", func() {
+		var target client.Client
+
+		BeforeEach(func() {
+			existing := twin("m1", "Machine")
+			existing.Namespace = "copy"
+			existing.Spec.Class.Version = "1"
+			target = fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(existing).Build()
+		})
+
+		It("skips existing resources", func() {
+			outcomes, err := Restore(context.Background(), target, archive, "copy", ConflictSkip)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outcomes).To(ContainElement(Outcome{Kind: "TwinInstance", Name: "m1", Action: "skipped"}))
+			Expect(outcomes).To(ContainElement(Outcome{Kind: "TwinClass", Name: "factory", Action: "created"}))
+			Expect(outcomes).To(HaveLen(9))
+
+			m1 := &dtdlv1.TwinInstance{}
+			Expect(target.Get(context.Background(), client.ObjectKey{Namespace: "copy", Name: "m1"}, m1)).To(Succeed())
+			Expect(m1.Spec.Class.Version).To(Equal("1"))
+		})
+
+		It("overwrites existing resources", func() {
+			outcomes, err := Restore(context.Background(), target, archive, "copy", ConflictOverwrite)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outcomes).To(ContainElement(Outcome{Kind: "TwinInstance", Name: "m1", Action: "overwritten"}))
+
+			m1 := &dtdlv1.TwinInstance{}
+			Expect(target.Get(context.Background(), client.ObjectKey{Namespace: "copy", Name: "m1"}, m1)).To(Succeed())
+			Expect(m1.Spec.Class.Version).To(BeEmpty())
+		})
+
+		It("renames conflicting resources and the edges to them", func() {
+			outcomes, err := Restore(context.Background(), target, archive, "copy", ConflictRename)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outcomes).To(ContainElement(Outcome{Kind: "TwinInstance", Name: "m1", Action: "renamed", RenamedTo: "m1-restored"}))
+
+			berlin := &dtdlv1.TwinInstance{}
+			Expect(target.Get(context.Background(), client.ObjectKey{Namespace: "copy", Name: "berlin"}, berlin)).To(Succeed())
+			Expect(berlin.Spec.Relationships[0].Targets[0].Name).To(Equal("m1-restored"))
+
+			outcomes, err = Restore(context.Background(), target, archive, "copy", ConflictRename)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outcomes).To(ContainElement(Outcome{Kind: "TwinClass", Name: "machine-1", Action: "skipped"}))
+			Expect(outcomes).To(ContainElement(Outcome{Kind: "TwinEnum", Name: "state", Action: "skipped"}))
+			Expect(target.Get(context.Background(), client.ObjectKey{Namespace: "copy", Name: "m1-restored-2"}, &dtdlv1.TwinInstance{})).To(Succeed())
+		})
+
+		It("refuses to rename classes that exist with another spec", func() {
+			existing := class("machine-1", "Machine", "1", "Factory")
+			existing.Namespace = "copy"
+			existing.ResourceVersion = ""
+			Expect(target.Create(context.Background(), existing)).To(Succeed())
+
+			outcomes, err := Restore(context.Background(), target, archive, "copy", ConflictRename)
+			Expect(err).To(MatchError("TwinClass machine-1 exists in namespace copy with another spec, and cannot be renamed as it is referred to by its spec name: restore with the skip or overwrite strategy"))
+			Expect(outcomes).NotTo(ContainElement(HaveField("RenamedTo", "machine-1-restored")))
+			Expect(target.Get(context.Background(), client.ObjectKey{Namespace: "copy", Name: "machine-1-restored"}, &dtdlv1.TwinClass{})).NotTo(Succeed())
+		})
+
+		It("rejects unknown strategies", func() {
+			_, err := Restore(context.Background(), target, archive, "copy", "merge")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
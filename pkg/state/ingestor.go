@@ -0,0 +1,208 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"golang.org/x/time/rate"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/broker"
+	"github.com/agwermann/dt-operator/pkg/instance"
+	"github.com/agwermann/dt-operator/pkg/model"
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+// Ingestor subscribes to the twin topics of the broker and writes the state
+// of the twins to the status of their TwinInstances. Messages only update
+// the store; the twins that changed are written every FlushInterval, at most
+// Limiter allows, so that chatty twins cost one status patch per interval.
+// State published below a class other than the class of the instance is
+// ignored, and numeric values reported as a quantity in another unit are
+// normalised to the unit their attribute declares.
+type Ingestor struct {
+	client.Client
+	Store *Store
+	// Broker is the URL of the broker, such as tcp://host:1883.
+	Broker string
+	// Credentials returns the credentials the ingestor connects with.
+	Credentials   func(context.Context) (broker.Credentials, error)
+	FlushInterval time.Duration
+	Limiter       *rate.Limiter
+}
+
+// Start ingests until the context is done, making the ingestor a Runnable of
+// the manager.
+func (i *Ingestor) Start(ctx context.Context) error {
+	credentials, err := i.Credentials(ctx)
+	if err != nil {
+		return err
+	}
+	c := Subscribe(ctx, i.Broker, topics.OperatorUsername+"-state", credentials, func(topic string, payload []byte) {
+		i.Store.Report(topic, payload)
+	})
+	defer c.Disconnect(250)
+	log.FromContext(ctx).WithName("state").Info("Ingesting twin state", "broker", i.Broker)
+
+	ticker := time.NewTicker(i.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			i.Flush(ctx)
+		}
+	}
+}
+
+// Subscribe connects to the broker at address with credentials and hands
+// every message published on a twin topic to handle. The client
+// reconnects, and subscribes again, until it is disconnected.
+func Subscribe(ctx context.Context, address, clientID string, credentials broker.Credentials, handle func(topic string, payload []byte)) mqtt.Client {
+	logger := log.FromContext(ctx)
+
+	options := credentials.Apply(mqtt.NewClientOptions()).
+		AddBroker(address).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			token := c.Subscribe(topics.Root+"/#", 0, func(_ mqtt.Client, message mqtt.Message) {
+				handle(message.Topic(), message.Payload())
+			})
+			if token.Wait() && token.Error() != nil {
+				logger.Error(token.Error(), "Unable to subscribe to twin topics")
+			}
+		})
+	c := mqtt.NewClient(options)
+	c.Connect()
+	return c
+}
+
+// NeedLeaderElection is true, so that a single replica writes the state.
+func (i *Ingestor) NeedLeaderElection() bool {
+	return true
+}
+
+// Flush writes the state of the twins that changed since the last flush.
+// Twins whose TwinInstance does not exist are forgotten, and twins that
+// cannot be written are retried on the next flush.
+func (i *Ingestor) Flush(ctx context.Context) {
+	logger := log.FromContext(ctx).WithName("state")
+
+	models := map[string]*model.Model{}
+	for _, key := range i.Store.TakeDirty() {
+		if err := i.Limiter.Wait(ctx); err != nil {
+			i.Store.MarkDirty(key)
+			continue
+		}
+		if err := i.write(ctx, key, models); err != nil {
+			if apierrors.IsNotFound(err) {
+				i.Store.Forget(key)
+				continue
+			}
+			logger.Error(err, "Unable to write twin state", "TwinInstance", key)
+			i.Store.MarkDirty(key)
+		}
+	}
+}
+
+func (i *Ingestor) write(ctx context.Context, key types.NamespacedName, models map[string]*model.Model) error {
+	logger := log.FromContext(ctx).WithName("state")
+
+	twin, ok := i.Store.Get(key)
+	if !ok {
+		return nil
+	}
+
+	twinInstance := &dtdlv1.TwinInstance{}
+	if err := i.Get(ctx, key, twinInstance); err != nil {
+		return err
+	}
+	resolved, err := i.class(ctx, twinInstance, models)
+	if err != nil {
+		return err
+	}
+
+	// Twins publish below the name of their class; state published below
+	// another class is not theirs to report.
+	className := twinInstance.Spec.Class.Name
+	attributes := map[string]*model.ResolvedAttribute{}
+	if resolved != nil {
+		className = resolved.Name
+		for j := range resolved.Attributes {
+			attributes[resolved.Attributes[j].Name] = &resolved.Attributes[j]
+		}
+	}
+	if twin.Class != className {
+		logger.Info("Ignoring state published under another class", "TwinInstance", key, "class", twin.Class, "expected", className)
+		i.Store.Forget(key)
+		return nil
+	}
+
+	patch := client.MergeFrom(twinInstance.DeepCopy())
+	if twinInstance.Status.Reported == nil {
+		twinInstance.Status.Reported = map[string]apiextensionsv1.JSON{}
+	}
+	for name, raw := range twin.Attributes {
+		if attribute, ok := attributes[name]; ok {
+			normalized, err := instance.Normalize(attribute, raw)
+			if err != nil {
+				logger.Info("Dropping reported value", "TwinInstance", key, "attribute", name, "reason", err.Error())
+				continue
+			}
+			raw = normalized
+		}
+		twinInstance.Status.Reported[name] = apiextensionsv1.JSON{Raw: raw}
+	}
+	lastSeen := metav1.NewTime(twin.LastSeen)
+	twinInstance.Status.LastSeen = &lastSeen
+	return i.Status().Patch(ctx, twinInstance, patch)
+}
+
+// class returns the resolved class of a twin instance, loading the model of
+// its namespace once per flush. It is nil when the class does not resolve.
+func (i *Ingestor) class(ctx context.Context, twinInstance *dtdlv1.TwinInstance, models map[string]*model.Model) (*model.ResolvedClass, error) {
+	m, ok := models[twinInstance.Namespace]
+	if !ok {
+		var err error
+		if m, err = model.Load(ctx, i.Client, client.InNamespace(twinInstance.Namespace)); err != nil {
+			return nil, err
+		}
+		models[twinInstance.Namespace] = m
+	}
+
+	twinClass, err := m.Select(twinInstance.Spec.Class.String())
+	if err != nil {
+		return nil, nil
+	}
+	resolved, err := m.ResolveClass(twinClass)
+	if err != nil {
+		return nil, nil
+	}
+	return resolved, nil
+}
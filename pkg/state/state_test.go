@@ -0,0 +1,141 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+var seen = time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+var m1 = types.NamespacedName{Namespace: "plant", Name: "m1"}
+
+func newStore() *Store {
+	store := NewStore()
+	store.Now = func() time.Time { return seen }
+	return store
+}
+
+var _ = Describe("Store", func() {
+	It("keeps the latest telemetry and reported properties", func() {
+		store := newStore()
+		Expect(store.Report("dt/plant/Machine/m1/telemetry/temperature", []byte("20.5"))).To(BeTrue())
+		Expect(store.Report("dt/plant/Machine/m1/telemetry/temperature", []byte("21"))).To(BeTrue())
+		Expect(store.Report("dt/plant/Machine/m1/properties/serial", []byte("A-1"))).To(BeTrue())
+		Expect(store.Report("dt/plant/Machine/m1/telemetry/spindle/rpm", []byte(`1200`))).To(BeTrue())
+		Expect(store.Report("dt/plant/Machine/m1/properties/speed/set", []byte("3"))).To(BeTrue())
+		Expect(store.Report("elsewhere/plant/Machine/m1/telemetry/temperature", []byte("1"))).To(BeFalse())
+
+		twin, ok := store.Get(m1)
+		Expect(ok).To(BeTrue())
+		Expect(twin.Class).To(Equal("Machine"))
+		Expect(twin.LastSeen).To(Equal(seen))
+		Expect(twin.Attributes).To(Equal(map[string]json.RawMessage{
+			"temperature": json.RawMessage("21"),
+			"serial":      json.RawMessage(`"A-1"`),
+			"spindle/rpm": json.RawMessage("1200"),
+		}))
+	})
+
+	It("starts over when a twin publishes below another class", func() {
+		store := newStore()
+		store.Report("dt/plant/Machine/m1/telemetry/temperature", []byte("20"))
+		store.Report("dt/plant/Press/m1/telemetry/force", []byte("3"))
+
+		twin, ok := store.Get(m1)
+		Expect(ok).To(BeTrue())
+		Expect(twin.Class).To(Equal("Press"))
+		Expect(twin.Attributes).To(Equal(map[string]json.RawMessage{"force": json.RawMessage("3")}))
+	})
+
+	It("hands out changed twins once", func() {
+		store := newStore()
+		store.Report("dt/plant/Machine/m1/telemetry/temperature", []byte("20"))
+		Expect(store.TakeDirty()).To(Equal([]types.NamespacedName{m1}))
+		Expect(store.TakeDirty()).To(BeEmpty())
+
+		store.MarkDirty(m1)
+		Expect(store.TakeDirty()).To(Equal([]types.NamespacedName{m1}))
+
+		store.Forget(m1)
+		store.MarkDirty(m1)
+		Expect(store.TakeDirty()).To(BeEmpty())
+		_, ok := store.Get(m1)
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("Ingestor", func() {
+	It("writes the state of changed twins to their status", func() {
+		scheme := runtime.NewScheme()
+		Expect(dtdlv0.AddToScheme(scheme)).To(Succeed())
+		Expect(dtdlv1.AddToScheme(scheme)).To(Succeed())
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&dtdlv0.TwinClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: "plant"},
+			Spec: dtdlv0.TwinClassSpec{Name: "Machine", Attributes: []dtdlv0.TwinClassAttributes{
+				{Name: "temperature", Kind: dtdlv0.Telemetry, TwinSchema: dtdlv0.TwinSchema{Type: "double"}},
+				{Name: "reach", TwinSchema: dtdlv0.TwinSchema{Type: "double"}, SemanticType: "Distance", Unit: "metre"},
+			}},
+		}, &dtdlv1.TwinInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: "m1", Namespace: "plant"},
+			Spec:       dtdlv1.TwinInstanceSpec{Class: dtdlv1.TwinClassReference{Name: "Machine"}},
+			Status:     dtdlv1.TwinInstanceStatus{TwinClass: "machine"},
+		}, &dtdlv1.TwinInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: "m2", Namespace: "plant"},
+			Spec:       dtdlv1.TwinInstanceSpec{Class: dtdlv1.TwinClassReference{Name: "Machine"}},
+		}).Build()
+
+		ingestor := &Ingestor{Client: c, Store: newStore(), Limiter: rate.NewLimiter(rate.Inf, 1)}
+		ingestor.Store.Report("dt/plant/Machine/m1/telemetry/temperature", []byte("20"))
+		ingestor.Store.Report("dt/plant/Machine/m1/properties/reach", []byte(`{"value": 1.5, "unit": "km"}`))
+		ingestor.Store.Report("dt/plant/Machine/gone/telemetry/temperature", []byte("20"))
+		ingestor.Store.Report("dt/plant/Press/m2/telemetry/temperature", []byte("20"))
+		ingestor.Flush(context.Background())
+
+		twinInstance := &dtdlv1.TwinInstance{}
+		Expect(c.Get(context.Background(), m1, twinInstance)).To(Succeed())
+		Expect(twinInstance.Status.TwinClass).To(Equal("machine"))
+		Expect(string(twinInstance.Status.Reported["temperature"].Raw)).To(Equal("20"))
+		Expect(string(twinInstance.Status.Reported["reach"].Raw)).To(Equal("1500"))
+		Expect(twinInstance.Status.LastSeen.Time.Equal(seen)).To(BeTrue())
+
+		_, ok := ingestor.Store.Get(client.ObjectKey{Namespace: "plant", Name: "gone"})
+		Expect(ok).To(BeFalse())
+
+		m2 := client.ObjectKey{Namespace: "plant", Name: "m2"}
+		Expect(c.Get(context.Background(), m2, twinInstance)).To(Succeed())
+		Expect(twinInstance.Status.Reported).To(BeEmpty())
+		Expect(twinInstance.Status.LastSeen).To(BeNil())
+		_, ok = ingestor.Store.Get(m2)
+		Expect(ok).To(BeFalse())
+		Expect(ingestor.Store.TakeDirty()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,157 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package state keeps the latest state twins report on the broker and
+// writes it to the status of their TwinInstances.
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/agwermann/dt-operator/pkg/topics"
+)
+
+// Twin is the state reported by a twin.
+type Twin struct {
+	// Class is the class segment of the topics the twin published on.
+	Class string
+	// Attributes holds the latest value of each telemetry and reported
+	// property, keyed by attribute name.
+	Attributes map[string]json.RawMessage
+	// LastSeen is when the twin last published.
+	LastSeen time.Time
+}
+
+// Store holds the state of twins, keyed by the namespaced name of their
+// TwinInstance, and remembers which twins changed since they were last
+// taken.
+type Store struct {
+	// Now returns the time messages are received at.
+	Now func() time.Time
+
+	mu    sync.Mutex
+	twins map[types.NamespacedName]*Twin
+	dirty map[types.NamespacedName]bool
+}
+
+// NewStore returns an empty store.
+func NewStore() *Store {
+	return &Store{
+		Now:   time.Now,
+		twins: map[types.NamespacedName]*Twin{},
+		dirty: map[types.NamespacedName]bool{},
+	}
+}
+
+// Report records a message published on topic. Only telemetry and reported
+// properties change the attributes of a twin; other twin topics only update
+// when it was last seen, and topics outside the twin layout are ignored.
+// A twin publishing below another class starts over with no attributes, so
+// that its state never mixes the values of two classes.
+// Payloads that are not JSON are kept as strings.
+func (s *Store) Report(topic string, payload []byte) bool {
+	namespace, class, instance, rest, ok := topics.ParseInstance(topic)
+	if !ok {
+		return false
+	}
+	attribute, isAttribute := topics.Attribute(rest)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := types.NamespacedName{Namespace: namespace, Name: instance}
+	twin, ok := s.twins[key]
+	if !ok {
+		twin = &Twin{Attributes: map[string]json.RawMessage{}}
+		s.twins[key] = twin
+	}
+	if twin.Class != class {
+		twin.Attributes = map[string]json.RawMessage{}
+		twin.Class = class
+	}
+	twin.LastSeen = s.Now()
+	if isAttribute {
+		twin.Attributes[attribute] = Value(payload)
+	}
+	s.dirty[key] = true
+	return true
+}
+
+// Value returns a payload as compact JSON, quoting payloads that are not
+// JSON.
+func Value(payload []byte) json.RawMessage {
+	compact := &bytes.Buffer{}
+	if err := json.Compact(compact, payload); err == nil {
+		return compact.Bytes()
+	}
+	raw, _ := json.Marshal(string(payload))
+	return raw
+}
+
+// Get returns a copy of the state of a twin.
+func (s *Store) Get(key types.NamespacedName) (Twin, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	twin, ok := s.twins[key]
+	if !ok {
+		return Twin{}, false
+	}
+	copied := *twin
+	copied.Attributes = make(map[string]json.RawMessage, len(twin.Attributes))
+	for name, raw := range twin.Attributes {
+		copied.Attributes[name] = raw
+	}
+	return copied, true
+}
+
+// TakeDirty returns the twins that changed since the last call.
+func (s *Store) TakeDirty() []types.NamespacedName {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]types.NamespacedName, 0, len(s.dirty))
+	for key := range s.dirty {
+		keys = append(keys, key)
+	}
+	s.dirty = map[types.NamespacedName]bool{}
+	return keys
+}
+
+// MarkDirty hands a twin back to the next TakeDirty, after its state could
+// not be written.
+func (s *Store) MarkDirty(key types.NamespacedName) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.twins[key]; ok {
+		s.dirty[key] = true
+	}
+}
+
+// Forget drops the state of a twin whose TwinInstance does not exist.
+func (s *Store) Forget(key types.NamespacedName) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.twins, key)
+	delete(s.dirty, key)
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ServiceAccess lists the class topics a service connecting with Username
+// may use.
+type ServiceAccess struct {
+	Username string
+	Topics   []Topic
+}
+
+// OperatorUsername is the MQTT username the operator connects with to ingest
+// the state of twins and measure their message rates.
+const OperatorUsername = "dt-operator"
+
+// HistoryUsername is the MQTT username the history store connects with.
+const HistoryUsername = OperatorUsername + "-history"
+
+// ExporterUsername is the MQTT username the metrics exporter of a broker
+// connects with.
+const ExporterUsername = OperatorUsername + "-exporter"
+
+// RecorderUsername returns the MQTT username the recorders of namespace
+// connect with. Namespaces cannot hold an @, so it is distinct from the
+// usernames of services.
+func RecorderUsername(namespace string) string {
+	return OperatorUsername + "-recorder@" + namespace
+}
+
+// TwinUsername returns the MQTT username the twin instances of namespace
+// connect with.
+func TwinUsername(namespace string) string {
+	return "twins@" + namespace
+}
+
+// ServiceUsername returns the MQTT username a TwinService connects with.
+func ServiceUsername(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// ACL renders a Mosquitto acl_file for clients that all authenticate. The
+// twins of a namespace connect with its TwinUsername, and may only use the
+// topics of twinTopics of their namespace: they publish telemetry, reported
+// properties and command responses, and subscribe to desired properties and
+// command requests. Services get the opposite rights on the topics of their
+// classes. The operator and the history store read every twin topic, the
+// metrics exporter the statistics of the broker, and the recorders of every
+// namespace the twin topics of their namespace.
+func ACL(twinTopics map[string][]Topic, services []ServiceAccess) string {
+	b := &strings.Builder{}
+	b.WriteString("# Generated by dt-operator. DO NOT EDIT.\n")
+
+	fmt.Fprintf(b, "\n# State ingestion and autoscaling\nuser %s\ntopic read %s/#\n", OperatorUsername, Root)
+	fmt.Fprintf(b, "\n# History\nuser %s\ntopic read %s/#\n", HistoryUsername, Root)
+	fmt.Fprintf(b, "\n# Broker metrics\nuser %s\ntopic read $SYS/#\n", ExporterUsername)
+
+	namespaces := []string{}
+	for namespace := range twinTopics {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		fmt.Fprintf(b, "\n# Twin instances of %s\nuser %s\n", namespace, TwinUsername(namespace))
+		writeRules(b, twinTopics[namespace], deviceAccess)
+		fmt.Fprintf(b, "\n# Recordings of %s\nuser %s\ntopic read %s\n", namespace, RecorderUsername(namespace), NamespaceFilter(namespace))
+	}
+
+	services = append([]ServiceAccess(nil), services...)
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Username < services[j].Username
+	})
+	for _, service := range services {
+		fmt.Fprintf(b, "\nuser %s\n", service.Username)
+		writeRules(b, service.Topics, serviceAccess)
+	}
+
+	return b.String()
+}
+
+// ServiceInputs returns the sorted names of the topics of a layout a
+// service reads: telemetry, reported properties and command responses.
+func ServiceInputs(layout []Topic) []string {
+	seen := map[string]bool{}
+	inputs := []string{}
+	for _, topic := range layout {
+		if serviceAccess(topic.Kind) == "read" && !seen[topic.Name] {
+			seen[topic.Name] = true
+			inputs = append(inputs, topic.Name)
+		}
+	}
+	sort.Strings(inputs)
+	return inputs
+}
+
+func deviceAccess(kind Kind) string {
+	switch kind {
+	case DesiredProperty, CommandRequest:
+		return "read"
+	default:
+		return "write"
+	}
+}
+
+func serviceAccess(kind Kind) string {
+	if deviceAccess(kind) == "read" {
+		return "write"
+	}
+	return "read"
+}
+
+func writeRules(b *strings.Builder, layout []Topic, access func(Kind) string) {
+	seen := map[string]bool{}
+	rules := []string{}
+	for _, topic := range layout {
+		rule := fmt.Sprintf("topic %s %s", access(topic.Kind), topic.Name)
+		if !seen[rule] {
+			seen[rule] = true
+			rules = append(rules, rule)
+		}
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		b.WriteString(rule + "\n")
+	}
+}
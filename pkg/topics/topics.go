@@ -0,0 +1,194 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topics defines the canonical MQTT topic layout of twin classes
+// and renders broker ACLs from it.
+//
+// Every twin instance owns the topic tree
+//
+//	dt/<namespace>/<class>/<instance>/
+//	  telemetry/<name>                  samples, not retained
+//	  properties/<name>                 reported value, retained
+//	  properties/<name>/set             desired value of a writable property
+//	  commands/<name>/request           command invocation
+//	  commands/<name>/response          command result
+//
+// Contents of components are named <slot>/<name>, so they live one level
+// below the contents of the embedding class.
+package topics
+
+import (
+	"strings"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+const Root = "dt"
+
+// AnyInstance is the single level wildcard used in place of the instance
+// segment of class topics.
+const AnyInstance = "+"
+
+type Kind string
+
+const (
+	Telemetry        Kind = "telemetry"
+	ReportedProperty Kind = "reportedProperty"
+	DesiredProperty  Kind = "desiredProperty"
+	CommandRequest   Kind = "commandRequest"
+	CommandResponse  Kind = "commandResponse"
+)
+
+// Topic is one topic of a class layout. Name holds AnyInstance in place of
+// the instance ID.
+type Topic struct {
+	Name     string `json:"name"`
+	Kind     Kind   `json:"kind"`
+	Content  string `json:"content"`
+	Unit     string `json:"unit,omitempty"`
+	Retained bool   `json:"retained"`
+}
+
+// Instance returns the topic prefix of a twin instance.
+func Instance(namespace, class, instance string) string {
+	return strings.Join([]string{Root, namespace, class, instance}, "/")
+}
+
+// ClassFilter returns a subscription filter matching every topic of every
+// instance of a class.
+func ClassFilter(namespace, class string) string {
+	return Instance(namespace, class, AnyInstance) + "/#"
+}
+
+// NamespaceFilter returns a subscription filter matching every twin topic of
+// a namespace.
+func NamespaceFilter(namespace string) string {
+	return strings.Join([]string{Root, namespace, "#"}, "/")
+}
+
+// InNamespace reports whether a subscription filter only matches twin topics
+// of a namespace.
+func InNamespace(filter, namespace string) bool {
+	return strings.HasPrefix(filter, strings.Join([]string{Root, namespace, ""}, "/"))
+}
+
+// ParseInstance splits a topic below the prefix of a twin instance into the
+// namespace, class and name of the instance and the rest of the topic.
+func ParseInstance(topic string) (namespace, class, instance, rest string, ok bool) {
+	parts := strings.SplitN(topic, "/", 5)
+	if len(parts) != 5 || parts[0] != Root || parts[1] == "" || parts[2] == "" || parts[3] == "" {
+		return "", "", "", "", false
+	}
+	return parts[1], parts[2], parts[3], parts[4], true
+}
+
+// Attribute returns the attribute carried by the rest of an instance topic,
+// when it is a telemetry or reported property topic.
+func Attribute(rest string) (string, bool) {
+	kind, attribute, _ := strings.Cut(rest, "/")
+	switch {
+	case attribute == "":
+		return "", false
+	case kind == "telemetry":
+		return attribute, true
+	case kind == "properties":
+		return attribute, !strings.HasSuffix(attribute, "/set")
+	}
+	return "", false
+}
+
+// ForClass returns the topic layout of a class.
+func ForClass(namespace string, class *model.ResolvedClass) []Topic {
+	prefix := Instance(namespace, class.Name, AnyInstance)
+	layout := []Topic{}
+
+	for _, attribute := range class.Attributes {
+		if attribute.Kind == dtdlv0.Telemetry {
+			layout = append(layout, Topic{
+				Name:    prefix + "/telemetry/" + attribute.Name,
+				Kind:    Telemetry,
+				Content: attribute.Name,
+				Unit:    attribute.Unit,
+			})
+			continue
+		}
+
+		layout = append(layout, Topic{
+			Name:     prefix + "/properties/" + attribute.Name,
+			Kind:     ReportedProperty,
+			Content:  attribute.Name,
+			Unit:     attribute.Unit,
+			Retained: true,
+		})
+		if attribute.Writable {
+			layout = append(layout, Topic{
+				Name:    prefix + "/properties/" + attribute.Name + "/set",
+				Kind:    DesiredProperty,
+				Content: attribute.Name,
+				Unit:    attribute.Unit,
+			})
+		}
+	}
+
+	for _, command := range class.Commands {
+		layout = append(layout, Topic{
+			Name:    prefix + "/commands/" + command.Name + "/request",
+			Kind:    CommandRequest,
+			Content: command.Name,
+		}, Topic{
+			Name:    prefix + "/commands/" + command.Name + "/response",
+			Kind:    CommandResponse,
+			Content: command.Name,
+		})
+	}
+
+	return layout
+}
+
+// ForService returns the topic layout of the classes a service selects,
+// given as class references such as Factory or dtmi:acme:Factory;>=2.
+func ForService(m *model.Model, namespace string, classes []string) ([]Topic, error) {
+	layout := []Topic{}
+	for _, reference := range classes {
+		class, err := m.Select(reference)
+		if err != nil {
+			return nil, err
+		}
+		resolved, err := m.ResolveClass(class)
+		if err != nil {
+			return nil, err
+		}
+		layout = append(layout, ForClass(namespace, resolved)...)
+	}
+	return layout, nil
+}
+
+// Match reports whether a topic matches a subscription filter, which may
+// hold the single level wildcard + and end with the multi level wildcard #.
+func Match(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i == len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topics
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+var _ = Describe("Topics", func() {
+	var layout []Topic
+
+	BeforeEach(func() {
+		m := model.New([]dtdlv0.TwinClass{{
+			Spec: dtdlv0.TwinClassSpec{
+				Name: "Machine",
+				Attributes: []dtdlv0.TwinClassAttributes{
+					{Name: "temperature", TwinSchema: dtdlv0.TwinSchema{Type: "double"}, Kind: dtdlv0.Telemetry, Unit: "kelvin"},
+					{Name: "serial", TwinSchema: dtdlv0.TwinSchema{Type: "string"}},
+					{Name: "speed", TwinSchema: dtdlv0.TwinSchema{Type: "integer"}, Kind: dtdlv0.Property, Writable: true},
+				},
+				Commands: []dtdlv0.TwinCommand{{Name: "reset"}},
+			},
+		}}, nil)
+
+		resolved, err := m.Resolve("Machine")
+		Expect(err).NotTo(HaveOccurred())
+		layout = ForClass("plant", resolved)
+	})
+
+	It("retains properties but not telemetry or commands", func() {
+		Expect(layout).To(Equal([]Topic{
+			{Name: "dt/plant/Machine/+/telemetry/temperature", Kind: Telemetry, Content: "temperature", Unit: "kelvin"},
+			{Name: "dt/plant/Machine/+/properties/serial", Kind: ReportedProperty, Content: "serial", Retained: true},
+			{Name: "dt/plant/Machine/+/properties/speed", Kind: ReportedProperty, Content: "speed", Retained: true},
+			{Name: "dt/plant/Machine/+/properties/speed/set", Kind: DesiredProperty, Content: "speed"},
+			{Name: "dt/plant/Machine/+/commands/reset/request", Kind: CommandRequest, Content: "reset"},
+			{Name: "dt/plant/Machine/+/commands/reset/response", Kind: CommandResponse, Content: "reset"},
+		}))
+	})
+
+	It("grants twins and services opposite rights", func() {
+		acl := ACL(map[string][]Topic{"plant": layout}, []ServiceAccess{{Username: ServiceUsername("plant", "monitor"), Topics: layout}})
+
+		Expect(acl).To(Equal(`# Generated by dt-operator. DO NOT EDIT.
+
+# State ingestion and autoscaling
+user dt-operator
+topic read dt/#
+
+# History
+user dt-operator-history
+topic read dt/#
+
+# Broker metrics
+user dt-operator-exporter
+topic read $SYS/#
+
+# Twin instances of plant
+user twins@plant
+topic read dt/plant/Machine/+/commands/reset/request
+topic read dt/plant/Machine/+/properties/speed/set
+topic write dt/plant/Machine/+/commands/reset/response
+topic write dt/plant/Machine/+/properties/serial
+topic write dt/plant/Machine/+/properties/speed
+topic write dt/plant/Machine/+/telemetry/temperature
+
+# Recordings of plant
+user dt-operator-recorder@plant
+topic read dt/plant/#
+
+user plant/monitor
+topic read dt/plant/Machine/+/commands/reset/response
+topic read dt/plant/Machine/+/properties/serial
+topic read dt/plant/Machine/+/properties/speed
+topic read dt/plant/Machine/+/telemetry/temperature
+topic write dt/plant/Machine/+/commands/reset/request
+topic write dt/plant/Machine/+/properties/speed/set
+`))
+	})
+
+	It("lists the topics services read", func() {
+		Expect(ServiceInputs(append(layout, layout...))).To(Equal([]string{
+			"dt/plant/Machine/+/commands/reset/response",
+			"dt/plant/Machine/+/properties/serial",
+			"dt/plant/Machine/+/properties/speed",
+			"dt/plant/Machine/+/telemetry/temperature",
+		}))
+	})
+
+	It("matches topics against subscription filters", func() {
+		Expect(Match("dt/plant/Machine/+/telemetry/temperature", "dt/plant/Machine/m1/telemetry/temperature")).To(BeTrue())
+		Expect(Match("dt/plant/Machine/+/telemetry/temperature", "dt/plant/Machine/m1/telemetry/pressure")).To(BeFalse())
+		Expect(Match("dt/plant/Machine/+/properties/speed", "dt/plant/Machine/m1/properties/speed/set")).To(BeFalse())
+		Expect(Match("dt/plant/#", "dt/plant/Machine/m1/properties/speed/set")).To(BeTrue())
+		Expect(Match("dt/plant/Machine/+", "dt/plant/Machine")).To(BeFalse())
+	})
+
+	It("keeps namespace filters within their namespace", func() {
+		Expect(NamespaceFilter("plant")).To(Equal("dt/plant/#"))
+		Expect(InNamespace(NamespaceFilter("plant"), "plant")).To(BeTrue())
+		Expect(InNamespace(ClassFilter("plant", "Machine"), "plant")).To(BeTrue())
+		Expect(InNamespace("dt/#", "plant")).To(BeFalse())
+		Expect(InNamespace("dt/+/Machine/#", "plant")).To(BeFalse())
+		Expect(InNamespace("dt/plant-2/#", "plant")).To(BeFalse())
+	})
+
+	It("parses instance topics", func() {
+		namespace, class, instance, rest, ok := ParseInstance("dt/plant/Cnc/cnc-1/telemetry/spindle/rpm")
+		Expect(ok).To(BeTrue())
+		Expect([]string{namespace, class, instance, rest}).To(Equal([]string{"plant", "Cnc", "cnc-1", "telemetry/spindle/rpm"}))
+
+		_, _, _, _, ok = ParseInstance("dt/plant/Cnc/cnc-1")
+		Expect(ok).To(BeFalse())
+		_, _, _, _, ok = ParseInstance("other/plant/Cnc/cnc-1/telemetry/rpm")
+		Expect(ok).To(BeFalse())
+
+		attribute, ok := Attribute(rest)
+		Expect(ok).To(BeTrue())
+		Expect(attribute).To(Equal("spindle/rpm"))
+		for _, rest := range []string{"properties/speed/set", "commands/reset/request", "telemetry"} {
+			_, ok = Attribute(rest)
+			Expect(ok).To(BeFalse(), rest)
+		}
+	})
+
+	It("places component contents below their slot", func() {
+		m := model.New([]dtdlv0.TwinClass{
+			{Spec: dtdlv0.TwinClassSpec{
+				Name:       "Cnc",
+				Components: []dtdlv0.TwinComponent{{Name: "spindle", Class: "Spindle"}},
+			}},
+			{Spec: dtdlv0.TwinClassSpec{
+				Name:       "Spindle",
+				Attributes: []dtdlv0.TwinClassAttributes{{Name: "rpm", TwinSchema: dtdlv0.TwinSchema{Type: "double"}, Kind: dtdlv0.Telemetry}},
+			}},
+		}, nil)
+
+		resolved, err := m.Resolve("Cnc")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ForClass("plant", resolved)).To(Equal([]Topic{
+			{Name: "dt/plant/Cnc/+/telemetry/spindle/rpm", Kind: Telemetry, Content: "spindle/rpm"},
+		}))
+	})
+})
@@ -0,0 +1,148 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package units holds the DTDL semantic types and units and converts values
+// between units of the same kind.
+//
+// Every unit is a linear function of the coherent unit of its unit type:
+// base = value*factor + offset. Converting between two units goes through
+// that base unit.
+package units
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SemanticType is a DTDL semantic type, such as Temperature, and the unit
+// type its units are drawn from.
+type SemanticType struct {
+	Name     string
+	UnitType string
+}
+
+// Unit is a DTDL unit, such as degreeCelsius.
+type Unit struct {
+	Name     string
+	Symbol   string
+	UnitType string
+	factor   float64
+	offset   float64
+}
+
+// Lookup finds a semantic type by its DTDL name.
+func Lookup(semanticType string) (SemanticType, bool) {
+	unitType, ok := semanticTypes[semanticType]
+	return SemanticType{Name: semanticType, UnitType: unitType}, ok
+}
+
+// LookupUnit finds a unit by its DTDL name or its symbol.
+func LookupUnit(unit string) (Unit, bool) {
+	if u, ok := unitsByName[unit]; ok {
+		return u, true
+	}
+	u, ok := unitsBySymbol[unit]
+	return u, ok
+}
+
+// Check reports whether unit can express values of semanticType. Either
+// may be empty, in which case only the other one is checked.
+func Check(semanticType, unit string) error {
+	var st SemanticType
+	if semanticType != "" {
+		var ok bool
+		if st, ok = Lookup(semanticType); !ok {
+			return fmt.Errorf("unknown semantic type %q", semanticType)
+		}
+	}
+	if unit == "" {
+		return nil
+	}
+
+	u, ok := LookupUnit(unit)
+	if !ok {
+		return fmt.Errorf("unknown unit %q", unit)
+	}
+	if semanticType != "" && u.UnitType != st.UnitType {
+		return fmt.Errorf("unit %q is a %s, but semantic type %s takes a %s", unit, u.UnitType, st.Name, st.UnitType)
+	}
+	return nil
+}
+
+// Convert converts value from one unit to another of the same unit type.
+func Convert(value float64, from, to string) (float64, error) {
+	source, ok := LookupUnit(from)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+	target, ok := LookupUnit(to)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+	if source.Name == target.Name {
+		return value, nil
+	}
+	if source.UnitType != target.UnitType {
+		return 0, fmt.Errorf("cannot convert %s (%s) to %s (%s)", source.Name, source.UnitType, target.Name, target.UnitType)
+	}
+
+	base := value*source.factor + source.offset
+	return (base - target.offset) / target.factor, nil
+}
+
+// Normalize converts a value reported in unit to the declared unit of an
+// attribute. Values without a unit are taken to be in the declared unit
+// already, as are values of attributes without one.
+func Normalize(value float64, unit, declared string) (float64, error) {
+	if unit == "" || declared == "" {
+		return value, nil
+	}
+	return Convert(value, unit, declared)
+}
+
+// SemanticTypes returns the names of all semantic types, sorted.
+func SemanticTypes() []string {
+	names := make([]string, 0, len(semanticTypes))
+	for name := range semanticTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Units returns the names of the units of a unit type, sorted.
+func Units(unitType string) []string {
+	names := []string{}
+	for _, u := range unitsByName {
+		if u.UnitType == unitType {
+			names = append(names, u.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+var unitsByName = map[string]Unit{}
+var unitsBySymbol = map[string]Unit{}
+
+func init() {
+	for _, u := range unitTable {
+		unitsByName[u.Name] = u
+		if u.Symbol != "" {
+			unitsBySymbol[u.Symbol] = u
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package units
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Vocabulary", func() {
+	It("has unique unit names and symbols", func() {
+		names := map[string]bool{}
+		symbols := map[string]bool{}
+		for _, u := range unitTable {
+			Expect(names).NotTo(HaveKey(u.Name))
+			names[u.Name] = true
+			if u.Symbol != "" {
+				Expect(symbols).NotTo(HaveKey(u.Symbol))
+				symbols[u.Symbol] = true
+			}
+		}
+	})
+
+	It("has units for every semantic type", func() {
+		for _, name := range SemanticTypes() {
+			semanticType, ok := Lookup(name)
+			Expect(ok).To(BeTrue())
+			Expect(Units(semanticType.UnitType)).NotTo(BeEmpty(), "no units for %s", name)
+		}
+	})
+})
+
+var _ = Describe("Check", func() {
+	DescribeTable("accepts matching units",
+		func(semanticType, unit string) {
+			Expect(Check(semanticType, unit)).To(Succeed())
+		},
+		Entry("temperature", "Temperature", "degreeCelsius"),
+		Entry("symbol", "Temperature", "K"),
+		Entry("distance", "Distance", "kilometre"),
+		Entry("semantic type only", "Power", ""),
+		Entry("unit only", "", "watt"),
+	)
+
+	DescribeTable("rejects mismatches",
+		func(semanticType, unit, message string) {
+			Expect(Check(semanticType, unit)).To(MatchError(message))
+		},
+		Entry("wrong unit type", "Temperature", "metre", `unit "metre" is a LengthUnit, but semantic type Temperature takes a TemperatureUnit`),
+		Entry("unknown semantic type", "Warmth", "kelvin", `unknown semantic type "Warmth"`),
+		Entry("unknown unit", "Temperature", "rankine", `unknown unit "rankine"`),
+	)
+})
+
+var _ = Describe("Convert", func() {
+	DescribeTable("converts between units",
+		func(value float64, from, to string, expected float64) {
+			converted, err := Convert(value, from, to)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(converted).To(BeNumerically("~", expected, 1e-9))
+		},
+		Entry("celsius to kelvin", 21.5, "degreeCelsius", "kelvin", 294.65),
+		Entry("fahrenheit to celsius", 212.0, "degreeFahrenheit", "degreeCelsius", 100.0),
+		Entry("kelvin to fahrenheit", 0.0, "kelvin", "degreeFahrenheit", -459.67),
+		Entry("kilowatt to watt", 1.5, "kW", "watt", 1500.0),
+		Entry("rpm to radians per second", 60.0, "revolutionPerMinute", "radianPerSecond", 6.283185307179586),
+		Entry("kibibyte to bit", 1.0, "kibibyte", "bit", 8192.0),
+		Entry("same unit", 3.0, "metre", "m", 3.0),
+	)
+
+	It("rejects units of different types", func() {
+		_, err := Convert(1, "metre", "second")
+		Expect(err).To(MatchError("cannot convert metre (LengthUnit) to second (TimeUnit)"))
+	})
+
+	It("normalizes values without a unit to the declared unit", func() {
+		Expect(Normalize(20, "", "degreeCelsius")).To(Equal(20.0))
+		Expect(Normalize(68, "°F", "degreeCelsius")).To(BeNumerically("~", 20, 1e-9))
+	})
+})
@@ -0,0 +1,322 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package units
+
+import "math"
+
+// Unit types of the DTDL v2 vocabulary.
+const (
+	AccelerationUnit        = "AccelerationUnit"
+	AngleUnit               = "AngleUnit"
+	AngularAccelerationUnit = "AngularAccelerationUnit"
+	AngularVelocityUnit     = "AngularVelocityUnit"
+	AreaUnit                = "AreaUnit"
+	CapacitanceUnit         = "CapacitanceUnit"
+	ChargeUnit              = "ChargeUnit"
+	CurrentUnit             = "CurrentUnit"
+	DataRateUnit            = "DataRateUnit"
+	DataSizeUnit            = "DataSizeUnit"
+	DensityUnit             = "DensityUnit"
+	EnergyUnit              = "EnergyUnit"
+	ForceUnit               = "ForceUnit"
+	FrequencyUnit           = "FrequencyUnit"
+	IlluminanceUnit         = "IlluminanceUnit"
+	InductanceUnit          = "InductanceUnit"
+	LengthUnit              = "LengthUnit"
+	LuminanceUnit           = "LuminanceUnit"
+	LuminousFluxUnit        = "LuminousFluxUnit"
+	LuminousIntensityUnit   = "LuminousIntensityUnit"
+	MagneticFluxUnit        = "MagneticFluxUnit"
+	MagneticInductionUnit   = "MagneticInductionUnit"
+	MassFlowRateUnit        = "MassFlowRateUnit"
+	MassUnit                = "MassUnit"
+	PowerUnit               = "PowerUnit"
+	PressureUnit            = "PressureUnit"
+	ResistanceUnit          = "ResistanceUnit"
+	SoundPressureUnit       = "SoundPressureUnit"
+	TemperatureUnit         = "TemperatureUnit"
+	TimeUnit                = "TimeUnit"
+	TorqueUnit              = "TorqueUnit"
+	Unitless                = "Unitless"
+	VelocityUnit            = "VelocityUnit"
+	VoltageUnit             = "VoltageUnit"
+	VolumeFlowRateUnit      = "VolumeFlowRateUnit"
+	VolumeUnit              = "VolumeUnit"
+)
+
+// semanticTypes maps every DTDL v2 semantic type to its unit type.
+var semanticTypes = map[string]string{
+	"Acceleration":        AccelerationUnit,
+	"Angle":               AngleUnit,
+	"AngularAcceleration": AngularAccelerationUnit,
+	"AngularVelocity":     AngularVelocityUnit,
+	"Area":                AreaUnit,
+	"Capacitance":         CapacitanceUnit,
+	"Current":             CurrentUnit,
+	"DataRate":            DataRateUnit,
+	"DataSize":            DataSizeUnit,
+	"Density":             DensityUnit,
+	"Distance":            LengthUnit,
+	"ElectricCharge":      ChargeUnit,
+	"Energy":              EnergyUnit,
+	"Force":               ForceUnit,
+	"Frequency":           FrequencyUnit,
+	"Humidity":            DensityUnit,
+	"Illuminance":         IlluminanceUnit,
+	"Inductance":          InductanceUnit,
+	"Latitude":            AngleUnit,
+	"Length":              LengthUnit,
+	"Longitude":           AngleUnit,
+	"Luminance":           LuminanceUnit,
+	"Luminosity":          PowerUnit,
+	"LuminousFlux":        LuminousFluxUnit,
+	"LuminousIntensity":   LuminousIntensityUnit,
+	"MagneticFlux":        MagneticFluxUnit,
+	"MagneticInduction":   MagneticInductionUnit,
+	"Mass":                MassUnit,
+	"MassFlowRate":        MassFlowRateUnit,
+	"Power":               PowerUnit,
+	"Pressure":            PressureUnit,
+	"RelativeHumidity":    Unitless,
+	"Resistance":          ResistanceUnit,
+	"SoundPressure":       SoundPressureUnit,
+	"Temperature":         TemperatureUnit,
+	"Thrust":              ForceUnit,
+	"TimeSpan":            TimeUnit,
+	"Torque":              TorqueUnit,
+	"Velocity":            VelocityUnit,
+	"Voltage":             VoltageUnit,
+	"Volume":              VolumeUnit,
+	"VolumeFlowRate":      VolumeFlowRateUnit,
+}
+
+func linear(name, symbol, unitType string, factor float64) Unit {
+	return Unit{Name: name, Symbol: symbol, UnitType: unitType, factor: factor}
+}
+
+// unitTable lists the DTDL v2 units with their factor to the coherent unit
+// of their unit type.
+var unitTable = []Unit{
+	linear("metrePerSecondSquared", "m/s²", AccelerationUnit, 1),
+	linear("centimetrePerSecondSquared", "cm/s²", AccelerationUnit, 1e-2),
+	linear("gForce", "g₀", AccelerationUnit, 9.80665),
+
+	linear("radian", "rad", AngleUnit, 1),
+	linear("degreeOfArc", "°", AngleUnit, math.Pi/180),
+	linear("minuteOfArc", "′", AngleUnit, math.Pi/10800),
+	linear("secondOfArc", "″", AngleUnit, math.Pi/648000),
+	linear("turn", "tr", AngleUnit, 2*math.Pi),
+
+	linear("radianPerSecondSquared", "rad/s²", AngularAccelerationUnit, 1),
+
+	linear("radianPerSecond", "rad/s", AngularVelocityUnit, 1),
+	linear("degreePerSecond", "°/s", AngularVelocityUnit, math.Pi/180),
+	linear("revolutionPerSecond", "rps", AngularVelocityUnit, 2*math.Pi),
+	linear("revolutionPerMinute", "rpm", AngularVelocityUnit, 2*math.Pi/60),
+
+	linear("squareMetre", "m²", AreaUnit, 1),
+	linear("squareCentimetre", "cm²", AreaUnit, 1e-4),
+	linear("squareMillimetre", "mm²", AreaUnit, 1e-6),
+	linear("squareKilometre", "km²", AreaUnit, 1e6),
+	linear("hectare", "ha", AreaUnit, 1e4),
+	linear("squareFoot", "ft²", AreaUnit, 0.09290304),
+	linear("squareInch", "in²", AreaUnit, 6.4516e-4),
+	linear("acre", "ac", AreaUnit, 4046.8564224),
+
+	linear("farad", "F", CapacitanceUnit, 1),
+	linear("millifarad", "mF", CapacitanceUnit, 1e-3),
+	linear("microfarad", "μF", CapacitanceUnit, 1e-6),
+	linear("nanofarad", "nF", CapacitanceUnit, 1e-9),
+	linear("picofarad", "pF", CapacitanceUnit, 1e-12),
+
+	linear("coulomb", "C", ChargeUnit, 1),
+
+	linear("ampere", "A", CurrentUnit, 1),
+	linear("milliampere", "mA", CurrentUnit, 1e-3),
+	linear("microampere", "μA", CurrentUnit, 1e-6),
+
+	linear("bitPerSecond", "bit/s", DataRateUnit, 1),
+	linear("kibibitPerSecond", "Kibit/s", DataRateUnit, 1<<10),
+	linear("mebibitPerSecond", "Mibit/s", DataRateUnit, 1<<20),
+	linear("gibibitPerSecond", "Gibit/s", DataRateUnit, 1<<30),
+	linear("tebibitPerSecond", "Tibit/s", DataRateUnit, 1<<40),
+	linear("exbibitPerSecond", "Eibit/s", DataRateUnit, 1<<60),
+	linear("zebibitPerSecond", "Zibit/s", DataRateUnit, math.Pow(2, 70)),
+	linear("yobibitPerSecond", "Yibit/s", DataRateUnit, math.Pow(2, 80)),
+	linear("bytePerSecond", "B/s", DataRateUnit, 8),
+	linear("kibibytePerSecond", "KiB/s", DataRateUnit, 8<<10),
+	linear("mebibytePerSecond", "MiB/s", DataRateUnit, 8<<20),
+	linear("gibibytePerSecond", "GiB/s", DataRateUnit, 8<<30),
+	linear("tebibytePerSecond", "TiB/s", DataRateUnit, 8<<40),
+	linear("exbibytePerSecond", "EiB/s", DataRateUnit, 8*math.Pow(2, 60)),
+	linear("zebibytePerSecond", "ZiB/s", DataRateUnit, 8*math.Pow(2, 70)),
+	linear("yobibytePerSecond", "YiB/s", DataRateUnit, 8*math.Pow(2, 80)),
+
+	linear("bit", "bit", DataSizeUnit, 1),
+	linear("kibibit", "Kibit", DataSizeUnit, 1<<10),
+	linear("mebibit", "Mibit", DataSizeUnit, 1<<20),
+	linear("gibibit", "Gibit", DataSizeUnit, 1<<30),
+	linear("tebibit", "Tibit", DataSizeUnit, 1<<40),
+	linear("exbibit", "Eibit", DataSizeUnit, 1<<60),
+	linear("zebibit", "Zibit", DataSizeUnit, math.Pow(2, 70)),
+	linear("yobibit", "Yibit", DataSizeUnit, math.Pow(2, 80)),
+	linear("byte", "B", DataSizeUnit, 8),
+	linear("kibibyte", "KiB", DataSizeUnit, 8<<10),
+	linear("mebibyte", "MiB", DataSizeUnit, 8<<20),
+	linear("gibibyte", "GiB", DataSizeUnit, 8<<30),
+	linear("tebibyte", "TiB", DataSizeUnit, 8<<40),
+	linear("exbibyte", "EiB", DataSizeUnit, 8*math.Pow(2, 60)),
+	linear("zebibyte", "ZiB", DataSizeUnit, 8*math.Pow(2, 70)),
+	linear("yobibyte", "YiB", DataSizeUnit, 8*math.Pow(2, 80)),
+
+	linear("kilogramPerCubicMetre", "kg/m³", DensityUnit, 1),
+	linear("gramPerCubicMetre", "g/m³", DensityUnit, 1e-3),
+
+	linear("joule", "J", EnergyUnit, 1),
+	linear("kilojoule", "kJ", EnergyUnit, 1e3),
+	linear("megajoule", "MJ", EnergyUnit, 1e6),
+	linear("gigajoule", "GJ", EnergyUnit, 1e9),
+	linear("electronvolt", "eV", EnergyUnit, 1.602176634e-19),
+	linear("megaelectronvolt", "MeV", EnergyUnit, 1.602176634e-13),
+	linear("kilowattHour", "kWh", EnergyUnit, 3.6e6),
+
+	linear("newton", "N", ForceUnit, 1),
+	linear("pound", "lbf", ForceUnit, 4.4482216152605),
+	linear("ounce", "ozf", ForceUnit, 0.27801385095378125),
+	linear("ton", "tonf", ForceUnit, 8896.443230521),
+
+	linear("hertz", "Hz", FrequencyUnit, 1),
+	linear("kilohertz", "kHz", FrequencyUnit, 1e3),
+	linear("megahertz", "MHz", FrequencyUnit, 1e6),
+	linear("gigahertz", "GHz", FrequencyUnit, 1e9),
+
+	linear("lux", "lx", IlluminanceUnit, 1),
+	linear("footcandle", "fc", IlluminanceUnit, 10.763910416709722),
+
+	linear("henry", "H", InductanceUnit, 1),
+	linear("millihenry", "mH", InductanceUnit, 1e-3),
+	linear("microhenry", "μH", InductanceUnit, 1e-6),
+
+	linear("metre", "m", LengthUnit, 1),
+	linear("centimetre", "cm", LengthUnit, 1e-2),
+	linear("millimetre", "mm", LengthUnit, 1e-3),
+	linear("micrometre", "μm", LengthUnit, 1e-6),
+	linear("nanometre", "nm", LengthUnit, 1e-9),
+	linear("kilometre", "km", LengthUnit, 1e3),
+	linear("foot", "ft", LengthUnit, 0.3048),
+	linear("inch", "in", LengthUnit, 0.0254),
+	linear("mile", "mi", LengthUnit, 1609.344),
+	linear("nauticalMile", "NM", LengthUnit, 1852),
+	linear("astronomicalUnit", "au", LengthUnit, 149597870700),
+
+	linear("candelaPerSquareMetre", "cd/m²", LuminanceUnit, 1),
+
+	linear("lumen", "lm", LuminousFluxUnit, 1),
+
+	linear("candela", "cd", LuminousIntensityUnit, 1),
+
+	linear("weber", "Wb", MagneticFluxUnit, 1),
+	linear("maxwell", "Mx", MagneticFluxUnit, 1e-8),
+
+	linear("tesla", "T", MagneticInductionUnit, 1),
+
+	linear("kilogramPerSecond", "kg/s", MassFlowRateUnit, 1),
+	linear("gramPerSecond", "g/s", MassFlowRateUnit, 1e-3),
+	linear("kilogramPerHour", "kg/h", MassFlowRateUnit, 1.0/3600),
+	linear("gramPerHour", "g/h", MassFlowRateUnit, 1e-3/3600),
+
+	linear("kilogram", "kg", MassUnit, 1),
+	linear("gram", "g", MassUnit, 1e-3),
+	linear("milligram", "mg", MassUnit, 1e-6),
+	linear("microgram", "μg", MassUnit, 1e-9),
+	linear("tonne", "t", MassUnit, 1e3),
+	linear("slug", "slug", MassUnit, 14.593902937206364),
+
+	linear("watt", "W", PowerUnit, 1),
+	linear("microwatt", "μW", PowerUnit, 1e-6),
+	linear("milliwatt", "mW", PowerUnit, 1e-3),
+	linear("kilowatt", "kW", PowerUnit, 1e3),
+	linear("megawatt", "MW", PowerUnit, 1e6),
+	linear("gigawatt", "GW", PowerUnit, 1e9),
+	linear("horsepower", "hp", PowerUnit, 745.6998715822702),
+	linear("kilowattHourPerYear", "kWh/yr", PowerUnit, 3.6e6/31536000),
+
+	linear("pascal", "Pa", PressureUnit, 1),
+	linear("kilopascal", "kPa", PressureUnit, 1e3),
+	linear("bar", "bar", PressureUnit, 1e5),
+	linear("millibar", "mbar", PressureUnit, 1e2),
+	linear("millimetresOfMercury", "mmHg", PressureUnit, 133.322387415),
+	linear("poundPerSquareInch", "psi", PressureUnit, 6894.757293168361),
+	linear("inchesOfMercury", "inHg", PressureUnit, 3386.389),
+	linear("inchesOfWater", "inH₂O", PressureUnit, 249.08891),
+
+	linear("ohm", "Ω", ResistanceUnit, 1),
+	linear("milliohm", "mΩ", ResistanceUnit, 1e-3),
+	linear("kiloohm", "kΩ", ResistanceUnit, 1e3),
+	linear("megaohm", "MΩ", ResistanceUnit, 1e6),
+
+	// Levels are logarithmic; a bel is ten decibels of the same ratio. Its
+	// symbol B is taken by the byte.
+	linear("decibel", "dB", SoundPressureUnit, 1),
+	linear("bel", "", SoundPressureUnit, 10),
+
+	{Name: "kelvin", Symbol: "K", UnitType: TemperatureUnit, factor: 1},
+	{Name: "degreeCelsius", Symbol: "°C", UnitType: TemperatureUnit, factor: 1, offset: 273.15},
+	{Name: "degreeFahrenheit", Symbol: "°F", UnitType: TemperatureUnit, factor: 5.0 / 9, offset: 459.67 * 5 / 9},
+
+	linear("second", "s", TimeUnit, 1),
+	linear("millisecond", "ms", TimeUnit, 1e-3),
+	linear("microsecond", "μs", TimeUnit, 1e-6),
+	linear("nanosecond", "ns", TimeUnit, 1e-9),
+	linear("minute", "min", TimeUnit, 60),
+	linear("hour", "h", TimeUnit, 3600),
+	linear("day", "d", TimeUnit, 86400),
+	linear("year", "yr", TimeUnit, 31536000),
+
+	linear("newtonMetre", "N·m", TorqueUnit, 1),
+
+	linear("percent", "%", Unitless, 1e-2),
+
+	linear("metrePerSecond", "m/s", VelocityUnit, 1),
+	linear("centimetrePerSecond", "cm/s", VelocityUnit, 1e-2),
+	linear("kilometrePerSecond", "km/s", VelocityUnit, 1e3),
+	linear("metrePerHour", "m/h", VelocityUnit, 1.0/3600),
+	linear("kilometrePerHour", "km/h", VelocityUnit, 1.0/3.6),
+	linear("milePerHour", "mph", VelocityUnit, 0.44704),
+	linear("milePerSecond", "mi/s", VelocityUnit, 1609.344),
+	linear("knot", "kn", VelocityUnit, 1852.0/3600),
+
+	linear("volt", "V", VoltageUnit, 1),
+	linear("millivolt", "mV", VoltageUnit, 1e-3),
+	linear("microvolt", "μV", VoltageUnit, 1e-6),
+	linear("kilovolt", "kV", VoltageUnit, 1e3),
+	linear("megavolt", "MV", VoltageUnit, 1e6),
+
+	linear("litrePerSecond", "L/s", VolumeFlowRateUnit, 1),
+	linear("millilitrePerSecond", "mL/s", VolumeFlowRateUnit, 1e-3),
+	linear("litrePerHour", "L/h", VolumeFlowRateUnit, 1.0/3600),
+	linear("millilitrePerHour", "mL/h", VolumeFlowRateUnit, 1e-3/3600),
+
+	linear("cubicMetre", "m³", VolumeUnit, 1),
+	linear("cubicCentimetre", "cm³", VolumeUnit, 1e-6),
+	linear("litre", "L", VolumeUnit, 1e-3),
+	linear("millilitre", "mL", VolumeUnit, 1e-6),
+	linear("cubicFoot", "ft³", VolumeUnit, 0.028316846592),
+	linear("cubicInch", "in³", VolumeUnit, 1.6387064e-5),
+	linear("fluidOunce", "fl oz", VolumeUnit, 2.95735295625e-5),
+	linear("gallon", "gal", VolumeUnit, 3.785411784e-3),
+}
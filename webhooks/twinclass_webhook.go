@@ -0,0 +1,134 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhooks holds the admission webhooks of the twin resources.
+package webhooks
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+//+kubebuilder:webhook:path=/validate-dtdl-digitaltwin-v0-twinclass,mutating=false,failurePolicy=fail,sideEffects=None,groups=dtdl.digitaltwin,resources=twinclasses,verbs=create;update,versions=v0,name=vtwinclass.kb.io,admissionReviewVersions=v1
+
+// TwinClassWebhook validates twin classes on admission.
+//
+// The deprecated relationship fields are not rewritten here: the conversion
+// to the v1 storage version maps them. Validation checks the class on its
+// own; references to enums and classes may be created later and are
+// reported by the TwinClass reconciler instead. It also keeps published
+// versions immutable: the spec of a class with an ID cannot change, and no
+// two classes of a namespace share an ID.
+type TwinClassWebhook struct {
+	Client client.Reader
+}
+
+func (w *TwinClassWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&dtdlv0.TwinClass{}).
+		WithValidator(w).
+		Complete()
+}
+
+func (w *TwinClassWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	return w.validate(ctx, nil, obj)
+}
+
+func (w *TwinClassWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	oldClass, ok := oldObj.(*dtdlv0.TwinClass)
+	if !ok {
+		return fmt.Errorf("expected a TwinClass but got a %T", oldObj)
+	}
+	return w.validate(ctx, oldClass, newObj)
+}
+
+func (w *TwinClassWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (w *TwinClassWebhook) validate(ctx context.Context, oldClass *dtdlv0.TwinClass, obj runtime.Object) error {
+	twinClass, ok := obj.(*dtdlv0.TwinClass)
+	if !ok {
+		return fmt.Errorf("expected a TwinClass but got a %T", obj)
+	}
+
+	errs := structuralErrors(model.New(nil, nil).Validate(twinClass))
+	if oldClass != nil && oldClass.Spec.ID != "" {
+		errs = append(errs, validateImmutable(oldClass, twinClass)...)
+	}
+	if twinClass.Spec.ID != "" && (oldClass == nil || oldClass.Spec.ID != twinClass.Spec.ID) {
+		idErrs, err := w.validateUniqueID(ctx, twinClass)
+		if err != nil {
+			return err
+		}
+		errs = append(errs, idErrs...)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(dtdlv0.GroupVersion.WithKind("TwinClass").GroupKind(), twinClass.Name, errs)
+}
+
+// validateImmutable rejects changes to the spec of a published class
+// version. Both specs are migrated first, so that moving from the
+// deprecated relationship fields to their replacements is not a change.
+func validateImmutable(oldClass, twinClass *dtdlv0.TwinClass) field.ErrorList {
+	published := oldClass.DeepCopy()
+	for i := range published.Spec.Relationships {
+		published.Spec.Relationships[i].MigrateLegacyFields()
+	}
+	updated := twinClass.DeepCopy()
+	for i := range updated.Spec.Relationships {
+		updated.Spec.Relationships[i].MigrateLegacyFields()
+	}
+	if equality.Semantic.DeepEqual(published.Spec, updated.Spec) {
+		return nil
+	}
+	return field.ErrorList{field.Forbidden(field.NewPath("spec"),
+		fmt.Sprintf("%s is published and cannot change; create a TwinClass with a new version instead", oldClass.Spec.ID))}
+}
+
+func (w *TwinClassWebhook) validateUniqueID(ctx context.Context, twinClass *dtdlv0.TwinClass) (field.ErrorList, error) {
+	twinClasses := &dtdlv0.TwinClassList{}
+	if err := w.Client.List(ctx, twinClasses, client.InNamespace(twinClass.Namespace)); err != nil {
+		return nil, err
+	}
+	for _, other := range twinClasses.Items {
+		if other.Name != twinClass.Name && other.Spec.ID == twinClass.Spec.ID {
+			return field.ErrorList{field.Invalid(field.NewPath("spec", "id"), twinClass.Spec.ID, "is already used by TwinClass "+other.Name)}, nil
+		}
+	}
+	return nil, nil
+}
+
+// structuralErrors drops the errors about missing enums and classes.
+func structuralErrors(errs field.ErrorList) field.ErrorList {
+	return errs.Filter(func(err error) bool {
+		fieldErr, ok := err.(*field.Error)
+		return ok && fieldErr.Type == field.ErrorTypeNotFound
+	})
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+)
+
+var _ = Describe("TwinClassWebhook", func() {
+	var webhook *TwinClassWebhook
+	var twinClass *dtdlv0.TwinClass
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(dtdlv0.AddToScheme(scheme)).To(Succeed())
+		webhook = &TwinClassWebhook{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&dtdlv0.TwinClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "line-v1", Namespace: "plant"},
+			Spec:       dtdlv0.TwinClassSpec{ID: "dtmi:acme:Line;1", Name: "Line"},
+		}).Build()}
+		twinClass = &dtdlv0.TwinClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "line", Namespace: "plant"},
+			Spec: dtdlv0.TwinClassSpec{
+				Name: "Line",
+				Attributes: []dtdlv0.TwinClassAttributes{
+					{Name: "state", TwinSchema: dtdlv0.TwinSchema{Type: "enumeration", Reference: "LineState"}},
+				},
+				Relationships: []dtdlv0.TwinRelationship{
+					{Name: "head", Multiplicity: dtdlv0.ONE, Reference: "Machine"},
+					{Name: "machines", Multiplicity: dtdlv0.MANY, Reference: "Machine"},
+				},
+				Components: []dtdlv0.TwinComponent{{Name: "conveyor", Class: "Conveyor"}},
+			},
+		}
+	})
+
+	It("accepts references to enums and classes that do not exist yet", func() {
+		Expect(webhook.ValidateCreate(context.TODO(), twinClass)).To(Succeed())
+	})
+
+	It("rejects structurally invalid classes", func() {
+		three := int32(3)
+		twinClass.Spec.Relationships[0].MaxMultiplicity = &three
+
+		err := webhook.ValidateUpdate(context.TODO(), twinClass.DeepCopy(), twinClass)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring(`spec.relationships[0].maxMultiplicity: Invalid value: 3: contradicts multiplicity "one"`)))
+	})
+
+	Describe("published versions", func() {
+		BeforeEach(func() {
+			twinClass.Spec.ID = "dtmi:acme:Line;2"
+			for i := range twinClass.Spec.Relationships {
+				twinClass.Spec.Relationships[i].MigrateLegacyFields()
+			}
+		})
+
+		It("rejects IDs not ending with the class name", func() {
+			twinClass.Spec.ID = "dtmi:acme:Conveyor;2"
+
+			err := webhook.ValidateCreate(context.TODO(), twinClass)
+			Expect(err).To(MatchError(ContainSubstring(`spec.id: Invalid value: "dtmi:acme:Conveyor;2": last segment must be the class name "Line"`)))
+		})
+
+		It("rejects IDs used by another class", func() {
+			twinClass.Spec.ID = "dtmi:acme:Line;1"
+
+			err := webhook.ValidateCreate(context.TODO(), twinClass)
+			Expect(err).To(MatchError(ContainSubstring(`spec.id: Invalid value: "dtmi:acme:Line;1": is already used by TwinClass line-v1`)))
+		})
+
+		It("rejects spec changes", func() {
+			updated := twinClass.DeepCopy()
+			updated.Spec.Attributes[0].Writable = true
+
+			err := webhook.ValidateUpdate(context.TODO(), twinClass, updated)
+			Expect(err).To(MatchError(ContainSubstring("spec: Forbidden: dtmi:acme:Line;2 is published and cannot change")))
+		})
+
+		It("allows metadata changes and the migration of legacy fields", func() {
+			stored := twinClass.DeepCopy()
+			stored.Spec.Relationships[0] = dtdlv0.TwinRelationship{Name: "head", Multiplicity: dtdlv0.ONE, Reference: "Machine"}
+
+			updated := stored.DeepCopy()
+			updated.Labels = map[string]string{"line": "north"}
+			updated.Spec.Relationships[0].MigrateLegacyFields()
+
+			Expect(webhook.ValidateUpdate(context.TODO(), stored, updated)).To(Succeed())
+		})
+
+		It("allows publishing an unversioned class", func() {
+			draft := twinClass.DeepCopy()
+			draft.Spec.ID = ""
+
+			Expect(webhook.ValidateUpdate(context.TODO(), draft, twinClass)).To(Succeed())
+		})
+	})
+})
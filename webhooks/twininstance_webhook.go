@@ -0,0 +1,110 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+	"github.com/agwermann/dt-operator/pkg/dtmi"
+	"github.com/agwermann/dt-operator/pkg/instance"
+	"github.com/agwermann/dt-operator/pkg/model"
+)
+
+//+kubebuilder:webhook:path=/validate-dtdl-digitaltwin-v1-twininstance,mutating=false,failurePolicy=fail,sideEffects=None,groups=dtdl.digitaltwin,resources=twininstances,verbs=create;update,versions=v1,name=vtwininstance.kb.io,admissionReviewVersions=v1
+
+// TwinInstanceWebhook validates twin instances on admission.
+//
+// Attribute values and relationship edges are checked against the class the
+// instance selects. Instances of classes that do not exist yet, or do not
+// resolve, are admitted; the TwinInstance reconciler reports them instead.
+// Updates leaving the spec unchanged are always admitted, so that finalizers
+// and labels can be managed on instances invalidated by a class change.
+type TwinInstanceWebhook struct {
+	Client client.Reader
+}
+
+func (w *TwinInstanceWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&dtdlv1.TwinInstance{}).
+		WithValidator(w).
+		Complete()
+}
+
+func (w *TwinInstanceWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	return w.validate(ctx, obj)
+}
+
+func (w *TwinInstanceWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	oldInstance, ok := oldObj.(*dtdlv1.TwinInstance)
+	if !ok {
+		return fmt.Errorf("expected a TwinInstance but got a %T", oldObj)
+	}
+	if newInstance, ok := newObj.(*dtdlv1.TwinInstance); ok && equality.Semantic.DeepEqual(oldInstance.Spec, newInstance.Spec) {
+		return nil
+	}
+	return w.validate(ctx, newObj)
+}
+
+func (w *TwinInstanceWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (w *TwinInstanceWebhook) validate(ctx context.Context, obj runtime.Object) error {
+	twinInstance, ok := obj.(*dtdlv1.TwinInstance)
+	if !ok {
+		return fmt.Errorf("expected a TwinInstance but got a %T", obj)
+	}
+
+	errs, err := w.validateSpec(ctx, twinInstance)
+	if err != nil {
+		return err
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(dtdlv1.GroupVersion.WithKind("TwinInstance").GroupKind(), twinInstance.Name, errs)
+}
+
+func (w *TwinInstanceWebhook) validateSpec(ctx context.Context, twinInstance *dtdlv1.TwinInstance) (field.ErrorList, error) {
+	reference := twinInstance.Spec.Class.String()
+	if _, err := dtmi.ParseReference(reference); err != nil {
+		return field.ErrorList{field.Invalid(field.NewPath("spec", "class"), reference, err.Error())}, nil
+	}
+
+	m, err := model.Load(ctx, w.Client, client.InNamespace(twinInstance.Namespace))
+	if err != nil {
+		return nil, err
+	}
+	twinClass, err := m.Select(reference)
+	if err != nil {
+		return nil, nil
+	}
+	resolved, err := m.ResolveClass(twinClass)
+	if err != nil {
+		return nil, nil
+	}
+	return instance.Validate(resolved, &twinInstance.Spec), nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	dtdlv0 "github.com/agwermann/dt-operator/api/v0"
+	dtdlv1 "github.com/agwermann/dt-operator/api/v1"
+)
+
+var _ = Describe("TwinInstanceWebhook", func() {
+	var webhook *TwinInstanceWebhook
+	var twinInstance *dtdlv1.TwinInstance
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(dtdlv0.AddToScheme(scheme)).To(Succeed())
+		webhook = &TwinInstanceWebhook{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&dtdlv0.TwinClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: "plant"},
+				Spec: dtdlv0.TwinClassSpec{
+					Name: "Machine",
+					Attributes: []dtdlv0.TwinClassAttributes{
+						{Name: "state", TwinSchema: dtdlv0.TwinSchema{Type: "enumeration", Reference: "MachineState"}},
+					},
+				},
+			},
+			&dtdlv0.TwinEnum{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine-state", Namespace: "plant"},
+				Spec:       dtdlv0.TwinEnumSpec{Name: "MachineState", Values: []string{"idle", "busy"}},
+			},
+		).Build()}
+		twinInstance = &dtdlv1.TwinInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: "press-1", Namespace: "plant"},
+			Spec: dtdlv1.TwinInstanceSpec{
+				Class:      dtdlv1.TwinClassReference{Name: "Machine"},
+				Attributes: map[string]apiextensionsv1.JSON{"state": {Raw: []byte(`"busy"`)}},
+			},
+		}
+	})
+
+	It("accepts values matching the class", func() {
+		Expect(webhook.ValidateCreate(context.TODO(), twinInstance)).To(Succeed())
+	})
+
+	It("rejects values outside the enum", func() {
+		twinInstance.Spec.Attributes["state"] = apiextensionsv1.JSON{Raw: []byte(`"broken"`)}
+		err := webhook.ValidateCreate(context.TODO(), twinInstance)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring(`spec.attributes[state]: Unsupported value: "broken"`)))
+	})
+
+	It("accepts updates leaving an invalid spec unchanged", func() {
+		twinInstance.Spec.Attributes["state"] = apiextensionsv1.JSON{Raw: []byte(`"broken"`)}
+		updated := twinInstance.DeepCopy()
+		updated.Finalizers = []string{"dtdl.digitaltwin/relationships"}
+		Expect(webhook.ValidateUpdate(context.TODO(), twinInstance, updated)).To(Succeed())
+
+		updated.Spec.Attributes["state"] = apiextensionsv1.JSON{Raw: []byte(`"lost"`)}
+		Expect(apierrors.IsInvalid(webhook.ValidateUpdate(context.TODO(), twinInstance, updated))).To(BeTrue())
+	})
+
+	It("accepts instances of classes that do not exist yet", func() {
+		twinInstance.Spec.Class = dtdlv1.TwinClassReference{Name: "Conveyor"}
+		Expect(webhook.ValidateCreate(context.TODO(), twinInstance)).To(Succeed())
+	})
+
+	It("rejects malformed class references", func() {
+		twinInstance.Spec.Class = dtdlv1.TwinClassReference{Name: "Machine", Version: ">>2"}
+		err := webhook.ValidateCreate(context.TODO(), twinInstance)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring(`spec.class: Invalid value: "Machine;>>2"`)))
+	})
+})